@@ -11,7 +11,12 @@ SERIAL=$(cat "$SYSFS_BASE/serial" 2>/dev/null || echo "")
 
 /usr/bin/logger -t usb-policy "New device busid=$BUSID vid=$VID pid=$PID serial=$SERIAL"
 
-if /usr/local/bin/endorsment_service check "$VID" "$PID" "$SERIAL"; then
+if ! RESULT=$(/usr/local/bin/usb-policy -p /etc/usb-device.policy "$BUSID" 2>&1); then
+    /usr/bin/logger -t usb-policy "Policy: DENY for $BUSID (descriptors: $RESULT), not binding"
+    exit 0
+fi
+
+if /usr/local/bin/endorsement-service check "$BUSID"; then
     /usr/bin/logger -t usb-policy "Policy: ALLOW for $BUSID, binding to usbip"
     /usr/sbin/usbip bind -b "$BUSID" || \
         /usr/bin/logger -t usb-policy "usbip bind failed for $BUSID"
@@ -5,17 +5,43 @@ INTERVAL=5   # seconds between checks
 
 while true; do
     # Get exportable devices on the server.
+    if ! list=$(usbip list -r "$SERVER" 2>/dev/null); then
+        sleep "$INTERVAL"
+        continue
+    fi
+
     mapfile -t remote_busids < <(
-        usbip list -r "$SERVER" 2>/dev/null | \
+        printf '%s\n' "$list" | \
         awk '/^[[:space:]]+[0-9.-]+:/ { sub(/:/,"",$1); print $1 }'
     )
 
-    # Get currently attached remote busids on this client
-    mapfile -t attached_busids < <(
+    # Get currently attached remote busids, along with their port, on this
+    # client
+    mapfile -t attached_ports < <(
         usbip port 2>/dev/null | \
-        awk '/usbip:\/\// { sub(/.*\//,""); print }'
+        awk '/^Port/ { port=$2; sub(/:/,"",port) }
+             /usbip:\/\// { b=$NF; sub(/.*\//,"",b); print port, b }'
     )
 
+    attached_busids=()
+
+    # Detach any busid no longer exported (e.g. unbound by usbip-watch once
+    # its endorsement expired or was revoked)
+    for p in "${attached_ports[@]}"; do
+        [ -z "$p" ] && continue
+        port="${p%% *}"
+        b="${p#* }"
+
+        if printf '%s\n' "${remote_busids[@]}" | grep -qx "$b"; then
+            attached_busids+=("$b")
+            continue
+        fi
+
+        echo "[usbip-auto] Detaching $b (port $port), no longer exported by $SERVER"
+        usbip detach -p "$port" || \
+            echo "[usbip-auto] Failed to detach $b"
+    done
+
     # Attach any remote busid that isn't already attached
     for b in "${remote_busids[@]}"; do
         [ -z "$b" ] && continue
@@ -0,0 +1,444 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The endorsement-service command manages the host side USB device
+// endorsement database (see USBIP/endorsements.json), with the same
+// semantics as the Trusted OS endorsement cache (see endorsement.Cache).
+//
+// Usage:
+//
+//	endorsement-service [-f <database>] check   <busid>
+//	endorsement-service [-f <database>] check   <vid> <pid> [serial]
+//	endorsement-service [-f <database>] endorse <vid> <pid> [serial] [--ttl <seconds>] [--note <text>]
+//	                                            [--bcd <bcd>] [--class <cc:ss:pp>] [--interfaces <cc:ss:pp,...>]
+//	endorsement-service [-f <database>] revoke  <vid> <pid> [serial]
+//	endorsement-service [-f <database>] list
+//	endorsement-service [-f <database>] purge-expired
+//	endorsement-service [-f <database>] export  [file]
+//	endorsement-service [-f <database>] import  <file>
+//
+// Endorsements are keyed as vid:pid:serial, with `-` for any serial number,
+// an endorsement for a serial number takes precedence over one for any serial
+// number.
+//
+// When given the bus ID of a device connected to the host (e.g. 1-1), check
+// reads its descriptors from sysfs and evaluates endorsements against its full
+// identity, including release number, class and interfaces. When given only a
+// vid:pid:serial identity, endorsements constrained on release number, class
+// or interfaces are not satisfied.
+//
+// The database is locked for the duration of each command and replaced
+// atomically when modified.
+//
+// The command replaces the USBIP/endorsment_service script, which is kept as
+// a shim invoking it.
+//
+// The exit status of check is 0 if the device is endorsed and 1 otherwise,
+// other commands exit with status 1 on errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+const defaultDatabase = "/var/lib/usb-policy/endorsements.json"
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("endorsement-service: ")
+}
+
+// database represents the locked endorsement database file.
+type database struct {
+	path  string
+	lock  *os.File
+	cache *endorsement.Cache
+}
+
+// open locks and loads the database, a missing database is empty.
+func open(path string) (db *database, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	// the lock file outlives the database, which is replaced on save
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return
+	}
+
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return
+	}
+
+	db = &database{
+		path:  path,
+		lock:  lock,
+		cache: endorsement.NewCache(),
+	}
+
+	data, err := os.ReadFile(path)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return db, nil
+	case err != nil:
+		db.close()
+		return nil, err
+	}
+
+	if _, err = db.cache.Import(data); err != nil {
+		db.close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return
+}
+
+func (db *database) close() {
+	db.lock.Close()
+}
+
+// save atomically replaces the database.
+func (db *database) save() (err error) {
+	data, err := db.cache.Export()
+
+	if err != nil {
+		return
+	}
+
+	tmp := db.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return
+	}
+
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		// ensure the new database is durable before replacing the old one
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	return os.Rename(tmp, db.path)
+}
+
+// parseArgs parses command flags interleaved with positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) (pos []string, err error) {
+	for {
+		if err = fs.Parse(args); err != nil {
+			return
+		}
+
+		if fs.NArg() == 0 {
+			return
+		}
+
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func parseDevice(args []string) (dev endorsement.DeviceID, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		return dev, errors.New("expected <vid> <pid> [serial]")
+	}
+
+	if len(args) == 2 {
+		args = append(args, "")
+	}
+
+	return endorsement.ParseDeviceID(args[0] + ":" + args[1] + ":" + args[2])
+}
+
+func expiry(e endorsement.Entry) string {
+	if e.Expiry == 0 {
+		return "None"
+	}
+
+	return strconv.FormatInt(e.Expiry, 10)
+}
+
+// busIDPattern matches the bus ID of a device connected to the host.
+var busIDPattern = regexp.MustCompile(`^[0-9]+-[0-9]+(\.[0-9]+)*$`)
+
+// identify returns the full identity of a device connected to the host,
+// given its bus ID, or the identity given as vid, pid and optional serial.
+func identify(args []string) (dev endorsement.Device, err error) {
+	if len(args) == 1 && busIDPattern.MatchString(args[0]) {
+		ud, err := usb.ReadSysfs(args[0])
+
+		if err != nil {
+			return dev, err
+		}
+
+		return endorsement.Identity(ud)
+	}
+
+	dev.DeviceID, err = parseDevice(args)
+
+	return
+}
+
+func check(db *database, args []string) (status int, err error) {
+	dev, err := identify(args)
+
+	if err != nil {
+		return
+	}
+
+	e, ok := db.cache.Lookup(&dev)
+
+	// expired endorsements are persisted as such
+	if ok && e.Status == endorsement.Expired {
+		err = db.save()
+	}
+
+	if !ok || e.Status != endorsement.Active {
+		return 1, err
+	}
+
+	return
+}
+
+func endorse(db *database, args []string) (status int, err error) {
+	var m endorsement.Match
+
+	fs := flag.NewFlagSet("endorse", flag.ContinueOnError)
+	ttl := fs.Int64("ttl", 0, "time-to-live in seconds (optional)")
+	note := fs.String("note", "", "readable note")
+	bcd := fs.String("bcd", "", "required device release number (hex)")
+	class := fs.String("class", "", "required device class (cc:ss:pp)")
+	ifaces := fs.String("interfaces", "", "permitted interface classes (cc:ss:pp,...)")
+
+	pos, err := parseArgs(fs, args)
+
+	if err != nil {
+		return
+	}
+
+	dev, err := parseDevice(pos)
+
+	if err != nil {
+		return
+	}
+
+	if *ttl < 0 {
+		return 0, errors.New("invalid time-to-live")
+	}
+
+	if *bcd != "" {
+		v, err := strconv.ParseUint(*bcd, 16, 16)
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid release number, %v", err)
+		}
+
+		b := uint16(v)
+		m.BCDDevice = &b
+	}
+
+	if *class != "" {
+		c, err := endorsement.ParseClass(*class)
+
+		if err != nil {
+			return 0, err
+		}
+
+		m.Class = &c
+	}
+
+	if *ifaces != "" {
+		if m.Interfaces, err = endorsement.ParseClasses(*ifaces); err != nil {
+			return
+		}
+	}
+
+	e := db.cache.Add(endorsement.Grant{
+		Device:   dev,
+		Match:    m,
+		Lifetime: time.Duration(*ttl) * time.Second,
+		Budget:   endorsement.Unlimited,
+		Note:     *note,
+	})
+
+	if err = db.save(); err != nil {
+		return
+	}
+
+	fmt.Printf("Endorsed %s (expiry=%s)\n", dev.Key(), expiry(e))
+
+	return
+}
+
+func revoke(db *database, args []string) (status int, err error) {
+	dev, err := parseDevice(args)
+
+	if err != nil {
+		return
+	}
+
+	if err = db.cache.Revoke(dev); err != nil {
+		return 0, fmt.Errorf("no entry for %s", dev.Key())
+	}
+
+	if err = db.save(); err != nil {
+		return
+	}
+
+	fmt.Printf("Revoked %s\n", dev.Key())
+
+	return
+}
+
+func list(db *database, args []string) (status int, err error) {
+	entries := db.cache.List()
+
+	if len(entries) == 0 {
+		fmt.Println("No endorsements")
+		return
+	}
+
+	for _, e := range entries {
+		fmt.Printf("%s  status=%s  expiry=%s  note=%s", e.Device.Key(), e.Status, expiry(e), e.Note)
+
+		if m := e.Match.String(); m != "any" {
+			fmt.Printf("  match=%s", m)
+		}
+
+		fmt.Println()
+	}
+
+	return
+}
+
+func purge(db *database, args []string) (status int, err error) {
+	devices := db.cache.Purge()
+
+	if len(devices) == 0 {
+		return
+	}
+
+	if err = db.save(); err != nil {
+		return
+	}
+
+	for _, dev := range devices {
+		fmt.Printf("Purged %s\n", dev.Key())
+	}
+
+	return
+}
+
+func export(db *database, args []string) (status int, err error) {
+	data, err := db.cache.Export()
+
+	if err != nil {
+		return
+	}
+
+	data = append(data, '\n')
+
+	switch len(args) {
+	case 0:
+		_, err = os.Stdout.Write(data)
+	case 1:
+		err = os.WriteFile(args[0], data, 0644)
+	default:
+		err = errors.New("expected [file]")
+	}
+
+	return
+}
+
+func importFile(db *database, args []string) (status int, err error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected <file>")
+	}
+
+	data, err := os.ReadFile(args[0])
+
+	if err != nil {
+		return
+	}
+
+	n, err := db.cache.Import(data)
+
+	if err != nil {
+		return
+	}
+
+	if err = db.save(); err != nil {
+		return
+	}
+
+	fmt.Printf("Imported %d endorsements\n", n)
+
+	return
+}
+
+var commands = map[string]func(*database, []string) (int, error){
+	"check":         check,
+	"endorse":       endorse,
+	"revoke":        revoke,
+	"list":          list,
+	"purge-expired": purge,
+	"export":        export,
+	"import":        importFile,
+}
+
+func main() {
+	path := flag.String("f", defaultDatabase, "endorsement database")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+
+	if !ok {
+		log.Fatalf("invalid command %q", flag.Arg(0))
+	}
+
+	db, err := open(*path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	status, err := cmd(db, flag.Args()[1:])
+	db.close()
+
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+
+	os.Exit(status)
+}
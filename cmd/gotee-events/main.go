@@ -0,0 +1,226 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The gotee-events command filters the structured log records emitted by the
+// GoTEE example Trusted OS, to assert on events in automated (e.g. QEMU) runs.
+//
+// Usage:
+//
+//	gotee-events [-c <component>] [-l <level>] [-m <msg>] [-f <key=value>]... [-q] [file|-]
+//
+// Records are read in JSON-lines format, as output by the Trusted OS
+// `events` console command (or forwarded with `events on`), from an SSH or
+// serial console capture: text preceding a record on the same line, as well
+// as lines not holding a record, are ignored.
+//
+// Records matching all filters are printed, fields within groups are
+// matched with dotted keys (e.g. ctx.pc=0x8).
+//
+// Like grep, the exit status is 0 if any record matches, 1 if none does and 2
+// on errors.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/logging"
+)
+
+// maxLineSize is the maximum size of a captured line.
+const maxLineSize = 1024 * 1024
+
+type fields []string
+
+func (f *fields) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fields) Set(val string) error {
+	if !strings.Contains(val, "=") {
+		return fmt.Errorf("invalid field %q, expected key=value", val)
+	}
+
+	*f = append(*f, val)
+
+	return nil
+}
+
+type filter struct {
+	component string
+	level     slog.Level
+	msg       string
+	fields    fields
+}
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("gotee-events: ")
+}
+
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(2)
+}
+
+// lookup returns the value of a record field, walking groups on dotted keys.
+func lookup(rec map[string]any, key string) (val any, ok bool) {
+	val = rec
+
+	for _, k := range strings.Split(key, ".") {
+		group, isGroup := val.(map[string]any)
+
+		if !isGroup {
+			return nil, false
+		}
+
+		if val, ok = group[k]; !ok {
+			return
+		}
+	}
+
+	return
+}
+
+// format returns the textual representation of a record field value.
+func format(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case nil:
+		return "null"
+	}
+
+	buf, _ := json.Marshal(val)
+
+	return string(buf)
+}
+
+func (f *filter) match(rec map[string]any) bool {
+	var level slog.Level
+
+	if f.component != "" && format(rec[logging.ComponentKey]) != f.component {
+		return false
+	}
+
+	if f.msg != "" && format(rec[slog.MessageKey]) != f.msg {
+		return false
+	}
+
+	if err := level.UnmarshalText([]byte(format(rec[slog.LevelKey]))); err != nil || level < f.level {
+		return false
+	}
+
+	for _, kv := range f.fields {
+		key, want, _ := strings.Cut(kv, "=")
+
+		if val, ok := lookup(rec, key); !ok || format(val) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parse returns the record held by a captured line, if any.
+func parse(line []byte) (rec map[string]any) {
+	i := bytes.IndexByte(line, '{')
+
+	if i < 0 {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(bytes.TrimSpace(line[i:])))
+	dec.UseNumber()
+
+	if err := dec.Decode(&rec); err != nil {
+		return nil
+	}
+
+	if _, ok := rec[slog.MessageKey]; !ok {
+		return nil
+	}
+
+	return
+}
+
+func filterEvents(f *filter, r io.Reader, w io.Writer, quiet bool) (n int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		rec := parse(line)
+
+		if rec == nil || !f.match(rec) {
+			continue
+		}
+
+		n += 1
+
+		if quiet {
+			continue
+		}
+
+		line = bytes.TrimSpace(line[bytes.IndexByte(line, '{'):])
+		fmt.Fprintf(w, "%s\n", line)
+	}
+
+	return n, scanner.Err()
+}
+
+func main() {
+	var level string
+
+	f := &filter{}
+
+	flag.StringVar(&f.component, "c", "", "component (loader, handler, tz, rpc, usb)")
+	flag.StringVar(&level, "l", "debug", "minimum level (debug, info, warn, error)")
+	flag.StringVar(&f.msg, "m", "", "message")
+	flag.Var(&f.fields, "f", "field (key=value), can be repeated")
+	quiet := flag.Bool("q", false, "quiet, only set exit status")
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+
+	if f.level, err = logging.ParseLevel(level); err != nil {
+		fatal(err)
+	}
+
+	in := os.Stdin
+
+	if path := flag.Arg(0); path != "" && path != "-" {
+		if in, err = os.Open(path); err != nil {
+			fatal(err)
+		}
+	}
+
+	n, err := filterEvents(f, in, os.Stdout, *quiet)
+
+	if err != nil {
+		fatal(err)
+	}
+
+	if n == 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The gotee-verify command verifies attestation quotes issued by the GoTEE
+// example Trusted OS against an enrolled device public key and a set of
+// reference values.
+//
+// Usage:
+//
+//	gotee-verify -k <hex public key> -r <reference.json> -n <hex nonce> [-l <eventlog.json>] <quote file|->
+//
+// The quote is the output of the Trusted OS `quote` console command, in hex
+// or raw binary format, while the nonce is the one issued by the Trusted OS
+// through RPC.GetChallenge or the `challenge` console command.
+//
+// The optional event log is the output of the Trusted OS `eventlog json`
+// console command, it is replayed and checked against the quote.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/attest"
+)
+
+type flags struct {
+	key       string
+	reference string
+	nonce     string
+	eventLog  string
+	verbose   bool
+}
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("gotee-verify: ")
+}
+
+func readQuote(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(path)
+}
+
+func verifyEventLog(f *flags, q *util.Quote) (err error) {
+	data, err := os.ReadFile(f.eventLog)
+
+	if err != nil {
+		return
+	}
+
+	events, err := attest.ParseEventLog(data)
+
+	if err != nil {
+		return
+	}
+
+	if f.verbose {
+		for i, e := range events {
+			log.Printf("event %d: %s %x %s", i, e.Type, e.Digest, e.Description)
+		}
+	}
+
+	return attest.VerifyEventLog(q, events)
+}
+
+func verify(f *flags, path string) (err error) {
+	var nonce [32]byte
+
+	pub, err := attest.ParsePublicKey(f.key)
+
+	if err != nil {
+		return fmt.Errorf("invalid public key, %v", err)
+	}
+
+	buf, err := hex.DecodeString(f.nonce)
+
+	if err != nil || len(buf) != len(nonce) {
+		return errors.New("invalid nonce")
+	}
+
+	copy(nonce[:], buf)
+
+	ref, err := attest.LoadReferenceValues(f.reference)
+
+	if err != nil {
+		return
+	}
+
+	data, err := readQuote(path)
+
+	if err != nil {
+		return
+	}
+
+	q, err := attest.ParseQuote(data)
+
+	if err != nil {
+		return fmt.Errorf("invalid quote, %v", err)
+	}
+
+	if f.verbose {
+		log.Printf("applet:%x", q.Applet)
+		log.Printf("os:%x", q.OS)
+	}
+
+	v := &attest.Verifier{
+		PublicKey: pub,
+		Reference: ref,
+	}
+
+	if err = v.Verify(q, nonce); err != nil || f.eventLog == "" {
+		return
+	}
+
+	return verifyEventLog(f, q)
+}
+
+func main() {
+	f := &flags{}
+
+	flag.StringVar(&f.key, "k", "", "enrolled device attestation public key (hex)")
+	flag.StringVar(&f.reference, "r", "", "reference values (JSON)")
+	flag.StringVar(&f.nonce, "n", "", "challenge nonce (hex)")
+	flag.StringVar(&f.eventLog, "l", "", "measured boot event log (JSON)")
+	flag.BoolVar(&f.verbose, "v", false, "verbose output")
+	flag.Parse()
+
+	if flag.NArg() != 1 || f.key == "" || f.reference == "" || f.nonce == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := verify(f, flag.Arg(0)); err != nil {
+		log.Fatalf("verification failed, %v", err)
+	}
+
+	log.Printf("quote verified")
+}
@@ -0,0 +1,135 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The usb-policy command evaluates a USB device policy, in the rule language
+// shared with the Normal World USB packet filter (see usb.Policy), against
+// the descriptors of connected or recorded devices.
+//
+// Usage:
+//
+//	usb-policy -p <policy file> [-v] <busid|descriptors.json>...
+//
+// Connected devices are identified by their Linux USB bus ID (e.g. 1-1), as
+// passed by udev to USBIP/usb-policy-handler.sh, and their descriptors are
+// read from sysfs.
+//
+// Recorded devices are JSON files (see USBIP/descriptors) holding the hex
+// encoded device, configuration and serial number string descriptors and the
+// HID report descriptors indexed by interface number.
+//
+// The exit status is 0 if all devices are allowed, 1 if any is denied.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+type flags struct {
+	policy  string
+	verbose bool
+}
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("usb-policy: ")
+}
+
+func loadRecording(path string) (d *usb.Device, err error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	return usb.ParseRecording(data)
+}
+
+func evaluate(f *flags, p *usb.Policy, arg string) (allowed bool, err error) {
+	var d *usb.Device
+
+	if strings.HasSuffix(arg, ".json") {
+		d, err = loadRecording(arg)
+	} else {
+		d, err = usb.ReadSysfs(arg)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if f.verbose {
+		dd := d.Descriptor
+		log.Printf("%s: %04x:%04x bcd:%04x class:%02x:%02x:%02x serial:%q", arg, dd.VendorId, dd.ProductId, dd.Device, dd.DeviceClass, dd.DeviceSubClass, dd.DeviceProtocol, d.Serial)
+
+		for _, iface := range d.Interfaces() {
+			log.Printf("%s: interface %d class:%02x:%02x:%02x endpoints:%d", arg, iface.InterfaceNumber, iface.InterfaceClass, iface.InterfaceSubClass, iface.InterfaceProtocol, len(iface.Endpoints))
+
+			if iface.HID == nil || iface.HID.Report == nil {
+				continue
+			}
+
+			r := iface.HID.Report
+			log.Printf("%s: interface %d applications:%v report IDs:%v boot keyboard:%v boot mouse:%v", arg, iface.InterfaceNumber, r.Applications, r.ReportIDs(), r.BootKeyboard(), r.BootMouse())
+		}
+	}
+
+	action, rule := p.Evaluate(d)
+
+	if rule == nil {
+		fmt.Printf("%s %s (default)\n", arg, action)
+	} else {
+		fmt.Printf("%s %s (line %d: %s)\n", arg, action, rule.Line, rule.Text)
+	}
+
+	return action == usb.Allow, nil
+}
+
+func main() {
+	f := &flags{}
+
+	flag.StringVar(&f.policy, "p", "", "policy rules")
+	flag.BoolVar(&f.verbose, "v", false, "verbose output")
+	flag.Parse()
+
+	if flag.NArg() == 0 || f.policy == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rules, err := os.ReadFile(f.policy)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p, err := usb.ParsePolicy(string(rules))
+
+	if err != nil {
+		log.Fatalf("invalid policy, %v", err)
+	}
+
+	status := 0
+
+	for _, arg := range flag.Args() {
+		allowed, err := evaluate(f, p, arg)
+
+		if err != nil {
+			// devices with invalid descriptors are denied
+			log.Printf("%s: %v", arg, err)
+		}
+
+		if !allowed {
+			status = 1
+		}
+	}
+
+	os.Exit(status)
+}
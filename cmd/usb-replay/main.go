@@ -0,0 +1,282 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The usb-replay command replays recorded boot keyboard reports through the
+// keystroke injection detector used by the Normal World USB packet filter
+// (see keystroke.Detector), or usbmon captures through the entire filter (see
+// filter.Filter).
+//
+// Usage:
+//
+//	usb-replay [-gap <duration>] [-burst <n>] [-rate <n>] [-window <duration>] [-shells <list>]
+//	           [-p <policy>] [-e <endorsements.json>] [-d <descriptors.json> [-i <interface>]]
+//	           <recording|capture.pcap>...
+//
+// Recordings hold one report per line in `<time> <hex report>` format (see
+// util/keystroke/testdata), when recorded device descriptors are given (see
+// USBIP/descriptors) each report is also printed as decoded by the report
+// descriptor of the selected interface (see usb.Decoder).
+//
+// Captures are usbmon pcap files (e.g. recorded with Wireshark on a usbmonX
+// interface), the IN payloads of each captured device are submitted to the
+// filter, with the device policy evaluated only when one is given. Packets
+// are checked against an in-process endorsement cache, initialized from the
+// JSON database when given (see USBIP/endorsements.json).
+//
+// The exit status is 0 if no alert is raised and no captured packet is
+// blocked, 1 otherwise.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/keystroke"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/usbmon"
+)
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("usb-replay: ")
+}
+
+func replay(c keystroke.Config, report *usb.ReportDescriptor, path string) (alerts []keystroke.Alert, err error) {
+	text, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	samples, err := keystroke.ParseSamples(string(text))
+
+	if err != nil {
+		return
+	}
+
+	if report != nil {
+		dec := usb.NewDecoder(report)
+
+		for _, s := range samples {
+			events, err := dec.Decode(s.Data)
+
+			if err != nil {
+				fmt.Printf("%s: %v %x (%v)\n", path, s.Time, s.Data, err)
+				continue
+			}
+
+			fmt.Printf("%s: %v %s\n", path, s.Time, usb.Events(events))
+		}
+	}
+
+	d := keystroke.NewDetector(c)
+
+	if alerts, err = d.Replay(samples); err != nil {
+		return
+	}
+
+	s := d.Stats()
+
+	fmt.Printf("%s: reports:%d keystrokes:%d bursts:%d longest:%d min:%v mean:%v max rate:%.1f/s\n",
+		path, s.Reports, s.Keystrokes, s.Bursts, s.LongestBurst, s.MinInterval, s.MeanInterval, s.MaxRate)
+
+	for _, a := range alerts {
+		fmt.Printf("%s: ALERT %s\n", path, a)
+	}
+
+	return
+}
+
+func replayCapture(f *filter.Filter, path string) (blocked int, err error) {
+	buf, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	c, err := usbmon.ReadCapture(bytes.NewReader(buf))
+
+	if err != nil {
+		return
+	}
+
+	for _, d := range c.Devices {
+		var pkts []filter.Packet
+
+		for _, p := range d.Payloads {
+			if p.Direction() != usb.IN {
+				continue
+			}
+
+			pkts = append(pkts, filter.Packet{
+				Time:     p.Time.Sub(c.Start),
+				Endpoint: p.Endpoint,
+				Data:     p.Data,
+			})
+		}
+
+		if len(pkts) == 0 {
+			continue
+		}
+
+		desc, err := d.Parse()
+
+		if err != nil {
+			fmt.Printf("%s: %s packets:%d blocked:%d (%v)\n", path, d.Address, len(pkts), len(pkts), err)
+			blocked += len(pkts)
+			continue
+		}
+
+		id := fmt.Sprintf("%s %04x:%04x", d.Address, desc.Descriptor.VendorId, desc.Descriptor.ProductId)
+
+		if f.Policy != nil && !f.Allow(desc) {
+			fmt.Printf("%s: %s packets:%d blocked:%d (policy)\n", path, id, len(pkts), len(pkts))
+			blocked += len(pkts)
+			continue
+		}
+
+		n := 0
+
+		for _, permitted := range f.Handle(desc, pkts) {
+			if !permitted {
+				n++
+			}
+		}
+
+		fmt.Printf("%s: %s packets:%d blocked:%d\n", path, id, len(pkts), n)
+		blocked += n
+	}
+
+	return
+}
+
+func newFilter(c keystroke.Config, policyPath string, dbPath string) (f *filter.Filter, err error) {
+	var policy *usb.Policy
+
+	cache := endorsement.NewCache()
+
+	if policyPath != "" {
+		text, err := os.ReadFile(policyPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if policy, err = usb.ParsePolicy(string(text)); err != nil {
+			return nil, fmt.Errorf("%s: %v", policyPath, err)
+		}
+	}
+
+	if dbPath != "" {
+		db, err := os.ReadFile(dbPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = cache.Import(db); err != nil {
+			return nil, fmt.Errorf("%s: %v", dbPath, err)
+		}
+	}
+
+	f = filter.New(filter.NewLocal(cache), policy)
+	f.Keystroke = c
+
+	return
+}
+
+func loadReport(path string, iface int) (r *usb.ReportDescriptor, err error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	d, err := usb.ParseRecording(data)
+
+	if err != nil {
+		return
+	}
+
+	for _, i := range d.Interfaces() {
+		if int(i.InterfaceNumber) == iface && i.HID != nil && i.HID.Report != nil {
+			return i.HID.Report, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no report descriptor for interface %d", iface)
+}
+
+func main() {
+	c := keystroke.DefaultConfig()
+
+	flag.DurationVar(&c.BurstGap, "gap", c.BurstGap, "minimum interval between keystroke bursts")
+	flag.IntVar(&c.MinBurst, "burst", c.MinBurst, "keystrokes before burst rate evaluation")
+	flag.Float64Var(&c.MaxRate, "rate", c.MaxRate, "maximum typing rate (keystrokes/s)")
+	flag.DurationVar(&c.RunWindow, "window", c.RunWindow, "maximum keystroke interval in the Run dialog")
+	shells := flag.String("shells", strings.Join(c.Shells, ","), "shells flagged after Win+R")
+	policyPath := flag.String("p", "", "device policy file (captures only)")
+	dbPath := flag.String("e", "", "endorsement database (captures only)")
+	descPath := flag.String("d", "", "recorded device descriptors (recordings only)")
+	iface := flag.Int("i", 0, "report descriptor interface number")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c.Shells = strings.Split(*shells, ",")
+	status := 0
+
+	f, err := newFilter(c, *policyPath, *dbPath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var report *usb.ReportDescriptor
+
+	if *descPath != "" {
+		if report, err = loadReport(*descPath, *iface); err != nil {
+			log.Fatalf("%s: %v", *descPath, err)
+		}
+	}
+
+	for _, path := range flag.Args() {
+		if filepath.Ext(path) == ".pcap" {
+			blocked, err := replayCapture(f, path)
+
+			if err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+
+			if blocked > 0 {
+				status = 1
+			}
+
+			continue
+		}
+
+		alerts, err := replay(c, report, path)
+
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+
+		if len(alerts) > 0 {
+			status = 1
+		}
+	}
+
+	os.Exit(status)
+}
@@ -0,0 +1,460 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The usbip-server command exports physical USB devices and virtual HID
+// devices over the USB/IP protocol (see usbip.Server), all device data passes
+// through the USB packet filter (device policy, keystroke injection detection,
+// rate limits and anomaly scoring and endorsement check) before reaching the
+// remote host.
+//
+// Usage:
+//
+//	usbip-server [-l <address>] [-p <policy>] [-e <endorsements.json>] [-k <key> -t <tokens>]
+//	             [-a <audit log>] [-i <interval>] [-r <recording>] [-loopback]
+//	             [-b <bus ID>]... [<descriptors.json>...]
+//
+// Physical devices, connected to the Linux host, are exported under their bus
+// ID (e.g. -b 1-1) through usbfs (see usbip.OpenUSBFS), which detaches them
+// from their kernel drivers, usbip-host and usbipd are not required.
+//
+// Virtual devices are described by recorded descriptors (see
+// USBIP/descriptors) and exported with bus IDs 1-1, 1-2 and so on, which must
+// not overlap with those of physical devices. Recorded reports (see
+// util/keystroke/testdata) are queued, with their recorded timing, on the
+// first interrupt IN endpoint of the first virtual device once it is first
+// polled.
+//
+// When a Trusted OS public key (hex, see the `tokens` console command) is
+// given, devices are only exported and their data only passed if permitted by
+// an endorsement token signed by it, tokens are read one per line from the
+// tokens file (see the `token` console command).
+//
+// Imported devices are tracked by a revocation watcher (see watch.Watcher),
+// which checks their endorsement (and token) at every interval and closes
+// the connection of devices no longer permitted, logging an audit record.
+//
+// In loopback mode, instead of listening, an in-process client lists and
+// imports the first device, retrieves its descriptors and prints its input
+// reports until the recording is exhausted, then exits.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/anomaly"
+	"github.com/usbarmory/GoTEE-example/util/attest"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/keystroke"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/usbip"
+	"github.com/usbarmory/GoTEE-example/util/watch"
+)
+
+// busIDs represents the bus IDs of the physical devices to export.
+type busIDs []string
+
+func (b *busIDs) String() string {
+	return fmt.Sprint(*b)
+}
+
+func (b *busIDs) Set(id string) error {
+	*b = append(*b, id)
+	return nil
+}
+
+// loopbackTimeout is the interval after which pending loopback input
+// transfers are unlinked.
+const loopbackTimeout = 2 * time.Second
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("usbip-server: ")
+}
+
+// replayDevice queues recorded reports on a virtual HID device once it is
+// first polled.
+type replayDevice struct {
+	*usbip.HIDDevice
+
+	ep      uint8
+	samples []keystroke.Sample
+	once    sync.Once
+}
+
+func (d *replayDevice) Transfer(req *usbip.Request, cancel <-chan struct{}) ([]byte, error) {
+	if req.Endpoint == d.ep {
+		d.once.Do(func() { go d.replay() })
+	}
+
+	return d.HIDDevice.Transfer(req, cancel)
+}
+
+func (d *replayDevice) replay() {
+	start := time.Now()
+
+	for _, s := range d.samples {
+		time.Sleep(time.Until(start.Add(s.Time)))
+
+		if err := d.Input(d.ep, s.Data); err != nil {
+			log.Printf("could not queue report, %v", err)
+		}
+	}
+}
+
+func loadDevice(path string) (d *usbip.HIDDevice, err error) {
+	var r usb.Recording
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &r); err != nil {
+		return
+	}
+
+	device, config, serial, reports, err := r.Descriptors()
+
+	if err != nil {
+		return
+	}
+
+	return usbip.NewHIDDevice(device, config, serial, reports)
+}
+
+func newFilter(policyPath string, dbPath string) (f *filter.Filter, cache *endorsement.Cache, err error) {
+	var policy *usb.Policy
+
+	cache = endorsement.NewCache()
+
+	if policyPath != "" {
+		text, err := os.ReadFile(policyPath)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if policy, err = usb.ParsePolicy(string(text)); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", policyPath, err)
+		}
+	}
+
+	if dbPath != "" {
+		db, err := os.ReadFile(dbPath)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, err = cache.Import(db); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", dbPath, err)
+		}
+	}
+
+	local := filter.NewLocal(cache)
+	local.Anomalies = anomaly.NewMonitor(anomaly.DefaultConfig())
+
+	return filter.New(local, policy), cache, nil
+}
+
+func loadTokens(key string, path string) (tokens *endorsement.Tokens, err error) {
+	pub, err := attest.ParsePublicKey(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key, %v", err)
+	}
+
+	tokens = endorsement.NewTokens(pub)
+
+	if path == "" {
+		return
+	}
+
+	text, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	n, err := tokens.Load(text)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	log.Printf("loaded %d endorsement tokens", n)
+
+	return
+}
+
+// interruptIn returns the first interrupt IN endpoint of a device, along
+// with its interface.
+func interruptIn(d *usb.Device) (iface *usb.InterfaceDescriptor, ep uint8) {
+	for _, iface := range d.Interfaces() {
+		for _, e := range iface.Endpoints {
+			if e.Direction() == usb.IN && e.TransferType() == usb.INTERRUPT {
+				return iface, e.EndpointAddress
+			}
+		}
+	}
+
+	return
+}
+
+func getDescriptor(c *usbip.Client, requestType uint8, typ uint8, index uint8, length uint16) (buf []byte, err error) {
+	setup := [8]byte{requestType, usbip.GET_DESCRIPTOR, 0, typ, index, 0, uint8(length), uint8(length >> 8)}
+
+	r, err := c.Control(setup, nil)
+
+	if err != nil {
+		return
+	}
+
+	if r.Status != 0 {
+		return nil, fmt.Errorf("GET_DESCRIPTOR failed (status %d)", r.Status)
+	}
+
+	return r.Data, nil
+}
+
+func loopback(s *usbip.Server, n int) (err error) {
+	srv, cli := net.Pipe()
+	go s.ServeConn(srv)
+
+	devices, err := usbip.DevList(cli)
+	cli.Close()
+
+	if err != nil {
+		return
+	}
+
+	for _, d := range devices {
+		fmt.Printf("loopback: %s %04x:%04x interfaces:%d\n", d.ID(), d.VendorId, d.ProductId, d.NumInterfaces)
+	}
+
+	if len(devices) == 0 {
+		return
+	}
+
+	srv, cli = net.Pipe()
+	go s.ServeConn(srv)
+
+	c, err := usbip.Import(cli, devices[0].ID())
+
+	if err != nil {
+		return
+	}
+
+	defer c.Close()
+
+	device, err := getDescriptor(c, 0x80, usb.DEVICE, 0, usb.DEVICE_LENGTH)
+
+	if err != nil {
+		return
+	}
+
+	config, err := getDescriptor(c, 0x80, usb.CONFIGURATION, 0, 0xffff)
+
+	if err != nil {
+		return
+	}
+
+	desc, err := usb.ParseDevice(device, config)
+
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("loopback: imported %s %04x:%04x\n", c.Info.ID(), desc.Descriptor.VendorId, desc.Descriptor.ProductId)
+
+	iface, ep := interruptIn(desc)
+
+	if iface == nil {
+		return errors.New("no interrupt IN endpoints")
+	}
+
+	var dec *usb.Decoder
+
+	if iface.HID != nil {
+		buf, err := getDescriptor(c, 0x81, usb.REPORT, iface.InterfaceNumber, iface.HID.ReportLength)
+
+		if err != nil {
+			return err
+		}
+
+		if err = desc.SetReport(iface.InterfaceNumber, buf); err != nil {
+			return err
+		}
+
+		dec = usb.NewDecoder(iface.HID.Report)
+	}
+
+	for i := 0; i < n; i++ {
+		seq, result, err := c.Submit(ep, [8]byte{}, 64, nil)
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case r := <-result:
+			if r.Status != 0 {
+				return fmt.Errorf("transfer failed (status %d)", r.Status)
+			}
+
+			events := ""
+
+			if dec != nil {
+				if ev, err := dec.Decode(r.Data); err == nil {
+					events = usb.Events(ev)
+				}
+			}
+
+			fmt.Printf("loopback: %x %s\n", r.Data, events)
+		case <-time.After(loopbackTimeout):
+			status, err := c.Unlink(seq)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("loopback: unlinked pending transfer (status %d)\n", status)
+
+			return nil
+		}
+	}
+
+	return
+}
+
+func main() {
+	addr := flag.String("l", ":3240", "listen address")
+	policyPath := flag.String("p", "", "device policy file")
+	dbPath := flag.String("e", "", "endorsement database")
+	key := flag.String("k", "", "Trusted OS public key (hex), enables token verification")
+	tokensPath := flag.String("t", "", "endorsement tokens")
+	recording := flag.String("r", "", "recorded reports")
+	auditPath := flag.String("a", "", "audit log of detached devices")
+	interval := flag.Duration("i", 5*time.Second, "endorsement check interval")
+	loop := flag.Bool("loopback", false, "import the first device with an in-process client")
+
+	var physical busIDs
+	flag.Var(&physical, "b", "bus ID of a physical device (repeatable)")
+
+	flag.Parse()
+
+	if flag.NArg() == 0 && len(physical) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, cache, err := newFilter(*policyPath, *dbPath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *key != "" {
+		if f.Tokens, err = loadTokens(*key, *tokensPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	s := usbip.NewServer(f)
+
+	// detach imported devices once their endorsement expires or is revoked
+	s.Watcher = watch.NewWatcher(cache, s, nil)
+	s.Watcher.Tokens = f.Tokens
+
+	if *auditPath != "" {
+		audit, err := os.OpenFile(*auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer audit.Close()
+
+		s.Watcher.Audit = audit
+	}
+
+	go s.Watcher.Run(*interval, nil)
+
+	var samples []keystroke.Sample
+
+	if *recording != "" {
+		text, err := os.ReadFile(*recording)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if samples, err = keystroke.ParseSamples(string(text)); err != nil {
+			log.Fatalf("%s: %v", *recording, err)
+		}
+	}
+
+	for _, busID := range physical {
+		dev, err := usbip.OpenUSBFS(busID)
+
+		if err != nil {
+			log.Fatalf("%s: %v", busID, err)
+		}
+
+		// reattach kernel drivers once the loopback test ends
+		defer dev.Close()
+
+		if err = s.Export(busID, dev); err != nil {
+			log.Fatalf("%s: %v", busID, err)
+		}
+	}
+
+	for i, path := range flag.Args() {
+		var dev usbip.Device
+
+		hid, err := loadDevice(path)
+
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+
+		dev = hid
+
+		if i == 0 && len(samples) > 0 {
+			_, ep := interruptIn(hid.Descriptor())
+			dev = &replayDevice{HIDDevice: hid, ep: ep, samples: samples}
+		}
+
+		if err = s.Export(fmt.Sprintf("1-%d", i+1), dev); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	if *loop {
+		if err = loopback(s, len(samples)+1); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	l, err := net.Listen("tcp", *addr)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Fatal(s.Serve(l))
+}
@@ -0,0 +1,208 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The usbip-watch command detaches devices exported by usbipd once their
+// endorsement expires or is revoked (see watch.Watcher).
+//
+// Usage:
+//
+//	usbip-watch [-f <database>] [-a <audit log>] [-i <interval>] [-k <key> -t <tokens>] [-n <command>]
+//
+// Devices bound to the usbip-host driver are tracked and, at every interval,
+// checked against the endorsement database (see USBIP/endorsements.json),
+// reloaded each time, and optionally against endorsement tokens signed by the
+// Trusted OS public key (see usbip-server).
+//
+// Devices no longer permitted are unbound (`usbip unbind`), so that they are
+// no longer exported and the host (see USBIP/usbip-auto-attach.sh) detaches
+// them, the notify command, if any, is then run with the bus ID as argument to
+// request the host to detach immediately. Each detach is appended to the
+// audit log in JSON lines format.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/attest"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/watch"
+)
+
+const (
+	defaultDatabase = "/var/lib/usb-policy/endorsements.json"
+	defaultAudit    = "/var/lib/usb-policy/detach.jsonl"
+	usbipHostDriver = "/sys/bus/usb/drivers/usbip-host"
+)
+
+// busIDPattern matches USB device bus IDs, excluding interfaces and driver
+// control files.
+var busIDPattern = regexp.MustCompile(`^\d+-[\d.]+$`)
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("usbip-watch: ")
+}
+
+// usbipTransport implements watch.Transport with the usbip tool.
+type usbipTransport struct {
+	notify string
+}
+
+func (t *usbipTransport) Unbind(busID string) error {
+	if out, err := exec.Command("usbip", "unbind", "-b", busID).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v, %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (t *usbipTransport) Detach(busID string) error {
+	if t.notify == "" {
+		return nil
+	}
+
+	if out, err := exec.Command(t.notify, busID).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v, %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// bound returns the identity of devices bound to the usbip-host driver,
+// indexed by bus ID.
+func bound() (devices map[string]endorsement.Device, err error) {
+	entries, err := os.ReadDir(usbipHostDriver)
+
+	if err != nil {
+		return
+	}
+
+	devices = make(map[string]endorsement.Device)
+
+	for _, e := range entries {
+		busID := e.Name()
+
+		if !busIDPattern.MatchString(busID) {
+			continue
+		}
+
+		ud, err := usb.ReadSysfs(busID)
+
+		if err != nil {
+			log.Printf("busid=%s, %v", busID, err)
+			continue
+		}
+
+		d, err := endorsement.Identity(ud)
+
+		if err != nil {
+			log.Printf("busid=%s, %v", busID, err)
+			continue
+		}
+
+		devices[busID] = d
+	}
+
+	return
+}
+
+// loadCache reads the endorsement database, on errors an empty cache is
+// returned so that all devices are detached.
+func loadCache(path string) *endorsement.Cache {
+	cache := endorsement.NewCache()
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		log.Print(err)
+		return cache
+	}
+
+	if _, err = cache.Import(data); err != nil {
+		log.Printf("%s: %v", path, err)
+		cache.Reset()
+	}
+
+	return cache
+}
+
+func loadTokens(key string, path string) (tokens *endorsement.Tokens, err error) {
+	pub, err := attest.ParsePublicKey(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key, %v", err)
+	}
+
+	tokens = endorsement.NewTokens(pub)
+
+	if path == "" {
+		return
+	}
+
+	text, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	if _, err = tokens.Load(text); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return
+}
+
+func main() {
+	dbPath := flag.String("f", defaultDatabase, "endorsement database")
+	auditPath := flag.String("a", defaultAudit, "audit log")
+	interval := flag.Duration("i", 5*time.Second, "check interval")
+	key := flag.String("k", "", "Trusted OS public key (hex), enables token verification")
+	tokensPath := flag.String("t", "", "endorsement tokens")
+	notify := flag.String("n", "", "command run with the bus ID of detached devices")
+	flag.Parse()
+
+	if err := os.MkdirAll(filepath.Dir(*auditPath), 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	audit, err := os.OpenFile(*auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer audit.Close()
+
+	w := watch.NewWatcher(nil, &usbipTransport{notify: *notify}, audit)
+
+	if *key != "" {
+		if w.Tokens, err = loadTokens(*key, *tokensPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for {
+		devices, err := bound()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w.Endorsements = loadCache(*dbPath)
+		w.Sync(devices)
+		w.Check()
+
+		time.Sleep(*interval)
+	}
+}
@@ -24,7 +24,11 @@ const (
 
 	// Main OS
 	NonSecureStart = 0x80000000
-	NonSecureSize  = 0x10000000 // 256MB
+	NonSecureSize  = 0x0ff00000 // 255MB
+
+	// Normal World and Trusted Applet mailbox (see util/mailbox)
+	MailboxStart = 0x8ff00000
+	MailboxSize  = 0x00100000 // 1MB
 )
 
 const textStartWord = 0x010db303
@@ -35,3 +35,8 @@ func TestDataAbort(tag string) {
 	log.Printf("%s is about to trigger a data abort", tag)
 	*p = 0xab
 }
+
+// Mailbox returns the Normal World and Trusted Applet mailbox memory.
+func Mailbox() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(uintptr(MailboxStart))), MailboxSize)
+}
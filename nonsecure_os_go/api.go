@@ -7,13 +7,29 @@ package main
 
 import (
 	"github.com/usbarmory/GoTEE/syscall"
+
+	"github.com/usbarmory/GoTEE-example/util"
 )
 
 const (
-	SYS_WRITE = syscall.SYS_WRITE
-	SYS_EXIT  = syscall.SYS_EXIT
+	SYS_WRITE       = syscall.SYS_WRITE
+	SYS_EXIT        = syscall.SYS_EXIT
+	SYS_ATTEST      = util.SYS_ATTEST
+	SYS_ENDORSEMENT = util.SYS_ENDORSEMENT
+	SYS_REENDORSE   = util.SYS_REENDORSE
+	SYS_FLAG        = util.SYS_FLAG
+	SYS_DOORBELL    = util.SYS_DOORBELL
+	SYS_NS_RPC      = util.SYS_NS_RPC
+	SYS_WRITE_BUF   = util.SYS_WRITE_BUF
 )
 
 // defined in api_*.s
 func printSecure(byte)
+func writeSecure(buf []byte) int
 func exit()
+func handshake(buf []byte) int
+func usbFilter(buf []byte) int
+func usbReendorse(buf []byte) int
+func usbFlag(buf []byte) int
+func doorbell() int
+func nsRPC(buf []byte) int
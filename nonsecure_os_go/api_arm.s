@@ -14,6 +14,18 @@ TEXT ·printSecure(SB),$0-1
 
 	RET
 
+// func writeSecure(buf []byte) int
+TEXT ·writeSecure(SB),$0-16
+	MOVW	$const_SYS_WRITE_BUF, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
+
 // func exit()
 TEXT ·exit(SB),$0
 	MOVW	$const_SYS_EXIT, R0
@@ -21,3 +33,73 @@ TEXT ·exit(SB),$0
 	WORD	$0xe1600070 // smc 0
 
 	RET
+
+// func handshake(buf []byte) int
+TEXT ·handshake(SB),$0-16
+	MOVW	$const_SYS_ATTEST, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
+
+// func usbFilter(buf []byte) int
+TEXT ·usbFilter(SB),$0-16
+	MOVW	$const_SYS_ENDORSEMENT, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
+
+// func usbReendorse(buf []byte) int
+TEXT ·usbReendorse(SB),$0-16
+	MOVW	$const_SYS_REENDORSE, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
+
+// func usbFlag(buf []byte) int
+TEXT ·usbFlag(SB),$0-16
+	MOVW	$const_SYS_FLAG, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
+
+// func doorbell() int
+TEXT ·doorbell(SB),$0-4
+	MOVW	$const_SYS_DOORBELL, R0
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func nsRPC(buf []byte) int
+TEXT ·nsRPC(SB),$0-16
+	MOVW	$const_SYS_NS_RPC, R0
+	MOVW	buf_base+0(FP), R1
+	MOVW	buf_len+4(FP), R2
+
+	WORD	$0xe1600070 // smc 0
+
+	MOVW	R0, ret+12(FP)
+
+	RET
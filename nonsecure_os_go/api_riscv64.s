@@ -17,6 +17,19 @@ TEXT ·printSecure(SB),$0-1
 
 	RET
 
+// func writeSecure(buf []byte) int
+TEXT ·writeSecure(SB),$0-32
+	MOV	$const_SYS_WRITE_BUF, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
+
 // func exit()
 TEXT ·exit(SB),$0
 	MOV	$const_SYS_EXIT, A0
@@ -25,3 +38,79 @@ TEXT ·exit(SB),$0
 	ECALL
 
 	RET
+
+// func handshake(buf []byte) int
+TEXT ·handshake(SB),$0-32
+	MOV	$const_SYS_ATTEST, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
+
+// func usbFilter(buf []byte) int
+TEXT ·usbFilter(SB),$0-32
+	MOV	$const_SYS_ENDORSEMENT, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
+
+// func usbReendorse(buf []byte) int
+TEXT ·usbReendorse(SB),$0-32
+	MOV	$const_SYS_REENDORSE, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
+
+// func usbFlag(buf []byte) int
+TEXT ·usbFlag(SB),$0-32
+	MOV	$const_SYS_FLAG, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
+
+// func doorbell() int
+TEXT ·doorbell(SB),$0-8
+	MOV	$const_SYS_DOORBELL, A0
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+0(FP)
+
+	RET
+
+// func nsRPC(buf []byte) int
+TEXT ·nsRPC(SB),$0-32
+	MOV	$const_SYS_NS_RPC, A0
+	MOV	buf_base+0(FP), A1
+	MOV	buf_len+8(FP), A2
+
+	MOV	$0, A7
+	ECALL
+
+	MOV	A0, ret+24(FP)
+
+	RET
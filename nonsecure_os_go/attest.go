@@ -0,0 +1,86 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/attest"
+)
+
+// AttestationKey is the hex encoded device attestation public key, enrolled
+// at build time (see Makefile), against which applet quotes are verified.
+var AttestationKey string
+
+// verifyQuote verifies a quote against the enrolled device attestation key,
+// quotes are rejected when no key is enrolled.
+func verifyQuote(q *util.Quote) (err error) {
+	pub, err := attest.ParsePublicKey(AttestationKey)
+
+	if err != nil {
+		return
+	}
+
+	return attest.Verify(q, pub)
+}
+
+// attestApplet performs the Normal World side of the mutual attestation
+// handshake with the Trusted Applet (see util.HandshakeRequest), over a nonce
+// issued by the Trusted OS.
+func attestApplet() (err error) {
+	var ch util.Challenge
+	var res util.HandshakeResponse
+
+	if err = secureCall("NS.GetChallenge", struct{}{}, &ch); err != nil {
+		return
+	}
+
+	nonce := ch.Nonce
+
+	buf := make([]byte, util.HandshakeResponseSize)
+	copy(buf, nonce[:])
+
+	log.Printf("supervisor requests applet attestation")
+
+	if n := handshake(buf); n < util.HandshakeResponseSize {
+		return fmt.Errorf("invalid handshake response (%d)", n)
+	}
+
+	if err = res.UnmarshalBinary(buf); err != nil {
+		return
+	}
+
+	switch res.Status {
+	case util.HandshakeAccepted:
+	case util.HandshakeRejected:
+		return errors.New("Normal World rejected by applet")
+	case util.HandshakeUnavailable:
+		return errors.New("applet unavailable")
+	default:
+		return fmt.Errorf("invalid handshake status (%d)", res.Status)
+	}
+
+	q, err := attest.ParseQuote(res.Quote)
+
+	if err != nil {
+		return
+	}
+
+	if q.Nonce != nonce {
+		return attest.ErrNonce
+	}
+
+	if err = verifyQuote(q); err != nil {
+		return
+	}
+
+	log.Printf("supervisor attested applet:%x key:%x", q.Applet, q.PublicKey)
+
+	return
+}
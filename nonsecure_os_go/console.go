@@ -0,0 +1,31 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// secureConsole implements io.Writer over the bulk write monitor call, to
+// avoid a world switch for each byte written by printSecure().
+type secureConsole struct{}
+
+func (secureConsole) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		r := writeSecure(p[:min(len(p), util.MaxWriteSize)])
+
+		if r <= 0 {
+			return n, io.ErrShortWrite
+		}
+
+		n += r
+		p = p[r:]
+	}
+
+	return
+}
@@ -0,0 +1,90 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// secureEndorser implements filter.Endorser through monitor calls to the
+// Trusted OS endorsement cache.
+type secureEndorser struct{}
+
+// Check submits a batch of packets, received from a device, to the secure
+// side endorsement cache and returns its verdict.
+func (secureEndorser) Check(b *endorsement.Batch) (v *endorsement.Verdict, err error) {
+	req, err := b.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, endorsement.BatchBufferSize)
+	copy(buf, req)
+
+	n := usbFilter(buf)
+
+	if n <= 0 || n > len(buf) {
+		return nil, fmt.Errorf("invalid verdict (%d)", n)
+	}
+
+	v = &endorsement.Verdict{}
+
+	if err = v.UnmarshalBinary(buf[:n]); err != nil {
+		return
+	}
+
+	if len(v.Permitted) != len(b.Packets) {
+		return nil, errors.New("invalid verdict count")
+	}
+
+	return
+}
+
+// Request raises a re-endorsement request, for review by the Trusted OS
+// operator, reporting the blocked packets of a device.
+func (secureEndorser) Request(b *endorsement.Batch) (queued bool, err error) {
+	req, err := b.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	switch usbReendorse(req) {
+	case 1:
+		queued = true
+	case 0:
+		// not required or already pending
+	default:
+		err = errors.New("request rejected")
+	}
+
+	return
+}
+
+// Flag reports suspicious activity of a device to the Trusted OS, which
+// downgrades or revokes its endorsement.
+func (secureEndorser) Flag(f *endorsement.Flag) (applied bool, err error) {
+	req, err := f.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	switch usbFlag(req) {
+	case 1:
+		applied = true
+	case 0:
+		// not endorsed
+	default:
+		err = errors.New("flag rejected")
+	}
+
+	return
+}
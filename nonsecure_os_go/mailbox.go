@@ -0,0 +1,127 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/mem"
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/attest"
+	"github.com/usbarmory/GoTEE-example/util/mailbox"
+)
+
+// appletMailbox is the Normal World side of the mailbox shared with the
+// Trusted Applet.
+var appletMailbox *mailbox.Mailbox
+
+// initMailbox initializes the mailbox, as required before the first doorbell.
+func initMailbox() (err error) {
+	if appletMailbox, err = mailbox.New(mem.Mailbox()); err != nil {
+		return
+	}
+
+	appletMailbox.Reset()
+
+	return
+}
+
+// exchange posts requests to the mailbox, rings the doorbell once and
+// returns the applet responses.
+func exchange(reqs []*mailbox.Message) (res []*mailbox.Message, err error) {
+	if appletMailbox == nil {
+		return nil, errors.New("mailbox not initialized")
+	}
+
+	for _, m := range reqs {
+		if err = mailbox.Post(appletMailbox.Requests, m); err != nil {
+			return
+		}
+	}
+
+	n := doorbell()
+
+	for i := 0; i < n; i++ {
+		m, err := mailbox.Fetch(appletMailbox.Responses)
+
+		if err != nil {
+			return res, err
+		}
+
+		res = append(res, m)
+	}
+
+	if n != len(reqs) {
+		return res, fmt.Errorf("served %d out of %d requests", n, len(reqs))
+	}
+
+	return
+}
+
+// testMailbox sends a batch of echo and quote requests to the applet through
+// the mailbox, the quote nonce is issued by the Trusted OS.
+func testMailbox() (err error) {
+	var ch util.Challenge
+
+	if err = initMailbox(); err != nil {
+		return
+	}
+
+	if err = secureCall("NS.GetChallenge", struct{}{}, &ch); err != nil {
+		return
+	}
+
+	nonce := ch.Nonce
+
+	echo := bytes.Repeat([]byte("GoTEE"), 1024)
+
+	reqs := []*mailbox.Message{
+		{Type: mailbox.Echo, Tag: 1, Payload: echo},
+		{Type: mailbox.Quote, Tag: 2, Payload: nonce[:]},
+	}
+
+	log.Printf("supervisor posts %d mailbox requests", len(reqs))
+
+	res, err := exchange(reqs)
+
+	if err != nil {
+		return
+	}
+
+	for _, m := range res {
+		if m.Error {
+			return fmt.Errorf("request %d failed, %s", m.Tag, m.Payload)
+		}
+
+		switch m.Tag {
+		case 1:
+			if !bytes.Equal(m.Payload, echo) {
+				return errors.New("echo mismatch")
+			}
+		case 2:
+			q, err := attest.ParseQuote(m.Payload)
+
+			if err != nil {
+				return err
+			}
+
+			if q.Nonce != nonce {
+				return attest.ErrNonce
+			}
+
+			if err = verifyQuote(q); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("supervisor received %d mailbox responses", len(res))
+
+	return
+}
@@ -8,16 +8,19 @@ import (
 	//"crypto/aes"
 	//"crypto/sha256"
 	"log"
-	"os"
 	"runtime"
 	_ "unsafe"
 	"bufio"
 	"strings"
+	"time"
 
 	"github.com/usbarmory/tamago/dma"
 	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
 
 	"github.com/usbarmory/GoTEE-example/mem"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/usb"
 	//usbarmory "github.com/usbarmory/tamago/board/usbarmory/mk2"
 	//"time"
 )
@@ -66,125 +69,9 @@ func fromHexChar(c byte) int {
     return -1
 }
 
-type deviceID struct {
-	vendorID uint16
-	productID uint16
-}
-
-type endorsementStatus uint8
-
-const (
-	endorsementUnknown endorsementStatus = iota
-	endorsementActive
-	endorsementExpired
-)
-
-type endorsementEntry struct {
-	device deviceID
-	status endorsementStatus
-	TTL uint32
-	log packetRingBuffer
-}
-
-type endorsementCache struct {
-	entries map[deviceID]*endorsementEntry
-}
-
-func newEndorsementCache() *endorsementCache {
-	return &endorsementCache{
-		entries: make(map[deviceID]*endorsementEntry),
-	}
-}
-
-// ======= Circular buffer logic =======
-
-const (
-	maxPacketsPerDevice = 256
-	maxLoggedBytesPerPacket = 64
-)
-
-type packetRecord struct {
-	len int
-	data [maxLoggedBytesPerPacket] byte
-}
-
-type packetRingBuffer struct {
-	next int
-	wrapped bool
-	records [maxPacketsPerDevice] packetRecord
-}
-
-func (rb *packetRingBuffer) logPacket(pkt []byte) {
-	if len(pkt) == 0 {
-		return
-	}
-	if len(pkt) > maxLoggedBytesPerPacket {
-		pkt = pkt[:maxLoggedBytesPerPacket]
-	}
-
-	rec := &rb.records[rb.next]
-	rec.len = len(pkt)
-
-	copy(rec.data[:], pkt)
-	rb.next++
-	if rb.next >= maxPacketsPerDevice{
-		rb.next = 0
-		rb.wrapped = true
-	}
-}
-
-func (rb *packetRingBuffer) dumpToLog(dev deviceID){
-	start := 0
-	if rb.wrapped {
-		start = rb.next
-	}
-	idx := 0
-	for i := 0; i < maxPacketsPerDevice; i++ {
-		pos := (start + i) % maxPacketsPerDevice
-		rec := rb.records[pos]
-		if rec.len == 0 {
-			continue
-		}
-		idx++
-		log.Printf("[USB] log[%d] dev=%s len=%d data=% x", idx, dev, rec.len, rec.data[:rec.len])
-	}
-}
-
-// ======= Checking endorsement cache and logging packets =======
-
-var usbEndorsements = newEndorsementCache()
-
-func handleUsbPacketFromDevice(dev deviceID, payload []byte) bool {
-	entry, ok := usbEndorsements.entries[dev]
-
-	if !ok {
-		log.Printf("[USB] BLOCK dev=%s (not endorsed) len=%d", dev, len(payload))
-		// TODO: Trigger re-endorsement request via applet/TEE
-		return false
-	}
-
-	// if TTL exhausted or status not active, mark as expired and block
-	if entry.status != endorsementActive || entry.TTL == 0 {
-		if entry.status != endorsementExpired {
-			entry.status = endorsementExpired
-		}
-		log.Printf("[USB] BLOCK dev=%s (endorsement expired, TTL=%d) len=%d",
-		    dev, entry.TTL, len(payload))
-		// TODO: Call placeholder re-endorsement function here
-		return false
-	}
-
-	entry.TTL--
-	entry.log.logPacket(payload)
-
-	log.Printf("[USB] PASS dev=%s len=%d remaining_TTL=%d", dev, len(payload), entry.TTL)
-	return true
-}
-
-
 func init() {
 	log.SetFlags(log.Ltime)
-	log.SetOutput(os.Stdout)
+	log.SetOutput(secureConsole{})
 
 	if !imx6ul.Native {
 		return
@@ -199,6 +86,22 @@ func init() {
 	}
 }
 
+// Descriptors of the replayed device (046d:c53f), a composite receiver with
+// HID boot keyboard and mouse interfaces (see USBIP/descriptors).
+const (
+	embeddedDeviceDescriptor = "12010002000000406d043fc5010401020001"
+	embeddedConfigDescriptor = "09023b00020104a031" +
+		"090400000103010100" + "092111010001223f00" + "0705810308000a" +
+		"090401000103010200" + "092111010001223200" + "07058203080001"
+	embeddedKeyboardReport = "05010906a101050719e029e71500250175019508810295017508810195057501" +
+		"050819012905910295017503910195067508150025650507190029658100c0"
+	embeddedMouseReport = "05010902a1010901a100050919012903150025019503750181029501750581" +
+		"010501093009311581257f750895028106c0c0"
+)
+
+// replayInterval is the interval between replayed packets.
+const replayInterval = 100 * time.Millisecond
+
 var embeddedKeyboardPackets = `
 6 0000160000000000
 6 0000000000000000
@@ -210,9 +113,56 @@ var embeddedKeyboardPackets = `
 func main() {
 	log.Printf("%s/%s (%s) • system/supervisor (Non-secure:%v)", runtime.GOOS, runtime.GOARCH, runtime.Version(), imx6ul.ARM.NonSecure())
 
+	attested := attestApplet()
+
+	if attested != nil {
+		log.Printf("supervisor could not attest applet, %v", attested)
+	}
+
+	// test RPC interface
+	testRPC()
+
+	// the applet serves mailbox requests only after mutual attestation
+	if attested == nil {
+		if err := testMailbox(); err != nil {
+			log.Printf("supervisor could not exchange mailbox requests, %v", err)
+		}
+	}
+
+	if err := loadTokens(); err != nil {
+		log.Printf("supervisor could not load endorsement tokens, devices are blocked, %v", err)
+	}
+
 	scanner := bufio.NewScanner(strings.NewReader(embeddedKeyboardPackets))
 	lineNum := 0
 
+	deviceDesc, _ := decodeHexString(embeddedDeviceDescriptor)
+	configDesc, _ := decodeHexString(embeddedConfigDescriptor)
+
+	dev, err := endorsement.Identify(deviceDesc, configDesc, nil)
+
+	if err != nil {
+		log.Fatalf("supervisor could not identify device, %v", err)
+	}
+
+	log.Printf("[USB] dev=%s bcdDevice=%04x class=%s interfaces=%v", dev, dev.BCDDevice, dev.Class, dev.Interfaces)
+
+	desc, err := usb.ParseDevice(deviceDesc, configDesc)
+
+	if err != nil {
+		log.Fatalf("supervisor could not parse device descriptors, %v", err)
+	}
+
+	for iface, report := range []string{embeddedKeyboardReport, embeddedMouseReport} {
+		buf, _ := decodeHexString(report)
+
+		if err = desc.SetReport(uint8(iface), buf); err != nil {
+			log.Printf("[USB] invalid report descriptor dev=%s interface=%d, %v", dev, iface, err)
+		}
+	}
+
+	var pkts []filter.Packet
+
 	for scanner.Scan() {
 		lineNum++
 		line := strings.TrimSpace(scanner.Text())
@@ -224,30 +174,28 @@ func main() {
 		hexStr := strings.ReplaceAll(parts[1], ":", "")
 		pkt, _ := decodeHexString(hexStr)
 
-		dev := deviceID{
-			vendorID: 0x046d,
-			productID: 0xc53f,
-		}
+		pkts = append(pkts, filter.Packet{
+			Time: time.Duration(len(pkts))*replayInterval,
+			Data: pkt,
+		})
+	}
 
-		// Add entry into endorsement cache for testing
-		entry, ok := usbEndorsements.entries[dev]
-		if !ok {
-			entry = &endorsementEntry{
-				device: dev,
-				status: endorsementActive,
-				TTL: 1000, //TBD
+	// packets of devices allowed by policy are submitted to the Trusted OS
+	// endorsement cache as a batch
+	if packetFilter.Allow(desc) {
+		for i, permitted := range packetFilter.Handle(desc, pkts) {
+			if !permitted {
+				log.Printf("[REPLAY] packet %d BLOCKED dev = %s", i, dev)
 			}
-			usbEndorsements.entries[dev] = entry
-		}
-
-		permitted := handleUsbPacketFromDevice(dev, pkt)
-		if !permitted {
-			log.Printf("[REPLAY] packet BLOCKED dev = %s", dev)
 		}
+	} else {
+		log.Printf("[REPLAY] %d packets BLOCKED dev = %s (policy)", len(pkts), dev)
 	}
 
 	log.Printf("[REPLAY] embedded keyboard packet replay complete")
 
+	replayCapture()
+
 	// uncomment to test memory protection
 	//mem.TestAccess("Non-secure OS")
 	//blink_right()
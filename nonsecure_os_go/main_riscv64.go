@@ -7,7 +7,6 @@ package main
 
 import (
 	"log"
-	"os"
 	"runtime"
 	_ "unsafe"
 
@@ -34,12 +33,28 @@ func printk(c byte) {
 
 func init() {
 	log.SetFlags(log.Ltime)
-	log.SetOutput(os.Stdout)
+	log.SetOutput(secureConsole{})
 }
 
 func main() {
 	log.Printf("%s/%s (%s) • supervisor", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
+	attested := attestApplet()
+
+	if attested != nil {
+		log.Printf("supervisor could not attest applet, %v", attested)
+	}
+
+	// test RPC interface
+	testRPC()
+
+	// the applet serves mailbox requests only after mutual attestation
+	if attested == nil {
+		if err := testMailbox(); err != nil {
+			log.Printf("supervisor could not exchange mailbox requests, %v", err)
+		}
+	}
+
 	// uncomment to test memory protection
 	// mem.TestAccess("supervisor")
 
@@ -0,0 +1,75 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/attest"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// devicePolicy is evaluated on the descriptors of each device before its
+// packets are submitted to the Trusted OS endorsement cache, it mirrors the
+// host side one (see USBIP/usb-device.policy).
+const devicePolicy = `
+deny has interface 08:*:* and has interface 03:*:*
+deny has interface 03:01:01 and not boot keyboard
+deny has application 0001:0006 and not has interface 03:01:01
+deny has interface 03:*:* and (has endpoint in bulk or has endpoint out bulk)
+allow any
+`
+
+var packetFilter *filter.Filter
+
+func init() {
+	policy, err := usb.ParsePolicy(devicePolicy)
+
+	if err != nil {
+		log.Fatalf("supervisor could not parse device policy, %v", err)
+	}
+
+	packetFilter = filter.New(secureEndorser{}, policy)
+
+	// devices are blocked until endorsement tokens are loaded
+	packetFilter.Tokens = endorsement.NewTokens(nil)
+}
+
+// loadTokens configures the packet filter with the endorsement tokens issued
+// by the Trusted OS (see `token` console command), verified against the
+// enrolled device attestation key (see AttestationKey), so that only devices
+// permitted by a token are submitted for an endorsement verdict.
+//
+// When no key is enrolled, or tokens cannot be retrieved, all devices remain
+// blocked.
+func loadTokens() (err error) {
+	var tokens []string
+
+	pub, err := attest.ParsePublicKey(AttestationKey)
+
+	if err != nil {
+		return
+	}
+
+	if err = secureCall("NS.GetTokens", struct{}{}, &tokens); err != nil {
+		return
+	}
+
+	t := endorsement.NewTokens(pub)
+	n, err := t.Load([]byte(strings.Join(tokens, "\n")))
+
+	if err != nil {
+		return
+	}
+
+	packetFilter.Tokens = t
+	log.Printf("supervisor loaded %d endorsement tokens", n)
+
+	return
+}
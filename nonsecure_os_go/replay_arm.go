@@ -0,0 +1,77 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/usbmon"
+)
+
+// embeddedCapture is a usbmon capture (see CAPTURE in the Makefile) replayed
+// through the packet filter after the embedded keyboard packets.
+//
+//go:embed assets/capture.pcap
+var embeddedCapture []byte
+
+// replayCapture submits the IN payloads of each device observed in the
+// embedded capture to the packet filter, preserving their capture timing.
+func replayCapture() {
+	c, err := usbmon.ReadCapture(bytes.NewReader(embeddedCapture))
+
+	if err != nil {
+		log.Printf("[REPLAY] invalid capture, %v", err)
+		return
+	}
+
+	for _, d := range c.Devices {
+		var pkts []filter.Packet
+
+		for _, p := range d.Payloads {
+			if p.Direction() != usb.IN {
+				continue
+			}
+
+			pkts = append(pkts, filter.Packet{
+				Time:     p.Time.Sub(c.Start),
+				Endpoint: p.Endpoint,
+				Data:     p.Data,
+			})
+		}
+
+		if len(pkts) == 0 {
+			continue
+		}
+
+		desc, err := d.Parse()
+
+		if err != nil {
+			log.Printf("[REPLAY] %d packets BLOCKED addr=%s (%v)", len(pkts), d.Address, err)
+			continue
+		}
+
+		if !packetFilter.Allow(desc) {
+			log.Printf("[REPLAY] %d packets BLOCKED addr=%s (policy)", len(pkts), d.Address)
+			continue
+		}
+
+		blocked := 0
+
+		for _, permitted := range packetFilter.Handle(desc, pkts) {
+			if !permitted {
+				blocked++
+			}
+		}
+
+		log.Printf("[REPLAY] addr=%s vid=%04x pid=%04x packets=%d blocked=%d", d.Address, desc.Descriptor.VendorId, desc.Descriptor.ProductId, len(pkts), blocked)
+	}
+
+	log.Printf("[REPLAY] embedded capture replay complete")
+}
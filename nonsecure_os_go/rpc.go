@@ -0,0 +1,34 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/nsrpc"
+)
+
+// secureCall issues an RPC call to the Trusted OS Normal World services
+// (e.g. NS.GetChallenge, NS.CheckEndorsement).
+func secureCall(serviceMethod string, args any, reply any) error {
+	return nsrpc.Call(nsRPC, serviceMethod, args, reply)
+}
+
+// testRPC requests a challenge nonce via RPC and attempts a call outside the
+// allow-listed services.
+func testRPC() {
+	var ch util.Challenge
+
+	if err := secureCall("NS.GetChallenge", struct{}{}, &ch); err != nil {
+		log.Printf("supervisor NS.GetChallenge error: %v", err)
+	} else {
+		log.Printf("supervisor received challenge nonce via RPC: %x", ch.Nonce[:])
+	}
+
+	err := secureCall("RPC.Echo", "hello", nil)
+	log.Printf("supervisor RPC.Echo error: %v (should not be nil)", err)
+}
@@ -0,0 +1,28 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/usbarmory/GoTEE/syscall"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// secureConsole implements io.Writer over the bulk write system call, to
+// avoid a supervisor call for each byte written by syscall.Print().
+type secureConsole struct{}
+
+func (secureConsole) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		r := min(len(p), util.MaxWriteSize)
+		syscall.Write(util.SYS_WRITE_BUF, p[:r], uint(r))
+
+		n += r
+		p = p[r:]
+	}
+
+	return
+}
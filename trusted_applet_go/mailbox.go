@@ -0,0 +1,132 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/usbarmory/GoTEE/syscall"
+
+	"github.com/usbarmory/GoTEE-example/mem"
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/mailbox"
+)
+
+// handleQuote returns a quote over a nonce issued by the Trusted OS to the
+// Normal World (see NS.GetChallenge), unknown or reused nonces are rejected by
+// the Trusted OS.
+func handleQuote(payload []byte) ([]byte, error) {
+	var ch util.Challenge
+	var q util.Quote
+
+	if len(payload) != len(ch.Nonce) {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	copy(ch.Nonce[:], payload)
+
+	if err := syscall.Call("RPC.Quote", ch, &q); err != nil {
+		return nil, err
+	}
+
+	return q.MarshalBinary()
+}
+
+// handleBatch returns the endorsement cache verdict on a batch of USB
+// packets.
+func handleBatch(payload []byte) ([]byte, error) {
+	var b endorsement.Batch
+	var v endorsement.Verdict
+
+	if err := b.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Call("Endorsement.Check", b, &v); err != nil {
+		return nil, err
+	}
+
+	return v.MarshalBinary()
+}
+
+// handleRequest serves a mailbox request.
+func handleRequest(req *mailbox.Message) (res *mailbox.Message) {
+	var err error
+
+	res = &mailbox.Message{
+		Type: req.Type,
+		Tag:  req.Tag,
+	}
+
+	switch req.Type {
+	case mailbox.Echo:
+		res.Payload = req.Payload
+	case mailbox.Quote:
+		res.Payload, err = handleQuote(req.Payload)
+	case mailbox.Batch:
+		res.Payload, err = handleBatch(req.Payload)
+	default:
+		err = fmt.Errorf("invalid request type %d", req.Type)
+	}
+
+	if err != nil {
+		res.Error = true
+		res.Payload = []byte(err.Error())
+	}
+
+	return
+}
+
+// serveMailbox waits for a Normal World doorbell and serves the requests
+// posted to the mailbox, the mailbox contents are untrusted and any
+// inconsistency stops the service until the next doorbell. It must only be
+// invoked once the Normal World has been attested (see serveHandshake).
+func serveMailbox() {
+	var n int
+
+	m, err := mailbox.New(mem.Mailbox())
+
+	if err != nil {
+		log.Printf("applet: invalid mailbox, %v", err)
+		return
+	}
+
+	log.Printf("applet: waiting for Normal World mailbox requests")
+
+	if err = syscall.Call("RPC.Mailbox", struct{}{}, nil); err != nil {
+		log.Printf("applet: no Normal World doorbell (%v)", err)
+		return
+	}
+
+	for {
+		req, err := mailbox.Fetch(m.Requests)
+
+		if errors.Is(err, mailbox.ErrEmpty) {
+			break
+		}
+
+		if err != nil {
+			log.Printf("applet: could not fetch mailbox request, %v", err)
+			break
+		}
+
+		if err = mailbox.Post(m.Responses, handleRequest(req)); err != nil {
+			log.Printf("applet: could not post mailbox response, %v", err)
+			break
+		}
+
+		n += 1
+	}
+
+	log.Printf("applet: served %d mailbox requests", n)
+
+	if err = syscall.Call("RPC.MailboxServed", n, nil); err != nil {
+		log.Printf("applet: RPC.MailboxServed error: %v", err)
+	}
+}
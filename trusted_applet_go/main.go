@@ -7,27 +7,43 @@ package main
 
 import (
 	"log"
-	"os"
 	"runtime"
 	"time"
 	//"crypto/aes"
-	"crypto/ed25519"
 	//"crypto/rand"
 	"crypto/sha256"
 	//"encoding/binary"
-	//"encoding/hex"
+	"encoding/hex"
+	"errors"
+	"fmt"
 
 	"github.com/usbarmory/GoTEE/applet"
 	"github.com/usbarmory/GoTEE/syscall"
 
 	"github.com/usbarmory/GoTEE-example/mem"
 	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/attest"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
 	//"github.com/usbarmory/tamago/soc/nxp/imx6ul"
 )
 
+// NormalWorldMeasurement is the hex encoded SHA-256 measurement of the
+// Normal World image expected by the applet during mutual attestation, it is
+// set at build time (see Makefile).
+var NormalWorldMeasurement string
+
+// AttestationKey is the hex encoded device attestation public key, enrolled
+// at build time (see Makefile), against which quotes are verified.
+var AttestationKey string
+
+// InsecureNormalWorld, when set to "1" at build time (see Makefile), accepts
+// any Normal World image in the absence of a reference measurement, it must
+// only be used for development.
+var InsecureNormalWorld string
+
 func init() {
 	log.SetFlags(log.Ltime)
-	log.SetOutput(os.Stdout)
+	log.SetOutput(secureConsole{})
 
 	// yield to monitor (w/ err != nil) on runtime panic
 	runtime.Exit = applet.Crash
@@ -53,13 +69,6 @@ func testRPC() {
 	}
 }
 
-// Quote is a tiny stand-in for an attestation structure.
-type Quote struct {
-	Nonce    [32]byte
-	CodeHash [32]byte
-	BuildID  string
-}
-
 func testChallenge() {
 	var ch util.Challenge
 	log.Printf("applet: requesting challenge nonce via RPC")
@@ -70,53 +79,143 @@ func testChallenge() {
 	log.Printf("applet: received challenge nonce: %x", ch.Nonce[:])
 }
 
-// testEd25519 uses randomness from syscall.GetRandom (monitor → hardware RNG)
-// and checks sign/verify, like what you’ll use for mutual attestation.
-func testEd25519() {
-	log.Printf("applet: testing ed25519 (using syscall.GetRandom for entropy)")
-
-	// 1) get 32 bytes of entropy from the monitor (which uses RNGB under the hood)
-	seed := make([]byte, ed25519.SeedSize)
-	syscall.GetRandom(seed, uint(len(seed)))
-
-	// 2) derive keypair from seed
-	priv := ed25519.NewKeyFromSeed(seed)
-	pub := priv.Public().(ed25519.PublicKey)
-
-	// 3) build a fake "quote"
-	var q Quote
-	var nonce [32]byte
-	syscall.GetRandom(nonce[:], uint(len(nonce)))
-	q.Nonce = nonce
-	q.CodeHash = sha256.Sum256([]byte("test-applet-code"))
-	q.BuildID = "trusted-applet-test-v1"
-
-	// canonical hash of the quote
-	h := sha256.New()
-	h.Write(q.Nonce[:])
-	h.Write(q.CodeHash[:])
-	h.Write([]byte(q.BuildID))
-	msg := h.Sum(nil)
-
-	sig := ed25519.Sign(priv, msg)
-	ok := ed25519.Verify(pub, msg, sig)
-
-	log.Printf("applet: quote nonce    = %x", q.Nonce[:])
-	log.Printf("applet: quote codehash = %x", q.CodeHash[:])
-	log.Printf("applet: sig            = %x", sig)
-	log.Printf("applet: verify(pub, msg, sig) = %v", ok)
+// testQuote requests attestation evidence for a fresh challenge, verifies its
+// signature and attempts its replay.
+func testQuote() {
+	var ch util.Challenge
+	var q util.Quote
+
+	if err := syscall.Call("RPC.GetChallenge", struct{}{}, &ch); err != nil {
+		log.Printf("applet: RPC.GetChallenge error: %v", err)
+		return
+	}
+
+	log.Printf("applet: requesting quote via RPC")
+
+	if err := syscall.Call("RPC.Quote", ch, &q); err != nil {
+		log.Printf("applet: RPC.Quote error: %v", err)
+		return
+	}
+
+	log.Printf("applet: quote applet = %x", q.Applet[:])
+	log.Printf("applet: quote os     = %x", q.OS[:])
+	log.Printf("applet: quote key    = %x", q.PublicKey)
+
+	if q.Nonce != ch.Nonce {
+		log.Printf("applet: quote nonce mismatch")
+		return
+	}
+
+	pub, err := attest.ParsePublicKey(AttestationKey)
+
+	if err != nil {
+		log.Printf("applet: cannot verify quote, %v", err)
+		return
+	}
+
+	log.Printf("applet: verify(quote) = %v", attest.Verify(&q, pub) == nil)
+
+	// replay test
+	err = syscall.Call("RPC.Quote", ch, &q)
+	log.Printf("applet: replayed quote request error: %v (should not be nil)", err)
 
 	// tamper test
-	h2 := sha256.New()
-	h2.Write(q.Nonce[:])
+	q.Applet = sha256.Sum256([]byte("different-code"))
+	log.Printf("applet: verify(tampered_quote) = %v (should be false)", attest.Verify(&q, pub) == nil)
+}
+
+// testEndorsement endorses the device replayed by the Normal World, it must
+// precede the handshake as the Normal World waits for its completion.
+func testEndorsement() {
+	var e endorsement.Entry
+	var entries []endorsement.Entry
+
+	g := endorsement.Grant{
+		Device: endorsement.DeviceID{
+			VendorID:  0x046d,
+			ProductID: 0xc53f,
+		},
+		// only as a HID boot keyboard and mouse composite device
+		Match: endorsement.Match{
+			Interfaces: []endorsement.Class{
+				endorsement.HIDBootKeyboard,
+				endorsement.HIDBootMouse,
+			},
+		},
+		Lifetime: 10 * time.Minute,
+		Budget:   1000,
+		Note:     "replay test",
+	}
 
-	diffHash := sha256.Sum256([]byte("different-code"))
-	h2.Write(diffHash[:])
+	log.Printf("applet: endorsing USB device %s via RPC", g.Device)
 
-	h2.Write([]byte(q.BuildID))
-	msgTampered := h2.Sum(nil)
-	ok2 := ed25519.Verify(pub, msgTampered, sig)
-	log.Printf("applet: verify(pub, tampered_msg, sig) = %v (should be false)", ok2)
+	if err := syscall.Call("Endorsement.Add", g, &e); err != nil {
+		log.Printf("applet: Endorsement.Add error: %v", err)
+		return
+	}
+
+	// the applet time is served by the monitor (SYS_NANOTIME)
+	log.Printf("applet: endorsement expires in %v", time.Unix(e.Expiry, 0).Sub(time.Now()).Round(time.Second))
+
+	if err := syscall.Call("Endorsement.List", struct{}{}, &entries); err != nil {
+		log.Printf("applet: Endorsement.List error: %v", err)
+		return
+	}
+
+	for _, e := range entries {
+		log.Printf("applet: endorsement dev=%s identity=%s status=%s expiry=%d budget=%d note=%q", e.Device, e.Match, e.Status, e.Expiry, e.Budget, e.Note)
+	}
+}
+
+// verifyNormalWorld checks the Normal World measurement, as recorded by the
+// monitor, against the expected one.
+func verifyNormalWorld(os util.Digest) error {
+	if NormalWorldMeasurement == "" {
+		if InsecureNormalWorld != "1" {
+			return errors.New("no reference measurement")
+		}
+
+		log.Printf("applet: *insecure* no Normal World reference measurement, accepting %x", os[:])
+		return nil
+	}
+
+	if hex.EncodeToString(os[:]) != NormalWorldMeasurement {
+		return fmt.Errorf("measurement mismatch (%x)", os[:])
+	}
+
+	return nil
+}
+
+// serveHandshake serves a Normal World attestation request, the applet quote
+// is returned only after the Normal World measurement is verified. Services
+// to the Normal World must only be released when it returns no error.
+func serveHandshake() (err error) {
+	var req util.HandshakeRequest
+	var q util.Quote
+
+	log.Printf("applet: waiting for Normal World attestation request")
+
+	if err = syscall.Call("RPC.Handshake", struct{}{}, &req); err != nil {
+		return fmt.Errorf("no Normal World attestation request (%v)", err)
+	}
+
+	res := util.HandshakeResponse{
+		Status: util.HandshakeRejected,
+	}
+
+	if err = verifyNormalWorld(req.OS); err != nil {
+		err = fmt.Errorf("Normal World rejected, %v", err)
+	} else if err = syscall.Call("RPC.Quote", util.Challenge{Nonce: req.Nonce}, &q); err != nil {
+		err = fmt.Errorf("RPC.Quote error: %v", err)
+	} else if res.Quote, err = q.MarshalBinary(); err == nil {
+		res.Status = util.HandshakeAccepted
+	}
+
+	if rerr := syscall.Call("RPC.HandshakeResponse", res, nil); rerr != nil && err == nil {
+		err = fmt.Errorf("RPC.HandshakeResponse error: %v", rerr)
+	}
+
+	return
 }
 
 func main() {
@@ -128,10 +227,22 @@ func main() {
 	// test RPC interface
 	testRPC()
 
-	testEd25519()
-
 	testChallenge()
 
+	testQuote()
+
+	// test USB endorsement cache
+	testEndorsement()
+
+	// test mutual attestation with Normal World, bulk requests are only
+	// served to an attested Normal World
+	if err := serveHandshake(); err != nil {
+		log.Printf("applet: Normal World services withheld, %v", err)
+	} else {
+		log.Printf("applet: Normal World attested, services released")
+		serveMailbox()
+	}
+
 	log.Printf("applet will sleep for 5 seconds")
 
 	ledStatus := util.LEDStatus{
@@ -25,14 +25,19 @@ func goHandler(ctx *monitor.ExecCtx) (err error) {
 	switch {
 	case ctx.A0() == syscall.SYS_WRITE:
 		// Override write syscall to avoid interleaved logs and to log
-		// simultaneously to remote terminal and serial console.
-		if Console.Term != nil {
-			util.BufferedTermLog(byte(ctx.A1()), ctx.Secure(), Console.Term)
-		} else {
-			util.BufferedStdoutLog(byte(ctx.A1()), ctx.Secure())
-		}
+		// simultaneously to remote terminals and serial console.
+		logStream(ctx).WriteByte(byte(ctx.A1()))
+	case ctx.A0() == util.SYS_WRITE_BUF:
+		// bulk write syscall on both security states
+		return writeBuffer(ctx)
 	case !ctx.Secure() && ctx.A0() == syscall.SYS_EXIT:
 		ctx.Stop()
+	case !ctx.Secure() && ctx.A0() == util.SYS_ATTEST:
+		return attestApplet(ctx)
+	case !ctx.Secure() && ctx.A0() == util.SYS_DOORBELL:
+		return ringDoorbell(ctx)
+	case !ctx.Secure() && ctx.A0() == util.SYS_NS_RPC:
+		return serveNonSecureRPC(ctx)
 	default:
 		return defaultHandler(ctx)
 	}
@@ -40,6 +45,44 @@ func goHandler(ctx *monitor.ExecCtx) (err error) {
 	return
 }
 
+// logStream returns the log stream of an execution context, registering a
+// default one if not already present.
+func logStream(ctx *monitor.ExecCtx) *util.LogStream {
+	if s := util.Logs.Stream(ctx); s != nil {
+		return s
+	}
+
+	if !ctx.Secure() {
+		return util.Logs.Register(ctx, "os", util.Red)
+	}
+
+	return util.Logs.Register(ctx, "applet", util.Green)
+}
+
+// writeBuffer handles a SYS_WRITE_BUF monitor call, the caller buffer is
+// validated against the caller memory and copied out to the console.
+func writeBuffer(ctx *monitor.ExecCtx) (err error) {
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n <= 0 {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, min(n, util.MaxWriteSize))
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	logStream(ctx).Write(buf)
+
+	ctx.Ret(len(buf))
+
+	return
+}
+
 func sbiHandler(ctx *monitor.ExecCtx) (err error) {
 	// SBI v0.2 or higher calls are treated separately from GoTEE calls
 	if ctx.X17 != 0 {
@@ -0,0 +1,154 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/usbarmory/GoTEE/monitor"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// handshakeTimeout is the maximum time each party waits for the other during
+// a mutual attestation handshake.
+const handshakeTimeout = 10 * time.Second
+
+// handshake represents a Normal World attestation request in flight.
+type handshake struct {
+	req util.HandshakeRequest
+	res chan util.HandshakeResponse
+}
+
+var (
+	// handshakes queues Normal World requests for the applet
+	handshakes = make(chan *handshake)
+
+	// pending holds the request being served by the applet
+	pending struct {
+		sync.Mutex
+		h *handshake
+	}
+
+	appletRunning      atomic.Bool
+	normalWorldRunning atomic.Bool
+)
+
+// lastMeasurement returns the most recent event log digest of any of the
+// given types.
+func lastMeasurement(types ...util.EventType) (d util.Digest, ok bool) {
+	events := measurements.Events()
+
+	for i := len(events) - 1; i >= 0; i-- {
+		for _, t := range types {
+			if events[i].Type == t {
+				return events[i].Digest, true
+			}
+		}
+	}
+
+	return
+}
+
+// osMeasurement returns the measurement of the running Normal World image,
+// either the GoTEE Normal World or a Linux kernel.
+func osMeasurement() (util.Digest, bool) {
+	return lastMeasurement(util.EventNormalWorld, util.EventKernel)
+}
+
+// attestApplet handles a SYS_ATTEST monitor call from the Normal World, the
+// request is forwarded to the applet along with the Normal World measurement
+// and the applet response is returned to the caller.
+func attestApplet(ctx *monitor.ExecCtx) (err error) {
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n < util.HandshakeResponseSize {
+		return errors.New("invalid handshake buffer size")
+	}
+
+	buf := make([]byte, n)
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	h := &handshake{
+		res: make(chan util.HandshakeResponse, 1),
+	}
+
+	copy(h.req.Nonce[:], buf[0:util.HandshakeRequestSize])
+	h.req.OS, _ = osMeasurement()
+
+	res := util.HandshakeResponse{
+		Status: util.HandshakeUnavailable,
+	}
+
+	// the nonce, issued by NS.GetChallenge, is consumed by the applet quote
+	if appletRunning.Load() {
+		log.Printf("SM forwarding Normal World attestation request (os:%x)", h.req.OS)
+
+		timeout := time.After(handshakeTimeout)
+
+		select {
+		case handshakes <- h:
+			select {
+			case res = <-h.res:
+			case <-timeout:
+			}
+		case <-timeout:
+		}
+	}
+
+	if buf, err = res.MarshalBinary(); err != nil {
+		return
+	}
+
+	ctx.Poke(off, buf)
+	ctx.Ret(len(buf))
+
+	return
+}
+
+// Handshake waits for a Normal World attestation request.
+func (r *RPC) Handshake(_ struct{}, out *util.HandshakeRequest) error {
+	if !normalWorldRunning.Load() {
+		return errors.New("Normal World not running")
+	}
+
+	select {
+	case h := <-handshakes:
+		pending.Lock()
+		pending.h = h
+		pending.Unlock()
+
+		*out = h.req
+	case <-time.After(handshakeTimeout):
+		return errors.New("timeout")
+	}
+
+	return nil
+}
+
+// HandshakeResponse returns the applet response to the pending Normal World
+// attestation request.
+func (r *RPC) HandshakeResponse(res util.HandshakeResponse, _ *bool) error {
+	pending.Lock()
+	defer pending.Unlock()
+
+	if pending.h == nil {
+		return errors.New("no pending request")
+	}
+
+	pending.h.res <- res
+	pending.h = nil
+
+	return nil
+}
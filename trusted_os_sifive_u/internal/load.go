@@ -14,6 +14,7 @@ import (
 
 	"github.com/usbarmory/GoTEE-example/mem"
 	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/logging"
 
 	"github.com/usbarmory/armory-boot/exec"
 )
@@ -30,6 +31,8 @@ func loadApplet() (ta *monitor.ExecCtx, err error) {
 		ELF:    TA,
 	}
 
+	measure(util.EventApplet, "trusted_applet.elf", TA)
+
 	if err = image.Load(); err != nil {
 		return
 	}
@@ -38,11 +41,17 @@ func loadApplet() (ta *monitor.ExecCtx, err error) {
 		return nil, fmt.Errorf("SM could not load applet, %v", err)
 	}
 
-	log.Printf("SM loaded applet addr:%#x entry:%#x size:%d", ta.Memory.Start(), ta.PC, len(TA))
+	loaderLog.Info("loaded applet",
+		"addr", logging.Hex(ta.Memory.Start()),
+		"entry", logging.Hex(ta.PC),
+		"size", len(TA))
 
 	// set applet as ELF debugging target
 	util.SetDebugTarget(image.ELF)
 
+	// derive attestation key
+	initAttestationKey()
+
 	// set memory protection function
 	ta.PMP = configurePMP
 
@@ -65,6 +74,8 @@ func loadSupervisor() (os *monitor.ExecCtx, err error) {
 		ELF:    OS,
 	}
 
+	measure(util.EventNormalWorld, "nonsecure_os_go.elf", OS)
+
 	if err = image.Load(); err != nil {
 		return
 	}
@@ -73,7 +84,10 @@ func loadSupervisor() (os *monitor.ExecCtx, err error) {
 		return nil, fmt.Errorf("SM could not load kernel, %v", err)
 	}
 
-	log.Printf("SM loaded kernel addr:%#x entry:%#x size:%d", os.Memory.Start(), os.PC, len(OS))
+	loaderLog.Info("loaded kernel",
+		"addr", logging.Hex(os.Memory.Start()),
+		"entry", logging.Hex(os.PC),
+		"size", len(OS))
 
 	// set memory protection function
 	os.PMP = configurePMP
@@ -88,15 +102,39 @@ func loadSupervisor() (os *monitor.ExecCtx, err error) {
 }
 
 func run(ctx *monitor.ExecCtx, wg *sync.WaitGroup) {
-	log.Printf("SM starting sp:%#.8x pc:%#.8x secure:%v", ctx.X2, ctx.PC, ctx.Secure())
+	loaderLog.Info("starting",
+		"sp", logging.Hex(ctx.X2),
+		"pc", logging.Hex(ctx.PC),
+		"secure", ctx.Secure())
+
+	running := &appletRunning
+
+	if !ctx.Secure() {
+		running = &normalWorldRunning
+	}
 
+	running.Store(true)
 	err := ctx.Run()
+	running.Store(false)
 
 	if wg != nil {
 		wg.Done()
 	}
 
-	log.Printf("SM stopped sp:%#.8x ra:%#.8x pc:%#.8x err:%v %s", ctx.X2, ctx.X1, ctx.PC, err, ctx)
+	attrs := []any{
+		"sp", logging.Hex(ctx.X2),
+		"ra", logging.Hex(ctx.X1),
+		"pc", logging.Hex(ctx.PC),
+		"secure", ctx.Secure(),
+	}
+
+	if err != nil {
+		loaderLog.Error("stopped", append(attrs, "err", err)...)
+	} else {
+		loaderLog.Info("stopped", attrs...)
+	}
+
+	log.Print(ctx)
 
 	if err != nil {
 		pcLine, _ := util.PCToLine(ctx.PC)
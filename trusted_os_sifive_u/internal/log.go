@@ -0,0 +1,16 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"github.com/usbarmory/GoTEE-example/util/logging"
+)
+
+// structured loggers, one for each component
+var (
+	loaderLog = logging.For(logging.Loader)
+	rpcLog    = logging.For(logging.RPC)
+)
@@ -0,0 +1,101 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// mailboxTimeout is the maximum time the Normal World waits for the applet
+// to serve mailbox requests.
+const mailboxTimeout = 10 * time.Second
+
+// doorbell represents a Normal World doorbell in flight.
+type doorbell struct {
+	served chan int
+}
+
+var (
+	// doorbells queues Normal World doorbells for the applet
+	doorbells = make(chan *doorbell)
+
+	// ringing holds the doorbell being served by the applet
+	ringing struct {
+		sync.Mutex
+		d *doorbell
+	}
+)
+
+// ringDoorbell handles a SYS_DOORBELL monitor call from the Normal World, the
+// applet is woken to serve the requests posted to the mailbox and the number
+// of served requests is returned to the caller.
+//
+// The mailbox memory is never accessed by the monitor, which only relays
+// notifications.
+func ringDoorbell(ctx *monitor.ExecCtx) (err error) {
+	var n int
+
+	d := &doorbell{
+		served: make(chan int, 1),
+	}
+
+	if appletRunning.Load() {
+		timeout := time.After(mailboxTimeout)
+
+		select {
+		case doorbells <- d:
+			select {
+			case n = <-d.served:
+			case <-timeout:
+				rpcLog.Warn("mailbox doorbell timeout")
+			}
+		case <-timeout:
+			rpcLog.Warn("mailbox doorbell timeout")
+		}
+	}
+
+	ctx.Ret(n)
+
+	return
+}
+
+// Mailbox waits for a Normal World doorbell.
+func (r *RPC) Mailbox(_ struct{}, _ *bool) error {
+	if !normalWorldRunning.Load() {
+		return errors.New("Normal World not running")
+	}
+
+	select {
+	case d := <-doorbells:
+		ringing.Lock()
+		ringing.d = d
+		ringing.Unlock()
+	case <-time.After(mailboxTimeout):
+		return errors.New("timeout")
+	}
+
+	return nil
+}
+
+// MailboxServed returns the number of mailbox requests served by the applet,
+// for the pending Normal World doorbell.
+func (r *RPC) MailboxServed(n int, _ *bool) error {
+	ringing.Lock()
+	defer ringing.Unlock()
+
+	if ringing.d == nil {
+		return errors.New("no pending doorbell")
+	}
+
+	ringing.d.served <- n
+	ringing.d = nil
+
+	return nil
+}
@@ -34,3 +34,23 @@ func (r *RPC) LED(led util.LEDStatus, _ *bool) error {
 
 	return nil
 }
+
+// GetChallenge issues a single use nonce to prevent replay attacks.
+func (r *RPC) GetChallenge(_ struct{}, out *util.Challenge) (err error) {
+	out.Nonce, err = Challenge()
+	return
+}
+
+// Quote returns attestation evidence, signed with the device attestation key,
+// over the caller nonce and the loaded Trusted Applet and Normal World images.
+func (r *RPC) Quote(ch util.Challenge, out *util.Quote) (err error) {
+	q, err := Quote(ch.Nonce)
+
+	if err != nil {
+		return
+	}
+
+	*out = *q
+
+	return
+}
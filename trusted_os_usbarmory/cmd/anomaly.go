@@ -0,0 +1,117 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/anomaly"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+
+	"github.com/usbarmory/GoTEE-example/trusted_os_usbarmory/internal"
+)
+
+func init() {
+	Add(Cmd{
+		Name: "anomaly",
+		Help: "show USB device rate limits and anomaly scores",
+		Fn:   anomalyCmd,
+	})
+
+	Add(Cmd{
+		Name:    "anomaly ",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^anomaly (rate|burst|ep_rate|ep_burst|threshold) (\S+)$`),
+		Syntax:  "<param> <val>",
+		Help:    "set USB rate limit (rate, burst, ep_rate, ep_burst) or anomaly threshold",
+		Fn:      anomalySetCmd,
+	})
+
+	Add(Cmd{
+		Name:    "release",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^release (\S+)$`),
+		Syntax:  "<device>",
+		Help:    "release quarantined USB device",
+		Fn:      releaseCmd,
+	})
+}
+
+func formatConfig(c anomaly.Config) string {
+	return fmt.Sprintf("rate:%g/s burst:%g ep_rate:%g/s ep_burst:%g learning:%d decay:%g threshold:%g",
+		c.DeviceRate, c.DeviceBurst, c.EndpointRate, c.EndpointBurst, c.Learning, c.Decay, c.Threshold)
+}
+
+func anomalyCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	c := gotee.AnomalyConfig()
+	fmt.Fprintf(&buf, "%s\n", formatConfig(c))
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "device\tscore\tstate\tpackets\tlimited\tanomalies\tblocked\tendpoints\tsizes\treason\n")
+
+	for _, s := range gotee.Anomalies.Stats() {
+		state := "ok"
+
+		if s.Quarantined {
+			state = "quarantined"
+		}
+
+		fmt.Fprintf(t, "%s\t%.2f\t%s\t%d\t%d\t%d\t%d\t% x\t%v\t%s\n",
+			s.Device, s.Score, state, s.Packets, s.Limited, s.Anomalies, s.Blocked, s.Endpoints, s.Sizes, s.Reason)
+	}
+
+	t.Flush()
+
+	return buf.String(), nil
+}
+
+func anomalySetCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	val, err := strconv.ParseFloat(arg[1], 64)
+
+	if err != nil || val < 0 {
+		return "", fmt.Errorf("invalid value %q", arg[1])
+	}
+
+	c := gotee.ConfigureAnomalies(func(c *anomaly.Config) {
+		switch arg[0] {
+		case "rate":
+			c.DeviceRate = val
+		case "burst":
+			c.DeviceBurst = val
+		case "ep_rate":
+			c.EndpointRate = val
+		case "ep_burst":
+			c.EndpointBurst = val
+		case "threshold":
+			c.Threshold = val
+		}
+	})
+
+	return formatConfig(c), nil
+}
+
+func releaseCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	dev, err := endorsement.ParseDeviceID(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	if err = gotee.ReleaseQuarantine(dev); err != nil {
+		return
+	}
+
+	return fmt.Sprintf("released %s", dev), nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util"
+
+	"github.com/usbarmory/GoTEE-example/trusted_os_usbarmory/internal"
+)
+
+func init() {
+	Add(Cmd{
+		Name: "challenge",
+		Help: "issue attestation nonce",
+		Fn:   challengeCmd,
+	})
+
+	Add(Cmd{
+		Name: "nonces",
+		Help: "show attestation nonce statistics",
+		Fn:   noncesCmd,
+	})
+
+	Add(Cmd{
+		Name:    "quote",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^quote ([[:xdigit:]]{64})$`),
+		Syntax:  "<hex nonce>",
+		Help:    "attestation quote (see gotee-verify)",
+		Fn:      quoteCmd,
+	})
+
+	Add(Cmd{
+		Name: "eventlog",
+		Help: "show measured boot event log",
+		Fn:   eventLogCmd,
+	})
+
+	Add(Cmd{
+		Name:    "eventlog ",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^eventlog (json)$`),
+		Syntax:  "json",
+		Help:    "export measured boot event log (see gotee-verify)",
+		Fn:      eventLogCmd,
+	})
+}
+
+func challengeCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	nonce, err := gotee.Challenge()
+
+	if err != nil {
+		return
+	}
+
+	return hex.EncodeToString(nonce[:]), nil
+}
+
+func noncesCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	s := gotee.NonceStats()
+
+	fmt.Fprintf(&buf, "issued:      %d\n", s.Issued)
+	fmt.Fprintf(&buf, "consumed:    %d\n", s.Consumed)
+	fmt.Fprintf(&buf, "outstanding: %d\n", s.Outstanding)
+	fmt.Fprintf(&buf, "evicted:     %d\n", s.Evicted)
+	fmt.Fprintf(&buf, "rejected:    %d (unknown:%d reused:%d expired:%d)", s.Unknown+s.Reused+s.Expired, s.Unknown, s.Reused, s.Expired)
+
+	return buf.String(), nil
+}
+
+func quoteCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	var nonce [32]byte
+
+	if _, err = hex.Decode(nonce[:], []byte(arg[0])); err != nil {
+		return "", fmt.Errorf("invalid nonce, %v", err)
+	}
+
+	q, err := gotee.Quote(nonce)
+
+	if err != nil {
+		return
+	}
+
+	buf, err := q.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func eventLogCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	events, pcr := gotee.EventLog()
+
+	if len(arg) > 0 {
+		if events == nil {
+			events = []util.Event{}
+		}
+
+		err = json.NewEncoder(&buf).Encode(events)
+		return buf.String(), err
+	}
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "#\ttype\tsha256\tdescription\n")
+
+	for i, e := range events {
+		fmt.Fprintf(t, "%d\t%s\t%x\t%s\n", i, e.Type, e.Digest, e.Description)
+	}
+
+	t.Flush()
+
+	fmt.Fprintf(&buf, "\npcr: %x", pcr)
+
+	return buf.String(), nil
+}
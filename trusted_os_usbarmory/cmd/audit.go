@@ -0,0 +1,98 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/audit"
+
+	"github.com/usbarmory/GoTEE-example/trusted_os_usbarmory/internal"
+)
+
+// maxAuditEntries is the number of most recent audit log entries shown.
+const maxAuditEntries = 32
+
+func init() {
+	Add(Cmd{
+		Name: "audit",
+		Help: "show USB audit log",
+		Fn:   auditCmd,
+	})
+
+	Add(Cmd{
+		Name:    "audit ",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^audit (export|verify) ?(.*)$`),
+		Syntax:  "<export|verify> (json)?",
+		Help:    "export or verify USB audit log (JSON)",
+		Fn:      auditExportCmd,
+	})
+}
+
+func auditCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	entries := gotee.AuditLog.Entries()
+
+	if n := len(entries); n > maxAuditEntries {
+		entries = entries[n-maxAuditEntries:]
+	}
+
+	seq, head := gotee.AuditLog.Head()
+	fmt.Fprintf(&buf, "head: %x (%d entries)\n", head, seq)
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "seq\ttime\tevent\tdevice\tstatus\tdetail\n")
+
+	for _, e := range entries {
+		fmt.Fprintf(t, "%d\t%s\t%s\t%s\t%s\t%s\n", e.Seq, formatTime(e.Time), e.Event, e.Device, e.Status, e.Detail)
+	}
+
+	t.Flush()
+
+	return buf.String(), nil
+}
+
+func auditExportCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	switch op, val := arg[0], arg[1]; op {
+	case "export":
+		buf, err := gotee.AuditLog.Export()
+		return string(buf), err
+	case "verify":
+		data := []byte(val)
+
+		// without argument the retained entries are verified
+		if len(data) == 0 {
+			if data, err = gotee.AuditLog.Export(); err != nil {
+				return
+			}
+		}
+
+		x, err := audit.Verify(data)
+
+		if err != nil {
+			return "", err
+		}
+
+		if err = gotee.AuditLog.Anchor(x); err != nil {
+			return "", err
+		}
+
+		first := x.Entries[0]
+		last := x.Entries[len(x.Entries)-1]
+
+		return fmt.Sprintf("verified entries %d-%d, anchored at %s", first.Seq, last.Seq, last.Hash), nil
+	}
+
+	return
+}
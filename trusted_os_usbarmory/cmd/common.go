@@ -7,14 +7,18 @@ package cmd
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"regexp"
 	"runtime/debug"
 	"runtime/pprof"
+	"strconv"
+	"time"
 
 	"golang.org/x/term"
 
 	usbarmory "github.com/usbarmory/tamago/board/usbarmory/mk2"
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
 )
 
 func init() {
@@ -44,6 +48,21 @@ func init() {
 		Fn:   stackallCmd,
 	})
 
+	Add(Cmd{
+		Name: "date",
+		Help: "show system time",
+		Fn:   dateCmd,
+	})
+
+	Add(Cmd{
+		Name:    "date ",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^date (\d+)$`),
+		Syntax:  "<epoch>",
+		Help:    "set system time (Unix seconds)",
+		Fn:      dateCmd,
+	})
+
 	Add(Cmd{
 		Name: "reboot",
 		Help: "reset device",
@@ -70,6 +89,21 @@ func stackallCmd(_ *term.Terminal, _ []string) (string, error) {
 	return buf.String(), nil
 }
 
+func dateCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	if len(arg) > 0 {
+		epoch, err := strconv.ParseInt(arg[0], 10, 64)
+
+		if err != nil {
+			return "", fmt.Errorf("invalid epoch, %v", err)
+		}
+
+		// the Secure World time is served to applets as SYS_NANOTIME
+		imx6ul.ARM.SetTime(epoch * int64(time.Second))
+	}
+
+	return time.Now().UTC().Format(time.RFC3339), nil
+}
+
 func rebootCmd(_ *term.Terminal, _ []string) (_ string, _ error) {
 	usbarmory.Reset()
 	return
@@ -0,0 +1,93 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/logging"
+)
+
+// defaultEvents is the number of JSON records replayed by default.
+const defaultEvents = 20
+
+func init() {
+	Add(Cmd{
+		Name: "events",
+		Help: "replay last structured log records (JSON)",
+		Fn:   eventsCmd,
+	})
+
+	Add(Cmd{
+		Name:    "events ",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^events (\d+|on|off)$`),
+		Syntax:  "<n|on|off>",
+		Help:    "replay last n records or (un)forward them",
+		Fn:      eventsCmd,
+	})
+
+	Add(Cmd{
+		Name: "loglevel",
+		Help: "show structured log level",
+		Fn:   logLevelCmd,
+	})
+
+	Add(Cmd{
+		Name:    "loglevel ",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^loglevel (debug|info|warn|error)$`),
+		Syntax:  "<debug|info|warn|error>",
+		Help:    "set structured log level",
+		Fn:      logLevelCmd,
+	})
+}
+
+func eventsCmd(term *term.Terminal, arg []string) (res string, err error) {
+	n := defaultEvents
+
+	switch {
+	case len(arg) == 0:
+	case arg[0] == "on":
+		logging.JSON.Attach(term)
+		return
+	case arg[0] == "off":
+		logging.JSON.Detach(term)
+		return
+	default:
+		if n, err = strconv.Atoi(arg[0]); err != nil || n <= 0 {
+			return "", errors.New("invalid number of records")
+		}
+	}
+
+	for _, rec := range logging.JSON.History(n) {
+		term.Write(rec)
+	}
+
+	return
+}
+
+func logLevelCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	if len(arg) == 0 {
+		return strings.ToLower(logging.Level.Level().String()), nil
+	}
+
+	level, err := logging.ParseLevel(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	logging.Level.Set(level)
+
+	return fmt.Sprintf("log level set to %s", arg[0]), nil
+}
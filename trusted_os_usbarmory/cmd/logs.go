@@ -0,0 +1,79 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// defaultLogLines is the number of log stream lines replayed by default.
+const defaultLogLines = 20
+
+func init() {
+	Add(Cmd{
+		Name: "logs",
+		Help: "show execution context log streams",
+		Fn:   logsCmd,
+	})
+
+	Add(Cmd{
+		Name:    "logs ",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^logs (\S+)(?: (\d+))?$`),
+		Syntax:  "<ctx> (n)?",
+		Help:    "replay last n lines of an execution context log stream",
+		Fn:      logsReplayCmd,
+	})
+}
+
+func logsCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "ctx\tcolour\tlines\n")
+
+	for _, s := range util.Logs.Streams() {
+		fmt.Fprintf(t, "%s\t%s\t%d\n", s.Name, s.Colour, s.Lines())
+	}
+
+	t.Flush()
+
+	fmt.Fprintf(&buf, "attached terminals: %d", util.Logs.Attached())
+
+	return buf.String(), nil
+}
+
+func logsReplayCmd(term *term.Terminal, arg []string) (res string, err error) {
+	n := defaultLogLines
+
+	s := util.Logs.Lookup(arg[0])
+
+	if s == nil {
+		return "", fmt.Errorf("unknown log stream %s", arg[0])
+	}
+
+	if len(arg[1]) > 0 {
+		if n, err = strconv.Atoi(arg[1]); err != nil || n <= 0 {
+			return "", errors.New("invalid number of lines")
+		}
+	}
+
+	for _, line := range s.History(n) {
+		util.WriteLine(term, s.Colour, line)
+	}
+
+	return
+}
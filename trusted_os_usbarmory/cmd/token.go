@@ -0,0 +1,138 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+
+	"github.com/usbarmory/GoTEE-example/trusted_os_usbarmory/internal"
+)
+
+func init() {
+	Add(Cmd{
+		Name:    "tokens",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^tokens(?: (export))?$`),
+		Syntax:  "(export)?",
+		Help:    "show or export (see usbip-server) USB device endorsement tokens",
+		Fn:      tokensCmd,
+	})
+
+	Add(Cmd{
+		Name:    "token",
+		Args:    4,
+		Pattern: regexp.MustCompile(`^token (\S+) (\S+)(?: (\S+))?(?: (.+))?$`),
+		Syntax:  "<device> <dur> (ifaces)? (note)?",
+		Help:    "issue USB device endorsement token for <dur> and interfaces (cc:ss:pp,...|any)",
+		Fn:      tokenCmd,
+	})
+}
+
+func formatInterfaces(classes []endorsement.Class) string {
+	if len(classes) == 0 {
+		return anyValue
+	}
+
+	var s []string
+
+	for _, c := range classes {
+		s = append(s, c.String())
+	}
+
+	return strings.Join(s, ",")
+}
+
+func tokensCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	pub, err := gotee.TokenKey()
+
+	if err != nil {
+		return
+	}
+
+	tokens := gotee.Tokens.List()
+
+	if arg[0] == "export" {
+		fmt.Fprintf(&buf, "# key:%x\n", pub)
+
+		for _, t := range tokens {
+			text, err := t.MarshalText()
+
+			if err != nil {
+				return "", err
+			}
+
+			fmt.Fprintf(&buf, "%s\n", text)
+		}
+
+		return buf.String(), nil
+	}
+
+	fmt.Fprintf(&buf, "key: %x\n", pub)
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "device\tissued\texpiry\tinterfaces\tnote\n")
+
+	for _, tk := range tokens {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\n", tk.Device, formatTime(tk.Issued), formatTime(tk.Expiry), formatInterfaces(tk.Interfaces), tk.Note)
+	}
+
+	t.Flush()
+
+	return buf.String(), nil
+}
+
+func tokenCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	var interfaces []endorsement.Class
+
+	dev, err := endorsement.ParseDeviceID(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	lifetime, err := time.ParseDuration(arg[1])
+
+	if err != nil {
+		return "", fmt.Errorf("invalid lifetime, %v", err)
+	}
+
+	if lifetime < 0 {
+		return "", errors.New("invalid lifetime")
+	}
+
+	if len(arg[2]) > 0 && arg[2] != anyValue {
+		if interfaces, err = endorsement.ParseClasses(arg[2]); err != nil {
+			return
+		}
+	}
+
+	tk, err := gotee.MintToken(dev, lifetime, interfaces, arg[3])
+
+	if err != nil {
+		return
+	}
+
+	text, err := tk.MarshalText()
+
+	if err != nil {
+		return
+	}
+
+	return fmt.Sprintf("issued %s until %s\n%s", tk.Device, formatTime(tk.Expiry), text), nil
+}
@@ -0,0 +1,311 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+
+	"github.com/usbarmory/GoTEE-example/trusted_os_usbarmory/internal"
+)
+
+func init() {
+	Add(Cmd{
+		Name: "endorsements",
+		Help: "show USB device endorsements",
+		Fn:   endorsementsCmd,
+	})
+
+	Add(Cmd{
+		Name:    "endorsements ",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^endorsements (open|export|import) ?(.*)$`),
+		Syntax:  "<open|export|import> (arg)?",
+		Help:    "sealed database (<uSD|eMMC>), JSON export/import",
+		Fn:      endorsementsDBCmd,
+	})
+
+	Add(Cmd{
+		Name:    "endorse",
+		Args:    4,
+		Pattern: regexp.MustCompile(`^endorse (\S+) (\S+)(?: (\d+))?(?: (.+))?$`),
+		Syntax:  "<device> <dur> (n)? (note)?",
+		Help:    "endorse USB device for <dur> (e.g. 1h, 0 never expires) and <n> packets",
+		Fn:      endorseCmd,
+	})
+
+	Add(Cmd{
+		Name:    "reendorse",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^reendorse(?: (\S+))?$`),
+		Syntax:  "(dur)?",
+		Help:    "review pending USB device re-endorsement requests",
+		Fn:      reendorseCmd,
+	})
+
+	Add(Cmd{
+		Name:    "revoke",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^revoke (\S+)$`),
+		Syntax:  "<device>",
+		Help:    "revoke USB device endorsement",
+		Fn:      revokeCmd,
+	})
+
+	Add(Cmd{
+		Name:    "restrict",
+		Args:    3,
+		Pattern: regexp.MustCompile(`^restrict (\S+) (bcd|class|interfaces) (\S+)$`),
+		Syntax:  "<device> <field> <val|any>",
+		Help:    "restrict USB device endorsement (bcd, class, interfaces)",
+		Fn:      restrictCmd,
+	})
+}
+
+// anyValue clears identity constraints (see restrictCmd)
+const anyValue = "any"
+
+// formatTime formats Unix seconds, zero values represent unset times.
+func formatTime(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+func endorsementsCmd(_ *term.Terminal, _ []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	t := tabwriter.NewWriter(&buf, 8, 8, 1, ' ', 0)
+
+	fmt.Fprintf(t, "device\tstatus\tcreated\texpiry\tpackets\tidentity\tnote\n")
+
+	for _, e := range gotee.Endorsements.List() {
+		budget := "-"
+
+		if e.Budget != endorsement.Unlimited {
+			budget = strconv.FormatUint(uint64(e.Budget), 10)
+		}
+
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Device, e.Status, formatTime(e.Created), formatTime(e.Expiry), budget, e.Match, e.Note)
+	}
+
+	t.Flush()
+
+	return buf.String(), nil
+}
+
+func endorseCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	g := endorsement.Grant{
+		Budget: endorsement.Unlimited,
+		Note:   arg[3],
+	}
+
+	if g.Device, err = endorsement.ParseDeviceID(arg[0]); err != nil {
+		return
+	}
+
+	if g.Lifetime, err = time.ParseDuration(arg[1]); err != nil {
+		return "", fmt.Errorf("invalid lifetime, %v", err)
+	}
+
+	if g.Lifetime < 0 {
+		return "", errors.New("invalid lifetime")
+	}
+
+	if len(arg[2]) > 0 {
+		budget, err := strconv.ParseUint(arg[2], 10, 32)
+
+		if err != nil {
+			return "", fmt.Errorf("invalid packets, %v", err)
+		}
+
+		g.Budget = uint32(min(budget, endorsement.Unlimited-1))
+	}
+
+	e, err := gotee.AddEndorsement(g)
+
+	if err != nil {
+		return
+	}
+
+	return fmt.Sprintf("endorsed %s until %s", e.Device, formatTime(e.Expiry)), nil
+}
+
+func revokeCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	dev, err := endorsement.ParseDeviceID(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	if err = gotee.RevokeEndorsement(dev); err != nil {
+		return
+	}
+
+	return fmt.Sprintf("revoked %s", dev), nil
+}
+
+func restrictCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	dev, err := endorsement.ParseDeviceID(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	e, ok := gotee.Endorsements.Get(dev)
+
+	if !ok {
+		return "", errors.New("no endorsement for device")
+	}
+
+	m := e.Match
+	val := arg[2]
+
+	switch arg[1] {
+	case "bcd":
+		m.BCDDevice = nil
+
+		if val != anyValue {
+			bcd, err := strconv.ParseUint(val, 16, 16)
+
+			if err != nil {
+				return "", fmt.Errorf("invalid bcd, %v", err)
+			}
+
+			m.BCDDevice = new(uint16)
+			*m.BCDDevice = uint16(bcd)
+		}
+	case "class":
+		m.Class = nil
+
+		if val != anyValue {
+			class, err := endorsement.ParseClass(val)
+
+			if err != nil {
+				return "", err
+			}
+
+			m.Class = &class
+		}
+	case "interfaces":
+		m.Interfaces = nil
+
+		if val != anyValue {
+			if m.Interfaces, err = endorsement.ParseClasses(val); err != nil {
+				return
+			}
+		}
+	}
+
+	if err = gotee.RestrictEndorsement(dev, m); err != nil {
+		return
+	}
+
+	return fmt.Sprintf("restricted %s to %s", dev, m), nil
+}
+
+func endorsementsDBCmd(_ *term.Terminal, arg []string) (res string, err error) {
+	switch op, val := arg[0], arg[1]; op {
+	case "open":
+		if err = gotee.OpenEndorsements(val); err != nil {
+			return
+		}
+
+		return endorsementsCmd(nil, nil)
+	case "export":
+		buf, err := gotee.ExportEndorsements()
+		return string(buf), err
+	case "import":
+		n, err := gotee.ImportEndorsements([]byte(val))
+
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("imported %d endorsements", n), nil
+	}
+
+	return
+}
+
+// maxReviewPackets is the number of most recent logged packets shown when
+// reviewing a re-endorsement request.
+const maxReviewPackets = 16
+
+func reendorseCmd(term *term.Terminal, arg []string) (res string, err error) {
+	lifetime := gotee.ReendorsementLifetime
+
+	if len(arg[0]) > 0 {
+		if lifetime, err = time.ParseDuration(arg[0]); err != nil || lifetime < 0 {
+			return "", fmt.Errorf("invalid lifetime %q", arg[0])
+		}
+	}
+
+	requests := gotee.Requests.Pending()
+
+	if len(requests) == 0 {
+		return "no pending re-endorsement requests", nil
+	}
+
+	for _, r := range requests {
+		var buf bytes.Buffer
+
+		fmt.Fprintf(&buf, "device:     %s\n", r.Device)
+		fmt.Fprintf(&buf, "identity:   %s\n", endorsement.Exact(&r.Device))
+		fmt.Fprintf(&buf, "status:     %s\n", r.Status)
+		fmt.Fprintf(&buf, "requested:  %s\n", formatTime(r.Time))
+
+		if r.Reason != "" {
+			fmt.Fprintf(&buf, "flagged:    %s\n", r.Reason)
+		}
+
+		pkts := gotee.Endorsements.PacketLog(r.Device.DeviceID)
+
+		if n := len(pkts); n > maxReviewPackets {
+			pkts = pkts[n-maxReviewPackets:]
+		}
+
+		fmt.Fprintf(&buf, "permitted packets (last %d):\n", len(pkts))
+
+		for _, pkt := range pkts {
+			fmt.Fprintf(&buf, "  % x\n", pkt)
+		}
+
+		fmt.Fprintf(&buf, "blocked packets (last %d):\n", len(r.Packets))
+
+		for _, pkt := range r.Packets {
+			fmt.Fprintf(&buf, "  % x\n", pkt)
+		}
+
+		fmt.Fprintf(term, "%s\nendorse %s for %v?\n", buf.String(), r.Device, lifetime)
+
+		if !confirm(term) {
+			gotee.DeclineRequest(r)
+			continue
+		}
+
+		e, err := gotee.ApproveRequest(r, lifetime)
+
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(term, "endorsed %s until %s\n", e.Device, formatTime(e.Expiry))
+	}
+
+	return
+}
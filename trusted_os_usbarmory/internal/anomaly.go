@@ -0,0 +1,58 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"errors"
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/util/anomaly"
+	"github.com/usbarmory/GoTEE-example/util/audit"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Anomalies holds the per-device rate limits and anomaly scores evaluated on
+// packets submitted by the Normal World, ahead of the endorsement check.
+var Anomalies = anomaly.NewMonitor(anomaly.DefaultConfig())
+
+func init() {
+	Anomalies.Quarantine = func(dev endorsement.DeviceID, reason string) {
+		usbLog.Warn("quarantined", "device", dev.String(), "reason", reason)
+		AuditLog.Append(audit.Quarantine, &dev, "", reason)
+	}
+}
+
+// ReleaseQuarantine lifts the quarantine of a device, resetting its anomaly
+// score and learned traffic.
+func ReleaseQuarantine(dev endorsement.DeviceID) error {
+	if !Anomalies.Release(dev) {
+		return errors.New("no anomaly state for device")
+	}
+
+	usbLog.Info("released", "device", dev.String())
+	AuditLog.Append(audit.Release, &dev, "", "")
+
+	return nil
+}
+
+// ConfigureAnomalies updates the rate limits and anomaly scoring parameters.
+func ConfigureAnomalies(update func(c *anomaly.Config)) anomaly.Config {
+	Anomalies.Lock()
+	defer Anomalies.Unlock()
+
+	update(&Anomalies.Config)
+	log.Printf("SM anomaly configuration %+v", Anomalies.Config)
+
+	return Anomalies.Config
+}
+
+// AnomalyConfig returns the rate limits and anomaly scoring parameters.
+func AnomalyConfig() anomaly.Config {
+	Anomalies.Lock()
+	defer Anomalies.Unlock()
+
+	return Anomalies.Config
+}
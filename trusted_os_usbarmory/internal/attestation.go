@@ -0,0 +1,110 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// attestationKeyDiversifier is the diversifier for the device attestation
+// key derivation.
+const attestationKeyDiversifier = "GoTEE-example attestation key v1"
+
+// Attestation nonces are single use and expire after nonceLifetime, at most
+// nonceLimit nonces are tracked at any given time.
+const (
+	nonceLifetime = 60 * time.Second
+	nonceLimit    = 64
+)
+
+// nonces tracks attestation nonces to prevent replay
+var nonces = &util.NonceRegistry{
+	Lifetime: nonceLifetime,
+	Limit:    nonceLimit,
+}
+
+var attestation struct {
+	sync.Mutex
+	key ed25519.PrivateKey
+}
+
+// initAttestationKey derives the device attestation key, which is stable
+// across boots as it is bound to the SoC hardware unique key.
+func initAttestationKey() (err error) {
+	attestation.Lock()
+	defer attestation.Unlock()
+
+	if attestation.key != nil {
+		return
+	}
+
+	seed, err := deriveKey(attestationKeyDiversifier)
+
+	if err != nil {
+		return
+	}
+
+	attestation.key = ed25519.NewKeyFromSeed(seed)
+
+	log.Printf("SM attestation key:%x", attestation.key.Public())
+
+	return
+}
+
+// Challenge issues a single use attestation nonce.
+func Challenge() ([32]byte, error) {
+	return nonces.Issue()
+}
+
+// NonceStats returns the attestation nonce registry statistics.
+func NonceStats() util.NonceStats {
+	return nonces.Stats()
+}
+
+// Quote returns attestation evidence for the given nonce, covering the
+// Trusted Applet and Normal World images and the measured boot event log.
+//
+// The Normal World measurement is taken from the event log, so that it
+// matches the image actually started (see osMeasurement()).
+//
+// The nonce must have been previously issued (see Challenge()) and is
+// consumed.
+func Quote(nonce [32]byte) (q *util.Quote, err error) {
+	if err = initAttestationKey(); err != nil {
+		return
+	}
+
+	if err = nonces.Consume(nonce); err != nil {
+		return
+	}
+
+	nw, ok := osMeasurement()
+
+	if !ok {
+		return nil, errors.New("Normal World not measured")
+	}
+
+	attestation.Lock()
+	defer attestation.Unlock()
+
+	q = &util.Quote{
+		Nonce:    nonce,
+		Applet:   sha256.Sum256(TA),
+		OS:       nw,
+		EventLog: measurements.PCR(),
+	}
+
+	q.Sign(attestation.key)
+
+	return
+}
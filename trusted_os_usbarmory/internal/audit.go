@@ -0,0 +1,58 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"fmt"
+
+	"github.com/usbarmory/GoTEE-example/util/audit"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// auditLimit is the number of most recent audit log entries retained.
+const auditLimit = 1024
+
+// AuditLog holds the audit log of endorsement changes and packet filter
+// decisions, its chain head is kept in Secure World memory so that the Normal
+// World cannot rewrite history.
+//
+// The chain head is not persistent, therefore the log is only verifiable
+// within the same power cycle.
+var AuditLog = &audit.Log{
+	Limit: auditLimit,
+}
+
+// checkBatch returns the verdict on a batch of packets from a device,
+// recording it in the audit log.
+func checkBatch(b *endorsement.Batch) (v *endorsement.Verdict) {
+	var permitted int
+
+	v = Anomalies.Check(Endorsements, b)
+
+	for _, ok := range v.Permitted {
+		if ok {
+			permitted++
+		}
+	}
+
+	dev := &b.Device.DeviceID
+
+	if permitted > 0 {
+		AuditLog.Append(audit.Allow, dev, v.Status.String(), fmt.Sprintf("packets:%d", permitted))
+	}
+
+	if blocked := len(v.Permitted) - permitted; blocked > 0 {
+		detail := fmt.Sprintf("packets:%d", blocked)
+
+		if v.Quarantined {
+			detail += " quarantined"
+		}
+
+		AuditLog.Append(audit.Deny, dev, v.Status.String(), detail)
+	}
+
+	return
+}
@@ -0,0 +1,364 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+
+	"github.com/usbarmory/GoTEE/monitor"
+
+	"github.com/usbarmory/GoTEE-example/util/audit"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// endorsementKeyDiversifier is the diversifier for the endorsement database
+// sealing key derivation.
+const endorsementKeyDiversifier = "GoTEE-example endorsement database key v1"
+
+// The sealed endorsement database is kept in a reserved area within the
+// unpartitioned space which precedes the first partition (10MiB) of the USB
+// armory Debian base image.
+const (
+	endorsementDBOffset = 8 << 20
+	endorsementDBSize   = 256 << 10
+)
+
+// Endorsements holds the USB device endorsement cache, it is kept in the
+// Secure World so that the Normal World can only request verdicts on packets.
+//
+// Endorsement expiry is checked against the Secure World system time, which
+// is also served to applets as SYS_NANOTIME (see `date` console command).
+var Endorsements = endorsement.NewCache()
+
+// rpmbKeyDiversifier is the diversifier for the eMMC RPMB authentication key
+// derivation.
+const rpmbKeyDiversifier = "GoTEE-example RPMB key v1"
+
+// endorsementCounterAddress is the eMMC RPMB half sector which holds the
+// endorsement database version.
+const endorsementCounterAddress = 0
+
+// EndorsementDevice is the device ("eMMC" or "uSD") holding the sealed
+// endorsement database opened at boot (see InitEndorsements()).
+var EndorsementDevice = "eMMC"
+
+// endorsementCounter holds the highest endorsement database version, to
+// detect rollback of the database.
+//
+// On real hardware the counter is held in the eMMC Replay Protected Memory
+// Block (RPMB), therefore rollback is detected across power cycles, under
+// emulation it is held in memory and only detected since boot.
+var endorsementCounter endorsement.Counter
+
+// emulatedCards replace eMMC and uSD cards under emulation.
+var emulatedCards = map[string]*endorsement.MemoryDevice{
+	"eMMC": {BlockSize: 512},
+	"uSD":  {BlockSize: 512},
+}
+
+var endorsementDB struct {
+	sync.Mutex
+	store *endorsement.Store
+}
+
+// initEndorsementCounter initializes the endorsement database rollback
+// counter, on first use the eMMC RPMB authentication key is programmed.
+func initEndorsementCounter() (err error) {
+	if endorsementCounter != nil {
+		return
+	}
+
+	if !imx6ul.Native {
+		log.Printf("SM using insecure endorsement rollback counter under emulation")
+		endorsementCounter = &endorsement.MemoryCounter{}
+		return
+	}
+
+	card, err := secureCard("eMMC")
+
+	if err != nil {
+		return
+	}
+
+	if err = card.Detect(); err != nil {
+		return
+	}
+
+	key, err := deriveKey(rpmbKeyDiversifier)
+
+	if err != nil {
+		return
+	}
+
+	counter := &endorsement.RPMBCounter{
+		Device:  card,
+		Key:     key,
+		Address: endorsementCounterAddress,
+	}
+
+	_, err = counter.Read()
+
+	if err == endorsement.ErrRPMBKey {
+		log.Printf("SM programming eMMC RPMB key")
+
+		if err = counter.ProgramKey(); err != nil {
+			return
+		}
+
+		_, err = counter.Read()
+	}
+
+	if err != nil {
+		return
+	}
+
+	endorsementCounter = counter
+
+	return
+}
+
+// InitEndorsements opens the sealed endorsement database at boot, on the
+// default device (see EndorsementDevice), before the Normal World is
+// launched.
+func InitEndorsements() (err error) {
+	if err = OpenEndorsements(EndorsementDevice); err != nil {
+		return fmt.Errorf("could not open endorsement database, %v", err)
+	}
+
+	return
+}
+
+// OpenEndorsements loads the sealed endorsement database from the given
+// device ("eMMC" or "uSD", emulated in memory when not running on real
+// hardware), creating it if not present.
+//
+// Once open, changes to endorsements are saved on the device.
+func OpenEndorsements(device string) (err error) {
+	var dev endorsement.BlockDevice
+	var blockSize int
+
+	if imx6ul.Native {
+		card, err := secureCard(device)
+
+		if err != nil {
+			return err
+		}
+
+		if err = card.Detect(); err != nil {
+			return err
+		}
+
+		dev = card
+		blockSize = card.Info().BlockSize
+	} else {
+		card, ok := emulatedCards[device]
+
+		if !ok {
+			return errors.New("invalid device")
+		}
+
+		dev = card
+		blockSize = card.BlockSize
+	}
+
+	if blockSize == 0 {
+		return errors.New("invalid block size")
+	}
+
+	key, err := deriveKey(endorsementKeyDiversifier)
+
+	if err != nil {
+		return
+	}
+
+	if err = initEndorsementCounter(); err != nil {
+		return fmt.Errorf("could not initialize rollback counter, %v", err)
+	}
+
+	store := &endorsement.Store{
+		Device:    dev,
+		BlockSize: blockSize,
+		LBA:       endorsementDBOffset / blockSize,
+		Blocks:    endorsementDBSize / blockSize,
+		Key:       key,
+		Counter:   endorsementCounter,
+	}
+
+	switch err = store.Load(Endorsements); err {
+	case nil:
+		log.Printf("SM loaded endorsement database device:%s version:%d", device, store.Version())
+	case endorsement.ErrNotFound:
+		log.Printf("SM creating endorsement database device:%s", device)
+
+		if err = store.Save(Endorsements); err != nil {
+			return
+		}
+	default:
+		return
+	}
+
+	endorsementDB.Lock()
+	endorsementDB.store = store
+	endorsementDB.Unlock()
+
+	return
+}
+
+// saveEndorsements saves the endorsement database, if open.
+func saveEndorsements() (err error) {
+	endorsementDB.Lock()
+	defer endorsementDB.Unlock()
+
+	if endorsementDB.store == nil {
+		return
+	}
+
+	if err = endorsementDB.store.Save(Endorsements); err != nil {
+		return fmt.Errorf("could not save endorsement database, %v", err)
+	}
+
+	log.Printf("SM saved endorsement database version:%d", endorsementDB.store.Version())
+
+	return
+}
+
+// AddEndorsement installs an active endorsement for a device.
+func AddEndorsement(g endorsement.Grant) (e endorsement.Entry, err error) {
+	e = Endorsements.Add(g)
+	log.Printf("SM endorsing %s expiry:%d budget:%d note:%q", e.Device, e.Expiry, e.Budget, e.Note)
+	AuditLog.Append(audit.Endorse, &e.Device, e.Status.String(), fmt.Sprintf("expiry:%d budget:%d match:%s note:%q", e.Expiry, e.Budget, e.Match, e.Note))
+	return e, saveEndorsements()
+}
+
+// RevokeEndorsement revokes the endorsement of a device.
+func RevokeEndorsement(dev endorsement.DeviceID) (err error) {
+	log.Printf("SM revoking %s", dev)
+
+	if err = Endorsements.Revoke(dev); err != nil {
+		return
+	}
+
+	AuditLog.Append(audit.Revoke, &dev, endorsement.Revoked.String(), "")
+
+	return saveEndorsements()
+}
+
+// DowngradeEndorsement expires the endorsement of a device.
+func DowngradeEndorsement(dev endorsement.DeviceID) (err error) {
+	log.Printf("SM downgrading %s", dev)
+
+	if err = Endorsements.Downgrade(dev); err != nil {
+		return
+	}
+
+	AuditLog.Append(audit.Downgrade, &dev, endorsement.Expired.String(), "")
+
+	return saveEndorsements()
+}
+
+// RestrictEndorsement replaces the identity constraints of the endorsement of a
+// device.
+func RestrictEndorsement(dev endorsement.DeviceID, m endorsement.Match) (err error) {
+	log.Printf("SM restricting %s to %s", dev, m)
+
+	if err = Endorsements.SetMatch(dev, m); err != nil {
+		return
+	}
+
+	AuditLog.Append(audit.Restrict, &dev, "", "match:"+m.String())
+
+	return saveEndorsements()
+}
+
+// ImportEndorsements installs all endorsements in JSON database format (see
+// USBIP/endorsements.json).
+func ImportEndorsements(data []byte) (n int, err error) {
+	if n, err = Endorsements.Import(data); err != nil {
+		return
+	}
+
+	log.Printf("SM imported %d endorsements", n)
+	AuditLog.Append(audit.Import, nil, "", fmt.Sprintf("endorsements:%d", n))
+
+	return n, saveEndorsements()
+}
+
+// ExportEndorsements returns all endorsements in JSON database format.
+func ExportEndorsements() ([]byte, error) {
+	return Endorsements.Export()
+}
+
+// Endorsement represents the RPC receiver for USB device endorsement
+// services.
+type Endorsement struct{}
+
+// Check returns a verdict on a batch of packets from a device.
+func (e *Endorsement) Check(b endorsement.Batch, out *endorsement.Verdict) error {
+	*out = *checkBatch(&b)
+	return nil
+}
+
+// Add installs an active endorsement for a device.
+func (e *Endorsement) Add(g endorsement.Grant, out *endorsement.Entry) (err error) {
+	*out, err = AddEndorsement(g)
+	return
+}
+
+// Revoke revokes the endorsement of a device.
+func (e *Endorsement) Revoke(dev endorsement.DeviceID, _ *bool) error {
+	return RevokeEndorsement(dev)
+}
+
+// List returns all endorsements.
+func (e *Endorsement) List(_ struct{}, out *[]endorsement.Entry) error {
+	*out = Endorsements.List()
+	return nil
+}
+
+// checkEndorsement handles a SYS_ENDORSEMENT monitor call from the Normal
+// World, the packet batch is read from the caller buffer, which is then
+// overwritten with the verdict.
+func checkEndorsement(ctx *monitor.ExecCtx) (err error) {
+	var b endorsement.Batch
+
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n < 0 || n > endorsement.BatchBufferSize {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, n)
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	if err = b.UnmarshalBinary(buf); err != nil {
+		ctx.Ret(-1)
+		return nil
+	}
+
+	if buf, err = checkBatch(&b).MarshalBinary(); err != nil {
+		return
+	}
+
+	if len(buf) > n {
+		ctx.Ret(-1)
+		return nil
+	}
+
+	ctx.Poke(off, buf)
+	ctx.Ret(len(buf))
+
+	return
+}
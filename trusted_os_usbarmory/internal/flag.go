@@ -0,0 +1,81 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"fmt"
+
+	"github.com/usbarmory/GoTEE/monitor"
+
+	"github.com/usbarmory/GoTEE-example/util/audit"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// flagDevice handles a SYS_FLAG monitor call from the Normal World, the flag
+// is read from the caller buffer.
+//
+// Downgraded devices are queued for operator review, along with the offending
+// packets, as re-endorsement requests.
+//
+// The call returns 1 if the endorsement is downgraded or revoked, 0 if the
+// device has no active endorsement and -1 on error.
+func flagDevice(ctx *monitor.ExecCtx) (err error) {
+	var f endorsement.Flag
+
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n < 0 || n > endorsement.FlagBufferSize {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, n)
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	if err = f.UnmarshalBinary(buf); err != nil {
+		ctx.Ret(-1)
+		return nil
+	}
+
+	e, _ := Endorsements.Lookup(&f.Batch.Device)
+
+	if e.Status != endorsement.Active {
+		ctx.Ret(0)
+		return
+	}
+
+	usbLog.Warn("flagged by Normal World", "device", f.Batch.Device.String(), "reason", f.Reason, "penalty", f.Penalty.String())
+	AuditLog.Append(audit.Flag, &f.Batch.Device.DeviceID, e.Status.String(), fmt.Sprintf("penalty:%s reason:%q", f.Penalty, f.Reason))
+
+	switch f.Penalty {
+	case endorsement.Downgrade:
+		err = DowngradeEndorsement(e.Device)
+	case endorsement.Block:
+		err = RevokeEndorsement(e.Device)
+	}
+
+	if err != nil {
+		usbLog.Error("could not apply penalty", "device", e.Device.String(), "err", err)
+		ctx.Ret(-1)
+		return nil
+	}
+
+	if f.Penalty == endorsement.Downgrade {
+		if _, err := Requests.Raise(&f.Batch, endorsement.Expired, f.Reason); err != nil {
+			usbLog.Error("could not queue re-endorsement", "device", f.Batch.Device.String(), "err", err)
+		} else {
+			usbLog.Info("re-endorsement requested", "device", f.Batch.Device.String())
+		}
+	}
+
+	ctx.Ret(1)
+
+	return
+}
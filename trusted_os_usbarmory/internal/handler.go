@@ -17,13 +17,14 @@ import (
 	"github.com/usbarmory/GoTEE/syscall"
 
 	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/logging"
 )
 
 var Console *util.Console
 
 func goHandler(ctx *monitor.ExecCtx) (err error) {
 	if ctx.ExceptionVector == arm.DATA_ABORT && ctx.NonSecure() {
-		log.Printf("SM trapped Non-secure data abort pc:%#.8x", ctx.R15-8)
+		handlerLog.Warn("trapped Non-secure data abort", "pc", logging.Hex(ctx.R15-8))
 
 		log.Print(ctx)
 		ctx.Stop()
@@ -38,17 +39,53 @@ func goHandler(ctx *monitor.ExecCtx) (err error) {
 	switch ctx.A0() {
 	case syscall.SYS_WRITE:
 		// Override write syscall to avoid interleaved logs and to log
-		// simultaneously to remote terminal and serial console.
-		if Console != nil {
-			util.BufferedTermLog(byte(ctx.A1()), !ctx.NonSecure(), Console.Term)
-		} else {
-			util.BufferedStdoutLog(byte(ctx.A1()), !ctx.NonSecure())
-		}
+		// simultaneously to remote terminals and serial console.
+		logStream(ctx).WriteByte(byte(ctx.A1()))
+	case util.SYS_WRITE_BUF:
+		// bulk write syscall on both security states
+		return writeBuffer(ctx)
 	case syscall.SYS_EXIT:
 		// support exit syscall on both security states
 		ctx.Stop()
+	case util.SYS_ATTEST:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return attestApplet(ctx)
+	case util.SYS_ENDORSEMENT:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return checkEndorsement(ctx)
+	case util.SYS_REENDORSE:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return requestEndorsement(ctx)
+	case util.SYS_FLAG:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return flagDevice(ctx)
+	case util.SYS_DOORBELL:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return ringDoorbell(ctx)
+	case util.SYS_NS_RPC:
+		if !ctx.NonSecure() {
+			return errors.New("unexpected monitor call")
+		}
+
+		return serveNonSecureRPC(ctx)
 	default:
 		if ctx.NonSecure() {
+			handlerLog.Error("unexpected monitor call", "a0", logging.Hex(ctx.A0()), "pc", logging.Hex(ctx.R15))
 			log.Print(ctx)
 			return errors.New("unexpected monitor call")
 		} else {
@@ -59,6 +96,44 @@ func goHandler(ctx *monitor.ExecCtx) (err error) {
 	return
 }
 
+// logStream returns the log stream of an execution context, registering a
+// default one if not already present.
+func logStream(ctx *monitor.ExecCtx) *util.LogStream {
+	if s := util.Logs.Stream(ctx); s != nil {
+		return s
+	}
+
+	if ctx.NonSecure() {
+		return util.Logs.Register(ctx, "os", util.Red)
+	}
+
+	return util.Logs.Register(ctx, "applet", util.Green)
+}
+
+// writeBuffer handles a SYS_WRITE_BUF monitor call, the caller buffer is
+// validated against the caller memory and copied out to the console.
+func writeBuffer(ctx *monitor.ExecCtx) (err error) {
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n <= 0 {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, min(n, util.MaxWriteSize))
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	logStream(ctx).Write(buf)
+
+	ctx.Ret(len(buf))
+
+	return
+}
+
 func linuxHandler(ctx *monitor.ExecCtx) (err error) {
 	if !ctx.NonSecure() {
 		return errors.New("unexpected processor mode")
@@ -69,7 +144,7 @@ func linuxHandler(ctx *monitor.ExecCtx) (err error) {
 		switch imx6ul.GIC.GetInterrupt(true) {
 		case imx6ul.TZ_WDOG.IRQ:
 			imx6ul.TZ_WDOG.Service(watchdogTimeout)
-			log.Printf("SM serviced TrustZone Watchdog")
+			tzLog.Debug("serviced TrustZone Watchdog")
 		}
 
 		return
@@ -0,0 +1,101 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// emulatedKey is used in place of the SoC hardware unique key under
+// emulation, keys derived from it are stable but obviously insecure.
+const emulatedKey = "GoTEE-example emulated hardware unique key"
+
+// derivedKeys caches derived keys, so that they remain available once the
+// CAAM is granted to the Normal World.
+var derivedKeys = struct {
+	sync.Mutex
+	keys map[string][]byte
+	// granted is set once the CAAM is granted to the Normal World
+	granted bool
+}{
+	keys: make(map[string][]byte),
+}
+
+// grantKeyDerivation marks the CAAM as granted to the Normal World, after
+// which only previously derived keys are available.
+func grantKeyDerivation() {
+	derivedKeys.Lock()
+	defer derivedKeys.Unlock()
+
+	derivedKeys.granted = true
+}
+
+// deriveKey returns a device specific 32 bytes key for the given diversifier,
+// derived from the SoC hardware unique key through the CAAM or DCP.
+//
+// As the CAAM is granted to the Normal World when it is launched, keys must
+// be derived before loading it (see initKeys()), as the CAAM is never taken
+// back from a running Normal World.
+func deriveKey(diversifier string) (k []byte, err error) {
+	derivedKeys.Lock()
+	defer derivedKeys.Unlock()
+
+	if k, ok := derivedKeys.keys[diversifier]; ok {
+		return k, nil
+	}
+
+	div := sha256.Sum256([]byte(diversifier))
+
+	switch {
+	case !imx6ul.Native:
+		log.Printf("SM deriving insecure key under emulation (%s)", diversifier)
+
+		mac := hmac.New(sha256.New, []byte(emulatedKey))
+		mac.Write(div[:])
+		k = mac.Sum(nil)
+	case imx6ul.CAAM != nil && derivedKeys.granted:
+		err = errors.New("CAAM granted to the Normal World, key not derived at boot")
+	case imx6ul.CAAM != nil:
+		// set CAAM as Secure
+		imx6ul.CAAM.SetOwner(true)
+
+		k = make([]byte, sha256.Size)
+		err = imx6ul.CAAM.DeriveKey(div[:], k)
+	case imx6ul.DCP != nil:
+		k, err = imx6ul.DCP.DeriveKey(div[:], make([]byte, aes.BlockSize), -1)
+	default:
+		err = errors.New("no key derivation hardware available")
+	}
+
+	if err == nil {
+		derivedKeys.keys[diversifier] = k
+	}
+
+	return
+}
+
+// initKeys derives all device keys, it must be called before the CAAM is
+// granted to the Normal World.
+func initKeys() (err error) {
+	for _, diversifier := range []string{
+		attestationKeyDiversifier,
+		endorsementKeyDiversifier,
+		rpmbKeyDiversifier,
+	} {
+		if _, err = deriveKey(diversifier); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/usbarmory/GoTEE-example/mem"
 	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/logging"
 
 	"github.com/usbarmory/armory-boot/config"
 	"github.com/usbarmory/armory-boot/disk"
@@ -51,18 +52,20 @@ func loadApplet(lockstep bool) (ta *monitor.ExecCtx, err error) {
 		ELF:    TA,
 	}
 
+	measure(util.EventApplet, "trusted_applet.elf", TA)
+
 	alias := uint32(mem.AppletPhysicalStart)
 
 	switch {
 	case lockstep:
-		log.Printf("SM loading applet in lockstep shadow memory")
+		loaderLog.Info("loading applet", "memory", "lockstep")
 		configureMMU(image.Region, mem.AppletShadowStart)
 
 		if err = image.Load(); err != nil {
 			return
 		}
 	case imx6ul.Native && imx6ul.BEE != nil && mem.BEE:
-		log.Printf("SM loading applet in BEE encrypted memory")
+		loaderLog.Info("loading applet", "memory", "bee")
 		alias = 0
 	}
 
@@ -76,14 +79,33 @@ func loadApplet(lockstep bool) (ta *monitor.ExecCtx, err error) {
 		return nil, fmt.Errorf("SM could not load applet, %v", err)
 	}
 
-	log.Printf("SM loaded applet addr:%#x entry:%#x size:%d", ta.Memory.Start(), ta.R15, len(TA))
+	// map the mailbox as Non-secure memory, to share the Normal World
+	// view of it, with applet access
+	imx6ul.ARM.ConfigureMMU(mem.MailboxStart, mem.MailboxStart+mem.MailboxSize, 0, arm.MemoryRegion|arm.TTE_NS|arm.TTE_EXECUTE_NEVER|arm.TTE_AP_011<<10)
+
+	loaderLog.Info("loaded applet",
+		"addr", logging.Hex(ta.Memory.Start()),
+		"entry", logging.Hex(ta.R15),
+		"size", len(TA))
 
 	// set applet as ELF debugging target
 	util.SetDebugTarget(image.ELF)
 
+	// derive device keys before the Normal World is granted the CAAM
+	if err = initKeys(); err != nil {
+		return nil, fmt.Errorf("SM could not derive device keys, %v", err)
+	}
+
+	if err = initAttestationKey(); err != nil {
+		return nil, fmt.Errorf("SM could not derive attestation key, %v", err)
+	}
+
 	// register example RPC receiver
 	ta.Server.Register(&RPC{})
 
+	// register USB endorsement RPC receiver
+	ta.Server.Register(&Endorsement{})
+
 	// set stack pointer to the end of available memory
 	ta.R13 = uint32(ta.Memory.End())
 
@@ -112,6 +134,8 @@ func loadNormalWorld(lock bool) (os *monitor.ExecCtx, err error) {
 		ELF:    OS,
 	}
 
+	measure(util.EventNormalWorld, "nonsecure_os_go.elf", OS)
+
 	if err = image.Load(); err != nil {
 		return
 	}
@@ -120,7 +144,10 @@ func loadNormalWorld(lock bool) (os *monitor.ExecCtx, err error) {
 		return nil, fmt.Errorf("SM could not load kernel, %v", err)
 	}
 
-	log.Printf("SM loaded kernel addr:%#x entry:%#x size:%d", os.Memory.Start(), os.R15, len(OS))
+	loaderLog.Info("loaded kernel",
+		"addr", logging.Hex(os.Memory.Start()),
+		"entry", logging.Hex(os.R15),
+		"size", len(OS))
 
 	if err = configureTrustZone(lock, false); err != nil {
 		return nil, fmt.Errorf("SM could not configure TrustZone, %v", err)
@@ -132,12 +159,19 @@ func loadNormalWorld(lock bool) (os *monitor.ExecCtx, err error) {
 	return
 }
 
-// loadLinux loads a Linux kernel as Normal World OS, the kernel configuration
-// is read from an armory-boot configuration file on the given device ("eMMC"
-// or "uSD").
-func loadLinux(device string) (os *monitor.ExecCtx, err error) {
+// pathOf returns the path element of an armory-boot configuration entry.
+func pathOf(entry []string) string {
+	if len(entry) == 0 {
+		return ""
+	}
+
+	return entry[0]
+}
+
+// secureCard returns the USDHC controller of the given device ("eMMC" or
+// "uSD"), set as Secure master.
+func secureCard(device string) (card *usdhc.USDHC, err error) {
 	var id int
-	var card *usdhc.USDHC
 
 	switch device {
 	case "uSD":
@@ -152,7 +186,18 @@ func loadLinux(device string) (os *monitor.ExecCtx, err error) {
 
 	// Set the device USDHC controller as Secure master to grant access to
 	// the Trusted OS DMA region.
-	if err = imx6ul.CSU.SetAccess(id, true, false); err != nil {
+	err = imx6ul.CSU.SetAccess(id, true, false)
+
+	return
+}
+
+// loadLinux loads a Linux kernel as Normal World OS, the kernel configuration
+// is read from an armory-boot configuration file on the given device ("eMMC"
+// or "uSD").
+func loadLinux(device string) (os *monitor.ExecCtx, err error) {
+	card, err := secureCard(device)
+
+	if err != nil {
 		return
 	}
 
@@ -170,6 +215,17 @@ func loadLinux(device string) (os *monitor.ExecCtx, err error) {
 
 	log.Printf("\n%s", conf.JSON)
 
+	measure(util.EventBootConfig, bootConfLinux, conf.JSON)
+	measure(util.EventKernel, pathOf(conf.KernelPath), conf.Kernel())
+
+	if dtb := conf.DeviceTreeBlob(); len(dtb) > 0 {
+		measure(util.EventDeviceTreeBlob, pathOf(conf.DeviceTreeBlobPath), dtb)
+	}
+
+	if initrd := conf.InitialRamDisk(); len(initrd) > 0 {
+		measure(util.EventInitialRamDisk, pathOf(conf.InitialRamDiskPath), initrd)
+	}
+
 	image := &exec.LinuxImage{
 		Region:               mem.NonSecureRegion,
 		Kernel:               conf.Kernel(),
@@ -189,7 +245,11 @@ func loadLinux(device string) (os *monitor.ExecCtx, err error) {
 		return nil, fmt.Errorf("SM could not load kernel, %v", err)
 	}
 
-	log.Printf("SM loaded kernel addr:%#x size:%d entry:%#x", os.Memory.Start(), len(image.Kernel), os.R15)
+	loaderLog.Info("loaded kernel",
+		"addr", logging.Hex(os.Memory.Start()),
+		"entry", logging.Hex(os.R15),
+		"size", len(image.Kernel),
+		"linux", true)
 
 	if err = configureTrustZone(true, true); err != nil {
 		return nil, fmt.Errorf("SM could not configure TrustZone, %v", err)
@@ -216,15 +276,41 @@ func run(ctx *monitor.ExecCtx, wg *sync.WaitGroup) {
 	mode := arm.ModeName(int(ctx.SPSR) & 0x1f)
 	ns := ctx.NonSecure()
 
-	log.Printf("SM starting mode:%s sp:%#.8x pc:%#.8x ns:%v", mode, ctx.R13, ctx.R15, ns)
+	loaderLog.Info("starting",
+		"mode", mode,
+		"sp", logging.Hex(ctx.R13),
+		"pc", logging.Hex(ctx.R15),
+		"ns", ns)
+
+	running := &appletRunning
 
+	if ns {
+		running = &normalWorldRunning
+	}
+
+	running.Store(true)
 	err := ctx.Run()
+	running.Store(false)
 
 	if wg != nil {
 		wg.Done()
 	}
 
-	log.Printf("SM stopped mode:%s sp:%#.8x lr:%#.8x pc:%#.8x ns:%v err:%v %s", mode, ctx.R13, ctx.R14, ctx.R15, ns, err, ctx)
+	attrs := []any{
+		"mode", mode,
+		"sp", logging.Hex(ctx.R13),
+		"lr", logging.Hex(ctx.R14),
+		"pc", logging.Hex(ctx.R15),
+		"ns", ns,
+	}
+
+	if err != nil {
+		loaderLog.Error("stopped", append(attrs, "err", err)...)
+	} else {
+		loaderLog.Info("stopped", attrs...)
+	}
+
+	log.Print(ctx)
 
 	if err != nil {
 		if ctx.Shadow != nil {
@@ -0,0 +1,19 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"github.com/usbarmory/GoTEE-example/util/logging"
+)
+
+// structured loggers, one for each component
+var (
+	loaderLog  = logging.For(logging.Loader)
+	handlerLog = logging.For(logging.Handler)
+	tzLog      = logging.For(logging.TZ)
+	rpcLog     = logging.For(logging.RPC)
+	usbLog     = logging.For(logging.USB)
+)
@@ -0,0 +1,27 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"log"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// measurements holds the measured boot event log for all images loaded by
+// the Trusted OS since boot.
+var measurements util.EventLog
+
+func measure(t util.EventType, desc string, data []byte) {
+	e := measurements.Measure(t, desc, data)
+	log.Printf("SM measured %s %s sha256:%x", e.Type, e.Description, e.Digest)
+}
+
+// EventLog returns the measured boot event log and its resulting register
+// value.
+func EventLog() ([]util.Event, util.Digest) {
+	return measurements.Events(), measurements.PCR()
+}
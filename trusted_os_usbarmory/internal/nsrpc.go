@@ -0,0 +1,101 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"github.com/usbarmory/GoTEE/monitor"
+
+	"github.com/usbarmory/GoTEE-example/util"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/nsrpc"
+)
+
+// NSServer serves Normal World RPC requests.
+var NSServer = nsrpc.NewServer()
+
+func init() {
+	NSServer.Register("NS", &NS{})
+
+	NSServer.Allow("NS.GetChallenge", 16)
+	NSServer.Allow("NS.CheckEndorsement", 2*endorsement.BatchBufferSize)
+	NSServer.Allow("NS.GetTokens", 16)
+}
+
+// NS represents the RPC receiver for Normal World services.
+type NS struct{}
+
+// GetChallenge issues a single use nonce to prevent replay attacks.
+func (r *NS) GetChallenge(_ struct{}, out *util.Challenge) (err error) {
+	out.Nonce, err = Challenge()
+	return
+}
+
+// CheckEndorsement returns a verdict on a batch of packets from a device.
+func (r *NS) CheckEndorsement(b endorsement.Batch, out *endorsement.Verdict) (err error) {
+	// enforce binary encoding limits
+	if _, err = b.MarshalBinary(); err != nil {
+		return
+	}
+
+	*out = *checkBatch(&b)
+
+	return
+}
+
+// GetTokens returns the endorsement tokens issued since boot, in text format,
+// for verification by the Normal World USB packet filter.
+func (r *NS) GetTokens(_ struct{}, out *[]string) (err error) {
+	if _, err = TokenKey(); err != nil {
+		return
+	}
+
+	for _, t := range Tokens.List() {
+		text, err := t.MarshalText()
+
+		if err != nil {
+			return err
+		}
+
+		*out = append(*out, string(text))
+	}
+
+	return
+}
+
+// serveNonSecureRPC handles a SYS_NS_RPC monitor call from the Normal World,
+// the request is read from the caller buffer, which is then overwritten with
+// the response.
+func serveNonSecureRPC(ctx *monitor.ExecCtx) (err error) {
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n < 0 || n > nsrpc.MaxRequestSize {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, n)
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	res, method, err := NSServer.Serve(buf)
+
+	if err != nil {
+		rpcLog.Warn("rejected Normal World RPC", "method", method, "err", err)
+	}
+
+	if len(res) > n {
+		ctx.Ret(-1)
+		return nil
+	}
+
+	ctx.Poke(off, res)
+	ctx.Ret(len(res))
+
+	return nil
+}
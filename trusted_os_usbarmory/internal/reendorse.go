@@ -0,0 +1,107 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"log"
+	"time"
+
+	"github.com/usbarmory/GoTEE/monitor"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// maxRequests is the maximum number of pending re-endorsement requests.
+const maxRequests = 16
+
+// ReendorsementLifetime is the default lifetime of endorsements installed
+// upon operator approval of a re-endorsement request.
+const ReendorsementLifetime = 1 * time.Hour
+
+// Requests holds re-endorsement requests raised by the Normal World, pending
+// operator review on the console.
+var Requests = &endorsement.Requests{
+	Limit: maxRequests,
+}
+
+// ApproveRequest installs a fresh endorsement, with the given lifetime, for
+// the device of a pending re-endorsement request.
+func ApproveRequest(r endorsement.Request, lifetime time.Duration) (e endorsement.Entry, err error) {
+	// the endorsement is restricted to the identity reviewed by the operator
+	g := endorsement.Grant{
+		Device:   r.Device.DeviceID,
+		Match:    endorsement.Exact(&r.Device),
+		Lifetime: lifetime,
+		Budget:   endorsement.Unlimited,
+		Note:     "re-endorsed by operator",
+	}
+
+	if e, err = AddEndorsement(g); err != nil {
+		return
+	}
+
+	Requests.Remove(r.Device.DeviceID)
+
+	return
+}
+
+// DeclineRequest discards a pending re-endorsement request.
+func DeclineRequest(r endorsement.Request) {
+	log.Printf("SM declined re-endorsement of %s", r.Device)
+	Requests.Remove(r.Device.DeviceID)
+}
+
+// requestEndorsement handles a SYS_REENDORSE monitor call from the Normal
+// World, the batch of blocked packets is read from the caller buffer.
+//
+// The call returns 1 if the request is queued for operator review, 0 if not
+// required or already pending and -1 on error.
+func requestEndorsement(ctx *monitor.ExecCtx) (err error) {
+	var b endorsement.Batch
+
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	if n < 0 || n > endorsement.BatchBufferSize {
+		ctx.Ret(-1)
+		return
+	}
+
+	buf := make([]byte, n)
+	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+	if err = b.UnmarshalBinary(buf); err != nil {
+		ctx.Ret(-1)
+		return nil
+	}
+
+	e, _ := Endorsements.Lookup(&b.Device)
+
+	// revoked devices can only be endorsed again explicitly
+	if e.Status == endorsement.Active || e.Status == endorsement.Revoked {
+		ctx.Ret(0)
+		return
+	}
+
+	queued, err := Requests.Raise(&b, e.Status, "")
+
+	switch {
+	case err != nil:
+		log.Printf("SM could not queue re-endorsement of %s, %v", b.Device, err)
+		ctx.Ret(-1)
+		return nil
+	case queued:
+		log.Printf("SM re-endorsement of %s (%s) requested, use `reendorse` to review", b.Device, e.Status)
+		ctx.Ret(1)
+	default:
+		ctx.Ret(0)
+	}
+
+	return
+}
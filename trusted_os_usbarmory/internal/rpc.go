@@ -7,7 +7,6 @@ package gotee
 
 import (
 	"errors"
-	"crypto/rand"
 
 	usbarmory "github.com/usbarmory/tamago/board/usbarmory/mk2"
 
@@ -38,12 +37,22 @@ func (r *RPC) LED(led util.LEDStatus, _ *bool) error {
 	return nil
 }
 
-// Generates Nonce to prevent replay attacks
-func (r *RPC) GetChallenge(_ struct{}, out *util.Challenge) error {
-	// generate a fresh 32-byte nonce using crypto/rand
-	if _, err := rand.Read(out.Nonce[:]); err != nil {
-		return errors.New("[RPC.GetChallenge] rand.Read error")
-		return err
+// GetChallenge issues a single use nonce to prevent replay attacks.
+func (r *RPC) GetChallenge(_ struct{}, out *util.Challenge) (err error) {
+	out.Nonce, err = Challenge()
+	return
+}
+
+// Quote returns attestation evidence, signed with the device attestation key,
+// over the caller nonce and the loaded Trusted Applet and Normal World images.
+func (r *RPC) Quote(ch util.Challenge, out *util.Quote) (err error) {
+	q, err := Quote(ch.Nonce)
+
+	if err != nil {
+		return
 	}
-	return nil
+
+	*out = *q
+
+	return
 }
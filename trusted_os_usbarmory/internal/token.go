@@ -0,0 +1,70 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package gotee
+
+import (
+	"crypto/ed25519"
+	"log"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Tokens holds the endorsement tokens issued since boot, verified against the
+// device attestation key.
+var Tokens *endorsement.Tokens
+
+// TokenKey returns the public key against which endorsement tokens are
+// verified, which is the device attestation key as tokens and quotes are
+// domain separated.
+func TokenKey() (pub ed25519.PublicKey, err error) {
+	if err = initAttestationKey(); err != nil {
+		return
+	}
+
+	attestation.Lock()
+	defer attestation.Unlock()
+
+	pub = attestation.key.Public().(ed25519.PublicKey)
+
+	if Tokens == nil {
+		Tokens = endorsement.NewTokens(pub)
+	}
+
+	return
+}
+
+// MintToken issues an endorsement token for a device, valid for the given
+// lifetime (zero if unlimited) and limited to the given interface classes
+// (any if empty).
+func MintToken(dev endorsement.DeviceID, lifetime time.Duration, interfaces []endorsement.Class, note string) (t *endorsement.Token, err error) {
+	if _, err = TokenKey(); err != nil {
+		return
+	}
+
+	t = &endorsement.Token{
+		Device:     dev,
+		Issued:     time.Now().Unix(),
+		Interfaces: interfaces,
+		Note:       note,
+	}
+
+	if lifetime > 0 {
+		t.Expiry = t.Issued + int64(lifetime/time.Second)
+	}
+
+	attestation.Lock()
+	err = t.Sign(attestation.key)
+	attestation.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("SM issued token %s expiry:%d note:%q", t.Device, t.Expiry, t.Note)
+
+	return t, Tokens.Add(t)
+}
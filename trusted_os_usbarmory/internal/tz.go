@@ -34,6 +34,7 @@ func configureTrustZone(lock bool, wdog bool) (err error) {
 
 	if imx6ul.CAAM != nil {
 		// set CAAM as NonSecure
+		grantKeyDerivation()
 		imx6ul.CAAM.SetOwner(false)
 	}
 
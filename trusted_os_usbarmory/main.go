@@ -92,6 +92,10 @@ func init() {
 
 	gotee.TA = taELF
 	gotee.OS = osELF
+
+	if err := gotee.InitEndorsements(); err != nil {
+		log.Printf("SM %v", err)
+	}
 }
 
 func serialConsole() {
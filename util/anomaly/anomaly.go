@@ -0,0 +1,397 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package anomaly implements per-device and per-endpoint packet rate limits,
+// along with a rolling anomaly score which quarantines devices whose traffic
+// deviates from what they exhibited when first seen.
+//
+// The anomaly score decays at every packet and is raised by rate limited
+// packets, packet sizes and endpoints not seen while learning the device
+// traffic. Packets of quarantined devices are blocked, regardless of their
+// endorsement, but still logged for review.
+package anomaly
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Anomaly score penalties
+const (
+	// RatePenalty is added for rate limited packets
+	RatePenalty = 1
+	// SizePenalty is added for packet sizes not seen while learning
+	SizePenalty = 1
+	// EndpointPenalty is added for endpoints not seen while learning
+	EndpointPenalty = 4
+)
+
+// Config represents the rate limits and anomaly scoring parameters, zero
+// rates disable the corresponding limit.
+type Config struct {
+	// DeviceRate is the sustained packet rate permitted for each device
+	// (packets/s)
+	DeviceRate float64
+	// DeviceBurst is the packet burst permitted for each device
+	DeviceBurst float64
+	// EndpointRate is the sustained packet rate permitted for each
+	// endpoint (packets/s)
+	EndpointRate float64
+	// EndpointBurst is the packet burst permitted for each endpoint
+	EndpointBurst float64
+	// Learning is the number of packets, of each device, from which its
+	// packet sizes and endpoints are learned
+	Learning int
+	// Decay is the fraction of the anomaly score retained at each packet
+	Decay float64
+	// Threshold is the anomaly score at which a device is quarantined,
+	// quarantine is disabled if zero
+	Threshold float64
+}
+
+// DefaultConfig returns rate limits accommodating full-speed HID devices
+// polled every millisecond, and an anomaly threshold reached by sustained
+// rate limiting or a few packets on unexpected endpoints.
+func DefaultConfig() Config {
+	return Config{
+		DeviceRate:    2000,
+		DeviceBurst:   128,
+		EndpointRate:  1000,
+		EndpointBurst: 64,
+		Learning:      64,
+		Decay:         0.95,
+		Threshold:     16,
+	}
+}
+
+// bucket represents a token bucket.
+type bucket struct {
+	tokens float64
+	last   int64
+}
+
+// take returns whether a token is available, at the argument time in
+// nanoseconds, and consumes it.
+func (b *bucket) take(rate float64, burst float64, now int64) bool {
+	if rate == 0 {
+		return true
+	}
+
+	if b.last == 0 {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+rate*float64(now-b.last)/float64(time.Second))
+	}
+
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Stats represents the rate limiting and anomaly scoring state of a device.
+type Stats struct {
+	// Device is the device identity
+	Device endorsement.DeviceID
+	// Score is the anomaly score
+	Score float64
+	// Quarantined is set once the device is quarantined
+	Quarantined bool
+	// Reason describes the anomaly which triggered the quarantine
+	Reason string
+	// Packets is the number of observed packets
+	Packets uint64
+	// Limited is the number of rate limited packets
+	Limited uint64
+	// Anomalies is the number of packets with unexpected size or endpoint
+	Anomalies uint64
+	// Blocked is the number of packets blocked by quarantine
+	Blocked uint64
+	// Endpoints holds the learned endpoint addresses
+	Endpoints []uint8
+	// Sizes holds the learned packet sizes
+	Sizes []int
+}
+
+type device struct {
+	Stats
+
+	bucket    bucket
+	endpoints map[uint8]*bucket
+	learned   int
+	// last is the time of the last observed packet
+	last int64
+	log  endorsement.PacketLog
+}
+
+// Monitor represents the rate limiting and anomaly scoring state of all
+// devices.
+type Monitor struct {
+	sync.Mutex
+
+	// Config holds the rate limits and anomaly scoring parameters
+	Config Config
+
+	// Nanotime returns the time source for rate limits, in nanoseconds
+	// since the Unix epoch, it defaults to time.Now().
+	Nanotime func() int64
+
+	// Quarantine, if not nil, is invoked, with the Monitor locked, when a
+	// device is quarantined.
+	Quarantine func(dev endorsement.DeviceID, reason string)
+
+	devices map[endorsement.DeviceID]*device
+}
+
+// NewMonitor returns a monitor with the argument configuration.
+func NewMonitor(c Config) *Monitor {
+	return &Monitor{
+		Config:  c,
+		devices: make(map[endorsement.DeviceID]*device),
+	}
+}
+
+func (m *Monitor) now() int64 {
+	if m.Nanotime == nil {
+		return time.Now().UnixNano()
+	}
+
+	return m.Nanotime()
+}
+
+// times returns the time of each packet of a batch, in nanoseconds, the last
+// packet is taken as received at the argument time and earlier ones at their
+// reported offset from it.
+//
+// As packet times are reported by the submitter, they are clamped so that
+// they never decrease nor precede the last packet of the previous batch,
+// therefore rate limits cannot be credited with more time than elapsed.
+func (d *device) times(b *endorsement.Batch, now int64) (times []int64) {
+	var last time.Duration
+
+	if n := len(b.Times); n > 0 {
+		last = b.Times[n-1]
+	}
+
+	t := d.last
+
+	for i := range b.Packets {
+		pt := now
+
+		if i < len(b.Times) {
+			pt = now - int64(last-b.Times[i])
+		}
+
+		t = min(max(t, pt), now)
+		times = append(times, t)
+	}
+
+	d.last = max(d.last, now)
+
+	return
+}
+
+// Observe returns which packets of a batch are within rate limits and
+// whether the device is quarantined, in which case all its packets are
+// blocked and logged.
+//
+// Rate limits are evaluated at the time of each packet (see Batch.Times),
+// relative to the batch arrival time.
+func (m *Monitor) Observe(b *endorsement.Batch) (permitted []bool, quarantined bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	c := &m.Config
+	dev := b.Device.DeviceID
+	now := m.now()
+
+	d, ok := m.devices[dev]
+
+	if !ok {
+		// packets are never credited with time before the device is
+		// first seen
+		d = &device{
+			Stats:     Stats{Device: dev},
+			endpoints: make(map[uint8]*bucket),
+			last:      now,
+		}
+
+		m.devices[dev] = d
+	}
+
+	permitted = make([]bool, len(b.Packets))
+	times := d.times(b, now)
+
+	for i, pkt := range b.Packets {
+		var ep uint8
+		var penalty float64
+		var reason string
+
+		if i < len(b.Endpoints) {
+			ep = b.Endpoints[i]
+		}
+
+		d.Packets++
+
+		if d.Quarantined {
+			d.Blocked++
+			d.log.Log(pkt)
+			continue
+		}
+
+		epb, known := d.endpoints[ep]
+
+		if !known {
+			epb = &bucket{}
+		}
+
+		switch {
+		case d.learned < c.Learning:
+			if !known {
+				d.endpoints[ep] = epb
+				d.Endpoints = append(d.Endpoints, ep)
+			}
+
+			if !slices.Contains(d.Sizes, len(pkt)) {
+				d.Sizes = append(d.Sizes, len(pkt))
+			}
+
+			d.learned++
+		case !known:
+			penalty += EndpointPenalty
+			reason = fmt.Sprintf("unexpected endpoint %#02x", ep)
+		case !slices.Contains(d.Sizes, len(pkt)):
+			penalty += SizePenalty
+			reason = fmt.Sprintf("unexpected packet size %d", len(pkt))
+		}
+
+		if penalty > 0 {
+			d.Anomalies++
+		}
+
+		// both limits are evaluated so that each bucket accounts for
+		// all packets
+		deviceOK := d.bucket.take(c.DeviceRate, c.DeviceBurst, times[i])
+		endpointOK := epb.take(c.EndpointRate, c.EndpointBurst, times[i])
+
+		if !deviceOK || !endpointOK {
+			d.Limited++
+			penalty += RatePenalty
+
+			if reason == "" {
+				reason = "rate limit exceeded"
+			}
+		}
+
+		d.Score = d.Score*c.Decay + penalty
+
+		if c.Threshold > 0 && d.Score >= c.Threshold {
+			d.Quarantined = true
+			d.Reason = reason
+			d.Blocked++
+			d.log.Log(pkt)
+
+			if m.Quarantine != nil {
+				m.Quarantine(dev, reason)
+			}
+
+			continue
+		}
+
+		permitted[i] = deviceOK && endpointOK
+	}
+
+	return permitted, d.Quarantined
+}
+
+// Check returns the verdict of an endorsement cache on the packets of a batch
+// which are within rate limits, the packets of quarantined devices are
+// blocked regardless of their endorsement.
+func (m *Monitor) Check(c *endorsement.Cache, b *endorsement.Batch) (v *endorsement.Verdict) {
+	var idx []int
+
+	permitted, quarantined := m.Observe(b)
+
+	sub := &endorsement.Batch{
+		Device: b.Device,
+	}
+
+	for i, ok := range permitted {
+		if ok {
+			sub.Packets = append(sub.Packets, b.Packets[i])
+			idx = append(idx, i)
+		}
+	}
+
+	v = c.Check(sub)
+
+	all := make([]bool, len(b.Packets))
+
+	for j, i := range idx {
+		all[i] = v.Permitted[j]
+	}
+
+	v.Permitted = all
+	v.Quarantined = quarantined
+
+	return
+}
+
+// Release lifts the quarantine of a device and resets its anomaly score and
+// learned traffic.
+func (m *Monitor) Release(dev endorsement.DeviceID) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.devices[dev]; !ok {
+		return false
+	}
+
+	delete(m.devices, dev)
+
+	return true
+}
+
+// Stats returns the state of all observed devices, sorted by device identity.
+func (m *Monitor) Stats() (stats []Stats) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, d := range m.devices {
+		s := d.Stats
+		s.Endpoints = append([]uint8(nil), d.Endpoints...)
+		s.Sizes = append([]int(nil), d.Sizes...)
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Device.String() < stats[j].Device.String()
+	})
+
+	return
+}
+
+// PacketLog returns the most recent packets blocked by the quarantine of a
+// device, oldest first.
+func (m *Monitor) PacketLog(dev endorsement.DeviceID) [][]byte {
+	m.Lock()
+	defer m.Unlock()
+
+	if d, ok := m.devices[dev]; ok {
+		return d.log.Records()
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+var testDevice = endorsement.DeviceID{VendorID: 0x046d, ProductID: 0xc31c}
+
+// testBatch returns a batch of 8 byte packets on endpoint 0x81, with the
+// argument packet times, if any.
+func testBatch(n int, times ...time.Duration) *endorsement.Batch {
+	b := &endorsement.Batch{
+		Device: endorsement.Device{DeviceID: testDevice},
+		Times:  times,
+	}
+
+	for i := 0; i < n; i++ {
+		b.Endpoints = append(b.Endpoints, 0x81)
+		b.Packets = append(b.Packets, make([]byte, 8))
+	}
+
+	return b
+}
+
+func count(permitted []bool) (n int) {
+	for _, ok := range permitted {
+		if ok {
+			n += 1
+		}
+	}
+
+	return
+}
+
+// spaced returns n packet times at the argument interval.
+func spaced(n int, interval time.Duration) (times []time.Duration) {
+	for i := 0; i < n; i++ {
+		times = append(times, time.Duration(i)*interval)
+	}
+
+	return
+}
+
+func TestBucket(t *testing.T) {
+	var b bucket
+
+	now := int64(time.Second)
+
+	for i := 0; i < 4; i++ {
+		if !b.take(10, 4, now) {
+			t.Fatalf("burst packet %d limited", i)
+		}
+	}
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		ok      bool
+	}{
+		{0, false},
+		{50 * time.Millisecond, false},
+		{50 * time.Millisecond, true},
+		{50 * time.Millisecond, false},
+		// refill never exceeds the burst
+		{time.Hour, true},
+		{0, true},
+		{0, true},
+		{0, true},
+		{0, false},
+	} {
+		now += int64(tc.elapsed)
+
+		if ok := b.take(10, 4, now); ok != tc.ok {
+			t.Errorf("%v: %v, want %v", time.Duration(now), ok, tc.ok)
+		}
+	}
+
+	// zero rates disable the limit
+	for i := 0; i < 16; i++ {
+		if !b.take(0, 0, now) {
+			t.Fatal("disabled limit applied")
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		elapsed   time.Duration
+		batch     *endorsement.Batch
+		permitted int
+	}{
+		// packets are received at batch arrival time
+		{"untimed", time.Second, testBatch(8), 1},
+		// packets received over the elapsed time
+		{"timed", time.Second, testBatch(8, spaced(8, 100*time.Millisecond)...), 8},
+		// packets credited with more time than elapsed
+		{"forged", time.Second, testBatch(8, spaced(8, time.Second)...), 1},
+		// packets credited with time after arrival
+		{"reordered", time.Second, testBatch(2, time.Second, 0), 1},
+	} {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+
+		m := NewMonitor(Config{DeviceRate: 10, DeviceBurst: 1})
+		m.Nanotime = func() int64 { return now }
+
+		// exhaust the burst
+		if permitted, _ := m.Observe(testBatch(4)); count(permitted) != 1 {
+			t.Errorf("%s: burst %v", tc.name, permitted)
+		}
+
+		now += int64(tc.elapsed)
+
+		if permitted, _ := m.Observe(tc.batch); count(permitted) != tc.permitted {
+			t.Errorf("%s: permitted %v, want %d packets", tc.name, permitted, tc.permitted)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	var quarantined []endorsement.DeviceID
+
+	c := Config{
+		Learning:  4,
+		Decay:     1,
+		Threshold: 2 * EndpointPenalty,
+	}
+
+	m := NewMonitor(c)
+	m.Quarantine = func(dev endorsement.DeviceID, reason string) {
+		quarantined = append(quarantined, dev)
+	}
+
+	if permitted, q := m.Observe(testBatch(c.Learning)); count(permitted) != c.Learning || q {
+		t.Fatalf("learning permitted:%v quarantined:%v", permitted, q)
+	}
+
+	// a packet size not seen while learning is penalized
+	b := testBatch(1)
+	b.Packets[0] = make([]byte, 3)
+
+	if permitted, q := m.Observe(b); count(permitted) != 1 || q {
+		t.Fatalf("unexpected size permitted:%v quarantined:%v", permitted, q)
+	}
+
+	// an endpoint not seen while learning is penalized, reaching the
+	// threshold
+	b = testBatch(2)
+	b.Endpoints[0] = 0x02
+	b.Endpoints[1] = 0x02
+
+	permitted, q := m.Observe(b)
+
+	if count(permitted) != 1 || !q || len(quarantined) != 1 {
+		t.Fatalf("unexpected endpoint permitted:%v quarantined:%v", permitted, q)
+	}
+
+	// all packets are now blocked and logged
+	if permitted, _ = m.Observe(testBatch(2)); count(permitted) != 0 {
+		t.Errorf("quarantined device permitted %v", permitted)
+	}
+
+	s := m.Stats()
+
+	if len(s) != 1 || s[0].Anomalies != 3 || s[0].Blocked != 3 || s[0].Reason != "unexpected endpoint 0x02" {
+		t.Errorf("invalid stats %+v", s)
+	}
+
+	if log := m.PacketLog(testDevice); len(log) != 3 {
+		t.Errorf("logged %d packets, want 3", len(log))
+	}
+
+	if !m.Release(testDevice) {
+		t.Fatal("could not release device")
+	}
+
+	if permitted, q = m.Observe(testBatch(1)); count(permitted) != 1 || q {
+		t.Errorf("released device permitted:%v quarantined:%v", permitted, q)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package attest implements verification of attestation quotes issued by the
+// GoTEE example Trusted OS.
+//
+// The package is pure Go and suitable for use on verifiers running outside
+// the device.
+package attest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+var (
+	ErrNoKey       = errors.New("no enrolled attestation public key")
+	ErrVersion     = errors.New("unsupported quote version")
+	ErrPublicKey   = errors.New("quote public key does not match enrolled key")
+	ErrSignature   = errors.New("invalid quote signature")
+	ErrNonce       = errors.New("quote nonce does not match challenge")
+	ErrMeasurement = errors.New("measurement does not match reference values")
+	ErrEventLog    = errors.New("event log does not match quote")
+)
+
+// Verifier represents an attestation verifier for a single enrolled device.
+type Verifier struct {
+	// PublicKey is the enrolled device attestation public key
+	PublicKey ed25519.PublicKey
+	// Reference holds the accepted measurements
+	Reference *ReferenceValues
+}
+
+// Verify checks the quote signature against the enrolled device public key,
+// the public key carried by the quote is never trusted on its own.
+func Verify(q *util.Quote, pub ed25519.PublicKey) error {
+	if len(pub) == 0 {
+		return ErrNoKey
+	}
+
+	if q.Version != util.QuoteVersion {
+		return ErrVersion
+	}
+
+	if len(pub) != ed25519.PublicKeySize || !bytes.Equal(q.PublicKey, pub) {
+		return ErrPublicKey
+	}
+
+	if !ed25519.Verify(pub, q.Bytes(), q.Signature) {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// Verify checks the quote signature against the enrolled device public key,
+// its freshness against the challenge nonce and its measurements against the
+// reference values.
+func (v *Verifier) Verify(q *util.Quote, nonce [32]byte) (err error) {
+	if err = Verify(q, v.PublicKey); err != nil {
+		return
+	}
+
+	if subtle.ConstantTimeCompare(q.Nonce[:], nonce[:]) != 1 {
+		return ErrNonce
+	}
+
+	if v.Reference == nil {
+		return fmt.Errorf("%w (missing)", ErrMeasurement)
+	}
+
+	return v.Reference.Match(q.Applet, q.OS)
+}
+
+// VerifyEventLog replays the measured boot event log and checks that the
+// resulting register value matches the one covered by the quote, which
+// therefore covers the type, description and digest of each event.
+//
+// The quote must be verified, see Verify(), before its event log is trusted.
+func VerifyEventLog(q *util.Quote, events []util.Event) error {
+	if util.Replay(events) != util.Digest(q.EventLog) {
+		return ErrEventLog
+	}
+
+	return nil
+}
+
+// ParseEventLog parses a measured boot event log in JSON format, as returned
+// by the Trusted OS `eventlog json` console command.
+func ParseEventLog(data []byte) (events []util.Event, err error) {
+	if err = json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("invalid event log, %v", err)
+	}
+
+	return
+}
+
+// ParseQuote parses a quote serialized with util.Quote.MarshalBinary(), either
+// in raw binary form or hex encoded (whitespace is ignored).
+func ParseQuote(data []byte) (q *util.Quote, err error) {
+	q = &util.Quote{}
+
+	if err = q.UnmarshalBinary(data); err == nil {
+		return
+	}
+
+	buf, hexErr := hex.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+
+	if hexErr != nil {
+		return nil, err
+	}
+
+	if err = q.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// ParsePublicKey parses a hex encoded Ed25519 public key, an empty string
+// returns ErrNoKey.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, ErrNoKey
+	}
+
+	buf, err := hex.DecodeString(strings.TrimSpace(s))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key size")
+	}
+
+	return ed25519.PublicKey(buf), nil
+}
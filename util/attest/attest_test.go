@@ -0,0 +1,250 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// golden holds the testdata quote, issued with a fixed key over a fixed nonce
+// and measurements.
+type golden struct {
+	raw   []byte
+	hex   []byte
+	pub   ed25519.PublicKey
+	nonce [32]byte
+	ref   *ReferenceValues
+}
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func loadGolden(t *testing.T) (g *golden) {
+	t.Helper()
+
+	var err error
+
+	g = &golden{
+		raw: readFile(t, "quote.bin"),
+		hex: readFile(t, "quote.hex"),
+	}
+
+	if g.pub, err = ParsePublicKey(string(readFile(t, "key.hex"))); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, err := hex.DecodeString(strings.TrimSpace(string(readFile(t, "nonce.hex"))))
+
+	if err != nil || len(nonce) != len(g.nonce) {
+		t.Fatalf("invalid testdata nonce, %v", err)
+	}
+
+	copy(g.nonce[:], nonce)
+
+	if g.ref, err = LoadReferenceValues(filepath.Join("testdata", "reference.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func (g *golden) quote(t *testing.T) *util.Quote {
+	t.Helper()
+
+	q, err := ParseQuote(g.raw)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q
+}
+
+func TestParseQuote(t *testing.T) {
+	g := loadGolden(t)
+
+	raw, err := ParseQuote(g.raw)
+
+	if err != nil {
+		t.Fatalf("raw quote: %v", err)
+	}
+
+	hexQuote, err := ParseQuote(g.hex)
+
+	if err != nil {
+		t.Fatalf("hex quote: %v", err)
+	}
+
+	for _, q := range []*util.Quote{raw, hexQuote} {
+		if q.Version != util.QuoteVersion {
+			t.Errorf("version %d, want %d", q.Version, util.QuoteVersion)
+		}
+
+		if q.Nonce != g.nonce {
+			t.Errorf("nonce %x, want %x", q.Nonce, g.nonce)
+		}
+
+		if !bytes.Equal(q.PublicKey, g.pub) {
+			t.Errorf("public key %x, want %x", q.PublicKey, g.pub)
+		}
+	}
+
+	for _, n := range []int{0, 1, len(g.raw) / 2, len(g.raw) - 1} {
+		if _, err := ParseQuote(g.raw[:n]); err == nil {
+			t.Errorf("truncated quote (%d bytes) parsed", n)
+		}
+	}
+
+	if _, err := ParseQuote(g.hex[:len(g.hex)/2]); err == nil {
+		t.Error("truncated hex quote parsed")
+	}
+
+	if _, err := ParseQuote([]byte("not a quote")); err == nil {
+		t.Error("garbage quote parsed")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	g := loadGolden(t)
+
+	seed := sha256.Sum256([]byte("another key"))
+	otherKey := ed25519.NewKeyFromSeed(seed[:])
+	other := otherKey.Public().(ed25519.PublicKey)
+
+	tamper := func(f func(q *util.Quote)) *util.Quote {
+		q := g.quote(t)
+		f(q)
+		return q
+	}
+
+	for _, tc := range []struct {
+		name string
+		q    *util.Quote
+		pub  ed25519.PublicKey
+		err  error
+	}{
+		{"good", g.quote(t), g.pub, nil},
+		{"no key", g.quote(t), nil, ErrNoKey},
+		{"self-signed", tamper(func(q *util.Quote) { q.Sign(otherKey) }), g.pub, ErrPublicKey},
+		{"wrong key", g.quote(t), other, ErrPublicKey},
+		{"invalid key", g.quote(t), g.pub[:16], ErrPublicKey},
+		{"version", tamper(func(q *util.Quote) { q.Version += 1 }), g.pub, ErrVersion},
+		{"tampered applet", tamper(func(q *util.Quote) { q.Applet[0] ^= 1 }), g.pub, ErrSignature},
+		{"tampered os", tamper(func(q *util.Quote) { q.OS[0] ^= 1 }), g.pub, ErrSignature},
+		{"tampered nonce", tamper(func(q *util.Quote) { q.Nonce[0] ^= 1 }), g.pub, ErrSignature},
+		{"tampered signature", tamper(func(q *util.Quote) { q.Signature[0] ^= 1 }), g.pub, ErrSignature},
+		{"substituted key", tamper(func(q *util.Quote) { q.PublicKey = other }), other, ErrSignature},
+	} {
+		if err := Verify(tc.q, tc.pub); !errors.Is(err, tc.err) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	g := loadGolden(t)
+
+	if _, err := ParsePublicKey(" \n"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("empty key: %v, want %v", err, ErrNoKey)
+	}
+
+	for _, s := range []string{"zz", hex.EncodeToString(g.pub[:31])} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("%q: parsed", s)
+		}
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	g := loadGolden(t)
+
+	seed := sha256.Sum256([]byte("another key"))
+	other := ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey)
+
+	wrongNonce := g.nonce
+	wrongNonce[31] ^= 1
+
+	tampered := g.quote(t)
+	tampered.Applet[0] ^= 1
+
+	unknownOS := &ReferenceValues{
+		Applet: g.ref.Applet,
+		OS:     []util.Digest{sha256.Sum256([]byte("other os"))},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		v     *Verifier
+		q     *util.Quote
+		nonce [32]byte
+		err   error
+	}{
+		{"good", &Verifier{g.pub, g.ref}, g.quote(t), g.nonce, nil},
+		{"nonce mismatch", &Verifier{g.pub, g.ref}, g.quote(t), wrongNonce, ErrNonce},
+		{"tampered measurement", &Verifier{g.pub, g.ref}, tampered, g.nonce, ErrSignature},
+		{"wrong key", &Verifier{other, g.ref}, g.quote(t), g.nonce, ErrPublicKey},
+		{"unknown measurement", &Verifier{g.pub, unknownOS}, g.quote(t), g.nonce, ErrMeasurement},
+		{"empty reference", &Verifier{g.pub, &ReferenceValues{}}, g.quote(t), g.nonce, ErrMeasurement},
+		{"missing reference", &Verifier{g.pub, nil}, g.quote(t), g.nonce, ErrMeasurement},
+	} {
+		if err := tc.v.Verify(tc.q, tc.nonce); !errors.Is(err, tc.err) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestVerifyEventLog(t *testing.T) {
+	log := &util.EventLog{}
+	log.Measure(util.EventApplet, "trusted_applet.elf", []byte("applet"))
+	log.Measure(util.EventKernel, "/boot/zImage", []byte("kernel"))
+
+	q := &util.Quote{EventLog: log.PCR()}
+	events := log.Events()
+
+	if err := VerifyEventLog(q, events); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(e []util.Event)
+	}{
+		{"type", func(e []util.Event) { e[1].Type = util.EventNormalWorld }},
+		{"description", func(e []util.Event) { e[1].Description = "/boot/vmlinuz" }},
+		{"digest", func(e []util.Event) { e[0].Digest[0] ^= 1 }},
+		{"order", func(e []util.Event) { e[0], e[1] = e[1], e[0] }},
+	} {
+		tampered := append([]util.Event(nil), events...)
+		tc.tamper(tampered)
+
+		if err := VerifyEventLog(q, tampered); !errors.Is(err, ErrEventLog) {
+			t.Errorf("tampered %s: %v, want %v", tc.name, err, ErrEventLog)
+		}
+	}
+
+	if err := VerifyEventLog(q, events[:1]); !errors.Is(err, ErrEventLog) {
+		t.Errorf("truncated: %v, want %v", err, ErrEventLog)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/usbarmory/GoTEE-example/util"
+)
+
+// ReferenceValues represents the set of accepted measurements for the Trusted
+// Applet and Normal World images, an empty set matches no measurement.
+//
+// Reference values are stored in JSON format:
+//
+//	{
+//	  "applet": ["<hex SHA-256>", ...],
+//	  "os": ["<hex SHA-256>", ...]
+//	}
+type ReferenceValues struct {
+	// Applet holds the accepted Trusted Applet measurements
+	Applet []util.Digest `json:"applet"`
+	// OS holds the accepted Normal World measurements
+	OS []util.Digest `json:"os"`
+}
+
+// ParseReferenceValues parses reference values in JSON format.
+func ParseReferenceValues(data []byte) (ref *ReferenceValues, err error) {
+	ref = &ReferenceValues{}
+
+	if err = json.Unmarshal(data, ref); err != nil {
+		return nil, fmt.Errorf("invalid reference values, %v", err)
+	}
+
+	return
+}
+
+// LoadReferenceValues reads and parses a reference values JSON file.
+func LoadReferenceValues(path string) (*ReferenceValues, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseReferenceValues(data)
+}
+
+func contains(set []util.Digest, m [sha256.Size]byte) bool {
+	for _, d := range set {
+		if d == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match verifies that the applet and OS measurements are both present within
+// the reference values.
+func (ref *ReferenceValues) Match(applet [sha256.Size]byte, os [sha256.Size]byte) error {
+	if !contains(ref.Applet, applet) {
+		return fmt.Errorf("%w (applet:%x)", ErrMeasurement, applet)
+	}
+
+	if !contains(ref.OS, os) {
+		return fmt.Errorf("%w (os:%x)", ErrMeasurement, os)
+	}
+
+	return nil
+}
@@ -1,5 +1,123 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
 package util
 
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// QuoteVersion is the current quote serialization format version.
+const QuoteVersion = 2
+
+// quoteMagic prefixes the canonical serialization of quotes to provide domain
+// separation for signatures issued with the attestation key.
+const quoteMagic = "GoTEE-quote"
+
+// quoteSize is the size of the canonical serialization of a quote, excluding
+// its signature.
+const quoteSize = len(quoteMagic) + 2 + 32 + sha256.Size*3 + ed25519.PublicKeySize
+
+// QuoteSize is the size of a serialized quote (see MarshalBinary()).
+const QuoteSize = quoteSize + ed25519.SignatureSize
+
+// Challenge represents a nonce issued by the Trusted OS (see RPC.GetChallenge)
+// or by a verifier to ensure freshness of attestation evidence.
 type Challenge struct {
 	Nonce [32]byte
 }
+
+// Quote represents attestation evidence, issued by the Trusted OS, over the
+// measurements of the loaded Trusted Applet and Normal World images and the
+// measured boot event log.
+type Quote struct {
+	// Version is the quote format version
+	Version uint16
+	// Nonce is the challenge provided by the caller
+	Nonce [32]byte
+	// Applet is the SHA-256 measurement of the Trusted Applet ELF
+	Applet [sha256.Size]byte
+	// OS is the SHA-256 measurement of the Normal World image (or Linux
+	// kernel), as recorded in the event log
+	OS [sha256.Size]byte
+	// EventLog is the measured boot event log register value (see Replay())
+	EventLog [sha256.Size]byte
+	// PublicKey is the device attestation public key (Ed25519)
+	PublicKey []byte
+	// Signature is the Ed25519 signature over the canonical serialization
+	Signature []byte
+}
+
+// Bytes returns the canonical serialization of the quote, which represents
+// the message covered by its signature.
+func (q *Quote) Bytes() []byte {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(quoteMagic)
+	binary.Write(buf, binary.BigEndian, q.Version)
+	buf.Write(q.Nonce[:])
+	buf.Write(q.Applet[:])
+	buf.Write(q.OS[:])
+	buf.Write(q.EventLog[:])
+
+	pub := make([]byte, ed25519.PublicKeySize)
+	copy(pub, q.PublicKey)
+	buf.Write(pub)
+
+	return buf.Bytes()
+}
+
+// MarshalBinary returns the canonical serialization of the quote followed by
+// its signature.
+func (q *Quote) MarshalBinary() ([]byte, error) {
+	if len(q.Signature) != ed25519.SignatureSize {
+		return nil, errors.New("invalid signature size")
+	}
+
+	return append(q.Bytes(), q.Signature...), nil
+}
+
+// UnmarshalBinary parses a quote previously serialized with MarshalBinary().
+func (q *Quote) UnmarshalBinary(data []byte) error {
+	if len(data) != QuoteSize {
+		return errors.New("invalid quote size")
+	}
+
+	if string(data[0:len(quoteMagic)]) != quoteMagic {
+		return errors.New("invalid quote magic")
+	}
+
+	off := len(quoteMagic)
+
+	if q.Version = binary.BigEndian.Uint16(data[off:]); q.Version != QuoteVersion {
+		return errors.New("unsupported quote version")
+	}
+
+	off += 2
+	off += copy(q.Nonce[:], data[off:])
+	off += copy(q.Applet[:], data[off:])
+	off += copy(q.OS[:], data[off:])
+	off += copy(q.EventLog[:], data[off:])
+
+	q.PublicKey = make([]byte, ed25519.PublicKeySize)
+	off += copy(q.PublicKey, data[off:])
+
+	q.Signature = make([]byte, ed25519.SignatureSize)
+	copy(q.Signature, data[off:])
+
+	return nil
+}
+
+// Sign fills the quote public key and signature using the argument private
+// key.
+func (q *Quote) Sign(key ed25519.PrivateKey) {
+	q.Version = QuoteVersion
+	q.PublicKey = key.Public().(ed25519.PublicKey)
+	q.Signature = ed25519.Sign(key, q.Bytes())
+}
@@ -0,0 +1,275 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package audit implements an append-only, hash-chained, audit log of USB
+// device endorsement changes and packet filter decisions.
+//
+// Each entry hash covers the previous entry hash, so that the chain head
+// commits to the entire history. The log is meant to be held by the secure
+// side, which anchors the chain head, so that exported copies can be verified
+// against it and history cannot be rewritten by the Normal World.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Audit events
+const (
+	// Endorse records the installation of an endorsement
+	Endorse = "endorse"
+	// Revoke records the revocation of an endorsement
+	Revoke = "revoke"
+	// Downgrade records the early expiry of an endorsement
+	Downgrade = "downgrade"
+	// Restrict records a change of endorsement identity constraints
+	Restrict = "restrict"
+	// Import records the import of an endorsement database
+	Import = "import"
+	// Flag records suspicious device activity reported by the filter
+	Flag = "flag"
+	// Quarantine records the quarantine of a device
+	Quarantine = "quarantine"
+	// Release records the release of a quarantined device
+	Release = "release"
+	// Allow records permitted packets
+	Allow = "allow"
+	// Deny records blocked packets
+	Deny = "deny"
+)
+
+// maxFieldSize is the maximum size of entry string fields.
+const maxFieldSize = 0xffff
+
+// Entry represents an audit log entry.
+type Entry struct {
+	// Seq is the entry sequence number, starting from 1
+	Seq uint64 `json:"seq"`
+	// Time is the entry time (Unix seconds)
+	Time int64 `json:"time"`
+	// Event is the audited event
+	Event string `json:"event"`
+	// Device is the device identity in vid:pid:serial format, if any
+	Device string `json:"device,omitempty"`
+	// Status is the endorsement status after the event, if any
+	Status string `json:"status,omitempty"`
+	// Detail holds event specific information
+	Detail string `json:"detail,omitempty"`
+	// Prev is the previous entry hash (hex)
+	Prev string `json:"prev"`
+	// Hash is the entry hash (hex)
+	Hash string `json:"hash"`
+}
+
+// sum returns the entry hash, over the previous entry hash and the entry
+// fields.
+func (e *Entry) sum(prev [sha256.Size]byte) (h [sha256.Size]byte) {
+	buf := append([]byte(nil), prev[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, e.Seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.Time))
+
+	for _, f := range []string{e.Event, e.Device, e.Status, e.Detail} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(f)))
+		buf = append(buf, f...)
+	}
+
+	return sha256.Sum256(buf)
+}
+
+// Export represents an exported audit log.
+type Export struct {
+	// Head is the chain head (hex) at the time of export
+	Head string `json:"head"`
+	// Entries holds the retained entries, oldest first
+	Entries []Entry `json:"entries"`
+}
+
+// Log represents an audit log.
+type Log struct {
+	sync.Mutex
+
+	// Limit is the number of most recent entries retained, all entries
+	// are retained if zero. Discarded entries remain committed to by the
+	// chain head.
+	Limit int
+
+	// Nanotime returns the time source for entries, in nanoseconds since
+	// the Unix epoch, it defaults to time.Now().
+	Nanotime func() int64
+
+	entries []Entry
+	head    [sha256.Size]byte
+	seq     uint64
+}
+
+// now returns the current time in Unix seconds.
+func (l *Log) now() int64 {
+	if l.Nanotime == nil {
+		return time.Now().Unix()
+	}
+
+	return l.Nanotime() / int64(time.Second)
+}
+
+// Append adds an entry to the log and returns it, the device identity is
+// optional.
+func (l *Log) Append(event string, dev *endorsement.DeviceID, status string, detail string) Entry {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(detail) > maxFieldSize {
+		detail = detail[:maxFieldSize]
+	}
+
+	l.seq++
+
+	e := Entry{
+		Seq:    l.seq,
+		Time:   l.now(),
+		Event:  event,
+		Status: status,
+		Detail: detail,
+		Prev:   hex.EncodeToString(l.head[:]),
+	}
+
+	if dev != nil {
+		e.Device = dev.Key()
+	}
+
+	l.head = e.sum(l.head)
+	e.Hash = hex.EncodeToString(l.head[:])
+
+	l.entries = append(l.entries, e)
+
+	if l.Limit > 0 && len(l.entries) > l.Limit {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-l.Limit:]...)
+	}
+
+	return e
+}
+
+// Head returns the sequence number of the last entry and the chain head.
+func (l *Log) Head() (seq uint64, head [sha256.Size]byte) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.seq, l.head
+}
+
+// Entries returns the retained entries, oldest first.
+func (l *Log) Entries() []Entry {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]Entry(nil), l.entries...)
+}
+
+// Export returns the retained entries and the chain head in JSON format.
+func (l *Log) Export() ([]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	return json.MarshalIndent(&Export{
+		Head:    hex.EncodeToString(l.head[:]),
+		Entries: l.entries,
+	}, "", "  ")
+}
+
+// Verify parses and verifies an exported audit log, entries must be
+// consecutive and correctly chained, and the last one must match the export
+// chain head. The verified export is returned.
+//
+// Verify does not anchor the export, see Log.Anchor().
+func Verify(data []byte) (x *Export, err error) {
+	x = &Export{}
+
+	if err = json.Unmarshal(data, x); err != nil {
+		return nil, fmt.Errorf("invalid audit log, %v", err)
+	}
+
+	if len(x.Entries) == 0 {
+		return nil, errors.New("empty audit log")
+	}
+
+	for i, e := range x.Entries {
+		var prev [sha256.Size]byte
+
+		if i > 0 && e.Seq != x.Entries[i-1].Seq+1 {
+			return nil, fmt.Errorf("entry %d, non consecutive sequence number", e.Seq)
+		}
+
+		if i > 0 && e.Prev != x.Entries[i-1].Hash {
+			return nil, fmt.Errorf("entry %d, broken chain", e.Seq)
+		}
+
+		buf, err := hex.DecodeString(e.Prev)
+
+		if err != nil || len(buf) != len(prev) {
+			return nil, fmt.Errorf("entry %d, invalid previous hash", e.Seq)
+		}
+
+		copy(prev[:], buf)
+
+		if e.Seq == 1 && prev != [sha256.Size]byte{} {
+			return nil, errors.New("entry 1, invalid previous hash")
+		}
+
+		if h := e.sum(prev); hex.EncodeToString(h[:]) != e.Hash {
+			return nil, fmt.Errorf("entry %d, invalid hash", e.Seq)
+		}
+	}
+
+	if x.Entries[len(x.Entries)-1].Hash != x.Head {
+		return nil, errors.New("chain head mismatch")
+	}
+
+	return
+}
+
+// Anchor verifies that the last entry of an exported audit log, previously
+// verified with Verify(), is part of the log history.
+//
+// Entries which are no longer retained cannot be anchored, unless the export
+// ends at the current chain head.
+func (l *Log) Anchor(x *Export) error {
+	l.Lock()
+	defer l.Unlock()
+
+	last := x.Entries[len(x.Entries)-1]
+
+	switch {
+	case last.Seq > l.seq:
+		return fmt.Errorf("entry %d, not in log history", last.Seq)
+	case last.Seq == l.seq:
+		if last.Hash != hex.EncodeToString(l.head[:]) {
+			return errors.New("chain head mismatch")
+		}
+
+		return nil
+	}
+
+	for _, e := range l.entries {
+		if e.Seq != last.Seq {
+			continue
+		}
+
+		if e.Hash != last.Hash {
+			return fmt.Errorf("entry %d, hash mismatch", last.Seq)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("entry %d, no longer retained", last.Seq)
+}
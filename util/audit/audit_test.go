@@ -0,0 +1,154 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+var testDevice = &endorsement.DeviceID{VendorID: 0x046d, ProductID: 0xc31c}
+
+func testLog(n int) *Log {
+	l := &Log{
+		Nanotime: func() int64 { return 1700000000e9 },
+	}
+
+	for i := 0; i < n; i++ {
+		l.Append(Allow, testDevice, "trusted", "1 packets")
+	}
+
+	return l
+}
+
+func export(t *testing.T, x *Export) []byte {
+	t.Helper()
+
+	buf, err := json.Marshal(x)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestVerify(t *testing.T) {
+	l := testLog(4)
+
+	buf, err := l.Export()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := Verify(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(x.Entries) != 4 || x.Entries[0].Seq != 1 {
+		t.Fatalf("invalid export %+v", x)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(x *Export)
+	}{
+		{"empty", func(x *Export) { x.Entries = nil }},
+		{"modified entry", func(x *Export) { x.Entries[1].Detail = "0 packets" }},
+		{"modified status", func(x *Export) { x.Entries[3].Status = "revoked" }},
+		{"removed entry", func(x *Export) { x.Entries = append(x.Entries[:1], x.Entries[2:]...) }},
+		{"reordered entries", func(x *Export) { x.Entries[1], x.Entries[2] = x.Entries[2], x.Entries[1] }},
+		{"rehashed entry", func(x *Export) {
+			x.Entries[3].Detail = "0 packets"
+			x.Entries[3].Hash = x.Entries[2].Hash
+		}},
+		{"head mismatch", func(x *Export) { x.Head = x.Entries[2].Hash }},
+		{"truncated head", func(x *Export) { x.Entries = x.Entries[:3] }},
+		{"invalid previous hash", func(x *Export) { x.Entries[0].Prev = "00" }},
+		{"forged first entry", func(x *Export) {
+			x.Entries = x.Entries[1:]
+			x.Entries[0].Seq = 1
+		}},
+	} {
+		x, err := Verify(buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tc.tamper(x)
+
+		if _, err := Verify(export(t, x)); err == nil {
+			t.Errorf("%s: verified", tc.name)
+		}
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	l := testLog(4)
+	l.Limit = 4
+
+	buf, err := l.Export()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := Verify(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.Anchor(x); err != nil {
+		t.Errorf("current head: %v", err)
+	}
+
+	// the export remains anchored while retained
+	for i := 0; i < 2; i++ {
+		l.Append(Deny, testDevice, "revoked", "1 packets")
+
+		if err = l.Anchor(x); err != nil {
+			t.Errorf("retained export: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		log  *Log
+	}{
+		// a log with a different history
+		{"forked", func() *Log {
+			l := testLog(3)
+			l.Append(Revoke, testDevice, "revoked", "")
+			return l
+		}()},
+		// a log which never reached the export
+		{"future", testLog(3)},
+		// a log which no longer retains the export
+		{"discarded", func() *Log {
+			l := testLog(4)
+			l.Limit = 1
+			l.Append(Allow, testDevice, "trusted", "1 packets")
+			return l
+		}()},
+	} {
+		if err = tc.log.Anchor(x); err == nil {
+			t.Errorf("%s: anchored", tc.name)
+		}
+	}
+
+	seq, head := l.Head()
+
+	if seq != 6 || l.Entries()[1].Hash != x.Entries[3].Hash {
+		t.Errorf("invalid log head %d %x", seq, head)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// MaxBatchPackets is the maximum number of packets in a batch.
+	MaxBatchPackets = 32
+	// MaxPacketSize is the maximum size of a packet in a batch.
+	MaxPacketSize = 64
+	// BatchBufferSize is the buffer size required to exchange any batch
+	// and its verdict.
+	BatchBufferSize = deviceHeaderSize + MaxSerialSize + MaxInterfaces*3 + 2 + MaxBatchPackets*(1+4+2+MaxPacketSize)
+
+	deviceHeaderSize  = 2 + 2 + 2 + 3 + 1 + 1
+	verdictHeaderSize = 1 + 8 + 4 + 1 + 2
+)
+
+// Batch represents a set of packets, received from a single device, submitted
+// for a verdict.
+//
+// Its binary format, used across the Normal World monitor call (see
+// util.SYS_ENDORSEMENT), is:
+//
+//	vid (uint16) | pid (uint16) | bcdDevice (uint16) | class (3 * uint8) |
+//	serial len (uint8) | serial | interfaces (uint8) | interfaces * class (3 * uint8) |
+//	count (uint16) | count * (endpoint (uint8) | time (uint32, µs) | len (uint16) | data)
+type Batch struct {
+	Device  Device
+	Packets [][]byte
+	// Endpoints holds the endpoint address of each packet, packets
+	// without one have unknown (0) endpoints
+	Endpoints []uint8
+	// Times holds the reception time of each packet, relative to the
+	// first one, packets without one are received with the first one
+	Times []time.Duration
+}
+
+// Verdict represents the secure side decision on a batch.
+//
+// Its binary format, written in place of the batch, is:
+//
+//	status (uint8) | expiry (int64) | budget (uint32) | quarantined (uint8) |
+//	count (uint16) | count * permitted (uint8)
+type Verdict struct {
+	// Status is the endorsement status after the batch
+	Status Status
+	// Expiry is the endorsement expiry time (Unix seconds), zero if unset
+	Expiry int64
+	// Budget is the remaining packet budget after the batch
+	Budget uint32
+	// Quarantined is set when the device is quarantined (see
+	// anomaly.Monitor), all its packets are then blocked
+	Quarantined bool
+	// Permitted holds the verdict for each packet of the batch
+	Permitted []bool
+}
+
+// MarshalBinary returns the serialized batch.
+func (b *Batch) MarshalBinary() ([]byte, error) {
+	d := &b.Device
+
+	if len(b.Packets) > MaxBatchPackets {
+		return nil, errors.New("too many packets")
+	}
+
+	if len(d.Serial) > MaxSerialSize {
+		return nil, errors.New("invalid serial number size")
+	}
+
+	if len(d.Interfaces) > MaxInterfaces {
+		return nil, errors.New("too many interfaces")
+	}
+
+	buf := make([]byte, 0, BatchBufferSize)
+	buf = binary.BigEndian.AppendUint16(buf, d.VendorID)
+	buf = binary.BigEndian.AppendUint16(buf, d.ProductID)
+	buf = binary.BigEndian.AppendUint16(buf, d.BCDDevice)
+	buf = append(buf, d.Class.Class, d.Class.SubClass, d.Class.Protocol)
+
+	buf = append(buf, uint8(len(d.Serial)))
+	buf = append(buf, d.Serial...)
+
+	buf = append(buf, uint8(len(d.Interfaces)))
+
+	for _, c := range d.Interfaces {
+		buf = append(buf, c.Class, c.SubClass, c.Protocol)
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(b.Packets)))
+
+	if len(b.Endpoints) > len(b.Packets) {
+		return nil, errors.New("too many endpoints")
+	}
+
+	if len(b.Times) > len(b.Packets) {
+		return nil, errors.New("too many times")
+	}
+
+	for i, pkt := range b.Packets {
+		var ep uint8
+		var t time.Duration
+
+		if len(pkt) > MaxPacketSize {
+			return nil, errors.New("packet too large")
+		}
+
+		if i < len(b.Endpoints) {
+			ep = b.Endpoints[i]
+		}
+
+		if i < len(b.Times) {
+			t = b.Times[i]
+		}
+
+		if t < 0 || t/time.Microsecond > 0xffffffff {
+			return nil, errors.New("invalid packet time")
+		}
+
+		buf = append(buf, ep)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(t/time.Microsecond))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(pkt)))
+		buf = append(buf, pkt...)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary parses a batch serialized with MarshalBinary().
+func (b *Batch) UnmarshalBinary(data []byte) error {
+	d := &b.Device
+
+	if len(data) < deviceHeaderSize {
+		return errors.New("invalid batch size")
+	}
+
+	d.VendorID = binary.BigEndian.Uint16(data[0:])
+	d.ProductID = binary.BigEndian.Uint16(data[2:])
+	d.BCDDevice = binary.BigEndian.Uint16(data[4:])
+	d.Class = Class{data[6], data[7], data[8]}
+
+	n := int(data[9])
+	data = data[10:]
+
+	if n > MaxSerialSize || len(data) < n+1 {
+		return errors.New("invalid serial number size")
+	}
+
+	d.Serial = string(data[:n])
+	data = data[n:]
+
+	n = int(data[0])
+	data = data[1:]
+
+	if n > MaxInterfaces || len(data) < n*3+2 {
+		return errors.New("invalid interfaces size")
+	}
+
+	d.Interfaces = nil
+
+	for i := 0; i < n; i++ {
+		d.Interfaces = append(d.Interfaces, Class{data[0], data[1], data[2]})
+		data = data[3:]
+	}
+
+	count := int(binary.BigEndian.Uint16(data))
+
+	if count > MaxBatchPackets {
+		return errors.New("too many packets")
+	}
+
+	b.Packets = make([][]byte, count)
+	b.Endpoints = make([]uint8, count)
+	b.Times = make([]time.Duration, count)
+	data = data[2:]
+
+	for i := 0; i < count; i++ {
+		if len(data) < 7 {
+			return errors.New("invalid batch size")
+		}
+
+		b.Endpoints[i] = data[0]
+		b.Times[i] = time.Duration(binary.BigEndian.Uint32(data[1:])) * time.Microsecond
+		n := int(binary.BigEndian.Uint16(data[5:]))
+		data = data[7:]
+
+		if n > MaxPacketSize || len(data) < n {
+			return errors.New("invalid packet size")
+		}
+
+		b.Packets[i] = append([]byte(nil), data[:n]...)
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// MarshalBinary returns the serialized verdict.
+func (v *Verdict) MarshalBinary() ([]byte, error) {
+	if len(v.Permitted) > MaxBatchPackets {
+		return nil, errors.New("too many packets")
+	}
+
+	buf := make([]byte, verdictHeaderSize, verdictHeaderSize+len(v.Permitted))
+	buf[0] = byte(v.Status)
+	binary.BigEndian.PutUint64(buf[1:], uint64(v.Expiry))
+	binary.BigEndian.PutUint32(buf[9:], v.Budget)
+
+	if v.Quarantined {
+		buf[13] = 1
+	}
+
+	binary.BigEndian.PutUint16(buf[14:], uint16(len(v.Permitted)))
+
+	for _, ok := range v.Permitted {
+		if ok {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary parses a verdict serialized with MarshalBinary().
+func (v *Verdict) UnmarshalBinary(data []byte) error {
+	if len(data) < verdictHeaderSize {
+		return errors.New("invalid verdict size")
+	}
+
+	v.Status = Status(data[0])
+	v.Expiry = int64(binary.BigEndian.Uint64(data[1:]))
+	v.Budget = binary.BigEndian.Uint32(data[9:])
+	v.Quarantined = data[13] == 1
+	count := int(binary.BigEndian.Uint16(data[14:]))
+
+	if count > MaxBatchPackets || len(data) < verdictHeaderSize+count {
+		return errors.New("invalid verdict size")
+	}
+
+	v.Permitted = make([]bool, count)
+
+	for i := range v.Permitted {
+		v.Permitted[i] = data[verdictHeaderSize+i] == 1
+	}
+
+	return nil
+}
@@ -0,0 +1,410 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package endorsement implements the USB device endorsement cache which
+// decides whether packets from a given device are permitted.
+//
+// The cache is meant to be held by the secure side, the Normal World only
+// submits packet batches for a verdict (see Batch and Verdict).
+package endorsement
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status represents an endorsement status.
+type Status uint8
+
+// Endorsement status values
+const (
+	Unknown Status = iota
+	Active
+	Expired
+	Revoked
+)
+
+var statusNames = map[Status]string{
+	Unknown: "unknown",
+	Active:  "trusted",
+	Expired: "expired",
+	Revoked: "revoked",
+}
+
+// String returns the endorsement status name, as used by the host side
+// endorsement database.
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+
+	return statusNames[Unknown]
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (s *Status) UnmarshalText(text []byte) error {
+	for status, name := range statusNames {
+		if name == string(text) {
+			*s = status
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid status %q", text)
+}
+
+// Entry represents a device endorsement.
+type Entry struct {
+	// Device is the endorsed device identity
+	Device DeviceID
+	// Match holds additional constraints on the device identity
+	Match Match
+	// Status is the endorsement status
+	Status Status
+	// Created is the endorsement creation time (Unix seconds)
+	Created int64
+	// Expiry is the endorsement expiry time (Unix seconds), zero if unset
+	Expiry int64
+	// Budget is the remaining number of packets permitted before expiry,
+	// zero once exhausted or Unlimited
+	Budget uint32
+	// Note is a human readable note
+	Note string
+}
+
+// Unlimited represents the absence of a packet budget.
+const Unlimited = math.MaxUint32
+
+// Grant represents the parameters of a new endorsement.
+type Grant struct {
+	// Device is the device identity to endorse
+	Device DeviceID
+	// Match holds additional constraints on the device identity
+	Match Match
+	// Lifetime is the endorsement validity period, zero if unlimited
+	Lifetime time.Duration
+	// Budget is the number of packets permitted, Unlimited if zero
+	Budget uint32
+	// Note is a human readable note
+	Note string
+}
+
+type record struct {
+	Entry
+	log PacketLog
+}
+
+// expire marks an active endorsement as expired once past its expiry time or
+// packet budget.
+func (r *record) expire(now int64) {
+	if r.Status != Active {
+		return
+	}
+
+	if (r.Expiry != 0 && now > r.Expiry) || r.Budget == 0 {
+		r.Status = Expired
+	}
+}
+
+// Cache represents a set of device endorsements.
+type Cache struct {
+	sync.Mutex
+
+	// Nanotime returns the time source against which endorsement expiry
+	// is checked, in nanoseconds since the Unix epoch, it defaults to
+	// time.Now().
+	Nanotime func() int64
+
+	records map[DeviceID]*record
+}
+
+// NewCache returns an empty endorsement cache.
+func NewCache() *Cache {
+	return &Cache{
+		records: make(map[DeviceID]*record),
+	}
+}
+
+// now returns the current time in Unix seconds.
+func (c *Cache) now() int64 {
+	if c.Nanotime == nil {
+		return time.Now().Unix()
+	}
+
+	return c.Nanotime() / int64(time.Second)
+}
+
+// Add installs an active endorsement, replacing any existing one for the same
+// device, and returns it.
+func (c *Cache) Add(g Grant) Entry {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+
+	r := &record{
+		Entry: Entry{
+			Device:  g.Device,
+			Match:   g.Match,
+			Status:  Active,
+			Created: now,
+			Budget:  g.Budget,
+			Note:    g.Note,
+		},
+	}
+
+	if r.Budget == 0 {
+		r.Budget = Unlimited
+	}
+
+	if g.Lifetime > 0 {
+		r.Expiry = now + int64(g.Lifetime/time.Second)
+	}
+
+	c.records[g.Device] = r
+
+	return r.Entry
+}
+
+// Set installs an endorsement as is, replacing any existing one for the same
+// device.
+func (c *Cache) Set(e Entry) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.records[e.Device] = &record{
+		Entry: e,
+	}
+}
+
+// Reset removes all endorsements.
+func (c *Cache) Reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.records = make(map[DeviceID]*record)
+}
+
+// Revoke revokes an existing endorsement.
+func (c *Cache) Revoke(dev DeviceID) error {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.records[dev]
+
+	if !ok {
+		return errors.New("no endorsement for device")
+	}
+
+	r.Status = Revoked
+
+	return nil
+}
+
+// Downgrade expires an active endorsement ahead of its expiry time, the device
+// remains eligible for re-endorsement.
+func (c *Cache) Downgrade(dev DeviceID) error {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.records[dev]
+
+	if !ok {
+		return errors.New("no endorsement for device")
+	}
+
+	if r.Status != Active {
+		return nil
+	}
+
+	r.Status = Expired
+	r.Expiry = c.now()
+
+	return nil
+}
+
+// Purge removes all expired endorsements and returns their devices, revoked
+// endorsements are retained as a record of the operator decision.
+func (c *Cache) Purge() (devices []DeviceID) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+
+	for dev, r := range c.records {
+		if r.expire(now); r.Status == Expired {
+			delete(c.records, dev)
+			devices = append(devices, dev)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].String() < devices[j].String()
+	})
+
+	return
+}
+
+// SetMatch replaces the identity constraints of an existing endorsement.
+func (c *Cache) SetMatch(dev DeviceID, m Match) error {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.records[dev]
+
+	if !ok {
+		return errors.New("no endorsement for device")
+	}
+
+	r.Match = m
+
+	return nil
+}
+
+// candidates returns the endorsements keyed on a device identity,
+// endorsements for its serial number precede ones for any serial number.
+func (c *Cache) candidates(dev DeviceID) (records []*record) {
+	keys := []DeviceID{dev}
+
+	if dev.Serial != "" {
+		keys = append(keys, DeviceID{
+			VendorID:  dev.VendorID,
+			ProductID: dev.ProductID,
+		})
+	}
+
+	for _, key := range keys {
+		if r, ok := c.records[key]; ok {
+			records = append(records, r)
+		}
+	}
+
+	return
+}
+
+// lookup returns the endorsement applicable to a device.
+func (c *Cache) lookup(d *Device) *record {
+	for _, r := range c.candidates(d.DeviceID) {
+		if r.Match.Matches(d) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the endorsement applicable to a device, if present.
+func (c *Cache) Lookup(d *Device) (e Entry, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if r := c.lookup(d); r != nil {
+		r.expire(c.now())
+		return r.Entry, true
+	}
+
+	return
+}
+
+// Get returns the endorsement for a device, if present.
+func (c *Cache) Get(dev DeviceID) (e Entry, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.records[dev]
+
+	if ok {
+		r.expire(c.now())
+		e = r.Entry
+	}
+
+	return
+}
+
+// List returns all endorsements, sorted by device identity.
+func (c *Cache) List() (entries []Entry) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+
+	for _, r := range c.records {
+		r.expire(now)
+		entries = append(entries, r.Entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Device.String() < entries[j].Device.String()
+	})
+
+	return
+}
+
+// PacketLog returns the most recent packets permitted for a device, oldest
+// first.
+func (c *Cache) PacketLog(dev DeviceID) [][]byte {
+	c.Lock()
+	defer c.Unlock()
+
+	if records := c.candidates(dev); len(records) > 0 {
+		return records[0].log.Records()
+	}
+
+	return nil
+}
+
+// Check returns a verdict for each packet of a batch, packets are permitted
+// until the endorsement expiry time or packet budget is reached. Permitted
+// packets consume the packet budget, if any, and are recorded in the device
+// packet log.
+func (c *Cache) Check(b *Batch) (v *Verdict) {
+	c.Lock()
+	defer c.Unlock()
+
+	v = &Verdict{
+		Permitted: make([]bool, len(b.Packets)),
+	}
+
+	// devices not matching any endorsement constraints are unknown
+	r := c.lookup(&b.Device)
+
+	if r == nil {
+		return
+	}
+
+	now := c.now()
+
+	for i, pkt := range b.Packets {
+		// if expired or not active block all remaining packets
+		if r.expire(now); r.Status != Active {
+			break
+		}
+
+		if r.Budget != Unlimited {
+			r.Budget--
+		}
+
+		r.log.Log(pkt)
+
+		v.Permitted[i] = true
+	}
+
+	r.expire(now)
+
+	v.Status = r.Status
+	v.Expiry = r.Expiry
+	v.Budget = r.Budget
+
+	return
+}
@@ -0,0 +1,138 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"testing"
+	"time"
+)
+
+var testDevice = DeviceID{VendorID: 0x046d, ProductID: 0xc31c}
+
+func testBatch(n int) *Batch {
+	return &Batch{
+		Device:  Device{DeviceID: testDevice},
+		Packets: make([][]byte, n),
+	}
+}
+
+func TestGrantBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		budget    uint32
+		packets   int
+		permitted int
+		status    Status
+		remaining uint32
+	}{
+		{"unset", 0, 16, 16, Active, Unlimited},
+		{"unlimited", Unlimited, 16, 16, Active, Unlimited},
+		{"partial", 16, 4, 4, Active, 12},
+		{"exhausted", 4, 16, 4, Expired, 0},
+	} {
+		c := NewCache()
+
+		if e := c.Add(Grant{Device: testDevice, Budget: tc.budget}); e.Status != Active {
+			t.Errorf("%s: new endorsement %s, want %s", tc.name, e.Status, Active)
+			continue
+		}
+
+		v := c.Check(testBatch(tc.packets))
+
+		permitted := 0
+
+		for _, ok := range v.Permitted {
+			if ok {
+				permitted += 1
+			}
+		}
+
+		if permitted != tc.permitted || v.Status != tc.status || v.Budget != tc.remaining {
+			t.Errorf("%s: permitted:%d status:%s budget:%d, want permitted:%d status:%s budget:%d", tc.name,
+				permitted, v.Status, v.Budget, tc.permitted, tc.status, tc.remaining)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		lifetime  time.Duration
+		elapsed   time.Duration
+		permitted int
+		status    Status
+	}{
+		{"unlimited", 0, 365 * 24 * time.Hour, 4, Active},
+		{"valid", time.Minute, 30 * time.Second, 4, Active},
+		{"expiry time", time.Minute, time.Minute, 4, Active},
+		{"expired", time.Minute, time.Minute + time.Second, 0, Expired},
+	} {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+
+		c := NewCache()
+		c.Nanotime = func() int64 { return now }
+		c.Add(Grant{Device: testDevice, Lifetime: tc.lifetime})
+
+		now += int64(tc.elapsed)
+
+		v := c.Check(testBatch(4))
+
+		permitted := 0
+
+		for _, ok := range v.Permitted {
+			if ok {
+				permitted += 1
+			}
+		}
+
+		if permitted != tc.permitted || v.Status != tc.status {
+			t.Errorf("%s: permitted:%d status:%s, want permitted:%d status:%s", tc.name,
+				permitted, v.Status, tc.permitted, tc.status)
+		}
+
+		if devices := c.Purge(); (len(devices) == 1) != (tc.status == Expired) {
+			t.Errorf("%s: purged %v", tc.name, devices)
+		}
+	}
+}
+
+func TestBudgetBatches(t *testing.T) {
+	c := NewCache()
+	c.Add(Grant{Device: testDevice, Budget: 10})
+
+	for i, want := range []uint32{6, 2, 0, 0} {
+		v := c.Check(testBatch(4))
+
+		if v.Budget != want {
+			t.Errorf("batch %d: budget %d, want %d", i, v.Budget, want)
+		}
+
+		if want == 0 && v.Status != Expired {
+			t.Errorf("batch %d: status %s, want %s", i, v.Status, Expired)
+		}
+	}
+
+	if e, _ := c.Get(testDevice); e.Status != Expired {
+		t.Errorf("endorsement %s, want %s", e.Status, Expired)
+	}
+
+	// expired endorsements can be renewed, revoked ones are retained
+	if e := c.Add(Grant{Device: testDevice}); e.Status != Active {
+		t.Errorf("renewed endorsement %s, want %s", e.Status, Active)
+	}
+
+	if err := c.Revoke(testDevice); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.Check(testBatch(1)); v.Permitted[0] || v.Status != Revoked {
+		t.Errorf("revoked endorsement permitted:%v status:%s", v.Permitted[0], v.Status)
+	}
+
+	if devices := c.Purge(); len(devices) != 0 {
+		t.Errorf("purged revoked endorsement %v", devices)
+	}
+}
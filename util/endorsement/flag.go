@@ -0,0 +1,104 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"errors"
+)
+
+const (
+	// MaxReasonSize is the maximum size of a flag reason.
+	MaxReasonSize = 128
+	// FlagBufferSize is the buffer size required to exchange any flag.
+	FlagBufferSize = 2 + MaxReasonSize + BatchBufferSize
+)
+
+// Penalty represents the action taken on the endorsement of a device flagged
+// by the Normal World.
+type Penalty uint8
+
+// Penalties
+const (
+	// Downgrade expires the endorsement, the device remains eligible for
+	// re-endorsement.
+	Downgrade Penalty = iota + 1
+	// Block revokes the endorsement.
+	Block
+)
+
+// String returns the penalty name.
+func (p Penalty) String() string {
+	switch p {
+	case Downgrade:
+		return "downgrade"
+	case Block:
+		return "block"
+	default:
+		return "invalid"
+	}
+}
+
+// Flag represents a report, by the Normal World, of suspicious activity of an
+// endorsed device (e.g. keystroke injection).
+//
+// Its binary format, used across the Normal World monitor call (see
+// util.SYS_FLAG), is:
+//
+//	penalty (uint8) | reason len (uint8) | reason | batch
+//
+// where the batch (see Batch) holds the offending packets.
+type Flag struct {
+	// Penalty is the requested penalty
+	Penalty Penalty
+	// Reason is a description of the suspicious activity
+	Reason string
+	// Batch holds the device identity and the offending packets
+	Batch Batch
+}
+
+// MarshalBinary returns the serialized flag.
+func (f *Flag) MarshalBinary() ([]byte, error) {
+	if len(f.Reason) > MaxReasonSize {
+		return nil, errors.New("invalid reason size")
+	}
+
+	batch, err := f.Batch.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 2+len(f.Reason)+len(batch))
+	buf = append(buf, byte(f.Penalty), uint8(len(f.Reason)))
+	buf = append(buf, f.Reason...)
+	buf = append(buf, batch...)
+
+	return buf, nil
+}
+
+// UnmarshalBinary parses a flag serialized with MarshalBinary().
+func (f *Flag) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("invalid flag size")
+	}
+
+	f.Penalty = Penalty(data[0])
+
+	if f.Penalty != Downgrade && f.Penalty != Block {
+		return errors.New("invalid penalty")
+	}
+
+	n := int(data[1])
+	data = data[2:]
+
+	if n > MaxReasonSize || len(data) < n {
+		return errors.New("invalid reason size")
+	}
+
+	f.Reason = string(data[:n])
+
+	return f.Batch.UnmarshalBinary(data[n:])
+}
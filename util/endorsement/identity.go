@@ -0,0 +1,301 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// anySerial is the serial number placeholder used in database keys of
+// devices endorsed regardless of their serial number.
+const anySerial = "-"
+
+const (
+	// MaxSerialSize is the maximum size of a device serial number.
+	MaxSerialSize = 64
+	// MaxInterfaces is the maximum number of interfaces of a device.
+	MaxInterfaces = 32
+)
+
+// Class represents a USB class, subclass and protocol triple.
+type Class struct {
+	Class    uint8
+	SubClass uint8
+	Protocol uint8
+}
+
+// Well known interface classes
+var (
+	HIDBootKeyboard = Class{0x03, 0x01, 0x01}
+	HIDBootMouse    = Class{0x03, 0x01, 0x02}
+)
+
+// String returns the class triple in cc:ss:pp format.
+func (c Class) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x", c.Class, c.SubClass, c.Protocol)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (c *Class) UnmarshalText(text []byte) (err error) {
+	*c, err = ParseClass(string(text))
+	return
+}
+
+// ParseClass parses a class triple in cc:ss:pp format.
+func ParseClass(s string) (c Class, err error) {
+	var v [3]uint8
+
+	f := strings.Split(s, ":")
+
+	if len(f) != 3 {
+		return c, fmt.Errorf("invalid class %q", s)
+	}
+
+	for i := range f {
+		n, err := strconv.ParseUint(f[i], 16, 8)
+
+		if err != nil {
+			return c, fmt.Errorf("invalid class %q", s)
+		}
+
+		v[i] = uint8(n)
+	}
+
+	return Class{v[0], v[1], v[2]}, nil
+}
+
+// ParseClasses parses a comma separated list of class triples.
+func ParseClasses(s string) (classes []Class, err error) {
+	for _, f := range strings.Split(s, ",") {
+		c, err := ParseClass(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		classes = append(classes, c)
+	}
+
+	return
+}
+
+// DeviceID represents the key of a USB device endorsement, an empty serial
+// number matches any device with the same vendor and product IDs.
+type DeviceID struct {
+	VendorID  uint16
+	ProductID uint16
+	Serial    string
+}
+
+// String returns the device identity in vid:pid or vid:pid:serial format.
+func (d DeviceID) String() string {
+	if d.Serial == "" {
+		return fmt.Sprintf("%04x:%04x", d.VendorID, d.ProductID)
+	}
+
+	return fmt.Sprintf("%04x:%04x:%s", d.VendorID, d.ProductID, d.Serial)
+}
+
+// Key returns the device identity in the vid:pid:serial format used as key in
+// the JSON database.
+func (d DeviceID) Key() string {
+	serial := d.Serial
+
+	if serial == "" {
+		serial = anySerial
+	}
+
+	return fmt.Sprintf("%04x:%04x:%s", d.VendorID, d.ProductID, serial)
+}
+
+// ParseDeviceID parses a device identity in vid:pid or vid:pid:serial format.
+func ParseDeviceID(s string) (d DeviceID, err error) {
+	f := strings.SplitN(s, ":", 3)
+
+	if len(f) < 2 {
+		return d, fmt.Errorf("invalid device %q", s)
+	}
+
+	vid, err := strconv.ParseUint(f[0], 16, 16)
+
+	if err != nil {
+		return d, fmt.Errorf("invalid vendor ID, %v", err)
+	}
+
+	pid, err := strconv.ParseUint(f[1], 16, 16)
+
+	if err != nil {
+		return d, fmt.Errorf("invalid product ID, %v", err)
+	}
+
+	d.VendorID = uint16(vid)
+	d.ProductID = uint16(pid)
+
+	if len(f) == 3 && f[2] != anySerial {
+		d.Serial = f[2]
+	}
+
+	if len(d.Serial) > MaxSerialSize {
+		return d, errors.New("invalid serial number size")
+	}
+
+	return
+}
+
+// Device represents the identity of a USB device, as presented by its
+// descriptors.
+type Device struct {
+	DeviceID
+
+	// BCDDevice is the device release number
+	BCDDevice uint16
+	// Class is the device class triple
+	Class Class
+	// Interfaces holds the distinct interface class triples
+	Interfaces []Class
+}
+
+// Identify returns the identity of a device from its device, configuration
+// (with all subordinate descriptors) and, if any, serial number string
+// descriptors.
+func Identify(device []byte, config []byte, serial []byte) (d Device, err error) {
+	ud, err := usb.ParseDevice(device, config)
+
+	if err != nil {
+		return
+	}
+
+	if len(serial) > 0 {
+		if ud.Serial, err = usb.ParseString(serial); err != nil {
+			return
+		}
+	}
+
+	return Identity(ud)
+}
+
+// Identity returns the identity of a device from its parsed descriptors.
+func Identity(ud *usb.Device) (d Device, err error) {
+	dd := ud.Descriptor
+
+	d.VendorID = dd.VendorId
+	d.ProductID = dd.ProductId
+	d.BCDDevice = dd.Device
+	d.Class = Class{dd.DeviceClass, dd.DeviceSubClass, dd.DeviceProtocol}
+	d.Serial = ud.Serial
+
+	if len(d.Serial) > MaxSerialSize {
+		return d, errors.New("invalid serial number size")
+	}
+
+	for _, iface := range ud.Interfaces() {
+		c := Class{iface.InterfaceClass, iface.InterfaceSubClass, iface.InterfaceProtocol}
+
+		if !slices.Contains(d.Interfaces, c) {
+			d.Interfaces = append(d.Interfaces, c)
+		}
+	}
+
+	if len(d.Interfaces) > MaxInterfaces {
+		return d, errors.New("too many interfaces")
+	}
+
+	return
+}
+
+// Match represents optional constraints, beyond vendor and product IDs and
+// serial number, on the identity of endorsed devices. Unset constraints match
+// any device.
+type Match struct {
+	// BCDDevice is the required device release number
+	BCDDevice *uint16 `json:"bcd_device,omitempty"`
+	// Class is the required device class triple
+	Class *Class `json:"class,omitempty"`
+	// Interfaces is the set of permitted interface class triples, all
+	// device interfaces must be part of it
+	Interfaces []Class `json:"interfaces,omitempty"`
+}
+
+// Exact returns constraints matching only devices with the same release
+// number, device class and set of interfaces as the argument one.
+func Exact(d *Device) Match {
+	bcd := d.BCDDevice
+	class := d.Class
+
+	return Match{
+		BCDDevice:  &bcd,
+		Class:      &class,
+		Interfaces: slices.Clone(d.Interfaces),
+	}
+}
+
+// Matches returns whether a device satisfies all constraints.
+func (m *Match) Matches(d *Device) bool {
+	if m.BCDDevice != nil && *m.BCDDevice != d.BCDDevice {
+		return false
+	}
+
+	if m.Class != nil && *m.Class != d.Class {
+		return false
+	}
+
+	if m.Interfaces == nil {
+		return true
+	}
+
+	// a device without interfaces cannot prove its function
+	if len(d.Interfaces) == 0 {
+		return false
+	}
+
+	for _, c := range d.Interfaces {
+		if !slices.Contains(m.Interfaces, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns a description of the constraints.
+func (m Match) String() string {
+	var s []string
+
+	if m.BCDDevice != nil {
+		s = append(s, fmt.Sprintf("bcd:%04x", *m.BCDDevice))
+	}
+
+	if m.Class != nil {
+		s = append(s, "class:"+m.Class.String())
+	}
+
+	if m.Interfaces != nil {
+		var ifaces []string
+
+		for _, c := range m.Interfaces {
+			ifaces = append(ifaces, c.String())
+		}
+
+		s = append(s, "interfaces:"+strings.Join(ifaces, ","))
+	}
+
+	if len(s) == 0 {
+		return "any"
+	}
+
+	return strings.Join(s, " ")
+}
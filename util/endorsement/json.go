@@ -0,0 +1,102 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Record represents a device endorsement in the JSON database format shared
+// with the host side endorsement service (see USBIP/endorsements.json).
+type Record struct {
+	Status  Status `json:"status"`
+	Created int64  `json:"created"`
+	Expiry  *int64 `json:"expiry"`
+	Note    string `json:"note"`
+	// Packets is the remaining packet budget, it is not used by the host
+	// side endorsement service.
+	Packets *uint32 `json:"packets,omitempty"`
+
+	// Match holds additional constraints on the device identity, they
+	// are not used by the host side endorsement service.
+	Match
+}
+
+// Export returns all endorsements in JSON database format.
+func (c *Cache) Export() ([]byte, error) {
+	db := make(map[string]Record)
+
+	for _, e := range c.List() {
+		r := Record{
+			Status:  e.Status,
+			Created: e.Created,
+			Note:    e.Note,
+			Match:   e.Match,
+		}
+
+		if e.Expiry != 0 {
+			r.Expiry = &e.Expiry
+		}
+
+		if e.Budget != Unlimited {
+			r.Packets = &e.Budget
+		}
+
+		db[e.Device.Key()] = r
+	}
+
+	return json.MarshalIndent(db, "", "  ")
+}
+
+// Import installs all endorsements in JSON database format, replacing any
+// existing one for the same device, and returns their number.
+func (c *Cache) Import(data []byte) (n int, err error) {
+	var db map[string]Record
+	var entries []Entry
+
+	if err = json.Unmarshal(data, &db); err != nil {
+		return 0, fmt.Errorf("invalid endorsement database, %v", err)
+	}
+
+	if db == nil {
+		return 0, errors.New("invalid endorsement database")
+	}
+
+	for key, r := range db {
+		dev, err := ParseDeviceID(key)
+
+		if err != nil {
+			return 0, err
+		}
+
+		e := Entry{
+			Device:  dev,
+			Match:   r.Match,
+			Status:  r.Status,
+			Created: r.Created,
+			Budget:  Unlimited,
+			Note:    r.Note,
+		}
+
+		if r.Expiry != nil {
+			e.Expiry = *r.Expiry
+		}
+
+		if r.Packets != nil {
+			e.Budget = *r.Packets
+		}
+
+		entries = append(entries, e)
+	}
+
+	for _, e := range entries {
+		c.Set(e)
+	}
+
+	return len(entries), nil
+}
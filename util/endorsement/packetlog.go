@@ -0,0 +1,67 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+const (
+	maxPacketsPerDevice     = 256
+	maxLoggedBytesPerPacket = 64
+)
+
+type packetRecord struct {
+	len  int
+	data [maxLoggedBytesPerPacket]byte
+}
+
+// PacketLog represents a circular buffer of the most recent packets, each
+// truncated to 64 bytes, of a device.
+type PacketLog struct {
+	next    int
+	wrapped bool
+	records [maxPacketsPerDevice]packetRecord
+}
+
+// Log records a packet.
+func (rb *PacketLog) Log(pkt []byte) {
+	if len(pkt) == 0 {
+		return
+	}
+
+	if len(pkt) > maxLoggedBytesPerPacket {
+		pkt = pkt[:maxLoggedBytesPerPacket]
+	}
+
+	rec := &rb.records[rb.next]
+	rec.len = len(pkt)
+	copy(rec.data[:], pkt)
+
+	rb.next++
+
+	if rb.next >= maxPacketsPerDevice {
+		rb.next = 0
+		rb.wrapped = true
+	}
+}
+
+// Records returns a copy of all logged packets, oldest first.
+func (rb *PacketLog) Records() (pkts [][]byte) {
+	start := 0
+
+	if rb.wrapped {
+		start = rb.next
+	}
+
+	for i := 0; i < maxPacketsPerDevice; i++ {
+		rec := rb.records[(start+i)%maxPacketsPerDevice]
+
+		if rec.len == 0 {
+			continue
+		}
+
+		pkts = append(pkts, append([]byte(nil), rec.data[:rec.len]...))
+	}
+
+	return
+}
@@ -0,0 +1,115 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyRequests is returned when the pending request limit is reached.
+var ErrTooManyRequests = errors.New("too many pending re-endorsement requests")
+
+// Request represents a re-endorsement request, raised by the Normal World for
+// a device whose packets are blocked.
+type Request struct {
+	// Device is the device identity
+	Device Device
+	// Status is the endorsement status at the time of the request
+	Status Status
+	// Time is the request time (Unix seconds)
+	Time int64
+	// Reason is the reason of a request raised on a flag (see Flag), if
+	// any
+	Reason string
+	// Packets holds the most recent blocked packets, as reported by the
+	// Normal World
+	Packets [][]byte
+}
+
+// Requests represents a queue of pending re-endorsement requests, at most one
+// per device identity key (see DeviceID).
+type Requests struct {
+	sync.Mutex
+
+	// Limit is the maximum number of pending requests
+	Limit int
+
+	pending []*Request
+}
+
+// Raise queues a re-endorsement request for the device of a batch of blocked
+// packets, requests for an already pending device are merged. The returned
+// flag indicates whether the request is new.
+//
+// The reason, if not empty, replaces the one of a pending request.
+func (q *Requests) Raise(b *Batch, status Status, reason string) (queued bool, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	var r *Request
+
+	for _, p := range q.pending {
+		if p.Device.DeviceID == b.Device.DeviceID {
+			r = p
+			break
+		}
+	}
+
+	if r == nil {
+		if q.Limit > 0 && len(q.pending) >= q.Limit {
+			return false, ErrTooManyRequests
+		}
+
+		r = &Request{
+			Device: b.Device,
+		}
+
+		q.pending = append(q.pending, r)
+		queued = true
+	}
+
+	r.Device = b.Device
+	r.Status = status
+	r.Time = time.Now().Unix()
+
+	if reason != "" {
+		r.Reason = reason
+	}
+	r.Packets = append(r.Packets, b.Packets...)
+
+	if n := len(r.Packets); n > MaxBatchPackets {
+		r.Packets = r.Packets[n-MaxBatchPackets:]
+	}
+
+	return
+}
+
+// Pending returns all pending requests, oldest first.
+func (q *Requests) Pending() (requests []Request) {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, r := range q.pending {
+		requests = append(requests, *r)
+	}
+
+	return
+}
+
+// Remove removes the pending request for a device, if present.
+func (q *Requests) Remove(dev DeviceID) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, r := range q.pending {
+		if r.Device.DeviceID == dev {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
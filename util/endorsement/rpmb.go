@@ -0,0 +1,237 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RPMB frame size and layout (p105, 6.6.22.4.1 RPMB data frame, JESD84-B51).
+const (
+	RPMBFrameSize = 512
+
+	rpmbKeyMAC     = 196
+	rpmbData       = 228
+	rpmbNonce      = 484
+	rpmbCounter    = 500
+	rpmbAddress    = 504
+	rpmbBlockCount = 506
+	rpmbResult     = 508
+	rpmbType       = 510
+)
+
+// RPMB request and response message types
+const (
+	rpmbProgramKey   = 0x0001
+	rpmbReadCounter  = 0x0002
+	rpmbWrite        = 0x0003
+	rpmbRead         = 0x0004
+	rpmbResultRead   = 0x0005
+	rpmbResponseMask = 0x0100
+)
+
+// RPMB operation results
+const (
+	rpmbOK               = 0x00
+	rpmbKeyNotProgrammed = 0x07
+	rpmbResultMask       = 0x7f
+	rpmbCounterExpired   = 0x80
+)
+
+// rpmbMagic identifies the counter block.
+var rpmbMagic = []byte("GoTEE-counter")
+
+var (
+	ErrRPMBKey      = errors.New("RPMB authentication key not programmed")
+	ErrRPMBResponse = errors.New("invalid RPMB response")
+)
+
+// RPMBDevice represents an eMMC Replay Protected Memory Block partition,
+// accessed one data frame at a time (see usdhc.USDHC).
+type RPMBDevice interface {
+	ReadRPMB(buf []byte) error
+	WriteRPMB(buf []byte, rel bool) error
+}
+
+// RPMBCounter implements a Counter held in an eMMC Replay Protected Memory
+// Block, it prevents rollback across power cycles as the block can only be
+// written, and its authenticated value read, with the RPMB key.
+type RPMBCounter struct {
+	sync.Mutex
+
+	// Device is the eMMC RPMB partition
+	Device RPMBDevice
+	// Key is the RPMB authentication key (HMAC-SHA256)
+	Key []byte
+	// Address is the RPMB half sector holding the counter
+	Address uint16
+}
+
+type rpmbFrame [RPMBFrameSize]byte
+
+func (f *rpmbFrame) typ() uint16    { return binary.BigEndian.Uint16(f[rpmbType:]) }
+func (f *rpmbFrame) result() uint16 { return binary.BigEndian.Uint16(f[rpmbResult:]) }
+
+func (f *rpmbFrame) sum(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(f[rpmbData:])
+	return mac.Sum(nil)
+}
+
+func (c *RPMBCounter) request(req *rpmbFrame, rel bool, want uint16) (res *rpmbFrame, err error) {
+	var nonce []byte
+
+	binary.BigEndian.PutUint16(req[rpmbType:], want)
+
+	switch want {
+	case rpmbReadCounter, rpmbRead:
+		nonce = req[rpmbNonce:rpmbCounter]
+
+		if _, err = rand.Read(nonce); err != nil {
+			return
+		}
+	case rpmbWrite:
+		copy(req[rpmbKeyMAC:rpmbData], req.sum(c.Key))
+	}
+
+	if err = c.Device.WriteRPMB(req[:], rel); err != nil {
+		return
+	}
+
+	res = &rpmbFrame{}
+
+	if want == rpmbProgramKey || want == rpmbWrite {
+		// the operation result is returned on a result read request
+		binary.BigEndian.PutUint16(res[rpmbType:], rpmbResultRead)
+
+		if err = c.Device.WriteRPMB(res[:], false); err != nil {
+			return
+		}
+
+		*res = rpmbFrame{}
+	}
+
+	if err = c.Device.ReadRPMB(res[:]); err != nil {
+		return
+	}
+
+	if res.typ() != want|rpmbResponseMask {
+		return nil, ErrRPMBResponse
+	}
+
+	switch result := res.result() & rpmbResultMask; result {
+	case rpmbOK:
+	case rpmbKeyNotProgrammed:
+		return nil, ErrRPMBKey
+	default:
+		return nil, fmt.Errorf("RPMB operation failed (%#x)", result)
+	}
+
+	// the key programming response is not authenticated
+	if want == rpmbProgramKey {
+		return
+	}
+
+	if !hmac.Equal(res[rpmbKeyMAC:rpmbData], res.sum(c.Key)) {
+		return nil, ErrRPMBResponse
+	}
+
+	if nonce != nil && !bytes.Equal(res[rpmbNonce:rpmbCounter], nonce) {
+		return nil, ErrRPMBResponse
+	}
+
+	return
+}
+
+// ProgramKey programs the RPMB authentication key, this can only be done
+// once for the lifetime of the eMMC.
+func (c *RPMBCounter) ProgramKey() (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	req := &rpmbFrame{}
+	copy(req[rpmbKeyMAC:rpmbData], c.Key)
+
+	_, err = c.request(req, true, rpmbProgramKey)
+
+	return
+}
+
+func (c *RPMBCounter) read() (n uint64, err error) {
+	req := &rpmbFrame{}
+	binary.BigEndian.PutUint16(req[rpmbAddress:], c.Address)
+
+	res, err := c.request(req, false, rpmbRead)
+
+	if err != nil {
+		return
+	}
+
+	data := res[rpmbData:rpmbNonce]
+
+	// never written
+	if !bytes.HasPrefix(data, rpmbMagic) {
+		return 0, nil
+	}
+
+	return binary.BigEndian.Uint64(data[len(rpmbMagic):]), nil
+}
+
+// Read returns the current counter value.
+func (c *RPMBCounter) Read() (uint64, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.read()
+}
+
+// Write sets the counter value.
+func (c *RPMBCounter) Write(n uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	cur, err := c.read()
+
+	if err != nil {
+		return
+	}
+
+	if n < cur {
+		return ErrRollback
+	}
+
+	if n == cur {
+		return
+	}
+
+	res, err := c.request(&rpmbFrame{}, false, rpmbReadCounter)
+
+	if err != nil {
+		return
+	}
+
+	if res.result()&rpmbCounterExpired != 0 {
+		return errors.New("RPMB write counter expired")
+	}
+
+	req := &rpmbFrame{}
+	copy(req[rpmbData:], rpmbMagic)
+	binary.BigEndian.PutUint64(req[rpmbData+len(rpmbMagic):], n)
+	copy(req[rpmbCounter:rpmbAddress], res[rpmbCounter:rpmbAddress])
+	binary.BigEndian.PutUint16(req[rpmbAddress:], c.Address)
+	binary.BigEndian.PutUint16(req[rpmbBlockCount:], 1)
+
+	_, err = c.request(req, true, rpmbWrite)
+
+	return
+}
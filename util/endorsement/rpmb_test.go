@@ -0,0 +1,161 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// emulatedRPMB implements the RPMB partition protocol of an eMMC.
+type emulatedRPMB struct {
+	key     []byte
+	counter uint32
+	blocks  map[uint16][]byte
+
+	req    rpmbFrame
+	result uint16
+	last   uint16
+}
+
+func (d *emulatedRPMB) WriteRPMB(buf []byte, rel bool) error {
+	var f rpmbFrame
+	copy(f[:], buf)
+
+	switch f.typ() {
+	case rpmbProgramKey:
+		d.last, d.result = rpmbProgramKey, rpmbOK
+
+		if d.key != nil {
+			d.result = 1
+			return nil
+		}
+
+		d.key = bytes.Clone(f[rpmbKeyMAC:rpmbData])
+	case rpmbWrite:
+		d.last, d.result = rpmbWrite, rpmbOK
+
+		switch {
+		case d.key == nil:
+			d.result = rpmbKeyNotProgrammed
+		case !rel || !hmac.Equal(f[rpmbKeyMAC:rpmbData], f.sum(d.key)):
+			d.result = 2
+		case binary.BigEndian.Uint32(f[rpmbCounter:]) != d.counter:
+			d.result = 3
+		default:
+			d.blocks[binary.BigEndian.Uint16(f[rpmbAddress:])] = bytes.Clone(f[rpmbData:rpmbNonce])
+			d.counter += 1
+		}
+	case rpmbResultRead:
+	default:
+		d.req = f
+		d.last = f.typ()
+	}
+
+	return nil
+}
+
+func (d *emulatedRPMB) ReadRPMB(buf []byte) error {
+	var f rpmbFrame
+
+	binary.BigEndian.PutUint16(f[rpmbType:], d.last|rpmbResponseMask)
+	binary.BigEndian.PutUint32(f[rpmbCounter:], d.counter)
+
+	switch d.last {
+	case rpmbReadCounter, rpmbRead:
+		copy(f[rpmbNonce:rpmbCounter], d.req[rpmbNonce:rpmbCounter])
+
+		if d.key == nil {
+			binary.BigEndian.PutUint16(f[rpmbResult:], rpmbKeyNotProgrammed)
+			break
+		}
+
+		if d.last == rpmbRead {
+			addr := binary.BigEndian.Uint16(d.req[rpmbAddress:])
+			copy(f[rpmbAddress:], d.req[rpmbAddress:rpmbBlockCount])
+			copy(f[rpmbData:rpmbNonce], d.blocks[addr])
+		}
+
+		copy(f[rpmbKeyMAC:rpmbData], f.sum(d.key))
+	default:
+		binary.BigEndian.PutUint16(f[rpmbResult:], d.result)
+
+		if d.last == rpmbWrite && d.key != nil {
+			copy(f[rpmbKeyMAC:rpmbData], f.sum(d.key))
+		}
+	}
+
+	copy(buf, f[:])
+
+	return nil
+}
+
+func TestRPMBCounter(t *testing.T) {
+	dev := &emulatedRPMB{blocks: make(map[uint16][]byte)}
+	key := bytes.Repeat([]byte{0x5a}, 32)
+
+	c := &RPMBCounter{
+		Device:  dev,
+		Key:     key,
+		Address: 1,
+	}
+
+	if _, err := c.Read(); !errors.Is(err, ErrRPMBKey) {
+		t.Fatalf("unprogrammed key: %v, want %v", err, ErrRPMBKey)
+	}
+
+	if err := c.ProgramKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := c.Read(); err != nil || n != 0 {
+		t.Fatalf("initial value %d %v, want 0", n, err)
+	}
+
+	for _, n := range []uint64{1, 2, 2, 10} {
+		if err := c.Write(n); err != nil {
+			t.Fatalf("write %d: %v", n, err)
+		}
+
+		if v, err := c.Read(); err != nil || v != n {
+			t.Fatalf("read %d %v, want %d", v, err, n)
+		}
+	}
+
+	if err := c.Write(9); !errors.Is(err, ErrRollback) {
+		t.Errorf("rollback: %v, want %v", err, ErrRollback)
+	}
+
+	// the counter survives a new instance (i.e. a power cycle)
+	c = &RPMBCounter{Device: dev, Key: key, Address: 1}
+
+	if n, err := c.Read(); err != nil || n != 10 {
+		t.Errorf("value after power cycle %d %v, want 10", n, err)
+	}
+
+	// unchanged values are not written
+	if dev.counter != 3 {
+		t.Errorf("RPMB write counter %d, want 3", dev.counter)
+	}
+
+	// responses not authenticated with the counter key are rejected
+	forged := &RPMBCounter{Device: dev, Key: bytes.Repeat([]byte{0xa5}, 32), Address: 1}
+
+	if _, err := forged.Read(); !errors.Is(err, ErrRPMBResponse) {
+		t.Errorf("wrong key read: %v, want %v", err, ErrRPMBResponse)
+	}
+
+	if err := forged.Write(20); err == nil {
+		t.Error("wrong key write accepted")
+	}
+
+	if err := forged.ProgramKey(); err == nil {
+		t.Error("key reprogrammed")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// storeMagic identifies a sealed endorsement database.
+const storeMagic = "GoTEE-edb"
+
+// storeHeaderSize is the size of the sealed database header:
+//
+//	magic | version (uint64) | length (uint32) | nonce
+const storeHeaderSize = len(storeMagic) + 8 + 4 + 12
+
+var (
+	ErrNotFound = errors.New("no endorsement database")
+	ErrRollback = errors.New("endorsement database rollback")
+)
+
+// BlockDevice represents a block addressable storage device, such as an
+// eMMC or uSD card (see usdhc.USDHC).
+type BlockDevice interface {
+	ReadBlocks(lba int, buf []byte) error
+	WriteBlocks(lba int, buf []byte) error
+}
+
+// Counter represents a monotonic counter, used to prevent rollback of the
+// sealed database to a previous version.
+type Counter interface {
+	// Read returns the current counter value.
+	Read() (uint64, error)
+	// Write sets the counter value, which must not decrease.
+	Write(uint64) error
+}
+
+// MemoryCounter implements a Counter held in memory, it prevents rollback only
+// for the lifetime of its owner.
+type MemoryCounter struct {
+	sync.Mutex
+	n uint64
+}
+
+// Read returns the current counter value.
+func (c *MemoryCounter) Read() (uint64, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.n, nil
+}
+
+// Write sets the counter value.
+func (c *MemoryCounter) Write(n uint64) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if n < c.n {
+		return ErrRollback
+	}
+
+	c.n = n
+
+	return nil
+}
+
+// MemoryDevice implements a BlockDevice held in memory, for use under
+// emulation.
+type MemoryDevice struct {
+	sync.Mutex
+
+	// BlockSize is the device block size
+	BlockSize int
+
+	data []byte
+}
+
+func (d *MemoryDevice) access(lba int, buf []byte, write bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if lba < 0 || len(buf)%d.BlockSize != 0 {
+		return errors.New("invalid block access")
+	}
+
+	off := lba * d.BlockSize
+
+	if end := off + len(buf); end > len(d.data) {
+		d.data = append(d.data, make([]byte, end-len(d.data))...)
+	}
+
+	if write {
+		copy(d.data[off:], buf)
+	} else {
+		copy(buf, d.data[off:])
+	}
+
+	return nil
+}
+
+// ReadBlocks reads the device starting at the given block.
+func (d *MemoryDevice) ReadBlocks(lba int, buf []byte) error {
+	return d.access(lba, buf, false)
+}
+
+// WriteBlocks writes the device starting at the given block.
+func (d *MemoryDevice) WriteBlocks(lba int, buf []byte) error {
+	return d.access(lba, buf, true)
+}
+
+// Store represents an endorsement database, in JSON format (see
+// Cache.Export()), sealed with AES-256-GCM on a reserved area of a block
+// device.
+//
+// Each save increments a version counter, covered by the authentication tag,
+// which must not be lower than the one held by the Counter on load.
+type Store struct {
+	sync.Mutex
+
+	// Device is the storage device
+	Device BlockDevice
+	// BlockSize is the device block size
+	BlockSize int
+	// LBA is the first block of the reserved area
+	LBA int
+	// Blocks is the size of the reserved area in blocks
+	Blocks int
+	// Key is the AES-256 sealing key
+	Key []byte
+	// Counter is the rollback protection counter
+	Counter Counter
+
+	version uint64
+}
+
+// Version returns the version of the last loaded or saved database.
+func (s *Store) Version() uint64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.version
+}
+
+func (s *Store) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.Key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Load opens the sealed database and replaces the argument cache contents
+// with it, ErrNotFound is returned if the reserved area holds no database.
+func (s *Store) Load(c *Cache) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	aead, err := s.aead()
+
+	if err != nil {
+		return
+	}
+
+	min, err := s.Counter.Read()
+
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, s.Blocks*s.BlockSize)
+
+	if err = s.Device.ReadBlocks(s.LBA, buf); err != nil {
+		return
+	}
+
+	if !bytes.HasPrefix(buf, []byte(storeMagic)) {
+		if min > 0 {
+			return ErrRollback
+		}
+
+		return ErrNotFound
+	}
+
+	hdr := buf[:storeHeaderSize]
+	off := len(storeMagic)
+
+	version := binary.BigEndian.Uint64(hdr[off:])
+	size := int(binary.BigEndian.Uint32(hdr[off+8:]))
+	nonce := hdr[off+12:]
+
+	if size > len(buf)-storeHeaderSize {
+		return errors.New("invalid endorsement database size")
+	}
+
+	data, err := aead.Open(nil, nonce, buf[storeHeaderSize:storeHeaderSize+size], hdr)
+
+	if err != nil {
+		return fmt.Errorf("invalid endorsement database, %v", err)
+	}
+
+	if version < min {
+		return ErrRollback
+	}
+
+	if err = s.Counter.Write(version); err != nil {
+		return
+	}
+
+	db := NewCache()
+
+	if _, err = db.Import(data); err != nil {
+		return
+	}
+
+	c.Reset()
+
+	for _, e := range db.List() {
+		c.Set(e)
+	}
+
+	s.version = version
+
+	return
+}
+
+// Save seals the argument cache contents, as a new database version, on the
+// reserved area.
+func (s *Store) Save(c *Cache) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	aead, err := s.aead()
+
+	if err != nil {
+		return
+	}
+
+	min, err := s.Counter.Read()
+
+	if err != nil {
+		return
+	}
+
+	data, err := c.Export()
+
+	if err != nil {
+		return
+	}
+
+	version := max(s.version, min) + 1
+	size := len(data) + aead.Overhead()
+
+	buf := make([]byte, s.Blocks*s.BlockSize)
+
+	if storeHeaderSize+size > len(buf) {
+		return errors.New("endorsement database exceeds reserved area")
+	}
+
+	hdr := buf[:storeHeaderSize]
+	off := copy(hdr, storeMagic)
+
+	binary.BigEndian.PutUint64(hdr[off:], version)
+	binary.BigEndian.PutUint32(hdr[off+8:], uint32(size))
+
+	nonce := hdr[off+12:]
+
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+
+	aead.Seal(buf[storeHeaderSize:storeHeaderSize], nonce, data, hdr)
+
+	if err = s.Device.WriteBlocks(s.LBA, buf); err != nil {
+		return
+	}
+
+	if err = s.Counter.Write(version); err != nil {
+		return
+	}
+
+	s.version = version
+
+	return
+}
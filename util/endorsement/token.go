@@ -0,0 +1,376 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package endorsement
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenVersion is the current token serialization format version.
+const TokenVersion = 1
+
+// tokenMagic prefixes the canonical serialization of tokens to provide domain
+// separation for signatures issued with the attestation key.
+const tokenMagic = "GoTEE-token"
+
+// MaxNoteSize is the maximum size of a token note.
+const MaxNoteSize = 128
+
+// Token errors
+var (
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrNoToken        = errors.New("no token for device")
+)
+
+// Token represents a device endorsement issued, and signed, by the Trusted
+// OS, so that its integrity can be verified outside of the Secure World (e.g.
+// by the Debian Normal World USB/IP exporter).
+//
+// Its canonical serialization, covered by the signature, is:
+//
+//	magic | version (uint16) | vid (uint16) | pid (uint16) | serial len (uint8) | serial |
+//	issued (int64) | expiry (int64) | interfaces (uint8) | interfaces * class (3 * uint8) |
+//	note len (uint8) | note
+type Token struct {
+	// Version is the token format version
+	Version uint16
+	// Device is the endorsed device identity
+	Device DeviceID
+	// Issued is the token issue time (Unix seconds)
+	Issued int64
+	// Expiry is the token expiry time (Unix seconds), zero if unset
+	Expiry int64
+	// Interfaces is the set of permitted interface class triples, any
+	// interface is permitted if empty
+	Interfaces []Class
+	// Note is the issuer note
+	Note string
+	// Signature is the Ed25519 signature over the canonical serialization
+	Signature []byte
+}
+
+// Bytes returns the canonical serialization of the token, which represents
+// the message covered by its signature.
+func (t *Token) Bytes() []byte {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(tokenMagic)
+	binary.Write(buf, binary.BigEndian, t.Version)
+	binary.Write(buf, binary.BigEndian, t.Device.VendorID)
+	binary.Write(buf, binary.BigEndian, t.Device.ProductID)
+	buf.WriteByte(uint8(len(t.Device.Serial)))
+	buf.WriteString(t.Device.Serial)
+	binary.Write(buf, binary.BigEndian, t.Issued)
+	binary.Write(buf, binary.BigEndian, t.Expiry)
+	buf.WriteByte(uint8(len(t.Interfaces)))
+
+	for _, c := range t.Interfaces {
+		buf.Write([]byte{c.Class, c.SubClass, c.Protocol})
+	}
+
+	buf.WriteByte(uint8(len(t.Note)))
+	buf.WriteString(t.Note)
+
+	return buf.Bytes()
+}
+
+func (t *Token) validate() error {
+	switch {
+	case len(t.Device.Serial) > MaxSerialSize:
+		return errors.New("invalid serial number size")
+	case len(t.Interfaces) > MaxInterfaces:
+		return errors.New("too many interfaces")
+	case len(t.Note) > MaxNoteSize:
+		return errors.New("invalid note size")
+	}
+
+	return nil
+}
+
+// Sign sets the token version and signature using the argument private key.
+func (t *Token) Sign(key ed25519.PrivateKey) (err error) {
+	if err = t.validate(); err != nil {
+		return
+	}
+
+	t.Version = TokenVersion
+	t.Signature = ed25519.Sign(key, t.Bytes())
+
+	return
+}
+
+// Verify verifies the token signature against the argument public key.
+func (t *Token) Verify(pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize || len(t.Signature) != ed25519.SignatureSize {
+		return ErrTokenSignature
+	}
+
+	if !ed25519.Verify(pub, t.Bytes(), t.Signature) {
+		return ErrTokenSignature
+	}
+
+	return nil
+}
+
+// Permits returns an error if the token is expired, at the argument time in
+// Unix seconds, or does not permit all device interfaces.
+func (t *Token) Permits(d *Device, now int64) error {
+	if t.Expiry != 0 && now > t.Expiry {
+		return ErrTokenExpired
+	}
+
+	if len(t.Interfaces) == 0 {
+		return nil
+	}
+
+	m := Match{Interfaces: t.Interfaces}
+
+	if !m.Matches(d) {
+		return errors.New("interfaces not permitted by token")
+	}
+
+	return nil
+}
+
+// MarshalBinary returns the canonical serialization of the token followed by
+// its signature.
+func (t *Token) MarshalBinary() ([]byte, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(t.Signature) != ed25519.SignatureSize {
+		return nil, errors.New("invalid signature size")
+	}
+
+	return append(t.Bytes(), t.Signature...), nil
+}
+
+// UnmarshalBinary parses a token serialized with MarshalBinary().
+func (t *Token) UnmarshalBinary(data []byte) error {
+	size := len(tokenMagic) + 2 + 2 + 2 + 1
+
+	if len(data) < size || string(data[0:len(tokenMagic)]) != tokenMagic {
+		return errors.New("invalid token")
+	}
+
+	data = data[len(tokenMagic):]
+
+	if t.Version = binary.BigEndian.Uint16(data); t.Version != TokenVersion {
+		return errors.New("unsupported token version")
+	}
+
+	t.Device.VendorID = binary.BigEndian.Uint16(data[2:])
+	t.Device.ProductID = binary.BigEndian.Uint16(data[4:])
+
+	n := int(data[6])
+	data = data[7:]
+
+	if n > MaxSerialSize || len(data) < n+8+8+1 {
+		return errors.New("invalid serial number size")
+	}
+
+	t.Device.Serial = string(data[:n])
+	data = data[n:]
+
+	t.Issued = int64(binary.BigEndian.Uint64(data[0:]))
+	t.Expiry = int64(binary.BigEndian.Uint64(data[8:]))
+
+	n = int(data[16])
+	data = data[17:]
+
+	if n > MaxInterfaces || len(data) < n*3+1 {
+		return errors.New("invalid interfaces size")
+	}
+
+	t.Interfaces = nil
+
+	for i := 0; i < n; i++ {
+		t.Interfaces = append(t.Interfaces, Class{data[0], data[1], data[2]})
+		data = data[3:]
+	}
+
+	n = int(data[0])
+	data = data[1:]
+
+	if n > MaxNoteSize || len(data) != n+ed25519.SignatureSize {
+		return errors.New("invalid token size")
+	}
+
+	t.Note = string(data[:n])
+	t.Signature = append([]byte(nil), data[n:]...)
+
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface, tokens are
+// encoded in URL safe base64 for transport over consoles and files.
+func (t *Token) MarshalText() ([]byte, error) {
+	buf, err := t.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(base64.RawURLEncoding.EncodeToString(buf)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (t *Token) UnmarshalText(text []byte) error {
+	buf, err := base64.RawURLEncoding.DecodeString(string(text))
+
+	if err != nil {
+		return errors.New("invalid token encoding")
+	}
+
+	return t.UnmarshalBinary(buf)
+}
+
+// Tokens represents a set of endorsement tokens, verified against the Trusted
+// OS public key.
+//
+// As tokens are self-contained they cannot be revoked before their expiry,
+// issuers should therefore favor short lifetimes.
+type Tokens struct {
+	sync.Mutex
+
+	// PublicKey is the Trusted OS public key (Ed25519)
+	PublicKey ed25519.PublicKey
+
+	// Nanotime returns the time source against which token expiry is
+	// checked, in nanoseconds since the Unix epoch, it defaults to
+	// time.Now().
+	Nanotime func() int64
+
+	tokens map[DeviceID]*Token
+}
+
+// NewTokens returns an empty token set verified against the argument public
+// key.
+func NewTokens(pub ed25519.PublicKey) *Tokens {
+	return &Tokens{
+		PublicKey: pub,
+		tokens:    make(map[DeviceID]*Token),
+	}
+}
+
+// now returns the current time in Unix seconds.
+func (s *Tokens) now() int64 {
+	if s.Nanotime == nil {
+		return time.Now().Unix()
+	}
+
+	return s.Nanotime() / int64(time.Second)
+}
+
+// Add verifies and installs a token, replacing any existing one for the same
+// device unless issued later.
+func (s *Tokens) Add(t *Token) (err error) {
+	if err = t.Verify(s.PublicKey); err != nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if prev, ok := s.tokens[t.Device]; ok && prev.Issued > t.Issued {
+		return
+	}
+
+	s.tokens[t.Device] = t
+
+	return
+}
+
+// Load verifies and installs tokens in text format, one per line, empty lines
+// and lines starting with `#` are ignored. The number of installed tokens is
+// returned.
+func (s *Tokens) Load(text []byte) (n int, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t := &Token{}
+
+		if err = t.UnmarshalText([]byte(line)); err != nil {
+			return n, fmt.Errorf("line %d, %v", i, err)
+		}
+
+		if err = s.Add(t); err != nil {
+			return n, fmt.Errorf("line %d, %v", i, err)
+		}
+
+		n++
+	}
+
+	return n, scanner.Err()
+}
+
+// Permit returns the token applicable to a device, or an error if none
+// permits it. Tokens for its serial number precede ones for any serial
+// number.
+func (s *Tokens) Permit(d *Device) (t *Token, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	keys := []DeviceID{d.DeviceID}
+
+	if d.Serial != "" {
+		keys = append(keys, DeviceID{
+			VendorID:  d.VendorID,
+			ProductID: d.ProductID,
+		})
+	}
+
+	err = ErrNoToken
+	now := s.now()
+
+	for _, key := range keys {
+		var ok bool
+
+		if t, ok = s.tokens[key]; !ok {
+			continue
+		}
+
+		if err = t.Permits(d, now); err == nil {
+			return
+		}
+	}
+
+	return nil, err
+}
+
+// List returns all tokens, sorted by device identity.
+func (s *Tokens) List() (tokens []*Token) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Device.String() < tokens[j].Device.String()
+	})
+
+	return
+}
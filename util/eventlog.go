@@ -0,0 +1,170 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// EventType represents the type of a measured boot event.
+type EventType uint32
+
+// Measured boot event types
+const (
+	EventApplet EventType = iota + 1
+	EventNormalWorld
+	EventKernel
+	EventDeviceTreeBlob
+	EventInitialRamDisk
+	EventBootConfig
+)
+
+var eventTypeNames = map[EventType]string{
+	EventApplet:         "applet",
+	EventNormalWorld:    "os",
+	EventKernel:         "kernel",
+	EventDeviceTreeBlob: "dtb",
+	EventInitialRamDisk: "initrd",
+	EventBootConfig:     "config",
+}
+
+// String returns the event type name.
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", uint32(t))
+}
+
+// Digest represents a SHA-256 digest, hex encoded in JSON.
+type Digest [sha256.Size]byte
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Digest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(d[:]))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Digest) UnmarshalJSON(data []byte) (err error) {
+	var s string
+
+	if err = json.Unmarshal(data, &s); err != nil {
+		return
+	}
+
+	buf, err := hex.DecodeString(s)
+
+	if err != nil {
+		return
+	}
+
+	if len(buf) != len(d) {
+		return fmt.Errorf("invalid digest size (%d)", len(buf))
+	}
+
+	copy(d[:], buf)
+
+	return
+}
+
+// Event represents a measured boot event log entry.
+type Event struct {
+	// Type is the measured image type
+	Type EventType `json:"type"`
+	// Description identifies the measured image (e.g. its path)
+	Description string `json:"description"`
+	// Digest is the SHA-256 measurement of the image
+	Digest Digest `json:"digest"`
+}
+
+// Hash returns the SHA-256 hash of the event canonical encoding:
+//
+//	type (uint32) | description length (uint32) | description | digest
+//
+// Integers are big-endian, so that each encoding identifies a single event.
+func (e *Event) Hash() (d Digest) {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(e.Type))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.Description)))
+	buf = append(buf, e.Description...)
+	buf = append(buf, e.Digest[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+// Extend returns the hash extension of a register value with an event, in the
+// fashion of TCG PCR extension (i.e. SHA-256(pcr || event hash)), the event
+// type and description are covered along with its digest (see Event.Hash()).
+func Extend(pcr Digest, e Event) Digest {
+	eh := e.Hash()
+
+	h := sha256.New()
+	h.Write(pcr[:])
+	h.Write(eh[:])
+
+	var res Digest
+	copy(res[:], h.Sum(nil))
+
+	return res
+}
+
+// Replay computes the register value resulting from the extension of all
+// events, starting from a zero value.
+func Replay(events []Event) (pcr Digest) {
+	for _, e := range events {
+		pcr = Extend(pcr, e)
+	}
+
+	return
+}
+
+// EventLog represents a TCG-style measured boot event log, each measured
+// image is recorded and hash extended into a PCR-like register.
+type EventLog struct {
+	sync.Mutex
+
+	events []Event
+	pcr    Digest
+}
+
+// Measure records the SHA-256 measurement of the argument data and extends
+// the log register with it.
+func (l *EventLog) Measure(t EventType, desc string, data []byte) Event {
+	e := Event{
+		Type:        t,
+		Description: desc,
+		Digest:      sha256.Sum256(data),
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.events = append(l.events, e)
+	l.pcr = Extend(l.pcr, e)
+
+	return e
+}
+
+// Events returns a copy of all recorded events.
+func (l *EventLog) Events() []Event {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]Event(nil), l.events...)
+}
+
+// PCR returns the current register value.
+func (l *EventLog) PCR() Digest {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.pcr
+}
@@ -0,0 +1,363 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package filter implements the Normal World USB packet filter, which
+// evaluates device descriptors against a policy (see usb.Policy), inspects
+// keyboard reports for keystroke injection (see keystroke.Detector) and submits
+// packets for a verdict to the endorsement service held by the Trusted OS.
+//
+// When token verification is enabled, packets are only submitted for a verdict
+// if the device is permitted by an endorsement token signed by the Trusted OS
+// (see endorsement.Tokens), so that tampering with Normal World endorsement
+// records does not grant access.
+//
+// HID input reports are decoded according to the device report descriptors
+// (see usb.Decoder), so that logged packets show their typed usages.
+//
+// The package is pure Go and shared with host side tools, so that captures
+// (see usbmon.Capture) can be replayed through the same filter code.
+package filter
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/keystroke"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// Endorser represents the endorsement service.
+type Endorser interface {
+	// Check returns the verdict on a batch of packets.
+	Check(b *endorsement.Batch) (*endorsement.Verdict, error)
+	// Request raises a re-endorsement request for a batch of blocked
+	// packets and returns whether it is queued.
+	Request(b *endorsement.Batch) (bool, error)
+	// Flag reports suspicious device activity and returns whether a
+	// penalty is applied.
+	Flag(f *endorsement.Flag) (bool, error)
+}
+
+// Packet represents a packet received from a device.
+type Packet struct {
+	// Time is the packet reception time, relative to any fixed origin
+	Time time.Duration
+	// Endpoint is the endpoint address, 0 if unknown
+	Endpoint uint8
+	// Data is the packet data
+	Data []byte
+}
+
+// Filter represents a USB packet filter.
+type Filter struct {
+	// Endorser is the endorsement service
+	Endorser Endorser
+	// Policy is the device descriptor policy
+	Policy *usb.Policy
+	// Keystroke holds the keystroke injection detector thresholds
+	Keystroke keystroke.Config
+	// Tokens holds the endorsement tokens, token verification is
+	// disabled if nil
+	Tokens *endorsement.Tokens
+
+	detectors map[endorsement.DeviceID]*keystroke.Detector
+	decoders  map[endorsement.DeviceID]map[uint8]*usb.Decoder
+}
+
+// New returns a packet filter with default keystroke injection detector
+// thresholds.
+func New(e Endorser, p *usb.Policy) *Filter {
+	return &Filter{
+		Endorser:  e,
+		Policy:    p,
+		Keystroke: keystroke.DefaultConfig(),
+		detectors: make(map[endorsement.DeviceID]*keystroke.Detector),
+		decoders:  make(map[endorsement.DeviceID]map[uint8]*usb.Decoder),
+	}
+}
+
+// Allow returns whether a device is allowed by the descriptor policy, only
+// packets of allowed devices should be handled.
+func (f *Filter) Allow(d *usb.Device) bool {
+	action, rule := f.Policy.Evaluate(d)
+
+	if rule == nil {
+		log.Printf("[USB] policy %s vid=%04x pid=%04x (default)", action, d.Descriptor.VendorId, d.Descriptor.ProductId)
+	} else {
+		log.Printf("[USB] policy %s vid=%04x pid=%04x (%s)", action, d.Descriptor.VendorId, d.Descriptor.ProductId, rule.Text)
+	}
+
+	return action == usb.Allow
+}
+
+// Authorize returns an error if token verification is enabled and the device
+// is not permitted by a valid endorsement token.
+func (f *Filter) Authorize(d *usb.Device) (err error) {
+	if f.Tokens == nil {
+		return
+	}
+
+	dev, err := endorsement.Identity(d)
+
+	if err != nil {
+		return
+	}
+
+	_, err = f.Tokens.Permit(&dev)
+
+	return
+}
+
+// Handle returns which packets from a device are permitted, packets are
+// submitted to the endorsement service in batches and blocked on any error.
+//
+// Keyboard reports are inspected for keystroke injection before the
+// endorsement check, so that flagged devices are subject to their penalty.
+func (f *Filter) Handle(d *usb.Device, pkts []Packet) (permitted []bool) {
+	dev, err := endorsement.Identity(d)
+
+	if err != nil {
+		log.Printf("[USB] BLOCK vid=%04x pid=%04x (%v)", d.Descriptor.VendorId, d.Descriptor.ProductId, err)
+		return make([]bool, len(pkts))
+	}
+
+	if f.Tokens != nil {
+		if _, err = f.Tokens.Permit(&dev); err != nil {
+			log.Printf("[USB] BLOCK dev=%s (%v) packets=%d", dev, err, len(pkts))
+			return make([]bool, len(pkts))
+		}
+	}
+
+	keyboard := keyboardEndpoints(d)
+	reports := f.decoders[dev.DeviceID]
+
+	if reports == nil {
+		reports = decoders(d)
+		f.decoders[dev.DeviceID] = reports
+	}
+
+	for len(pkts) > 0 {
+		n := min(len(pkts), endorsement.MaxBatchPackets)
+		permitted = append(permitted, f.handle(dev, keyboard, reports, pkts[:n])...)
+		pkts = pkts[n:]
+	}
+
+	return
+}
+
+func (f *Filter) handle(dev endorsement.Device, keyboard []uint8, reports map[uint8]*usb.Decoder, pkts []Packet) []bool {
+	var data [][]byte
+	var endpoints []uint8
+	var times []time.Duration
+	var events []string
+
+	for _, pkt := range pkts {
+		data = append(data, pkt.Data)
+		endpoints = append(endpoints, pkt.Endpoint)
+		times = append(times, max(0, pkt.Time-pkts[0].Time))
+		events = append(events, decode(reports, keyboard, pkt))
+	}
+
+	first := f.inspect(dev, keyboard, pkts)
+
+	v, err := f.Endorser.Check(&endorsement.Batch{Device: dev, Packets: data, Endpoints: endpoints, Times: times})
+
+	if err != nil {
+		log.Printf("[USB] BLOCK dev=%s (%v)", dev, err)
+		return make([]bool, len(pkts))
+	}
+
+	var blocked [][]byte
+
+	for i, pkt := range data {
+		switch {
+		case first >= 0 && i >= first:
+			// offending packets are blocked regardless of the verdict
+			v.Permitted[i] = false
+			log.Printf("[USB] BLOCK dev=%s (keystroke injection) len=%d%s", dev, len(pkt), events[i])
+		case v.Permitted[i]:
+			log.Printf("[USB] PASS dev=%s len=%d%s", dev, len(pkt), events[i])
+			continue
+		case v.Quarantined:
+			log.Printf("[USB] BLOCK dev=%s (quarantined) len=%d%s", dev, len(pkt), events[i])
+		case v.Status == endorsement.Active:
+			log.Printf("[USB] BLOCK dev=%s (rate limited) len=%d%s", dev, len(pkt), events[i])
+		case v.Status == endorsement.Unknown:
+			log.Printf("[USB] BLOCK dev=%s (not endorsed) len=%d%s", dev, len(pkt), events[i])
+		default:
+			log.Printf("[USB] BLOCK dev=%s (endorsement %s) len=%d%s", dev, v.Status, len(pkt), events[i])
+		}
+
+		blocked = append(blocked, pkt)
+	}
+
+	log.Printf("[USB] dev=%s status=%s expiry=%d remaining_packets=%d", dev, v.Status, v.Expiry, v.Budget)
+
+	// revoked and quarantined devices are not eligible for re-endorsement
+	if len(blocked) > 0 && v.Status != endorsement.Revoked && !v.Quarantined {
+		queued, err := f.Endorser.Request(&endorsement.Batch{Device: dev, Packets: blocked})
+
+		switch {
+		case err != nil:
+			log.Printf("[USB] could not request re-endorsement dev=%s, %v", dev, err)
+		case queued:
+			log.Printf("[USB] re-endorsement requested dev=%s", dev)
+		}
+	}
+
+	return v.Permitted
+}
+
+// keyboardEndpoints returns the IN endpoint addresses of the HID boot
+// keyboard interfaces of a device.
+func keyboardEndpoints(d *usb.Device) (eps []uint8) {
+	for _, iface := range d.Interfaces() {
+		if iface.InterfaceClass != usb.HID_CLASS || iface.InterfaceSubClass != 1 || iface.InterfaceProtocol != 1 {
+			continue
+		}
+
+		for _, ep := range iface.Endpoints {
+			if ep.Direction() == usb.IN {
+				eps = append(eps, ep.EndpointAddress)
+			}
+		}
+	}
+
+	return
+}
+
+// decoders returns the HID input report decoders of a device, by IN
+// endpoint address.
+func decoders(d *usb.Device) map[uint8]*usb.Decoder {
+	reports := make(map[uint8]*usb.Decoder)
+
+	for _, iface := range d.Interfaces() {
+		if iface.HID == nil || iface.HID.Report == nil {
+			continue
+		}
+
+		dec := usb.NewDecoder(iface.HID.Report)
+
+		for _, ep := range iface.Endpoints {
+			if ep.Direction() == usb.IN {
+				reports[ep.EndpointAddress] = dec
+			}
+		}
+	}
+
+	return reports
+}
+
+// decode returns the typed usages of a HID input report, for logging, or an
+// empty string if the packet cannot be decoded.
+func decode(reports map[uint8]*usb.Decoder, keyboard []uint8, pkt Packet) string {
+	ep := pkt.Endpoint
+
+	// packets of unknown endpoints are assumed to be keyboard reports
+	if ep == 0 && len(keyboard) > 0 {
+		ep = keyboard[0]
+	}
+
+	dec, ok := reports[ep]
+
+	if !ok {
+		return ""
+	}
+
+	events, err := dec.Decode(pkt.Data)
+
+	if err != nil || len(events) == 0 {
+		return ""
+	}
+
+	return " " + usb.Events(events)
+}
+
+// inspect feeds the boot keyboard reports of a device to its keystroke
+// injection detector, on alerts the device is flagged and the index of the
+// first offending packet is returned (-1 otherwise).
+//
+// Superhuman typing rates downgrade the device endorsement, while suspicious
+// key sequences revoke it.
+func (f *Filter) inspect(dev endorsement.Device, keyboard []uint8, pkts []Packet) (first int) {
+	var penalty endorsement.Penalty
+	var reason string
+
+	first = -1
+
+	if len(keyboard) == 0 {
+		return
+	}
+
+	d, ok := f.detectors[dev.DeviceID]
+
+	if !ok {
+		d = keystroke.NewDetector(f.Keystroke)
+		f.detectors[dev.DeviceID] = d
+	}
+
+	for i, pkt := range pkts {
+		// packets of unknown endpoints are assumed to be reports
+		if pkt.Endpoint != 0 && !slices.Contains(keyboard, pkt.Endpoint) {
+			continue
+		}
+
+		alerts, err := d.Report(pkt.Time, pkt.Data)
+
+		if err != nil {
+			// not a boot keyboard report
+			continue
+		}
+
+		for _, a := range alerts {
+			log.Printf("[USB] ALERT dev=%s %s", dev, a)
+
+			if first < 0 {
+				first = i
+				reason = a.Reason
+			}
+
+			if a.Kind == keystroke.Sequence {
+				penalty = endorsement.Block
+			} else if penalty == 0 {
+				penalty = endorsement.Downgrade
+			}
+		}
+	}
+
+	if first < 0 {
+		return
+	}
+
+	if len(reason) > endorsement.MaxReasonSize {
+		reason = reason[:endorsement.MaxReasonSize]
+	}
+
+	var offending [][]byte
+
+	for _, pkt := range pkts[first:] {
+		offending = append(offending, pkt.Data)
+	}
+
+	fl := &endorsement.Flag{
+		Penalty: penalty,
+		Reason:  reason,
+		Batch: endorsement.Batch{
+			Device:  dev,
+			Packets: offending,
+		},
+	}
+
+	switch applied, err := f.Endorser.Flag(fl); {
+	case err != nil:
+		log.Printf("[USB] could not flag dev=%s, %v", dev, err)
+	case applied:
+		log.Printf("[USB] endorsement penalty applied dev=%s penalty=%s", dev, penalty)
+	}
+
+	return
+}
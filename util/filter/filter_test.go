@@ -0,0 +1,199 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/keystroke"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// descriptors is the path of recorded device descriptors.
+var descriptors = filepath.Join("..", "..", "USBIP", "descriptors")
+
+// reports is the path of recorded keyboard reports.
+var reports = filepath.Join("..", "keystroke", "testdata")
+
+func loadKeyboard(t *testing.T) (d *usb.Device, dev endorsement.Device) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(descriptors, "boot_keyboard.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err = usb.ParseRecording(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if dev, err = endorsement.Identity(d); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func loadPackets(t *testing.T, name string) (pkts []Packet) {
+	t.Helper()
+
+	text, err := os.ReadFile(filepath.Join(reports, name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := keystroke.ParseSamples(string(text))
+
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	for _, s := range samples {
+		pkts = append(pkts, Packet{Time: s.Time, Data: s.Data})
+	}
+
+	return
+}
+
+func count(permitted []bool) (n int) {
+	for _, ok := range permitted {
+		if ok {
+			n += 1
+		}
+	}
+
+	return
+}
+
+func TestHandle(t *testing.T) {
+	d, dev := loadKeyboard(t)
+	pkts := loadPackets(t, "human_typing.txt")
+
+	for _, tc := range []struct {
+		name      string
+		setup     func(c *endorsement.Cache)
+		permitted int
+		requested bool
+	}{
+		{"not endorsed", func(c *endorsement.Cache) {}, 0, true},
+		{"endorsed", func(c *endorsement.Cache) {
+			c.Add(endorsement.Grant{Device: dev.DeviceID})
+		}, len(pkts), false},
+		{"budget", func(c *endorsement.Cache) {
+			c.Add(endorsement.Grant{Device: dev.DeviceID, Budget: 10})
+		}, 10, true},
+		{"revoked", func(c *endorsement.Cache) {
+			c.Add(endorsement.Grant{Device: dev.DeviceID})
+			c.Revoke(dev.DeviceID)
+		}, 0, false},
+		{"other device", func(c *endorsement.Cache) {
+			c.Add(endorsement.Grant{Device: endorsement.DeviceID{VendorID: 0x046d, ProductID: 0xc31c}})
+		}, 0, true},
+	} {
+		c := endorsement.NewCache()
+		tc.setup(c)
+
+		local := NewLocal(c)
+		f := New(local, nil)
+
+		permitted := f.Handle(d, pkts)
+
+		if len(permitted) != len(pkts) || count(permitted) != tc.permitted {
+			t.Errorf("%s: permitted %d/%d packets, want %d", tc.name, count(permitted), len(permitted), tc.permitted)
+		}
+
+		if requested := len(local.Requests.Pending()) > 0; requested != tc.requested {
+			t.Errorf("%s: re-endorsement requested:%v, want %v", tc.name, requested, tc.requested)
+		}
+	}
+}
+
+func TestHandleInjection(t *testing.T) {
+	d, dev := loadKeyboard(t)
+
+	for _, tc := range []struct {
+		name   string
+		status endorsement.Status
+	}{
+		{"human_typing.txt", endorsement.Active},
+		// superhuman typing rates downgrade the endorsement
+		{"injection_rate.txt", endorsement.Expired},
+		// suspicious sequences revoke the endorsement
+		{"injection_run.txt", endorsement.Revoked},
+	} {
+		pkts := loadPackets(t, tc.name)
+
+		c := endorsement.NewCache()
+		c.Add(endorsement.Grant{Device: dev.DeviceID})
+
+		f := New(NewLocal(c), nil)
+
+		// penalties apply ahead of the endorsement check
+		permitted := f.Handle(d, pkts)
+
+		if e, _ := c.Get(dev.DeviceID); e.Status != tc.status {
+			t.Errorf("%s: endorsement %s, want %s", tc.name, e.Status, tc.status)
+		}
+
+		if blocked := len(pkts) - count(permitted); (blocked > 0) != (tc.status != endorsement.Active) {
+			t.Errorf("%s: blocked %d/%d packets", tc.name, blocked, len(pkts))
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	d, dev := loadKeyboard(t)
+	pkts := loadPackets(t, "human_typing.txt")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := endorsement.NewCache()
+	c.Add(endorsement.Grant{Device: dev.DeviceID})
+
+	f := New(NewLocal(c), nil)
+	f.Tokens = endorsement.NewTokens(pub)
+
+	// endorsed devices are blocked without a token
+	if err = f.Authorize(d); !errors.Is(err, endorsement.ErrNoToken) {
+		t.Errorf("authorized without token (%v)", err)
+	}
+
+	if n := count(f.Handle(d, pkts)); n != 0 {
+		t.Errorf("permitted %d packets without token", n)
+	}
+
+	token := &endorsement.Token{
+		Version: endorsement.TokenVersion,
+		Device:  dev.DeviceID,
+	}
+
+	if err = token.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Tokens.Add(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Authorize(d); err != nil {
+		t.Errorf("not authorized with token (%v)", err)
+	}
+
+	if n := count(f.Handle(d, pkts)); n != len(pkts) {
+		t.Errorf("permitted %d/%d packets with token", n, len(pkts))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"github.com/usbarmory/GoTEE-example/util/anomaly"
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Local implements an Endorser over an in-process endorsement cache, for
+// host side replay, it mirrors the Trusted OS monitor call handlers.
+type Local struct {
+	// Cache holds the device endorsements
+	Cache *endorsement.Cache
+	// Requests holds the re-endorsement requests
+	Requests *endorsement.Requests
+	// Anomalies holds the rate limits and anomaly scores, evaluated
+	// ahead of the endorsement check, if not nil
+	Anomalies *anomaly.Monitor
+}
+
+// NewLocal returns an in-process endorsement service.
+func NewLocal(c *endorsement.Cache) *Local {
+	return &Local{
+		Cache:    c,
+		Requests: &endorsement.Requests{},
+	}
+}
+
+// Check returns the verdict on a batch of packets.
+func (l *Local) Check(b *endorsement.Batch) (*endorsement.Verdict, error) {
+	if l.Anomalies != nil {
+		return l.Anomalies.Check(l.Cache, b), nil
+	}
+
+	return l.Cache.Check(b), nil
+}
+
+// Request raises a re-endorsement request for a batch of blocked packets.
+func (l *Local) Request(b *endorsement.Batch) (bool, error) {
+	e, _ := l.Cache.Lookup(&b.Device)
+
+	// revoked devices can only be endorsed again explicitly
+	if e.Status == endorsement.Active || e.Status == endorsement.Revoked {
+		return false, nil
+	}
+
+	return l.Requests.Raise(b, e.Status, "")
+}
+
+// Flag applies the penalty requested for suspicious device activity.
+func (l *Local) Flag(f *endorsement.Flag) (applied bool, err error) {
+	e, _ := l.Cache.Lookup(&f.Batch.Device)
+
+	if e.Status != endorsement.Active {
+		return
+	}
+
+	switch f.Penalty {
+	case endorsement.Downgrade:
+		if err = l.Cache.Downgrade(e.Device); err != nil {
+			return
+		}
+
+		_, err = l.Requests.Raise(&f.Batch, endorsement.Expired, f.Reason)
+	case endorsement.Block:
+		err = l.Cache.Revoke(e.Device)
+	}
+
+	return err == nil, err
+}
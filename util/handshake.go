@@ -0,0 +1,104 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The mutual attestation handshake between Normal World and Trusted Applet is
+// routed through the monitor as follows:
+//
+//	Normal World               Trusted OS                 Trusted Applet
+//	     |                          |                            |
+//	     |-- NS.GetChallenge ------>|                            |
+//	     |<--------- nonce ---------|                            |
+//	     |                          |<----- RPC.Handshake -------| (waits)
+//	     |-- SYS_ATTEST(nonce) ---->|                            |
+//	     |                          |-- nonce, NW measurement -->|
+//	     |                          |                            | verify NW
+//	     |                          |<------- RPC.Quote ---------|
+//	     |                          |<-- RPC.HandshakeResponse --|
+//	     |<----- status, quote -----|                            |
+//	     | verify quote             |                            |
+//
+// The Normal World measurement is provided by the monitor, from its measured
+// boot event log, so that it cannot be forged by the Normal World itself. The
+// nonce must have been issued by the Trusted OS, as it is consumed by the
+// applet quote.
+
+// HandshakeStatus represents the outcome of a mutual attestation handshake.
+type HandshakeStatus uint32
+
+// Handshake status values
+const (
+	// HandshakeAccepted indicates that the applet verified the Normal
+	// World measurement and released its services.
+	HandshakeAccepted HandshakeStatus = iota + 1
+	// HandshakeRejected indicates that the applet did not accept the
+	// Normal World measurement.
+	HandshakeRejected
+	// HandshakeUnavailable indicates that no applet served the request.
+	HandshakeUnavailable
+)
+
+// HandshakeRequestSize is the size of a serialized Normal World handshake
+// request.
+const HandshakeRequestSize = 32
+
+// HandshakeResponseSize is the size of a serialized handshake response, which
+// is also the minimum buffer size for SYS_ATTEST monitor calls.
+const HandshakeResponseSize = 4 + QuoteSize
+
+// HandshakeRequest represents a Normal World attestation request, as
+// forwarded by the monitor to the Trusted Applet.
+type HandshakeRequest struct {
+	// Nonce is the Normal World challenge, issued by the Trusted OS
+	Nonce [32]byte
+	// OS is the Normal World measurement, set by the monitor
+	OS Digest
+}
+
+// HandshakeResponse represents the Trusted Applet response to a Normal World
+// attestation request.
+type HandshakeResponse struct {
+	// Status is the handshake outcome
+	Status HandshakeStatus
+	// Quote is the serialized applet quote over the Normal World nonce,
+	// present only for accepted handshakes
+	Quote []byte
+}
+
+// MarshalBinary returns the serialized response, the quote is zero filled
+// when absent.
+func (r *HandshakeResponse) MarshalBinary() ([]byte, error) {
+	if len(r.Quote) != 0 && len(r.Quote) != QuoteSize {
+		return nil, errors.New("invalid quote size")
+	}
+
+	buf := make([]byte, HandshakeResponseSize)
+	binary.BigEndian.PutUint32(buf, uint32(r.Status))
+	copy(buf[4:], r.Quote)
+
+	return buf, nil
+}
+
+// UnmarshalBinary parses a response serialized with MarshalBinary().
+func (r *HandshakeResponse) UnmarshalBinary(data []byte) error {
+	if len(data) < HandshakeResponseSize {
+		return errors.New("invalid response size")
+	}
+
+	r.Status = HandshakeStatus(binary.BigEndian.Uint32(data))
+	r.Quote = nil
+
+	if r.Status == HandshakeAccepted {
+		r.Quote = append([]byte(nil), data[4:HandshakeResponseSize]...)
+	}
+
+	return nil
+}
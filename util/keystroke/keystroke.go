@@ -0,0 +1,273 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package keystroke implements detection of HID keystroke injection (BadUSB)
+// attacks, from the timing and content of boot keyboard reports.
+//
+// The package is pure Go and suitable for use on both the Normal World and
+// host side tools.
+package keystroke
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// maxCommandSize is the maximum size of a command tracked after Win+R.
+const maxCommandSize = 256
+
+// Config represents the detector thresholds.
+type Config struct {
+	// BurstGap is the minimum interval between keystrokes of distinct
+	// bursts
+	BurstGap time.Duration
+	// MinBurst is the number of keystrokes after which the typing rate
+	// of a burst is evaluated
+	MinBurst int
+	// MaxRate is the maximum typing rate, in keystrokes per second,
+	// within a burst
+	MaxRate float64
+	// RunWindow is the maximum interval between keystrokes, after Win+R
+	// opens the Windows Run dialog, for the typed command to be tracked
+	RunWindow time.Duration
+	// Shells holds the command names flagged when launched from the Run
+	// dialog
+	Shells []string
+}
+
+// DefaultConfig returns the default detector thresholds, sustained typing
+// rates above 20 keystrokes per second (about 240 words per minute) exceed
+// those of human typists.
+func DefaultConfig() Config {
+	return Config{
+		BurstGap:  1 * time.Second,
+		MinBurst:  16,
+		MaxRate:   20,
+		RunWindow: 5 * time.Second,
+		Shells:    []string{"cmd", "powershell", "pwsh", "wscript", "cscript", "mshta"},
+	}
+}
+
+// Kind represents an alert kind.
+type Kind int
+
+// Alert kinds
+const (
+	// Rate is raised on superhuman typing rates.
+	Rate Kind = iota
+	// Sequence is raised on suspicious key sequences.
+	Sequence
+)
+
+// Alert represents a keystroke injection alert.
+type Alert struct {
+	Kind Kind
+	// Time is the time of the report raising the alert
+	Time time.Duration
+	// Reason is a description of the alert
+	Reason string
+}
+
+// String returns a description of the alert.
+func (a Alert) String() string {
+	return fmt.Sprintf("%v %s", a.Time, a.Reason)
+}
+
+// Stats represents keystroke timing statistics.
+type Stats struct {
+	// Reports is the number of processed reports
+	Reports int
+	// Keystrokes is the number of key presses
+	Keystrokes int
+	// Bursts is the number of keystroke bursts (see Config.BurstGap)
+	Bursts int
+	// LongestBurst is the number of keystrokes of the longest burst
+	LongestBurst int
+	// MinInterval is the minimum interval between keystrokes
+	MinInterval time.Duration
+	// MeanInterval is the mean interval between keystrokes within bursts
+	MeanInterval time.Duration
+	// MaxRate is the maximum typing rate, in keystrokes per second, of
+	// bursts of at least Config.MinBurst keystrokes
+	MaxRate float64
+}
+
+// Detector represents a keystroke injection detector for a single keyboard,
+// fed with its boot keyboard reports.
+type Detector struct {
+	Config
+
+	stats Stats
+	prev  usb.KeyboardReport
+
+	// last keystroke time
+	last time.Duration
+	// sum of intra-burst intervals
+	sum       time.Duration
+	intervals int
+
+	// current burst
+	burstStart   time.Duration
+	burstLength  int
+	burstFlagged bool
+
+	// Run dialog tracking
+	run     bool
+	runTime time.Duration
+	command []rune
+}
+
+// NewDetector returns a detector with the given thresholds.
+func NewDetector(c Config) *Detector {
+	return &Detector{
+		Config: c,
+	}
+}
+
+// Stats returns the keystroke timing statistics.
+func (d *Detector) Stats() Stats {
+	return d.stats
+}
+
+// Report processes a boot keyboard report, received at the given time
+// (relative to any fixed origin), and returns any raised alert.
+func (d *Detector) Report(t time.Duration, buf []byte) (alerts []Alert, err error) {
+	var r usb.KeyboardReport
+
+	if err = r.UnmarshalBinary(buf); err != nil {
+		return
+	}
+
+	d.stats.Reports++
+
+	for _, key := range r.Pressed(&d.prev) {
+		if a := d.keystroke(t); a != nil {
+			alerts = append(alerts, *a)
+		}
+
+		if a := d.sequence(t, r.Modifiers, key); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+
+	d.prev = r
+
+	return
+}
+
+func (d *Detector) keystroke(t time.Duration) (a *Alert) {
+	s := &d.stats
+	s.Keystrokes++
+
+	interval := t - d.last
+	d.last = t
+
+	if s.Keystrokes > 1 && (s.Keystrokes == 2 || interval < s.MinInterval) {
+		s.MinInterval = interval
+	}
+
+	if s.Keystrokes == 1 || interval > d.BurstGap {
+		s.Bursts++
+		d.burstStart = t
+		d.burstLength = 0
+		d.burstFlagged = false
+	} else {
+		d.sum += interval
+		d.intervals++
+		s.MeanInterval = d.sum / time.Duration(d.intervals)
+	}
+
+	d.burstLength++
+	s.LongestBurst = max(s.LongestBurst, d.burstLength)
+
+	if d.burstLength < d.MinBurst || d.burstLength < 2 {
+		return
+	}
+
+	rate := math.Inf(1)
+
+	if elapsed := t - d.burstStart; elapsed > 0 {
+		rate = float64(d.burstLength-1) / elapsed.Seconds()
+	}
+
+	s.MaxRate = max(s.MaxRate, rate)
+
+	if rate <= d.MaxRate || d.burstFlagged {
+		return
+	}
+
+	d.burstFlagged = true
+
+	return &Alert{
+		Kind:   Rate,
+		Time:   t,
+		Reason: fmt.Sprintf("superhuman typing rate (%.0f keystrokes/s over %d keystrokes)", rate, d.burstLength),
+	}
+}
+
+func (d *Detector) sequence(t time.Duration, modifiers uint8, key uint8) (a *Alert) {
+	if key == usb.KEY_R && modifiers&usb.GUI != 0 && modifiers&^usb.GUI == 0 {
+		d.run = true
+		d.runTime = t
+		d.command = nil
+		return
+	}
+
+	if !d.run {
+		return
+	}
+
+	if t-d.runTime > d.RunWindow {
+		d.run = false
+		return
+	}
+
+	d.runTime = t
+
+	switch key {
+	case usb.KEY_ENTER:
+		d.run = false
+
+		if cmd := string(d.command); d.shell(cmd) {
+			return &Alert{
+				Kind:   Sequence,
+				Time:   t,
+				Reason: fmt.Sprintf("Win+R followed by command shell (%q)", cmd),
+			}
+		}
+	case usb.KEY_BACKSPACE:
+		if n := len(d.command); n > 0 {
+			d.command = d.command[:n-1]
+		}
+	case usb.KEY_ESC:
+		d.run = false
+	default:
+		if r, ok := usb.KeyRune(key, modifiers&usb.SHIFT != 0); ok && len(d.command) < maxCommandSize {
+			d.command = append(d.command, r)
+		}
+	}
+
+	return
+}
+
+// shell returns whether a command launches one of the configured shells.
+func (d *Detector) shell(cmd string) bool {
+	f := strings.Fields(strings.ToLower(cmd))
+
+	if len(f) == 0 {
+		return false
+	}
+
+	name := path.Base(strings.ReplaceAll(f[0], `\`, "/"))
+	name = strings.TrimSuffix(name, ".exe")
+
+	return slices.Contains(d.Shells, name)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package keystroke
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func replay(t *testing.T, name string) (alerts []Alert, stats Stats) {
+	t.Helper()
+
+	text, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := ParseSamples(string(text))
+
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	d := NewDetector(DefaultConfig())
+
+	if alerts, err = d.Replay(samples); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return alerts, d.Stats()
+}
+
+func TestHumanTyping(t *testing.T) {
+	alerts, stats := replay(t, "human_typing.txt")
+
+	if len(alerts) != 0 {
+		t.Errorf("alerts %v, want none", alerts)
+	}
+
+	if stats.Keystrokes != 41 || stats.MaxRate > DefaultConfig().MaxRate {
+		t.Errorf("keystrokes:%d max rate:%.1f, want 41 keystrokes under %.0f/s", stats.Keystrokes, stats.MaxRate, DefaultConfig().MaxRate)
+	}
+}
+
+func TestInjectionRate(t *testing.T) {
+	alerts, _ := replay(t, "injection_rate.txt")
+
+	if len(alerts) != 1 {
+		t.Fatalf("alerts %v, want one", alerts)
+	}
+
+	// raised once the minimum burst length is reached
+	if a := alerts[0]; a.Kind != Rate || a.Time != 120*time.Millisecond {
+		t.Errorf("alert %v (kind %d), want rate alert at 120ms", a, a.Kind)
+	}
+}
+
+func TestInjectionRun(t *testing.T) {
+	alerts, stats := replay(t, "injection_run.txt")
+
+	if len(alerts) != 1 {
+		t.Fatalf("alerts %v, want one", alerts)
+	}
+
+	a := alerts[0]
+
+	if a.Kind != Sequence || !strings.Contains(a.Reason, "powershell") {
+		t.Errorf("alert %v (kind %d), want sequence alert on powershell", a, a.Kind)
+	}
+
+	// typed at a human-like rate
+	if stats.MaxRate > DefaultConfig().MaxRate {
+		t.Errorf("max rate %.1f, want under %.0f/s", stats.MaxRate, DefaultConfig().MaxRate)
+	}
+}
+
+func TestParseSamples(t *testing.T) {
+	for _, text := range []string{
+		"0ms",
+		"xyz 0000040000000000",
+		"0ms zz",
+		"0ms 0000040000000000 00",
+	} {
+		if _, err := ParseSamples(text); err == nil {
+			t.Errorf("%q: parsed", text)
+		}
+	}
+}
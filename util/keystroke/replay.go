@@ -0,0 +1,82 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package keystroke
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sample represents a recorded keyboard report.
+type Sample struct {
+	// Time is the report time from the start of the recording
+	Time time.Duration
+	// Data is the report
+	Data []byte
+}
+
+// ParseSamples parses recorded keyboard reports, one per line in
+// `<time> <hex report>` format, where the time is a duration from the start of
+// the recording (e.g. 1.25s or 120ms). Empty lines and `#` comments are
+// ignored.
+func ParseSamples(text string) (samples []Sample, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		s := scanner.Text()
+
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+
+		f := strings.Fields(s)
+
+		if len(f) == 0 {
+			continue
+		}
+
+		if len(f) != 2 {
+			return nil, fmt.Errorf("line %d: invalid sample", line)
+		}
+
+		t, err := time.ParseDuration(f[0])
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time, %v", line, err)
+		}
+
+		data, err := hex.DecodeString(f[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid report, %v", line, err)
+		}
+
+		samples = append(samples, Sample{Time: t, Data: data})
+	}
+
+	return samples, scanner.Err()
+}
+
+// Replay processes recorded keyboard reports and returns all raised alerts.
+func (d *Detector) Replay(samples []Sample) (alerts []Alert, err error) {
+	for i, s := range samples {
+		a, err := d.Report(s.Time, s.Data)
+
+		if err != nil {
+			return nil, fmt.Errorf("sample %d, %v", i, err)
+		}
+
+		alerts = append(alerts, a...)
+	}
+
+	return
+}
@@ -7,53 +7,308 @@ package util
 
 import (
 	"bytes"
+	"io"
 	"os"
+	"sort"
+	"sync"
 
 	"golang.org/x/term"
 )
 
-var secureOutput bytes.Buffer
-var nonSecureOutput bytes.Buffer
-
 const outputLimit = 1024
 const flushChr = 0x0a // \n
 
-func BufferedStdoutLog(c byte, secure bool) {
-	var buf *bytes.Buffer
+// HistorySize is the number of lines retained by each log stream.
+const HistorySize = 256
 
-	if secure {
-		buf = &secureOutput
-	} else {
-		buf = &nonSecureOutput
+// Colour represents a log stream colour.
+type Colour int
+
+// Log stream colours
+const (
+	Default Colour = iota
+	Red
+	Green
+	Yellow
+	Blue
+	Magenta
+	Cyan
+	White
+)
+
+var colourNames = map[Colour]string{
+	Default: "default",
+	Red:     "red",
+	Green:   "green",
+	Yellow:  "yellow",
+	Blue:    "blue",
+	Magenta: "magenta",
+	Cyan:    "cyan",
+	White:   "white",
+}
+
+func (c Colour) String() string {
+	return colourNames[c]
+}
+
+// Escape returns the terminal escape sequence for the colour.
+func (c Colour) Escape(t *term.Terminal) []byte {
+	switch c {
+	case Red:
+		return t.Escape.Red
+	case Green:
+		return t.Escape.Green
+	case Yellow:
+		return t.Escape.Yellow
+	case Blue:
+		return t.Escape.Blue
+	case Magenta:
+		return t.Escape.Magenta
+	case Cyan:
+		return t.Escape.Cyan
+	case White:
+		return t.Escape.White
 	}
 
-	buf.WriteByte(c)
+	return nil
+}
+
+// LogStream represents the log output of an execution context, output is
+// assembled in lines which are retained in a bounded history and forwarded
+// to the terminals attached to the stream multiplexer.
+type LogStream struct {
+	sync.Mutex
 
-	if c == flushChr || buf.Len() > outputLimit {
-		os.Stdout.Write(buf.Bytes())
-		buf.Reset()
+	// Name is the stream name
+	Name string
+	// Colour is the stream colour on terminals
+	Colour Colour
+
+	mux     *LogMux
+	line    bytes.Buffer
+	history [HistorySize][]byte
+	next    int
+	count   int
+	total   uint64
+}
+
+// WriteByte appends a byte to the stream, complete lines are recorded and
+// forwarded.
+func (s *LogStream) WriteByte(c byte) error {
+	s.Lock()
+	s.line.WriteByte(c)
+
+	if c != flushChr && s.line.Len() <= outputLimit {
+		s.Unlock()
+		return nil
 	}
+
+	line := bytes.Clone(s.line.Bytes())
+	s.line.Reset()
+
+	s.history[s.next] = line
+	s.next = (s.next + 1) % HistorySize
+	s.count = min(s.count+1, HistorySize)
+	s.total++
+	colour := s.Colour
+	s.Unlock()
+
+	s.mux.forward(colour, line)
+
+	return nil
 }
 
-func BufferedTermLog(c byte, secure bool, t *term.Terminal) {
-	var buf *bytes.Buffer
-	var color []byte
+// Write implements the io.Writer interface.
+func (s *LogStream) Write(p []byte) (n int, err error) {
+	for _, c := range p {
+		s.WriteByte(c)
+	}
 
-	if secure {
-		buf = &secureOutput
-		color = t.Escape.Green
-	} else {
-		buf = &nonSecureOutput
-		color = t.Escape.Red
+	return len(p), nil
+}
+
+// History returns up to n of the most recent lines, oldest first, all
+// retained lines are returned if n is not positive.
+func (s *LogStream) History(n int) (lines [][]byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	if n <= 0 || n > s.count {
+		n = s.count
+	}
+
+	for i := n; i > 0; i-- {
+		lines = append(lines, s.history[(s.next-i+HistorySize)%HistorySize])
 	}
 
-	buf.WriteByte(c)
+	return
+}
 
-	if c == flushChr || buf.Len() > outputLimit {
-		t.Write(color)
-		t.Write(buf.Bytes())
-		t.Write(t.Escape.Reset)
+// Lines returns the number of lines written to the stream.
+func (s *LogStream) Lines() uint64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.total
+}
+
+// LogMux represents a multiplexer of execution context log streams.
+type LogMux struct {
+	sync.Mutex
+
+	// Stdout receives stream output only while no terminal is attached,
+	// it defaults to os.Stdout.
+	Stdout io.Writer
+
+	keys    map[any]*LogStream
+	streams map[string]*LogStream
+	terms   map[*term.Terminal]bool
+}
+
+// Logs is the default log stream multiplexer.
+var Logs = &LogMux{}
+
+func (m *LogMux) init() {
+	if m.streams == nil {
+		m.keys = make(map[any]*LogStream)
+		m.streams = make(map[string]*LogStream)
+		m.terms = make(map[*term.Terminal]bool)
+	}
+}
+
+// Register binds an execution context (or any other key) to the named
+// stream, which is created if not present. A stream previously bound to a
+// different key (e.g. a terminated execution context with the same name) is
+// rebound, retaining its history.
+func (m *LogMux) Register(key any, name string, colour Colour) *LogStream {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+
+	s, ok := m.streams[name]
+
+	if !ok {
+		s = &LogStream{
+			Name: name,
+			mux:  m,
+		}
+
+		m.streams[name] = s
+	}
+
+	for k, ks := range m.keys {
+		if ks == s {
+			delete(m.keys, k)
+		}
+	}
+
+	s.Lock()
+	s.Colour = colour
+	s.Unlock()
+
+	m.keys[key] = s
+
+	return s
+}
+
+// Stream returns the stream bound to a key, if any.
+func (m *LogMux) Stream(key any) *LogStream {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+
+	return m.keys[key]
+}
+
+// Lookup returns the stream with the argument name, if any.
+func (m *LogMux) Lookup(name string) *LogStream {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+
+	return m.streams[name]
+}
+
+// Streams returns all streams, sorted by name.
+func (m *LogMux) Streams() (streams []*LogStream) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, s := range m.streams {
+		streams = append(streams, s)
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+
+	return
+}
+
+// Attach forwards the output of all streams, including the ones registered
+// afterwards, to a terminal.
+func (m *LogMux) Attach(t *term.Terminal) {
+	m.Lock()
+	defer m.Unlock()
 
-		buf.Reset()
+	m.init()
+	m.terms[t] = true
+}
+
+// Detach stops forwarding stream output to a terminal.
+func (m *LogMux) Detach(t *term.Terminal) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.terms, t)
+}
+
+// Attached returns the number of attached terminals.
+func (m *LogMux) Attached() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return len(m.terms)
+}
+
+// forward writes a stream line to all attached terminals or, only when none
+// is attached, to Stdout.
+func (m *LogMux) forward(colour Colour, line []byte) {
+	var terms []*term.Terminal
+
+	m.Lock()
+
+	for t := range m.terms {
+		terms = append(terms, t)
+	}
+
+	out := m.Stdout
+	m.Unlock()
+
+	if len(terms) == 0 {
+		if out == nil {
+			out = os.Stdout
+		}
+
+		out.Write(line)
+		return
+	}
+
+	for _, t := range terms {
+		WriteLine(t, colour, line)
+	}
+}
+
+// WriteLine writes a log line to a terminal with the argument colour.
+func WriteLine(t *term.Terminal, c Colour, line []byte) {
+	if esc := c.Escape(t); esc != nil {
+		t.Write(esc)
+		t.Write(line)
+		t.Write(t.Escape.Reset)
+	} else {
+		t.Write(line)
 	}
 }
@@ -0,0 +1,274 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package logging implements the Trusted OS structured logger, with levels,
+// component tags and key/value fields (see log/slog).
+//
+// Each record is logged in text format, through the standard logger, and
+// written in JSON-lines format to a sink, which retains a bounded history and
+// forwards records to attached writers (e.g. SSH or serial terminals) so that
+// they can be collected by host tools (see gotee-events).
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Components
+const (
+	// Loader tags execution context loading and scheduling events
+	Loader = "loader"
+	// Handler tags exception and monitor call handling events
+	Handler = "handler"
+	// TZ tags TrustZone configuration and watchdog events
+	TZ = "tz"
+	// RPC tags Normal World RPC and mailbox events
+	RPC = "rpc"
+	// USB tags USB endorsement and filtering events
+	USB = "usb"
+)
+
+// ComponentKey is the record attribute key for component tags.
+const ComponentKey = "component"
+
+// HistorySize is the number of JSON records retained by a sink.
+const HistorySize = 256
+
+// Hex represents an integer field logged in hexadecimal format (e.g.
+// addresses).
+type Hex uint64
+
+// LogValue implements the slog.LogValuer interface.
+func (h Hex) LogValue() slog.Value {
+	return slog.StringValue(fmt.Sprintf("%#x", uint64(h)))
+}
+
+// Sink represents a JSON-lines record sink.
+type Sink struct {
+	sync.Mutex
+
+	history [HistorySize][]byte
+	next    int
+	count   int
+	writers map[io.Writer]bool
+}
+
+// Write records a JSON-lines record and forwards it to attached writers, it
+// implements the io.Writer interface.
+func (s *Sink) Write(p []byte) (n int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	rec := bytes.Clone(p)
+
+	s.history[s.next] = rec
+	s.next = (s.next + 1) % HistorySize
+	s.count = min(s.count+1, HistorySize)
+
+	for w := range s.writers {
+		w.Write(rec)
+	}
+
+	return len(p), nil
+}
+
+// Attach forwards subsequent records to a writer.
+func (s *Sink) Attach(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.writers == nil {
+		s.writers = make(map[io.Writer]bool)
+	}
+
+	s.writers[w] = true
+}
+
+// Detach stops forwarding records to a writer.
+func (s *Sink) Detach(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.writers, w)
+}
+
+// History returns up to n of the most recent records, oldest first, all
+// retained records are returned if n is not positive.
+func (s *Sink) History(n int) (records [][]byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	if n <= 0 || n > s.count {
+		n = s.count
+	}
+
+	for i := n; i > 0; i-- {
+		records = append(records, s.history[(s.next-i+HistorySize)%HistorySize])
+	}
+
+	return
+}
+
+// TextHandler implements slog.Handler, records are logged in text format
+// through the standard logger and in JSON-lines format to a sink.
+type TextHandler struct {
+	// Prefix is prepended to text records
+	Prefix string
+
+	json   slog.Handler
+	level  slog.Leveler
+	attrs  []groupAttr
+	groups []string
+}
+
+// groupAttr represents an attribute along with its enclosing groups.
+type groupAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+// NewHandler returns a handler for records of at least the argument level.
+func NewHandler(sink io.Writer, level slog.Leveler, prefix string) *TextHandler {
+	return &TextHandler{
+		Prefix: prefix,
+		json:   slog.NewJSONHandler(sink, &slog.HandlerOptions{Level: level}),
+		level:  level,
+	}
+}
+
+// Enabled implements the slog.Handler interface.
+func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func appendAttr(buf *strings.Builder, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, append(groups, a.Key), ga)
+		}
+
+		return
+	}
+
+	val := a.Value.String()
+
+	if strings.ContainsAny(val, " \t\n\"=") || val == "" {
+		val = strconv.Quote(val)
+	}
+
+	buf.WriteByte(' ')
+
+	for _, g := range groups {
+		buf.WriteString(g + ".")
+	}
+
+	buf.WriteString(a.Key + "=" + val)
+}
+
+// Handle implements the slog.Handler interface.
+func (h *TextHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf strings.Builder
+	var component string
+	var attrs []groupAttr
+
+	collect := func(a groupAttr) {
+		if a.attr.Key == ComponentKey && len(a.groups) == 0 {
+			component = a.attr.Value.String()
+		} else {
+			attrs = append(attrs, a)
+		}
+	}
+
+	for _, a := range h.attrs {
+		collect(a)
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		collect(groupAttr{h.groups, a})
+		return true
+	})
+
+	buf.WriteString(h.Prefix)
+
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String() + " ")
+	}
+
+	if component != "" {
+		buf.WriteString("[" + component + "] ")
+	}
+
+	buf.WriteString(r.Message)
+
+	for _, a := range attrs {
+		appendAttr(&buf, a.groups, a.attr)
+	}
+
+	log.Print(buf.String())
+
+	return h.json.Handle(ctx, r)
+}
+
+// WithAttrs implements the slog.Handler interface.
+func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.json = h.json.WithAttrs(attrs)
+	c.attrs = append([]groupAttr(nil), h.attrs...)
+
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, groupAttr{h.groups, a})
+	}
+
+	return &c
+}
+
+// WithGroup implements the slog.Handler interface.
+func (h *TextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := *h
+	c.json = h.json.WithGroup(name)
+	c.groups = append(append([]string(nil), h.groups...), name)
+
+	return &c
+}
+
+var (
+	// Level is the minimum level of logged records.
+	Level = new(slog.LevelVar)
+
+	// JSON is the default JSON-lines sink.
+	JSON = &Sink{}
+
+	// Default is the default structured logger.
+	Default = slog.New(NewHandler(JSON, Level, "SM "))
+)
+
+// For returns the default structured logger tagged with a component.
+func For(component string) *slog.Logger {
+	return Default.With(ComponentKey, component)
+}
+
+// ParseLevel parses a level name (debug, info, warn, error).
+func ParseLevel(s string) (level slog.Level, err error) {
+	err = level.UnmarshalText([]byte(s))
+	return
+}
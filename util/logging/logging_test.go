@@ -0,0 +1,184 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// capture returns a logger over a new sink, along with the sink and the
+// standard logger output.
+func capture(t *testing.T, level slog.Level) (l *slog.Logger, sink *Sink, text *bytes.Buffer) {
+	t.Helper()
+
+	text = new(bytes.Buffer)
+	w, flags := log.Writer(), log.Flags()
+
+	log.SetOutput(text)
+	log.SetFlags(0)
+
+	t.Cleanup(func() {
+		log.SetOutput(w)
+		log.SetFlags(flags)
+	})
+
+	sink = &Sink{}
+	l = slog.New(NewHandler(sink, level, "SM "))
+
+	return
+}
+
+func TestHandler(t *testing.T) {
+	l, sink, text := capture(t, slog.LevelInfo)
+
+	for _, tc := range []struct {
+		name string
+		log  func()
+		text string
+		json map[string]any
+	}{
+		{"message", func() { l.Info("started") },
+			"SM started",
+			map[string]any{"level": "INFO", "msg": "started"}},
+		{"component", func() { l.With(ComponentKey, Loader).Warn("exited", "pc", Hex(0x80000000)) },
+			"SM WARN [loader] exited pc=0x80000000",
+			map[string]any{"level": "WARN", "component": "loader", "pc": "0x80000000"}},
+		{"quoting", func() { l.Error("failed", "err", "not found", "note", "") },
+			`SM ERROR failed err="not found" note=""`,
+			map[string]any{"err": "not found", "note": ""}},
+		{"groups", func() { l.WithGroup("dev").With("vid", 0x1209).Info("endorsed", slog.Group("token", "expiry", 0)) },
+			"SM endorsed dev.vid=4617 dev.token.expiry=0",
+			map[string]any{"dev": map[string]any{"vid": 4617.0, "token": map[string]any{"expiry": 0.0}}}},
+		{"grouped component", func() { l.WithGroup("ctx").Info("event", ComponentKey, USB) },
+			"SM event ctx.component=usb",
+			map[string]any{"ctx": map[string]any{"component": "usb"}}},
+	} {
+		text.Reset()
+		tc.log()
+
+		if s := strings.TrimSuffix(text.String(), "\n"); s != tc.text {
+			t.Errorf("%s: text %q, want %q", tc.name, s, tc.text)
+		}
+
+		h := sink.History(1)
+
+		if len(h) != 1 {
+			t.Fatalf("%s: no JSON record", tc.name)
+		}
+
+		var rec map[string]any
+
+		if err := json.Unmarshal(h[0], &rec); err != nil {
+			t.Fatalf("%s: invalid JSON record %q", tc.name, h[0])
+		}
+
+		for k, v := range tc.json {
+			if fmt.Sprint(rec[k]) != fmt.Sprint(v) {
+				t.Errorf("%s: JSON %s=%v, want %v", tc.name, k, rec[k], v)
+			}
+		}
+	}
+
+	// records below the handler level are discarded
+	text.Reset()
+	n := len(sink.History(0))
+	l.Debug("discarded")
+
+	if text.Len() != 0 || len(sink.History(0)) != n {
+		t.Errorf("debug record logged %q", text)
+	}
+}
+
+func TestSink(t *testing.T) {
+	var a, b bytes.Buffer
+
+	sink := &Sink{}
+
+	if h := sink.History(0); len(h) != 0 {
+		t.Errorf("empty sink history %q", h)
+	}
+
+	sink.Attach(&a)
+	sink.Attach(&b)
+
+	for i := 0; i < HistorySize+8; i++ {
+		if i == 4 {
+			sink.Detach(&b)
+		}
+
+		fmt.Fprintf(sink, "%d\n", i)
+	}
+
+	if n := strings.Count(a.String(), "\n"); n != HistorySize+8 {
+		t.Errorf("forwarded %d records, want %d", n, HistorySize+8)
+	}
+
+	if s := b.String(); s != "0\n1\n2\n3\n" {
+		t.Errorf("detached writer received %q", s)
+	}
+
+	for _, tc := range []struct {
+		n     int
+		first int
+		count int
+	}{
+		{0, 8, HistorySize},
+		{-1, 8, HistorySize},
+		{HistorySize + 1, 8, HistorySize},
+		{3, HistorySize + 5, 3},
+	} {
+		h := sink.History(tc.n)
+
+		if len(h) != tc.count {
+			t.Errorf("history(%d): %d records, want %d", tc.n, len(h), tc.count)
+			continue
+		}
+
+		if first := string(h[0]); first != fmt.Sprintf("%d\n", tc.first) {
+			t.Errorf("history(%d): first record %q, want %d", tc.n, first, tc.first)
+		}
+
+		// oldest first
+		if last := string(h[len(h)-1]); last != fmt.Sprintf("%d\n", HistorySize+7) {
+			t.Errorf("history(%d): last record %q", tc.n, last)
+		}
+	}
+
+	// records are retained as written
+	p := []byte("record\n")
+	sink.Write(p)
+	p[0] = 'R'
+
+	if h := sink.History(1); string(h[0]) != "record\n" {
+		t.Errorf("retained record %q", h[0])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		level slog.Level
+		ok    bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"info", slog.LevelInfo, true},
+		{"WARN", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"verbose", 0, false},
+	} {
+		level, err := ParseLevel(tc.s)
+
+		if (err == nil) != tc.ok || (tc.ok && level != tc.level) {
+			t.Errorf("%s: %v (%v), want %v", tc.s, level, err, tc.level)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package mailbox implements the shared memory mailbox between the Normal
+// World and the Trusted Applet, allowing bulk requests (e.g. packet batches,
+// quote requests) without a monitor call for each byte.
+//
+// The mailbox memory (see mem.MailboxStart) is split in two single producer,
+// single consumer, rings: the request ring, written by the Normal World and
+// read by the applet, and the response ring, written by the applet and read
+// by the Normal World. Each ring holds length-prefixed messages, the Normal
+// World rings the doorbell (see util.SYS_DOORBELL) once requests are posted
+// and the monitor wakes the applet to serve them.
+//
+// Ownership rules:
+//
+//   - the mailbox is initialized (see Mailbox.Reset) only by the Normal
+//     World, before its first doorbell;
+//   - the ring head index and the free area, between head and tail, are
+//     owned by the producer, which writes a message there and only then
+//     publishes it by advancing the head;
+//   - the ring tail index and the used area, between tail and head, are
+//     owned by the consumer, which copies a message out and only then
+//     releases it by advancing the tail;
+//   - the applet treats all mailbox contents, indices included, as
+//     untrusted and validates them before use.
+package mailbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// headerSize is the ring header size, head and tail indices are kept on
+	// separate cache lines as they are written by different parties.
+	headerSize = 128
+	headOffset = 0
+	tailOffset = 64
+
+	// lengthSize is the message length prefix size
+	lengthSize = 4
+
+	// MinSize is the minimum mailbox size.
+	MinSize = 2 * (headerSize + 4096)
+
+	// MaxMessageSize is the maximum message size.
+	MaxMessageSize = 64 * 1024
+)
+
+var (
+	// ErrEmpty is returned when no message is available.
+	ErrEmpty = errors.New("mailbox empty")
+	// ErrFull is returned when a message does not fit the ring free area.
+	ErrFull = errors.New("mailbox full")
+	// ErrCorrupt is returned when ring indices or message lengths are
+	// invalid.
+	ErrCorrupt = errors.New("mailbox corrupted")
+)
+
+// Ring represents a single producer, single consumer, ring of
+// length-prefixed messages.
+type Ring struct {
+	header []byte
+	data   []byte
+}
+
+// NewRing returns a ring over the argument memory, which must be 4 bytes
+// aligned.
+func NewRing(buf []byte) (r *Ring, err error) {
+	if len(buf) <= headerSize+lengthSize {
+		return nil, errors.New("invalid ring size")
+	}
+
+	if uintptr(unsafe.Pointer(&buf[0]))%4 != 0 {
+		return nil, errors.New("invalid ring alignment")
+	}
+
+	return &Ring{
+		header: buf[0:headerSize],
+		data:   buf[headerSize:],
+	}, nil
+}
+
+func (r *Ring) index(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.header[off]))
+}
+
+// indices returns the ring head and tail, or ErrCorrupt if they are out of
+// bounds.
+func (r *Ring) indices() (head int, tail int, err error) {
+	head = int(atomic.LoadUint32(r.index(headOffset)))
+	tail = int(atomic.LoadUint32(r.index(tailOffset)))
+
+	if head >= len(r.data) || tail >= len(r.data) {
+		err = ErrCorrupt
+	}
+
+	return
+}
+
+// used returns the number of bytes between tail and head.
+func (r *Ring) used(head int, tail int) int {
+	return (head - tail + len(r.data)) % len(r.data)
+}
+
+// read copies ring data starting at the argument offset, wrapping around the
+// ring end.
+func (r *Ring) read(off int, buf []byte) {
+	n := copy(buf, r.data[off:])
+	copy(buf[n:], r.data)
+}
+
+// write copies data to the ring starting at the argument offset, wrapping
+// around the ring end.
+func (r *Ring) write(off int, buf []byte) {
+	n := copy(r.data[off:], buf)
+	copy(r.data, buf[n:])
+}
+
+// Reset empties the ring, it must only be invoked when neither party is
+// accessing it.
+func (r *Ring) Reset() {
+	atomic.StoreUint32(r.index(headOffset), 0)
+	atomic.StoreUint32(r.index(tailOffset), 0)
+}
+
+// Free returns the number of message bytes which can be currently sent.
+func (r *Ring) Free() int {
+	head, tail, err := r.indices()
+
+	if err != nil {
+		return 0
+	}
+
+	// one byte is always left free to tell a full ring from an empty one
+	free := len(r.data) - r.used(head, tail) - 1 - lengthSize
+
+	return min(max(free, 0), MaxMessageSize)
+}
+
+// Send writes a message to the ring, it must only be invoked by the ring
+// producer.
+func (r *Ring) Send(msg []byte) (err error) {
+	if len(msg) > MaxMessageSize {
+		return fmt.Errorf("message too large (%d)", len(msg))
+	}
+
+	head, tail, err := r.indices()
+
+	if err != nil {
+		return
+	}
+
+	if len(r.data)-r.used(head, tail)-1 < lengthSize+len(msg) {
+		return ErrFull
+	}
+
+	var length [lengthSize]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(msg)))
+
+	r.write(head, length[:])
+	r.write((head+lengthSize)%len(r.data), msg)
+
+	// publish only after the message is written
+	head = (head + lengthSize + len(msg)) % len(r.data)
+	atomic.StoreUint32(r.index(headOffset), uint32(head))
+
+	return
+}
+
+// Receive returns a copy of the oldest message in the ring and releases it,
+// it must only be invoked by the ring consumer.
+func (r *Ring) Receive() (msg []byte, err error) {
+	var length [lengthSize]byte
+
+	head, tail, err := r.indices()
+
+	if err != nil {
+		return
+	}
+
+	used := r.used(head, tail)
+
+	if used == 0 {
+		return nil, ErrEmpty
+	}
+
+	if used < lengthSize {
+		return nil, ErrCorrupt
+	}
+
+	r.read(tail, length[:])
+	n := int(binary.LittleEndian.Uint32(length[:]))
+
+	if n > MaxMessageSize || n > used-lengthSize {
+		return nil, ErrCorrupt
+	}
+
+	msg = make([]byte, n)
+	r.read((tail+lengthSize)%len(r.data), msg)
+
+	// release only after the message is copied
+	tail = (tail + lengthSize + n) % len(r.data)
+	atomic.StoreUint32(r.index(tailOffset), uint32(tail))
+
+	return
+}
+
+// Mailbox represents the Normal World and Trusted Applet mailbox.
+type Mailbox struct {
+	// Requests is the ring written by the Normal World
+	Requests *Ring
+	// Responses is the ring written by the Trusted Applet
+	Responses *Ring
+}
+
+// New returns a mailbox over the argument memory, split in equally sized
+// request and response rings.
+func New(buf []byte) (m *Mailbox, err error) {
+	if len(buf) < MinSize {
+		return nil, errors.New("invalid mailbox size")
+	}
+
+	half := len(buf) / 2 &^ 3
+	m = &Mailbox{}
+
+	if m.Requests, err = NewRing(buf[0:half]); err != nil {
+		return nil, err
+	}
+
+	if m.Responses, err = NewRing(buf[half : 2*half]); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Reset empties both rings, it must only be invoked by the Normal World
+// before its first doorbell.
+func (m *Mailbox) Reset() {
+	m.Requests.Reset()
+	m.Responses.Reset()
+}
@@ -0,0 +1,163 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package mailbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testRing returns a ring with a data area of the argument size, along with
+// its memory.
+func testRing(t *testing.T, size int) (r *Ring, buf []byte) {
+	t.Helper()
+
+	buf = make([]byte, headerSize+size)
+	r, err := NewRing(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestRing(t *testing.T) {
+	r, _ := testRing(t, 64)
+
+	if _, err := r.Receive(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("empty ring: %v, want %v", err, ErrEmpty)
+	}
+
+	if free := r.Free(); free != 64-1-lengthSize {
+		t.Fatalf("free %d, want %d", free, 64-1-lengthSize)
+	}
+
+	// messages wrap around the ring end, across the length prefix and
+	// the payload
+	for i := 0; i < 32; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, 1+i%23)
+
+		if err := r.Send(msg); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if err := r.Send(nil); err != nil {
+			t.Fatalf("empty message %d: %v", i, err)
+		}
+
+		if res, err := r.Receive(); err != nil || !bytes.Equal(res, msg) {
+			t.Fatalf("message %d: %x (%v), want %x", i, res, err, msg)
+		}
+
+		if res, err := r.Receive(); err != nil || len(res) != 0 {
+			t.Fatalf("empty message %d: %x (%v)", i, res, err)
+		}
+	}
+
+	if err := r.Send(make([]byte, r.Free())); err != nil {
+		t.Fatalf("message filling the ring: %v", err)
+	}
+
+	if free := r.Free(); free != 0 {
+		t.Errorf("full ring free %d", free)
+	}
+
+	if err := r.Send(nil); !errors.Is(err, ErrFull) {
+		t.Errorf("full ring: %v, want %v", err, ErrFull)
+	}
+
+	if err := r.Send(make([]byte, MaxMessageSize+1)); err == nil {
+		t.Error("oversized message sent")
+	}
+}
+
+func TestRingCorrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(buf []byte)
+	}{
+		{"head out of bounds", func(buf []byte) {
+			binary.LittleEndian.PutUint32(buf[headOffset:], 64)
+		}},
+		{"tail out of bounds", func(buf []byte) {
+			binary.LittleEndian.PutUint32(buf[tailOffset:], 0xffffffff)
+		}},
+		{"truncated length", func(buf []byte) {
+			binary.LittleEndian.PutUint32(buf[headOffset:], 2)
+		}},
+		{"length exceeding used area", func(buf []byte) {
+			binary.LittleEndian.PutUint32(buf[headerSize:], 9)
+		}},
+		{"length exceeding message size", func(buf []byte) {
+			binary.LittleEndian.PutUint32(buf[headerSize:], MaxMessageSize+1)
+		}},
+	} {
+		r, buf := testRing(t, 64)
+
+		if err := r.Send(make([]byte, 8)); err != nil {
+			t.Fatal(err)
+		}
+
+		tc.corrupt(buf)
+
+		if _, err := r.Receive(); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: %v, want %v", tc.name, err, ErrCorrupt)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	m, err := New(make([]byte, MinSize))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Reset()
+
+	req := &Message{Type: Echo, Tag: 0x1234, Payload: []byte("ping")}
+
+	if err = Post(m.Requests, req); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Fetch(m.Requests)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Type != req.Type || res.Tag != req.Tag || res.Error || !bytes.Equal(res.Payload, req.Payload) {
+		t.Errorf("message %+v, want %+v", res, req)
+	}
+
+	if err = Post(m.Responses, &Message{Type: Quote, Error: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err = Fetch(m.Responses); err != nil || !res.Error {
+		t.Errorf("error response %+v (%v)", res, err)
+	}
+
+	if _, err = Fetch(m.Responses); !errors.Is(err, ErrEmpty) {
+		t.Errorf("empty ring: %v, want %v", err, ErrEmpty)
+	}
+
+	if err = Post(m.Requests, &Message{Payload: make([]byte, MaxMessageSize)}); err == nil {
+		t.Error("oversized payload posted")
+	}
+
+	if err = (&Message{}).UnmarshalBinary([]byte{Echo, 0, 0}); err == nil {
+		t.Error("truncated message decoded")
+	}
+
+	if _, err = New(make([]byte, MinSize-1)); err == nil {
+		t.Error("undersized mailbox")
+	}
+}
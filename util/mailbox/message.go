@@ -0,0 +1,100 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package mailbox
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Request types
+const (
+	// Echo requests the payload to be returned unmodified
+	Echo = 1 + iota
+	// Quote requests an applet quote, the payload is a challenge nonce
+	// and the response an attestation quote (see attest.ParseQuote)
+	Quote
+	// Batch requests a verdict on a batch of USB packets, the payload is
+	// an endorsement.Batch and the response an endorsement.Verdict
+	Batch
+)
+
+// messageHeaderSize is the size of type, flags and tag fields.
+const messageHeaderSize = 4
+
+// flagError is set in responses to failed requests.
+const flagError = 1 << 0
+
+// Message represents a mailbox request or response.
+type Message struct {
+	// Type is the request type
+	Type uint8
+	// Error is set in responses to failed requests, in which case the
+	// payload holds the error string
+	Error bool
+	// Tag is chosen by the Normal World and copied in the response, to
+	// match responses with requests
+	Tag uint16
+	// Payload is the type specific message payload
+	Payload []byte
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if len(m.Payload) > MaxMessageSize-messageHeaderSize {
+		return nil, errors.New("payload too large")
+	}
+
+	buf := make([]byte, messageHeaderSize, messageHeaderSize+len(m.Payload))
+	buf[0] = m.Type
+
+	if m.Error {
+		buf[1] |= flagError
+	}
+
+	binary.LittleEndian.PutUint16(buf[2:4], m.Tag)
+
+	return append(buf, m.Payload...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) < messageHeaderSize {
+		return errors.New("invalid message size")
+	}
+
+	m.Type = data[0]
+	m.Error = data[1]&flagError != 0
+	m.Tag = binary.LittleEndian.Uint16(data[2:4])
+	m.Payload = append([]byte(nil), data[messageHeaderSize:]...)
+
+	return nil
+}
+
+// Post sends a message to the argument ring.
+func Post(r *Ring, m *Message) error {
+	buf, err := m.MarshalBinary()
+
+	if err != nil {
+		return err
+	}
+
+	return r.Send(buf)
+}
+
+// Fetch receives a message from the argument ring.
+func Fetch(r *Ring) (m *Message, err error) {
+	buf, err := r.Receive()
+
+	if err != nil {
+		return
+	}
+
+	m = &Message{}
+	err = m.UnmarshalBinary(buf)
+
+	return
+}
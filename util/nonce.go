@@ -0,0 +1,176 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNonceUnknown = errors.New("unknown nonce")
+	ErrNonceReused  = errors.New("reused nonce")
+	ErrNonceExpired = errors.New("expired nonce")
+)
+
+// NonceStats represents nonce registry statistics.
+type NonceStats struct {
+	// Issued is the number of issued nonces
+	Issued uint64
+	// Consumed is the number of successfully consumed nonces
+	Consumed uint64
+	// Unknown is the number of rejected unknown nonces
+	Unknown uint64
+	// Reused is the number of rejected reused nonces
+	Reused uint64
+	// Expired is the number of rejected expired nonces
+	Expired uint64
+	// Evicted is the number of nonces discarded, before use, to honour
+	// the registry limit
+	Evicted uint64
+	// Outstanding is the number of nonces available for use
+	Outstanding int
+}
+
+type nonceEntry struct {
+	serial uint64
+	expiry time.Time
+	used   bool
+}
+
+// NonceRegistry tracks the lifecycle of nonces to prevent replay of
+// attestation requests, each nonce is valid for a single use within its
+// lifetime.
+//
+// Nonces can only be issued by the registry, each one is prefixed with a
+// monotonic serial number followed by random bytes. Consumed nonces are
+// retained until expiry to distinguish reuse attempts from unknown nonces,
+// afterwards they are retired along with expired and evicted ones. Retired
+// nonces are never accepted again, as any nonce with a serial number lower
+// than the next one to be issued and no longer tracked is rejected.
+type NonceRegistry struct {
+	sync.Mutex
+
+	// Lifetime is the nonce validity period
+	Lifetime time.Duration
+	// Limit is the maximum number of tracked nonces, the oldest ones are
+	// evicted when exceeded
+	Limit int
+
+	nonces map[[32]byte]*nonceEntry
+	serial uint64
+	stats  NonceStats
+}
+
+func (r *NonceRegistry) prune(now time.Time) {
+	var oldest [32]byte
+	var oldestEntry *nonceEntry
+
+	for n, e := range r.nonces {
+		if now.After(e.expiry) {
+			delete(r.nonces, n)
+		}
+	}
+
+	for r.Limit > 0 && len(r.nonces) >= r.Limit {
+		oldestEntry = nil
+
+		for n, e := range r.nonces {
+			if oldestEntry == nil || e.serial < oldestEntry.serial {
+				oldest = n
+				oldestEntry = e
+			}
+		}
+
+		if !oldestEntry.used {
+			r.stats.Evicted += 1
+		}
+
+		delete(r.nonces, oldest)
+	}
+}
+
+// Issue generates and records a fresh nonce.
+func (r *NonceRegistry) Issue() (nonce [32]byte, err error) {
+	if _, err = rand.Read(nonce[8:]); err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+
+	if r.nonces == nil {
+		r.nonces = make(map[[32]byte]*nonceEntry)
+	}
+
+	r.prune(now)
+
+	binary.BigEndian.PutUint64(nonce[0:8], r.serial)
+
+	r.nonces[nonce] = &nonceEntry{
+		serial: r.serial,
+		expiry: now.Add(r.Lifetime),
+	}
+
+	r.serial += 1
+
+	r.stats.Issued += 1
+
+	return
+}
+
+// Consume validates a nonce, which must have been previously issued and not
+// yet used, expired or retired, and marks it as used.
+func (r *NonceRegistry) Consume(nonce [32]byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	e, ok := r.nonces[nonce]
+
+	switch {
+	case !ok && binary.BigEndian.Uint64(nonce[0:8]) < r.serial:
+		// retired after use, expiry or eviction
+		r.stats.Expired += 1
+		return ErrNonceExpired
+	case !ok:
+		r.stats.Unknown += 1
+		return ErrNonceUnknown
+	case e.used:
+		r.stats.Reused += 1
+		return ErrNonceReused
+	case time.Now().After(e.expiry):
+		delete(r.nonces, nonce)
+		r.stats.Expired += 1
+		return ErrNonceExpired
+	}
+
+	e.used = true
+	r.stats.Consumed += 1
+
+	return nil
+}
+
+// Stats returns the registry statistics.
+func (r *NonceRegistry) Stats() (stats NonceStats) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	stats = r.stats
+
+	for _, e := range r.nonces {
+		if !e.used && !now.After(e.expiry) {
+			stats.Outstanding += 1
+		}
+	}
+
+	return
+}
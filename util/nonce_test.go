@@ -0,0 +1,104 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func issue(t *testing.T, r *NonceRegistry) [32]byte {
+	t.Helper()
+
+	n, err := r.Issue()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestNonceReplay(t *testing.T) {
+	r := &NonceRegistry{
+		Lifetime: time.Hour,
+		Limit:    4,
+	}
+
+	n := issue(t, r)
+
+	if err := r.Consume(n); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Consume(n); !errors.Is(err, ErrNonceReused) {
+		t.Errorf("consumed nonce: %v, want %v", err, ErrNonceReused)
+	}
+
+	// evict the consumed nonce, it remains rejected
+	for i := 0; i < 1024; i++ {
+		issue(t, r)
+	}
+
+	if err := r.Consume(n); !errors.Is(err, ErrNonceExpired) {
+		t.Errorf("retired nonce: %v, want %v", err, ErrNonceExpired)
+	}
+
+	if s := r.Stats(); s.Issued != 1025 || s.Consumed != 1 || s.Evicted != 1020 || s.Outstanding != 4 {
+		t.Errorf("stats %+v, want issued:1025 consumed:1 evicted:1020 outstanding:4", s)
+	}
+}
+
+func TestNonceExpiry(t *testing.T) {
+	r := &NonceRegistry{
+		Lifetime: time.Millisecond,
+	}
+
+	n := issue(t, r)
+
+	time.Sleep(2 * time.Millisecond)
+
+	if err := r.Consume(n); !errors.Is(err, ErrNonceExpired) {
+		t.Errorf("expired nonce: %v, want %v", err, ErrNonceExpired)
+	}
+
+	if err := r.Consume(n); !errors.Is(err, ErrNonceExpired) {
+		t.Errorf("retired expired nonce: %v, want %v", err, ErrNonceExpired)
+	}
+}
+
+func TestNonceUnknown(t *testing.T) {
+	r := &NonceRegistry{
+		Lifetime: time.Hour,
+	}
+
+	n := issue(t, r)
+
+	// not yet issued serial number
+	forged := n
+	forged[7] += 1
+
+	// issued serial number with different random bytes
+	tampered := n
+	tampered[31] ^= 0xff
+
+	if err := r.Consume(forged); !errors.Is(err, ErrNonceUnknown) {
+		t.Errorf("forged nonce: %v, want %v", err, ErrNonceUnknown)
+	}
+
+	if err := r.Consume(tampered); err == nil {
+		t.Error("tampered nonce accepted")
+	}
+
+	if err := r.Consume(n); err != nil {
+		t.Errorf("issued nonce: %v", err)
+	}
+
+	if m := issue(t, r); m[7] != n[7]+1 {
+		t.Errorf("serial %x after %x, want monotonic", m[:8], n[:8])
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package nsrpc implements JSON-RPC calls from the Normal World to the
+// Trusted OS over a single monitor call (see util.SYS_NS_RPC).
+//
+// Unlike the Trusted Applet RPC channel (see GoTEE syscall.Call), the Normal
+// World is untrusted: only allow-listed methods are served, each with an
+// argument size limit, and malformed requests are answered with an error
+// rather than interrupting the caller.
+//
+// The request is passed in a Normal World buffer which, on return, holds the
+// response.
+package nsrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+)
+
+// MaxRequestSize is the maximum size of a request, as well as the size of
+// the buffer exchanged with the Trusted OS, responses are truncated to it.
+const MaxRequestSize = 16 * 1024
+
+// request represents the fields of a JSON-RPC request inspected before
+// serving it.
+type request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// response represents a JSON-RPC error response.
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result any             `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// conn implements the io.ReadWriteCloser interface required by the JSON-RPC
+// server codec over a single request.
+type conn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+// Server represents a Normal World RPC server.
+type Server struct {
+	sync.Mutex
+
+	server  *rpc.Server
+	allowed map[string]int
+}
+
+// NewServer returns an empty Normal World RPC server.
+func NewServer() *Server {
+	return &Server{
+		server:  rpc.NewServer(),
+		allowed: make(map[string]int),
+	}
+}
+
+// Register publishes the receiver methods under the argument service name,
+// no method is served until allowed with Allow().
+func (s *Server) Register(name string, rcvr any) error {
+	return s.server.RegisterName(name, rcvr)
+}
+
+// Allow adds a method (e.g. NS.GetChallenge) to the allow list, along with
+// the maximum size of its JSON encoded arguments.
+func (s *Server) Allow(serviceMethod string, limit int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.allowed[serviceMethod] = limit
+}
+
+// Methods returns the allow-listed methods and their argument size limits.
+func (s *Server) Methods() map[string]int {
+	s.Lock()
+	defer s.Unlock()
+
+	methods := make(map[string]int, len(s.allowed))
+
+	for m, limit := range s.allowed {
+		methods[m] = limit
+	}
+
+	return methods
+}
+
+func errorResponse(id json.RawMessage, err error) []byte {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	buf, _ := json.Marshal(&response{ID: id, Error: err.Error()})
+
+	return buf
+}
+
+// Serve serves a JSON-RPC request and returns the JSON-RPC response.
+func (s *Server) Serve(buf []byte) (res []byte, method string, err error) {
+	var req request
+	var out bytes.Buffer
+
+	if len(buf) > MaxRequestSize {
+		err = errors.New("request too large")
+		return errorResponse(nil, err), "", err
+	}
+
+	// requests are NUL padded to the buffer size
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	if err = json.Unmarshal(buf, &req); err != nil {
+		err = errors.New("invalid request")
+		return errorResponse(nil, err), "", err
+	}
+
+	method = req.Method
+
+	s.Lock()
+	limit, ok := s.allowed[method]
+	s.Unlock()
+
+	switch {
+	case !ok:
+		err = fmt.Errorf("method %q not allowed", method)
+	case len(req.Params) > limit:
+		err = fmt.Errorf("arguments too large (%d > %d)", len(req.Params), limit)
+	}
+
+	if err != nil {
+		return errorResponse(req.ID, err), method, err
+	}
+
+	codec := jsonrpc.NewServerCodec(&conn{
+		Reader: bytes.NewReader(buf),
+		Writer: &out,
+	})
+
+	if err = s.server.ServeRequest(codec); err != nil {
+		return errorResponse(req.ID, err), method, err
+	}
+
+	return out.Bytes(), method, nil
+}
+
+// stream implements the io.ReadWriteCloser interface required by the
+// JSON-RPC client codec, the write issues the monitor call and buffers its
+// response for reading, only one request is supported.
+type stream struct {
+	call  func(buf []byte) int
+	res   []byte
+	ready chan struct{}
+}
+
+func (s *stream) Read(p []byte) (n int, err error) {
+	<-s.ready
+
+	if len(s.res) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, s.res)
+	s.res = s.res[n:]
+
+	return
+}
+
+func (s *stream) Write(p []byte) (n int, err error) {
+	select {
+	case <-s.ready:
+		return 0, errors.New("request already issued")
+	default:
+	}
+
+	defer close(s.ready)
+
+	if len(p) > MaxRequestSize {
+		return 0, errors.New("request too large")
+	}
+
+	buf := make([]byte, MaxRequestSize)
+	copy(buf, p)
+
+	if r := s.call(buf); r > 0 && r <= len(buf) {
+		s.res = buf[:r]
+	}
+
+	return len(p), nil
+}
+
+func (s *stream) Close() error {
+	return nil
+}
+
+var mux sync.Mutex
+
+// Call issues an RPC call through the argument monitor call function, which
+// must pass its buffer to the Trusted OS and return the response size.
+func Call(call func(buf []byte) int, serviceMethod string, args any, reply any) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	client := jsonrpc.NewClient(&stream{
+		call:  call,
+		ready: make(chan struct{}),
+	})
+	defer client.Close()
+
+	return client.Call(serviceMethod, args, reply)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package nsrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+type Test struct{}
+
+func (Test) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func (Test) Fail(args string, reply *string) error {
+	return errors.New("failed")
+}
+
+func (Test) Hidden(args string, reply *string) error {
+	*reply = "hidden"
+	return nil
+}
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+
+	s := NewServer()
+
+	if err := s.Register("Test", Test{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Allow("Test.Echo", 16)
+	s.Allow("Test.Fail", 16)
+
+	return s
+}
+
+// monitor returns a monitor call function serving requests with the
+// argument server.
+func monitor(s *Server) func(buf []byte) int {
+	return func(buf []byte) int {
+		res, _, _ := s.Serve(buf)
+		return copy(buf, res)
+	}
+}
+
+func TestCall(t *testing.T) {
+	var reply string
+
+	s := testServer(t)
+
+	if err := Call(monitor(s), "Test.Echo", "ping", &reply); err != nil || reply != "ping" {
+		t.Fatalf("reply %q (%v), want ping", reply, err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		args   string
+		err    string
+	}{
+		{"not allowed", "Test.Hidden", "", "not allowed"},
+		{"unknown", "Test.Unknown", "", "not allowed"},
+		{"arguments too large", "Test.Echo", strings.Repeat("a", 16), "arguments too large"},
+		{"method error", "Test.Fail", "", "failed"},
+	} {
+		reply = ""
+		err := Call(monitor(s), tc.method, tc.args, &reply)
+
+		var serverErr rpc.ServerError
+
+		if !errors.As(err, &serverErr) || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: %v, want server error %q", tc.name, err, tc.err)
+		}
+
+		if reply != "" {
+			t.Errorf("%s: reply %q", tc.name, reply)
+		}
+	}
+
+	if err := Call(monitor(s), "Test.Echo", strings.Repeat("a", MaxRequestSize), &reply); err == nil {
+		t.Error("oversized request issued")
+	}
+
+	// missing or oversized monitor call responses
+	for _, size := range []int{0, -1, MaxRequestSize + 1} {
+		call := func(buf []byte) int { return size }
+
+		if err := Call(call, "Test.Echo", "ping", &reply); err == nil {
+			t.Errorf("response size %d: no error", size)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := testServer(t)
+
+	for _, tc := range []struct {
+		name string
+		req  []byte
+		err  string
+	}{
+		{"valid", []byte(`{"method":"Test.Echo","params":["ping"],"id":1}`), ""},
+		{"NUL padded", append([]byte(`{"method":"Test.Echo","params":["ping"],"id":1}`), make([]byte, 64)...), ""},
+		{"invalid JSON", []byte(`{"method":`), "invalid request"},
+		{"empty", make([]byte, 64), "invalid request"},
+		{"too large", bytes.Repeat([]byte(" "), MaxRequestSize+1), "request too large"},
+		{"not allowed", []byte(`{"method":"Test.Hidden","params":[""],"id":2}`), "not allowed"},
+		{"invalid arguments", []byte(`{"method":"Test.Echo","params":[1],"id":3}`), "cannot unmarshal"},
+	} {
+		var res response
+
+		buf, _, err := s.Serve(tc.req)
+
+		if jsonErr := json.Unmarshal(buf, &res); jsonErr != nil {
+			t.Errorf("%s: invalid response %q", tc.name, buf)
+			continue
+		}
+
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(res.Error, tc.err)):
+			t.Errorf("%s: %v (%q), want %q", tc.name, err, res.Error, tc.err)
+		}
+	}
+}
@@ -18,6 +18,8 @@ import (
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
+
+	"github.com/usbarmory/GoTEE-example/util/logging"
 )
 
 // Console represents an SSH console instance.
@@ -54,6 +56,13 @@ func (c *Console) handleChannel(newChannel ssh.NewChannel) {
 		log.SetOutput(io.MultiWriter(logWriter, c.Term))
 		defer log.SetOutput(logWriter)
 
+		// attach to execution context log streams
+		Logs.Attach(c.Term)
+		defer Logs.Detach(c.Term)
+
+		// stop event forwarding, if enabled with `events on`
+		defer logging.JSON.Detach(c.Term)
+
 		c.Handler(c.Term)
 
 		log.Printf("closing ssh connection")
@@ -142,13 +151,13 @@ func (c *Console) Start() (err error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 
 	if err != nil {
-		return fmt.Errorf("private key generation error: ", err)
+		return fmt.Errorf("private key generation error: %v", err)
 	}
 
 	signer, err := ssh.NewSignerFromKey(key)
 
 	if err != nil {
-		return fmt.Errorf("key conversion error: ", err)
+		return fmt.Errorf("key conversion error: %v", err)
 	}
 
 	log.Printf("starting ssh server (%s)", ssh.FingerprintSHA256(signer.PublicKey()))
@@ -167,7 +176,12 @@ func NewScreenConsole() *Console {
 		io.Writer
 	}{os.Stdin, os.Stdout}
 
-	return &Console{
+	c := &Console{
 		Term: term.NewTerminal(screen, ""),
 	}
+
+	// attach to execution context log streams
+	Logs.Attach(c.Term)
+
+	return c
 }
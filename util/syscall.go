@@ -0,0 +1,46 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package util
+
+// GoTEE example monitor calls, numbered apart from the GoTEE ones (see
+// github.com/usbarmory/GoTEE/syscall) to avoid conflicts.
+const (
+	// SYS_ATTEST requests Trusted Applet attestation from the Normal
+	// World (see HandshakeRequest, HandshakeResponse).
+	SYS_ATTEST = 0x100 + iota
+
+	// SYS_ENDORSEMENT requests a verdict on a batch of USB packets from
+	// the Normal World (see endorsement.Batch, endorsement.Verdict).
+	SYS_ENDORSEMENT
+
+	// SYS_REENDORSE raises a re-endorsement request, for the device of a
+	// batch of blocked USB packets, from the Normal World (see
+	// endorsement.Batch).
+	SYS_REENDORSE
+
+	// SYS_FLAG reports suspicious activity of a USB device, requesting
+	// the downgrade or revocation of its endorsement, from the Normal
+	// World (see endorsement.Flag).
+	SYS_FLAG
+
+	// SYS_DOORBELL notifies the Trusted Applet of requests posted to the
+	// mailbox, from the Normal World, and returns the number of requests
+	// served (see mailbox.Mailbox).
+	SYS_DOORBELL
+
+	// SYS_NS_RPC serves a JSON-RPC request from the Normal World, the
+	// response overwrites the request buffer (see nsrpc.Server).
+	SYS_NS_RPC
+
+	// SYS_WRITE_BUF writes a buffer, within the caller memory, to the
+	// monitor console and returns the number of bytes written, at most
+	// MaxWriteSize, from the Normal World or Trusted Applet.
+	SYS_WRITE_BUF
+)
+
+// MaxWriteSize is the maximum number of bytes written by each SYS_WRITE_BUF
+// monitor call.
+const MaxWriteSize = 4096
@@ -0,0 +1,323 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// HID input event types, modeled after the Linux input subsystem ones.
+const (
+	// EV_KEY is a key or button state change, the event value is 1
+	// (down) or 0 (up)
+	EV_KEY = iota
+	// EV_REL is a relative axis motion
+	EV_REL
+	// EV_ABS is an absolute value change
+	EV_ABS
+	// EV_VENDOR is vendor defined data, held in the event Data
+	EV_VENDOR
+)
+
+// Event represents a typed usage decoded from a HID input report.
+type Event struct {
+	// Type is the event type (EV_KEY, EV_REL, EV_ABS or EV_VENDOR)
+	Type int
+	// ReportID is the input report ID, 0 if report IDs are not used
+	ReportID uint8
+	// Usage is the event usage
+	Usage Usage
+	// Value is the event value
+	Value int32
+	// Data holds the field data of vendor defined events
+	Data []byte
+}
+
+// String returns the event in readable format (e.g. "KEY_A down",
+// "REL_X -3").
+func (e Event) String() string {
+	switch e.Type {
+	case EV_KEY:
+		if e.Value != 0 {
+			return e.Usage.Name() + " down"
+		}
+
+		return e.Usage.Name() + " up"
+	case EV_REL:
+		return fmt.Sprintf("REL_%s %d", e.Usage.Name(), e.Value)
+	case EV_ABS:
+		return fmt.Sprintf("ABS_%s %d", e.Usage.Name(), e.Value)
+	default:
+		return fmt.Sprintf("VENDOR %s %x", e.Usage, e.Data)
+	}
+}
+
+// Events returns events in readable format, separated by commas.
+func Events(events []Event) string {
+	var s []string
+
+	for _, e := range events {
+		s = append(s, e.String())
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// fieldState holds the state of a report field, as of its last report.
+type fieldState struct {
+	// keys holds the pressed keys and buttons
+	keys []Usage
+	// values holds the absolute values by element index
+	values map[int]int32
+}
+
+// Decoder represents a stateful HID input report decoder, reports are
+// interpreted according to the report descriptor and compared against the
+// previous report of the same ID to yield key and absolute value changes.
+type Decoder struct {
+	// Report is the report descriptor
+	Report *ReportDescriptor
+
+	numbered bool
+	state    map[*Field]*fieldState
+}
+
+// NewDecoder returns a decoder for the input reports of a report descriptor.
+func NewDecoder(r *ReportDescriptor) *Decoder {
+	d := &Decoder{
+		Report: r,
+		state:  make(map[*Field]*fieldState),
+	}
+
+	for _, f := range r.Fields {
+		if f.Type == INPUT && f.ReportID != 0 {
+			d.numbered = true
+		}
+	}
+
+	return d
+}
+
+// Reset clears the state of all fields, as for a device reconnection.
+func (d *Decoder) Reset() {
+	clear(d.state)
+}
+
+// bits returns the little endian value of a bit range.
+func bits(data []byte, off uint32, size uint32) (v uint32) {
+	for i := uint32(0); i < size; i++ {
+		bit := off + i
+
+		if data[bit/8]&(1<<(bit%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+
+	return
+}
+
+// value returns the i-th element of a field, sign extended when the logical
+// range is signed.
+func (f *Field) value(data []byte, i int) int32 {
+	v := bits(data, f.Offset+uint32(i)*f.ReportSize, f.ReportSize)
+
+	if f.LogicalMinimum < 0 && f.ReportSize < 32 && v&(1<<(f.ReportSize-1)) != 0 {
+		v |= ^uint32(0) << f.ReportSize
+	}
+
+	return int32(v)
+}
+
+// vendor returns whether a field holds vendor defined data.
+func (f *Field) vendor() bool {
+	if f.Application.Vendor() {
+		return true
+	}
+
+	u, ok := f.Usage(0)
+
+	return ok && u.Vendor()
+}
+
+// key returns whether the elements of a variable field represent keys or
+// buttons rather than values.
+func (f *Field) key() bool {
+	return f.ReportSize == 1 || (f.LogicalMinimum == 0 && f.LogicalMaximum == 1)
+}
+
+// Decode returns the events for an input report, the report must include
+// its report ID prefix when report IDs are in use.
+func (d *Decoder) Decode(buf []byte) (events []Event, err error) {
+	var id uint8
+
+	if d.numbered {
+		if len(buf) == 0 {
+			return nil, errors.New("missing report ID")
+		}
+
+		id = buf[0]
+		buf = buf[1:]
+	}
+
+	fields := d.Report.Report(INPUT, id)
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("unknown input report ID %d", id)
+	}
+
+	if len(buf) < d.Report.ReportSize(INPUT, id) {
+		return nil, fmt.Errorf("invalid input report size %d", len(buf))
+	}
+
+	for _, f := range fields {
+		if f.Constant() || f.ReportSize == 0 {
+			continue
+		}
+
+		var ev []Event
+
+		switch {
+		case f.vendor():
+			ev = d.vendor(f, buf)
+		case f.Variable():
+			ev = d.variable(f, buf)
+		default:
+			ev = d.array(f, buf)
+		}
+
+		for i := range ev {
+			ev[i].ReportID = id
+		}
+
+		events = append(events, ev...)
+	}
+
+	return
+}
+
+func (d *Decoder) fieldState(f *Field) *fieldState {
+	s, ok := d.state[f]
+
+	if !ok {
+		s = &fieldState{
+			values: make(map[int]int32),
+		}
+
+		d.state[f] = s
+	}
+
+	return s
+}
+
+// keys returns the key events for the transition between two sets of
+// pressed keys.
+func keys(prev []Usage, cur []Usage) (events []Event) {
+	for _, u := range prev {
+		if !slices.Contains(cur, u) {
+			events = append(events, Event{Type: EV_KEY, Usage: u, Value: 0})
+		}
+	}
+
+	for _, u := range cur {
+		if !slices.Contains(prev, u) {
+			events = append(events, Event{Type: EV_KEY, Usage: u, Value: 1})
+		}
+	}
+
+	return
+}
+
+func (d *Decoder) vendor(f *Field, buf []byte) []Event {
+	u, ok := f.Usage(0)
+
+	if !ok {
+		u = f.Application
+	}
+
+	data := make([]byte, (f.Size()+7)/8)
+
+	for i := range data {
+		data[i] = uint8(bits(buf, f.Offset+uint32(i)*8, min(8, f.Size()-uint32(i)*8)))
+	}
+
+	return []Event{{Type: EV_VENDOR, Usage: u, Data: data}}
+}
+
+func (d *Decoder) variable(f *Field, buf []byte) (events []Event) {
+	var pressed []Usage
+
+	s := d.fieldState(f)
+
+	for i := 0; i < int(f.ReportCount); i++ {
+		u, ok := f.Usage(i)
+
+		if !ok {
+			continue
+		}
+
+		v := f.value(buf, i)
+
+		switch {
+		case f.Relative():
+			if v != 0 {
+				events = append(events, Event{Type: EV_REL, Usage: u, Value: v})
+			}
+		case f.key():
+			if v != 0 {
+				pressed = append(pressed, u)
+			}
+		default:
+			if prev, ok := s.values[i]; !ok || prev != v {
+				events = append(events, Event{Type: EV_ABS, Usage: u, Value: v})
+			}
+
+			s.values[i] = v
+		}
+	}
+
+	if f.key() && !f.Relative() {
+		events = append(events, keys(s.keys, pressed)...)
+		s.keys = pressed
+	}
+
+	return
+}
+
+func (d *Decoder) array(f *Field, buf []byte) (events []Event) {
+	var pressed []Usage
+
+	s := d.fieldState(f)
+
+	for i := 0; i < int(f.ReportCount); i++ {
+		v := f.value(buf, i)
+
+		// out of range values signal that no usage is selected
+		if v < f.LogicalMinimum || v > f.LogicalMaximum {
+			continue
+		}
+
+		u, ok := f.Usage(int(v - f.LogicalMinimum))
+
+		if !ok || u.ID() == 0 || slices.Contains(pressed, u) {
+			continue
+		}
+
+		// the key state is unknown when too many keys are pressed
+		if u == NewUsage(KEYBOARD_KEYPAD, KEY_ERR_ROLLOVER) {
+			return
+		}
+
+		pressed = append(pressed, u)
+	}
+
+	events = keys(s.keys, pressed)
+	s.keys = pressed
+
+	return
+}
@@ -0,0 +1,166 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Report descriptors of a boot keyboard and mouse (see USBIP/descriptors).
+const (
+	keyboardReport = "05010906a101050719e029e71500250175019508810295017508810195057501" +
+		"050819012905910295017503910195067508150025650507190029658100c0"
+	mouseReport = "05010902a1010901a100050919012903150025019503750181029501750581" +
+		"010501093009311581257f750895028106c0c0"
+	// 12-bit signed absolute X axis, padded to 16 bits
+	absoluteReport = "05010904a10109301600f826ff07750c95018102750495018103c0"
+	// 32-bit signed absolute X axis
+	absolute32Report = "05010904a1010930170000008027ffffff7f752095018102c0"
+	// relative X axis in report ID 1
+	numberedReport = "05010902a101850109301581257f750895018106c0"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	buf, err := hex.DecodeString(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func newTestDecoder(t *testing.T, report string) *Decoder {
+	t.Helper()
+
+	r, err := ParseReport(decodeHex(t, report))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDecoder(r)
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		report  string
+		reports []string
+		events  []string
+	}{
+		{
+			"array rollover", keyboardReport,
+			[]string{
+				"0200040000000000",
+				"0000040500000000",
+				"0000010101010101",
+				"0000000000000000",
+			},
+			[]string{
+				"KEY_LEFTSHIFT down, KEY_A down",
+				"KEY_LEFTSHIFT up, KEY_B down",
+				// key state unknown, previous state retained
+				"",
+				"KEY_A up, KEY_B up",
+			},
+		},
+		{
+			"relative sign extension", mouseReport,
+			[]string{
+				"01ff02",
+				"008100",
+				"000000",
+			},
+			[]string{
+				"BTN_LEFT down, REL_X -1, REL_Y 2",
+				"BTN_LEFT up, REL_X -127",
+				"",
+			},
+		},
+		{
+			"absolute sign extension", absoluteReport,
+			[]string{
+				"ff0f",
+				"ff0f",
+				"ff07",
+				"0008",
+			},
+			[]string{
+				"ABS_X -1",
+				// unchanged absolute values yield no event
+				"",
+				"ABS_X 2047",
+				"ABS_X -2048",
+			},
+		},
+		{
+			"absolute 32-bit", absolute32Report,
+			[]string{
+				"ffffffff",
+				"ffffff7f",
+			},
+			[]string{
+				"ABS_X -1",
+				"ABS_X 2147483647",
+			},
+		},
+		{
+			"report ID", numberedReport,
+			[]string{
+				"01fe",
+			},
+			[]string{
+				"REL_X -2",
+			},
+		},
+	} {
+		d := newTestDecoder(t, tc.report)
+
+		for i, report := range tc.reports {
+			ev, err := d.Decode(decodeHex(t, report))
+
+			if err != nil {
+				t.Errorf("%s: report %d: %v", tc.name, i, err)
+				continue
+			}
+
+			if s := Events(ev); s != tc.events[i] {
+				t.Errorf("%s: report %d: %q, want %q", tc.name, i, s, tc.events[i])
+			}
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		report string
+		data   string
+	}{
+		{"short report", keyboardReport, "00000400000000"},
+		{"short relative report", mouseReport, "01ff"},
+		{"missing report ID", numberedReport, ""},
+		{"unknown report ID", numberedReport, "02fe"},
+		{"short numbered report", numberedReport, "01"},
+	} {
+		d := newTestDecoder(t, tc.report)
+
+		if _, err := d.Decode(decodeHex(t, tc.data)); err == nil {
+			t.Errorf("%s: decoded", tc.name)
+		}
+	}
+}
+
+func TestParseReportSize(t *testing.T) {
+	// 33-bit field
+	if _, err := ParseReport(decodeHex(t, "05010904a1010930752195018102c0")); err == nil {
+		t.Error("report size > 32 accepted")
+	}
+}
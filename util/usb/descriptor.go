@@ -0,0 +1,382 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package usb implements parsing of USB descriptors, including HID report
+// descriptors, as received by a host from an untrusted device, along with a
+// rule language to evaluate device policies over them (see Policy) and the
+// decoding of HID input reports into typed usages (see Decoder).
+//
+// The package is pure Go and suitable for use on both the Normal World and
+// host side tools.
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// USB descriptor types (p279, Table 9-5, USB2.0)
+const (
+	DEVICE        = 1
+	CONFIGURATION = 2
+	STRING        = 3
+	INTERFACE     = 4
+	ENDPOINT      = 5
+)
+
+// HID class descriptor types (p49, 7.1, HID1.11)
+const (
+	HID    = 0x21
+	REPORT = 0x22
+)
+
+// USB descriptor lengths
+const (
+	DEVICE_LENGTH        = 18
+	CONFIGURATION_LENGTH = 9
+	INTERFACE_LENGTH     = 9
+	ENDPOINT_LENGTH      = 7
+	HID_LENGTH           = 9
+)
+
+// Endpoint directions
+const (
+	OUT = 0
+	IN  = 1
+)
+
+// Endpoint transfer types
+const (
+	CONTROL     = 0
+	ISOCHRONOUS = 1
+	BULK        = 2
+	INTERRUPT   = 3
+)
+
+// USB interface classes (https://www.usb.org/defined-class-codes)
+const (
+	AUDIO_CLASS        = 0x01
+	CDC_CLASS          = 0x02
+	HID_CLASS          = 0x03
+	MASS_STORAGE_CLASS = 0x08
+	VENDOR_CLASS       = 0xff
+)
+
+// DeviceDescriptor implements p290, Table 9-8. Standard Device Descriptor,
+// USB2.0.
+type DeviceDescriptor struct {
+	Length            uint8
+	DescriptorType    uint8
+	BcdUSB            uint16
+	DeviceClass       uint8
+	DeviceSubClass    uint8
+	DeviceProtocol    uint8
+	MaxPacketSize     uint8
+	VendorId          uint16
+	ProductId         uint16
+	Device            uint16
+	Manufacturer      uint8
+	Product           uint8
+	SerialNumber      uint8
+	NumConfigurations uint8
+}
+
+// UnmarshalBinary parses a device descriptor.
+func (d *DeviceDescriptor) UnmarshalBinary(buf []byte) (err error) {
+	if len(buf) < DEVICE_LENGTH || buf[0] < DEVICE_LENGTH {
+		return errors.New("invalid device descriptor size")
+	}
+
+	if buf[1] != DEVICE {
+		return errors.New("invalid device descriptor type")
+	}
+
+	d.Length = buf[0]
+	d.DescriptorType = buf[1]
+	d.BcdUSB = binary.LittleEndian.Uint16(buf[2:])
+	d.DeviceClass = buf[4]
+	d.DeviceSubClass = buf[5]
+	d.DeviceProtocol = buf[6]
+	d.MaxPacketSize = buf[7]
+	d.VendorId = binary.LittleEndian.Uint16(buf[8:])
+	d.ProductId = binary.LittleEndian.Uint16(buf[10:])
+	d.Device = binary.LittleEndian.Uint16(buf[12:])
+	d.Manufacturer = buf[14]
+	d.Product = buf[15]
+	d.SerialNumber = buf[16]
+	d.NumConfigurations = buf[17]
+
+	return
+}
+
+// ConfigurationDescriptor implements p293, Table 9-10. Standard Configuration
+// Descriptor, USB2.0.
+type ConfigurationDescriptor struct {
+	Length             uint8
+	DescriptorType     uint8
+	TotalLength        uint16
+	NumInterfaces      uint8
+	ConfigurationValue uint8
+	Configuration      uint8
+	Attributes         uint8
+	MaxPower           uint8
+
+	Interfaces []*InterfaceDescriptor
+}
+
+// InterfaceDescriptor implements p296, Table 9-12. Standard Interface
+// Descriptor, USB2.0.
+type InterfaceDescriptor struct {
+	Length            uint8
+	DescriptorType    uint8
+	InterfaceNumber   uint8
+	AlternateSetting  uint8
+	NumEndpoints      uint8
+	InterfaceClass    uint8
+	InterfaceSubClass uint8
+	InterfaceProtocol uint8
+	Interface         uint8
+
+	// HID is the HID class descriptor, if any
+	HID       *HIDDescriptor
+	Endpoints []*EndpointDescriptor
+}
+
+// UnmarshalBinary parses an interface descriptor.
+func (d *InterfaceDescriptor) UnmarshalBinary(buf []byte) (err error) {
+	if len(buf) < INTERFACE_LENGTH || buf[0] < INTERFACE_LENGTH {
+		return errors.New("invalid interface descriptor size")
+	}
+
+	if buf[1] != INTERFACE {
+		return errors.New("invalid interface descriptor type")
+	}
+
+	d.Length = buf[0]
+	d.DescriptorType = buf[1]
+	d.InterfaceNumber = buf[2]
+	d.AlternateSetting = buf[3]
+	d.NumEndpoints = buf[4]
+	d.InterfaceClass = buf[5]
+	d.InterfaceSubClass = buf[6]
+	d.InterfaceProtocol = buf[7]
+	d.Interface = buf[8]
+
+	return
+}
+
+// EndpointDescriptor implements p297, Table 9-13. Standard Endpoint
+// Descriptor, USB2.0.
+type EndpointDescriptor struct {
+	Length          uint8
+	DescriptorType  uint8
+	EndpointAddress uint8
+	Attributes      uint8
+	MaxPacketSize   uint16
+	Interval        uint8
+}
+
+// UnmarshalBinary parses an endpoint descriptor.
+func (d *EndpointDescriptor) UnmarshalBinary(buf []byte) (err error) {
+	if len(buf) < ENDPOINT_LENGTH || buf[0] < ENDPOINT_LENGTH {
+		return errors.New("invalid endpoint descriptor size")
+	}
+
+	if buf[1] != ENDPOINT {
+		return errors.New("invalid endpoint descriptor type")
+	}
+
+	d.Length = buf[0]
+	d.DescriptorType = buf[1]
+	d.EndpointAddress = buf[2]
+	d.Attributes = buf[3]
+	d.MaxPacketSize = binary.LittleEndian.Uint16(buf[4:])
+	d.Interval = buf[6]
+
+	return
+}
+
+// Number returns the endpoint number.
+func (d *EndpointDescriptor) Number() int {
+	return int(d.EndpointAddress & 0b1111)
+}
+
+// Direction returns the endpoint direction (IN or OUT).
+func (d *EndpointDescriptor) Direction() int {
+	return int(d.EndpointAddress >> 7)
+}
+
+// TransferType returns the endpoint transfer type.
+func (d *EndpointDescriptor) TransferType() int {
+	return int(d.Attributes & 0b11)
+}
+
+// HIDDescriptor implements p22, 6.2.1 HID Descriptor, HID1.11.
+type HIDDescriptor struct {
+	Length         uint8
+	DescriptorType uint8
+	BcdHID         uint16
+	CountryCode    uint8
+	NumDescriptors uint8
+	// ReportLength is the size of the report descriptor
+	ReportLength uint16
+
+	// Report is the parsed report descriptor, if retrieved (see
+	// Device.SetReport())
+	Report *ReportDescriptor
+}
+
+// UnmarshalBinary parses a HID class descriptor.
+func (d *HIDDescriptor) UnmarshalBinary(buf []byte) (err error) {
+	if len(buf) < HID_LENGTH || buf[0] < HID_LENGTH {
+		return errors.New("invalid HID descriptor size")
+	}
+
+	if buf[1] != HID {
+		return errors.New("invalid HID descriptor type")
+	}
+
+	d.Length = buf[0]
+	d.DescriptorType = buf[1]
+	d.BcdHID = binary.LittleEndian.Uint16(buf[2:])
+	d.CountryCode = buf[4]
+	d.NumDescriptors = buf[5]
+
+	if d.NumDescriptors == 0 || int(d.Length) < 6+int(d.NumDescriptors)*3 {
+		return errors.New("invalid HID descriptor")
+	}
+
+	// look for the report descriptor among class descriptors
+	for i := 0; i < int(d.NumDescriptors); i++ {
+		off := 6 + i*3
+
+		if buf[off] == REPORT {
+			d.ReportLength = binary.LittleEndian.Uint16(buf[off+1:])
+			return
+		}
+	}
+
+	return errors.New("missing HID report descriptor")
+}
+
+// next returns the next descriptor within a buffer and the remaining data.
+func next(buf []byte) (desc []byte, rest []byte, err error) {
+	if len(buf) < 2 {
+		return nil, nil, errors.New("truncated descriptor")
+	}
+
+	n := int(buf[0])
+
+	if n < 2 || n > len(buf) {
+		return nil, nil, fmt.Errorf("invalid descriptor length %d", n)
+	}
+
+	return buf[:n], buf[n:], nil
+}
+
+// ParseConfiguration parses a configuration descriptor along with all its
+// subordinate descriptors, as returned by a GET_DESCRIPTOR request for its
+// total length.
+//
+// Endpoint and HID class descriptors are attached to the interface descriptor
+// preceding them, any other descriptor is skipped.
+func ParseConfiguration(buf []byte) (d *ConfigurationDescriptor, err error) {
+	if len(buf) < CONFIGURATION_LENGTH || buf[0] < CONFIGURATION_LENGTH {
+		return nil, errors.New("invalid configuration descriptor size")
+	}
+
+	if buf[1] != CONFIGURATION {
+		return nil, errors.New("invalid configuration descriptor type")
+	}
+
+	d = &ConfigurationDescriptor{
+		Length:             buf[0],
+		DescriptorType:     buf[1],
+		TotalLength:        binary.LittleEndian.Uint16(buf[2:]),
+		NumInterfaces:      buf[4],
+		ConfigurationValue: buf[5],
+		Configuration:      buf[6],
+		Attributes:         buf[7],
+		MaxPower:           buf[8],
+	}
+
+	if int(d.TotalLength) > len(buf) {
+		return nil, errors.New("truncated configuration descriptor")
+	}
+
+	buf = buf[d.Length:d.TotalLength]
+
+	var iface *InterfaceDescriptor
+
+	for len(buf) > 0 {
+		var desc []byte
+
+		if desc, buf, err = next(buf); err != nil {
+			return nil, err
+		}
+
+		switch desc[1] {
+		case INTERFACE:
+			iface = &InterfaceDescriptor{}
+
+			if err = iface.UnmarshalBinary(desc); err != nil {
+				return nil, err
+			}
+
+			d.Interfaces = append(d.Interfaces, iface)
+		case ENDPOINT:
+			if iface == nil {
+				return nil, errors.New("endpoint descriptor outside interface")
+			}
+
+			ep := &EndpointDescriptor{}
+
+			if err = ep.UnmarshalBinary(desc); err != nil {
+				return nil, err
+			}
+
+			iface.Endpoints = append(iface.Endpoints, ep)
+		case HID:
+			if iface == nil || iface.InterfaceClass != HID_CLASS {
+				continue
+			}
+
+			if iface.HID != nil {
+				return nil, errors.New("duplicate HID descriptor")
+			}
+
+			iface.HID = &HIDDescriptor{}
+
+			if err = iface.HID.UnmarshalBinary(desc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return
+}
+
+// ParseString parses a string descriptor (p273, 9.6.7 String, USB2.0).
+func ParseString(buf []byte) (s string, err error) {
+	desc, _, err := next(buf)
+
+	if err != nil {
+		return
+	}
+
+	if desc[1] != STRING || len(desc)%2 != 0 {
+		return "", errors.New("invalid string descriptor")
+	}
+
+	u := make([]uint16, (len(desc)-2)/2)
+
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(desc[2+i*2:])
+	}
+
+	return string(utf16.Decode(u)), nil
+}
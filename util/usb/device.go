@@ -0,0 +1,77 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Device represents the descriptors presented by a USB device.
+type Device struct {
+	Descriptor    *DeviceDescriptor
+	Configuration *ConfigurationDescriptor
+
+	// Serial is the serial number string, if any
+	Serial string
+}
+
+// ParseDevice parses the device descriptor and the active configuration
+// descriptor, along with all its subordinate descriptors, of a device.
+func ParseDevice(device []byte, config []byte) (d *Device, err error) {
+	d = &Device{
+		Descriptor: &DeviceDescriptor{},
+	}
+
+	if err = d.Descriptor.UnmarshalBinary(device); err != nil {
+		return nil, err
+	}
+
+	if d.Configuration, err = ParseConfiguration(config); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Interfaces returns all interface descriptors, of any alternate setting, of
+// the active configuration.
+func (d *Device) Interfaces() []*InterfaceDescriptor {
+	if d.Configuration == nil {
+		return nil
+	}
+
+	return d.Configuration.Interfaces
+}
+
+// SetReport parses a HID report descriptor, as returned by a GET_DESCRIPTOR
+// request to a HID interface, and attaches it to the interface HID class
+// descriptor.
+func (d *Device) SetReport(iface uint8, buf []byte) (err error) {
+	var found bool
+
+	for _, i := range d.Interfaces() {
+		if i.InterfaceNumber != iface || i.HID == nil {
+			continue
+		}
+
+		if len(buf) != int(i.HID.ReportLength) {
+			return fmt.Errorf("invalid report descriptor size (%d != %d)", len(buf), i.HID.ReportLength)
+		}
+
+		if i.HID.Report, err = ParseReport(buf); err != nil {
+			return
+		}
+
+		found = true
+	}
+
+	if !found {
+		return errors.New("no HID interface")
+	}
+
+	return
+}
@@ -0,0 +1,145 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"errors"
+	"slices"
+)
+
+// BOOT_KEYBOARD_REPORT_LENGTH is the size of boot keyboard input reports.
+const BOOT_KEYBOARD_REPORT_LENGTH = 8
+
+// Boot keyboard modifier bits (p60, Appendix B.1, HID1.11)
+const (
+	LEFT_CTRL   = 1 << 0
+	LEFT_SHIFT  = 1 << 1
+	LEFT_ALT    = 1 << 2
+	LEFT_GUI    = 1 << 3
+	RIGHT_CTRL  = 1 << 4
+	RIGHT_SHIFT = 1 << 5
+	RIGHT_ALT   = 1 << 6
+	RIGHT_GUI   = 1 << 7
+
+	CTRL  = LEFT_CTRL | RIGHT_CTRL
+	SHIFT = LEFT_SHIFT | RIGHT_SHIFT
+	ALT   = LEFT_ALT | RIGHT_ALT
+	GUI   = LEFT_GUI | RIGHT_GUI
+)
+
+// Keyboard/Keypad page usages (HID Usage Tables 1.4, 10)
+const (
+	KEY_ERR_ROLLOVER = 0x01
+	KEY_A            = 0x04
+	KEY_R            = 0x15
+	KEY_Z            = 0x1d
+	KEY_1            = 0x1e
+	KEY_0            = 0x27
+	KEY_ENTER        = 0x28
+	KEY_ESC          = 0x29
+	KEY_BACKSPACE    = 0x2a
+	KEY_TAB          = 0x2b
+	KEY_SPACE        = 0x2c
+)
+
+// keyRunes maps Keyboard/Keypad page usages, from Space to Slash, to their
+// unshifted and shifted characters on a US layout.
+var keyRunes = map[uint8][2]rune{
+	KEY_SPACE: {' ', ' '},
+	0x2d:      {'-', '_'},
+	0x2e:      {'=', '+'},
+	0x2f:      {'[', '{'},
+	0x30:      {']', '}'},
+	0x31:      {'\\', '|'},
+	0x33:      {';', ':'},
+	0x34:      {'\'', '"'},
+	0x35:      {'`', '~'},
+	0x36:      {',', '<'},
+	0x37:      {'.', '>'},
+	0x38:      {'/', '?'},
+}
+
+var shiftedDigits = []rune("!@#$%^&*()")
+
+// KeyboardReport represents a boot keyboard input report (p59, Appendix B.1,
+// HID1.11).
+type KeyboardReport struct {
+	// Modifiers holds the modifier key bits
+	Modifiers uint8
+	// Keys holds the pressed key codes, 0 for unused slots
+	Keys [6]uint8
+}
+
+// UnmarshalBinary parses a boot keyboard input report.
+func (r *KeyboardReport) UnmarshalBinary(buf []byte) (err error) {
+	if len(buf) != BOOT_KEYBOARD_REPORT_LENGTH {
+		return errors.New("invalid keyboard report size")
+	}
+
+	r.Modifiers = buf[0]
+	copy(r.Keys[:], buf[2:])
+
+	return
+}
+
+// RollOver returns whether the report signals that too many keys are pressed
+// (phantom state).
+func (r *KeyboardReport) RollOver() bool {
+	return r.Keys[0] == KEY_ERR_ROLLOVER
+}
+
+// Pressed returns the keys pressed in the report which were not pressed in
+// the previous one.
+func (r *KeyboardReport) Pressed(prev *KeyboardReport) (keys []uint8) {
+	if r.RollOver() {
+		return
+	}
+
+	for _, k := range r.Keys {
+		if k == 0 || slices.Contains(keys, k) {
+			continue
+		}
+
+		if prev != nil && !prev.RollOver() && slices.Contains(prev.Keys[:], k) {
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	return
+}
+
+// KeyRune returns the character typed by a key, on a US layout, with or
+// without shift.
+func KeyRune(key uint8, shift bool) (r rune, ok bool) {
+	var i int
+
+	if shift {
+		i = 1
+	}
+
+	switch {
+	case key >= KEY_A && key <= KEY_Z:
+		r = 'a' + rune(key-KEY_A)
+
+		if shift {
+			r -= 'a' - 'A'
+		}
+
+		return r, true
+	case key >= KEY_1 && key <= KEY_0:
+		if shift {
+			return shiftedDigits[key-KEY_1], true
+		}
+
+		return []rune("1234567890")[key-KEY_1], true
+	}
+
+	runes, ok := keyRunes[key]
+
+	return runes[i], ok
+}
@@ -0,0 +1,627 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Action represents the outcome of a policy rule.
+type Action int
+
+// Policy actions
+const (
+	Deny Action = iota
+	Allow
+)
+
+// String returns the action name.
+func (a Action) String() string {
+	if a == Allow {
+		return "allow"
+	}
+
+	return "deny"
+}
+
+// Rule represents a policy rule.
+type Rule struct {
+	// Action is the rule outcome when its condition is met
+	Action Action
+	// Line is the rule line number within the policy
+	Line int
+	// Text is the rule condition text
+	Text string
+
+	cond condition
+}
+
+// String returns the rule in policy format.
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s %s", r.Action, r.Text)
+}
+
+// Policy represents an ordered set of rules evaluated against the
+// descriptors of a device, the first matching rule applies.
+//
+// Each line holds a rule, in the `<allow|deny> <condition>` format, where
+// the condition combines the following predicates with `and`, `or`, `not`
+// and parentheses (`and` binds tighter than `or`), empty lines and `#`
+// comments are ignored:
+//
+//	any                           always true
+//	vid <hex>                     device vendor ID
+//	pid <hex>                     device product ID
+//	bcd <hex>                     device release number
+//	serial <string>               device serial number
+//	class <cc:ss:pp>              device class triple
+//	has interface <cc:ss:pp>      any interface class triple
+//	all interfaces <cc:ss:pp>     every interface class triple (at least one)
+//	interfaces <op> <n>           number of interfaces (==, !=, <, <=, >, >=)
+//	has endpoint <in|out> <type>  any endpoint of the given direction and type
+//	                              (control, isochronous, bulk, interrupt)
+//	has application <pppp:iiii>   any HID top level application collection
+//	boot keyboard                 every HID boot keyboard interface (03:01:01)
+//	                              has a boot compatible report descriptor
+//	boot mouse                    every HID boot mouse interface (03:01:02)
+//	                              has a boot compatible report descriptor
+//
+// Class triple fields can be set to `*` to match any value.
+//
+// Example:
+//
+//	# no composite mass storage and HID devices
+//	deny has interface 08:*:* and has interface 03:*:*
+//	# keyboards must present a boot protocol report
+//	deny has interface 03:01:01 and not boot keyboard
+//	allow any
+type Policy struct {
+	Rules []*Rule
+	// Default is the outcome when no rule matches
+	Default Action
+}
+
+// condition represents a predicate over the descriptors of a device.
+type condition func(d *Device) bool
+
+// ParsePolicy parses a set of rules, the default outcome is Deny.
+func ParsePolicy(text string) (p *Policy, err error) {
+	p = &Policy{
+		Default: Deny,
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		s := scanner.Text()
+
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		r, err := parseRule(s)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		r.Line = line
+		p.Rules = append(p.Rules, r)
+	}
+
+	return p, scanner.Err()
+}
+
+// Evaluate returns the outcome of the policy for a device, along with the
+// matching rule (nil when the default applies).
+func (p *Policy) Evaluate(d *Device) (Action, *Rule) {
+	for _, r := range p.Rules {
+		if r.cond(d) {
+			return r.Action, r
+		}
+	}
+
+	return p.Default, nil
+}
+
+func parseRule(s string) (r *Rule, err error) {
+	f := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s))
+	r = &Rule{}
+
+	switch f[0] {
+	case "allow":
+		r.Action = Allow
+	case "deny":
+		r.Action = Deny
+	default:
+		return nil, fmt.Errorf("invalid action %q", f[0])
+	}
+
+	if len(f) == 1 {
+		return nil, fmt.Errorf("missing condition")
+	}
+
+	p := &parser{tokens: f[1:]}
+
+	if r.cond, err = p.or(); err != nil {
+		return
+	}
+
+	if len(p.tokens) > 0 {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[0])
+	}
+
+	r.Text = strings.Join(f[1:], " ")
+
+	return
+}
+
+// parser implements a recursive descent parser for rule conditions.
+type parser struct {
+	tokens []string
+}
+
+func (p *parser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+
+	return p.tokens[0]
+}
+
+func (p *parser) next() (t string, err error) {
+	if len(p.tokens) == 0 {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+
+	t = p.tokens[0]
+	p.tokens = p.tokens[1:]
+
+	return
+}
+
+func (p *parser) expect(tokens ...string) (err error) {
+	for _, want := range tokens {
+		t, err := p.next()
+
+		if err != nil {
+			return err
+		}
+
+		if t != want {
+			return fmt.Errorf("expected %q, got %q", want, t)
+		}
+	}
+
+	return
+}
+
+func (p *parser) or() (c condition, err error) {
+	if c, err = p.and(); err != nil {
+		return
+	}
+
+	for p.peek() == "or" {
+		p.next()
+
+		rhs, err := p.and()
+
+		if err != nil {
+			return nil, err
+		}
+
+		lhs := c
+		c = func(d *Device) bool { return lhs(d) || rhs(d) }
+	}
+
+	return
+}
+
+func (p *parser) and() (c condition, err error) {
+	if c, err = p.not(); err != nil {
+		return
+	}
+
+	for p.peek() == "and" {
+		p.next()
+
+		rhs, err := p.not()
+
+		if err != nil {
+			return nil, err
+		}
+
+		lhs := c
+		c = func(d *Device) bool { return lhs(d) && rhs(d) }
+	}
+
+	return
+}
+
+func (p *parser) not() (c condition, err error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+
+		if c, err = p.not(); err != nil {
+			return
+		}
+
+		neg := c
+		return func(d *Device) bool { return !neg(d) }, nil
+	case "(":
+		p.next()
+
+		if c, err = p.or(); err != nil {
+			return
+		}
+
+		return c, p.expect(")")
+	}
+
+	return p.predicate()
+}
+
+func (p *parser) hex16() (v uint16, err error) {
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	n, err := strconv.ParseUint(t, 16, 16)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", t)
+	}
+
+	return uint16(n), nil
+}
+
+func (p *parser) predicate() (c condition, err error) {
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	switch t {
+	case "any":
+		return func(*Device) bool { return true }, nil
+	case "vid", "pid", "bcd":
+		v, err := p.hex16()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *Device) bool {
+			switch t {
+			case "vid":
+				return d.Descriptor.VendorId == v
+			case "pid":
+				return d.Descriptor.ProductId == v
+			default:
+				return d.Descriptor.Device == v
+			}
+		}, nil
+	case "serial":
+		serial, err := p.next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *Device) bool { return d.Serial == serial }, nil
+	case "class":
+		m, err := p.class()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *Device) bool {
+			dd := d.Descriptor
+			return m.matches(dd.DeviceClass, dd.DeviceSubClass, dd.DeviceProtocol)
+		}, nil
+	case "has":
+		return p.has()
+	case "all":
+		if err = p.expect("interfaces"); err != nil {
+			return
+		}
+
+		m, err := p.class()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *Device) bool {
+			ifaces := d.Interfaces()
+
+			for _, iface := range ifaces {
+				if !m.matchesInterface(iface) {
+					return false
+				}
+			}
+
+			return len(ifaces) > 0
+		}, nil
+	case "interfaces":
+		return p.count()
+	case "boot":
+		return p.boot()
+	}
+
+	return nil, fmt.Errorf("invalid predicate %q", t)
+}
+
+func (p *parser) has() (c condition, err error) {
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	switch t {
+	case "interface":
+		m, err := p.class()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *Device) bool {
+			for _, iface := range d.Interfaces() {
+				if m.matchesInterface(iface) {
+					return true
+				}
+			}
+
+			return false
+		}, nil
+	case "endpoint":
+		return p.endpoint()
+	case "application":
+		return p.application()
+	}
+
+	return nil, fmt.Errorf("invalid predicate \"has %s\"", t)
+}
+
+func (p *parser) endpoint() (c condition, err error) {
+	var dir, typ int
+
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	switch t {
+	case "in":
+		dir = IN
+	case "out":
+		dir = OUT
+	default:
+		return nil, fmt.Errorf("invalid endpoint direction %q", t)
+	}
+
+	if t, err = p.next(); err != nil {
+		return
+	}
+
+	switch t {
+	case "control":
+		typ = CONTROL
+	case "isochronous":
+		typ = ISOCHRONOUS
+	case "bulk":
+		typ = BULK
+	case "interrupt":
+		typ = INTERRUPT
+	default:
+		return nil, fmt.Errorf("invalid endpoint type %q", t)
+	}
+
+	return func(d *Device) bool {
+		for _, iface := range d.Interfaces() {
+			for _, ep := range iface.Endpoints {
+				if ep.Direction() == dir && ep.TransferType() == typ {
+					return true
+				}
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func (p *parser) application() (c condition, err error) {
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	f := strings.Split(t, ":")
+
+	if len(f) != 2 {
+		return nil, fmt.Errorf("invalid usage %q", t)
+	}
+
+	page, err := strconv.ParseUint(f[0], 16, 16)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid usage %q", t)
+	}
+
+	id, err := strconv.ParseUint(f[1], 16, 16)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid usage %q", t)
+	}
+
+	u := NewUsage(uint16(page), uint16(id))
+
+	return func(d *Device) bool {
+		for _, iface := range d.Interfaces() {
+			if iface.HID == nil || iface.HID.Report == nil {
+				continue
+			}
+
+			for _, app := range iface.HID.Report.Applications {
+				if app == u {
+					return true
+				}
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func (p *parser) count() (c condition, err error) {
+	op, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	n, err := strconv.Atoi(t)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid count %q", t)
+	}
+
+	var cmp func(int) bool
+
+	switch op {
+	case "==":
+		cmp = func(v int) bool { return v == n }
+	case "!=":
+		cmp = func(v int) bool { return v != n }
+	case "<":
+		cmp = func(v int) bool { return v < n }
+	case "<=":
+		cmp = func(v int) bool { return v <= n }
+	case ">":
+		cmp = func(v int) bool { return v > n }
+	case ">=":
+		cmp = func(v int) bool { return v >= n }
+	default:
+		return nil, fmt.Errorf("invalid operator %q", op)
+	}
+
+	return func(d *Device) bool { return cmp(len(d.Interfaces())) }, nil
+}
+
+func (p *parser) boot() (c condition, err error) {
+	var protocol uint8
+	var boot func(r *ReportDescriptor) bool
+
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	switch t {
+	case "keyboard":
+		protocol = 1
+		boot = (*ReportDescriptor).BootKeyboard
+	case "mouse":
+		protocol = 2
+		boot = (*ReportDescriptor).BootMouse
+	default:
+		return nil, fmt.Errorf("invalid boot device %q", t)
+	}
+
+	return func(d *Device) bool {
+		var found bool
+
+		for _, iface := range d.Interfaces() {
+			if iface.InterfaceClass != HID_CLASS || iface.InterfaceSubClass != 1 || iface.InterfaceProtocol != protocol {
+				continue
+			}
+
+			// a report descriptor which was not retrieved cannot
+			// be proven to be boot compatible
+			if iface.HID == nil || iface.HID.Report == nil || !boot(iface.HID.Report) {
+				return false
+			}
+
+			found = true
+		}
+
+		return found
+	}, nil
+}
+
+// classMatch represents a class triple pattern, nil fields match any value.
+type classMatch [3]*uint8
+
+func (p *parser) class() (m classMatch, err error) {
+	t, err := p.next()
+
+	if err != nil {
+		return
+	}
+
+	f := strings.Split(t, ":")
+
+	if len(f) != 3 {
+		return m, fmt.Errorf("invalid class %q", t)
+	}
+
+	for i := range f {
+		if f[i] == "*" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(f[i], 16, 8)
+
+		if err != nil {
+			return m, fmt.Errorf("invalid class %q", t)
+		}
+
+		v := uint8(n)
+		m[i] = &v
+	}
+
+	return
+}
+
+func (m classMatch) matches(class, subClass, protocol uint8) bool {
+	for i, v := range []uint8{class, subClass, protocol} {
+		if m[i] != nil && *m[i] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m classMatch) matchesInterface(iface *InterfaceDescriptor) bool {
+	return m.matches(iface.InterfaceClass, iface.InterfaceSubClass, iface.InterfaceProtocol)
+}
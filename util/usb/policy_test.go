@@ -0,0 +1,227 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// descriptors is the path of recorded device descriptors.
+var descriptors = filepath.Join("..", "..", "USBIP", "descriptors")
+
+// devicePolicy is the path of the example descriptor policy.
+var devicePolicy = filepath.Join("..", "..", "USBIP", "usb-device.policy")
+
+func loadRecording(t *testing.T, name string) *Device {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(descriptors, name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ParseRecording(data)
+
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return d
+}
+
+func loadPolicy(t *testing.T) *Policy {
+	t.Helper()
+
+	rules, err := os.ReadFile(devicePolicy)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ParsePolicy(string(rules))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestPolicyRecordings(t *testing.T) {
+	p := loadPolicy(t)
+
+	for _, tc := range []struct {
+		name   string
+		file   string
+		action Action
+		rule   string
+	}{
+		{"plain keyboard", "boot_keyboard.json", Allow, "any"},
+		{"keyboard and mouse", "logitech_c53f.json", Allow, "any"},
+		{"unifying receiver", "logitech_c53f_receiver.json", Allow, "any"},
+		{"storage and keyboard composite", "storage_keyboard.json", Deny, "has interface 08:*:* and has interface 03:*:*"},
+		{"report ID keyboard", "keyboard_report_id.json", Deny, "has interface 03:01:01 and not boot keyboard"},
+	} {
+		action, rule := p.Evaluate(loadRecording(t, tc.file))
+
+		if action != tc.action {
+			t.Errorf("%s: %s, want %s", tc.name, action, tc.action)
+		}
+
+		if rule == nil || rule.Text != tc.rule {
+			t.Errorf("%s: matched rule %v, want %q", tc.name, rule, tc.rule)
+		}
+	}
+}
+
+func TestPolicyPredicates(t *testing.T) {
+	keyboard := loadRecording(t, "boot_keyboard.json")
+	storage := loadRecording(t, "storage_keyboard.json")
+	receiver := loadRecording(t, "logitech_c53f_receiver.json")
+
+	for _, tc := range []struct {
+		rule string
+		d    *Device
+		want bool
+	}{
+		{"vid 1a86 and pid e026", keyboard, true},
+		{"vid 046d", keyboard, false},
+		{"bcd 0100", keyboard, true},
+		{"serial 4C530001230115117442", storage, true},
+		{"class 00:00:00", keyboard, true},
+		{"interfaces == 1", keyboard, true},
+		{"interfaces > 2", receiver, true},
+		{"all interfaces 03:*:*", receiver, true},
+		{"all interfaces 03:*:*", storage, false},
+		{"has endpoint in bulk", storage, true},
+		{"has endpoint out bulk", keyboard, false},
+		{"has endpoint in interrupt", keyboard, true},
+		{"has application 000c:0001", receiver, true},
+		{"has application 000c:0001", keyboard, false},
+		{"boot keyboard and not boot mouse", keyboard, true},
+		{"boot mouse", receiver, false},
+		{"not (vid 1a86 or vid 046d)", storage, true},
+		{"vid 1a86 or vid 046d and pid 0000", keyboard, true},
+	} {
+		p, err := ParsePolicy("allow " + tc.rule)
+
+		if err != nil {
+			t.Errorf("%q: %v", tc.rule, err)
+			continue
+		}
+
+		if action, _ := p.Evaluate(tc.d); (action == Allow) != tc.want {
+			t.Errorf("%q: %s, want match:%v", tc.rule, action, tc.want)
+		}
+	}
+}
+
+func TestPolicyDefaultDeny(t *testing.T) {
+	p, err := ParsePolicy("# no rules\n\nallow vid ffff\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if action, rule := p.Evaluate(loadRecording(t, "boot_keyboard.json")); action != Deny || rule != nil {
+		t.Errorf("unmatched device: %s (%v), want default deny", action, rule)
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	for _, rules := range []string{
+		"permit any",
+		"allow",
+		"allow vid",
+		"allow vid xyz",
+		"allow class 03:01",
+		"allow has interface 03:01:zz",
+		"allow interfaces ~ 1",
+		"allow has endpoint in sideways",
+		"allow (any",
+		"allow any and",
+		"allow any any",
+	} {
+		if _, err := ParsePolicy(rules); err == nil {
+			t.Errorf("%q: parsed", rules)
+		}
+	}
+}
+
+func TestMalformedDescriptors(t *testing.T) {
+	decode := func(s string) []byte {
+		buf, err := hex.DecodeString(s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return buf
+	}
+
+	device := decode("1201000200000040861a26e0000101020001")
+	config := decode("09022200010100a0310904000001030101000921110100012241000705810308000a")
+
+	for _, tc := range []struct {
+		name   string
+		device []byte
+		config []byte
+	}{
+		{"empty device", nil, config},
+		{"truncated device", device[:8], config},
+		{"device type", append([]byte{0x12, 0x02}, device[2:]...), config},
+		{"empty configuration", device, nil},
+		{"truncated configuration", device, config[:20]},
+		{"configuration total length", device, append(append([]byte{}, config[:2]...), append([]byte{0xff, 0x00}, config[4:]...)...)},
+		{"descriptor length overrun", device, append(append([]byte{}, config[:9]...), append([]byte{0x40}, config[10:]...)...)},
+		{"zero descriptor length", device, append(append([]byte{}, config[:9]...), append([]byte{0x00}, config[10:]...)...)},
+	} {
+		if _, err := ParseDevice(tc.device, tc.config); err == nil {
+			t.Errorf("%s: parsed", tc.name)
+		}
+	}
+
+	d, err := ParseDevice(device, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the configuration declares a 65 byte report descriptor
+	report := decode("05010906a101050719e029e71500250175019508810295017508810195057501050819012905910295017503910195067508150025650507190029658100c0")
+
+	if err = d.SetReport(0, report); err == nil {
+		t.Error("report descriptor size mismatch: accepted")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		report string
+	}{
+		{"unbalanced collection", "05010906a101"},
+		{"unbalanced end collection", "c0"},
+		{"truncated item", "050109"},
+	} {
+		if _, err := ParseReport(decode(tc.report)); err == nil {
+			t.Errorf("%s: parsed", tc.name)
+		}
+	}
+
+	for _, rec := range []string{
+		`{"device": "zz", "configuration": ""}`,
+		`{"device": "1201", "configuration": "0902"}`,
+		`{"device": "1201000200000040861a26e0000101020001", "configuration": "09022200010100a0310904000001030101000921110100012241000705810308000a", "reports": {"x": "c0"}}`,
+		`not json`,
+	} {
+		if _, err := ParseRecording([]byte(rec)); err == nil {
+			t.Errorf("%s: parsed", rec)
+		}
+	}
+}
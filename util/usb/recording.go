@@ -0,0 +1,94 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Recording represents recorded device descriptors in JSON format (see
+// USBIP/descriptors), holding the hex encoded device, configuration and
+// serial number string descriptors and the HID report descriptors indexed by
+// interface number.
+type Recording struct {
+	Device        string            `json:"device"`
+	Configuration string            `json:"configuration"`
+	Serial        string            `json:"serial,omitempty"`
+	Reports       map[string]string `json:"reports,omitempty"`
+}
+
+// Descriptors returns the decoded descriptors of a recording, HID report
+// descriptors are indexed by interface number.
+func (r *Recording) Descriptors() (device []byte, config []byte, serial []byte, reports map[uint8][]byte, err error) {
+	if device, err = hex.DecodeString(r.Device); err != nil {
+		err = fmt.Errorf("invalid device descriptor, %v", err)
+		return
+	}
+
+	if config, err = hex.DecodeString(r.Configuration); err != nil {
+		err = fmt.Errorf("invalid configuration descriptor, %v", err)
+		return
+	}
+
+	if serial, err = hex.DecodeString(r.Serial); err != nil {
+		err = fmt.Errorf("invalid serial descriptor, %v", err)
+		return
+	}
+
+	reports = make(map[uint8][]byte)
+
+	for n, report := range r.Reports {
+		iface, e := strconv.ParseUint(n, 10, 8)
+
+		if e != nil {
+			err = fmt.Errorf("invalid interface number %q", n)
+			return
+		}
+
+		if reports[uint8(iface)], err = hex.DecodeString(report); err != nil {
+			err = fmt.Errorf("invalid report descriptor, %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// ParseRecording parses recorded device descriptors in JSON format.
+func ParseRecording(data []byte) (d *Device, err error) {
+	var r Recording
+
+	if err = json.Unmarshal(data, &r); err != nil {
+		return
+	}
+
+	device, config, serial, reports, err := r.Descriptors()
+
+	if err != nil {
+		return
+	}
+
+	if d, err = ParseDevice(device, config); err != nil {
+		return
+	}
+
+	if len(serial) > 0 {
+		if d.Serial, err = ParseString(serial); err != nil {
+			return nil, err
+		}
+	}
+
+	for iface, buf := range reports {
+		if err = d.SetReport(iface, buf); err != nil {
+			return nil, fmt.Errorf("interface %d, %v", iface, err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,578 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// HID report descriptor item types (p26, 6.2.2.2, HID1.11)
+const (
+	mainItem   = 0
+	globalItem = 1
+	localItem  = 2
+)
+
+// longItem is the prefix of HID long items (p27, 6.2.2.3, HID1.11)
+const longItem = 0xfe
+
+// HID main item tags (p28, 6.2.2.4, HID1.11)
+const (
+	INPUT          = 0x8
+	OUTPUT         = 0x9
+	COLLECTION     = 0xa
+	FEATURE        = 0xb
+	END_COLLECTION = 0xc
+)
+
+// HID collection types (p33, 6.2.2.6, HID1.11)
+const (
+	PHYSICAL    = 0x00
+	APPLICATION = 0x01
+	LOGICAL     = 0x02
+)
+
+// HID global item tags (p35, 6.2.2.7, HID1.11)
+const (
+	usagePage      = 0x0
+	logicalMinimum = 0x1
+	logicalMaximum = 0x2
+	reportSize     = 0x7
+	reportID       = 0x8
+	reportCount    = 0x9
+	push           = 0xa
+	pop            = 0xb
+)
+
+// HID local item tags (p40, 6.2.2.8, HID1.11)
+const (
+	usage        = 0x0
+	usageMinimum = 0x1
+	usageMaximum = 0x2
+)
+
+// HID usage pages (HID Usage Tables 1.4)
+const (
+	GENERIC_DESKTOP = 0x01
+	KEYBOARD_KEYPAD = 0x07
+	LED             = 0x08
+	BUTTON          = 0x09
+	CONSUMER        = 0x0c
+	VENDOR_PAGE     = 0xff00
+)
+
+// Generic Desktop usages (HID Usage Tables 1.4)
+const (
+	POINTER  = 0x01
+	MOUSE    = 0x02
+	KEYBOARD = 0x06
+	X        = 0x30
+	Y        = 0x31
+	WHEEL    = 0x38
+)
+
+// HID report descriptor parsing limits, applied to descriptors received from
+// untrusted devices.
+const (
+	// MaxReportLength is the maximum report descriptor size
+	MaxReportLength = 4096
+	// MaxFields is the maximum number of fields (main items)
+	MaxFields = 256
+	// MaxDepth is the maximum nesting of collections and item state
+	// stack
+	MaxDepth = 16
+
+	maxUsages      = 1024
+	maxReportSize  = 32
+	maxReportCount = 1024
+	maxReportBits  = 8 * 4096
+)
+
+// Main item data flags (p30, 6.2.2.5, HID1.11)
+const (
+	constantFlag = 1 << 0
+	variableFlag = 1 << 1
+	relativeFlag = 1 << 2
+)
+
+// Usage represents an extended HID usage, the usage page is held in the 16
+// most significant bits.
+type Usage uint32
+
+// Page returns the usage page.
+func (u Usage) Page() uint16 {
+	return uint16(u >> 16)
+}
+
+// ID returns the usage ID.
+func (u Usage) ID() uint16 {
+	return uint16(u)
+}
+
+// String returns the usage in pppp:iiii format.
+func (u Usage) String() string {
+	return fmt.Sprintf("%04x:%04x", u.Page(), u.ID())
+}
+
+// NewUsage returns the extended usage for a usage page and ID.
+func NewUsage(page uint16, id uint16) Usage {
+	return Usage(page)<<16 | Usage(id)
+}
+
+// Field represents a HID report field, as defined by an Input, Output or
+// Feature main item.
+type Field struct {
+	// Type is the main item tag (INPUT, OUTPUT or FEATURE)
+	Type uint8
+	// ReportID is the report ID, 0 if report IDs are not used
+	ReportID uint8
+	// Flags holds the main item data
+	Flags uint32
+	// Application is the usage of the top level application collection
+	Application Usage
+
+	// Usages holds the usages defined by individual Usage items
+	Usages []Usage
+	// UsageMinimum and UsageMaximum hold the range defined by Usage
+	// Minimum and Maximum items, following any individual usage
+	UsageMinimum Usage
+	UsageMaximum Usage
+
+	LogicalMinimum int32
+	LogicalMaximum int32
+	ReportSize     uint32
+	ReportCount    uint32
+
+	// Offset is the field offset, in bits, within the report data (not
+	// including the report ID)
+	Offset uint32
+}
+
+// Constant returns whether the field holds constant (padding) data.
+func (f *Field) Constant() bool {
+	return f.Flags&constantFlag != 0
+}
+
+// Variable returns whether each field element represents a distinct usage,
+// as opposed to an array of usage indices.
+func (f *Field) Variable() bool {
+	return f.Flags&variableFlag != 0
+}
+
+// Relative returns whether the field data is relative to the previous
+// report.
+func (f *Field) Relative() bool {
+	return f.Flags&relativeFlag != 0
+}
+
+// Size returns the field size in bits.
+func (f *Field) Size() uint32 {
+	return f.ReportSize * f.ReportCount
+}
+
+func (f *Field) hasRange() bool {
+	return f.UsageMinimum != 0 || f.UsageMaximum != 0
+}
+
+// Usage returns, for variable fields, the usage of the i-th element or, for
+// array fields, the usage of index i (the element value minus the logical
+// minimum).
+func (f *Field) Usage(i int) (u Usage, ok bool) {
+	if i < 0 {
+		return
+	}
+
+	if i < len(f.Usages) {
+		return f.Usages[i], true
+	}
+
+	if f.hasRange() {
+		if u = f.UsageMinimum + Usage(i-len(f.Usages)); u <= f.UsageMaximum {
+			return u, true
+		}
+
+		if f.Variable() {
+			return f.UsageMaximum, true
+		}
+
+		return 0, false
+	}
+
+	// variable fields reuse the last usage for remaining elements
+	if f.Variable() && len(f.Usages) > 0 {
+		return f.Usages[len(f.Usages)-1], true
+	}
+
+	return
+}
+
+// ReportDescriptor represents a parsed HID report descriptor (p23, 6.2.2,
+// HID1.11).
+type ReportDescriptor struct {
+	// Applications holds the usages of top level application collections
+	Applications []Usage
+	// Fields holds all report fields in descriptor order
+	Fields []*Field
+}
+
+type globals struct {
+	usagePage      uint16
+	logicalMinimum int32
+	logicalMaximum int32
+	reportSize     uint32
+	reportID       uint8
+	reportCount    uint32
+}
+
+type locals struct {
+	usages       []Usage
+	usageMinimum Usage
+	usageMaximum Usage
+}
+
+// usage resolves a local usage item, items shorter than 4 bytes refer to the
+// current usage page.
+func (g *globals) usage(data []byte, v uint32) Usage {
+	if len(data) == 4 {
+		return Usage(v)
+	}
+
+	return NewUsage(g.usagePage, uint16(v))
+}
+
+func itemValue(data []byte) (u uint32, s int32) {
+	switch len(data) {
+	case 1:
+		return uint32(data[0]), int32(int8(data[0]))
+	case 2:
+		v := binary.LittleEndian.Uint16(data)
+		return uint32(v), int32(int16(v))
+	case 4:
+		v := binary.LittleEndian.Uint32(data)
+		return v, int32(v)
+	}
+
+	return
+}
+
+// ParseReport parses a HID report descriptor, only short items are
+// interpreted while long items are skipped.
+func ParseReport(buf []byte) (r *ReportDescriptor, err error) {
+	var g globals
+	var l locals
+	var stack []globals
+	var collections []Usage
+
+	if len(buf) > MaxReportLength {
+		return nil, errors.New("invalid report descriptor size")
+	}
+
+	r = &ReportDescriptor{}
+	offsets := make(map[[2]uint8]uint32)
+
+	for len(buf) > 0 {
+		prefix := buf[0]
+
+		if prefix == longItem {
+			if len(buf) < 3 || len(buf) < 3+int(buf[1]) {
+				return nil, errors.New("truncated long item")
+			}
+
+			buf = buf[3+int(buf[1]):]
+			continue
+		}
+
+		size := []int{0, 1, 2, 4}[prefix&0b11]
+		typ := (prefix >> 2) & 0b11
+		tag := prefix >> 4
+
+		if len(buf) < 1+size {
+			return nil, errors.New("truncated item")
+		}
+
+		data := buf[1 : 1+size]
+		buf = buf[1+size:]
+
+		u, s := itemValue(data)
+
+		switch typ {
+		case mainItem:
+			switch tag {
+			case INPUT, OUTPUT, FEATURE:
+				if len(r.Fields) >= MaxFields {
+					return nil, errors.New("too many report fields")
+				}
+
+				f := &Field{
+					Type:           tag,
+					ReportID:       g.reportID,
+					Flags:          u,
+					Usages:         l.usages,
+					UsageMinimum:   l.usageMinimum,
+					UsageMaximum:   l.usageMaximum,
+					LogicalMinimum: g.logicalMinimum,
+					LogicalMaximum: g.logicalMaximum,
+					ReportSize:     g.reportSize,
+					ReportCount:    g.reportCount,
+				}
+
+				if len(collections) > 0 {
+					f.Application = collections[0]
+				}
+
+				key := [2]uint8{tag, g.reportID}
+				f.Offset = offsets[key]
+
+				if offsets[key] += f.Size(); offsets[key] > maxReportBits {
+					return nil, errors.New("report too large")
+				}
+
+				r.Fields = append(r.Fields, f)
+			case COLLECTION:
+				if len(collections) >= MaxDepth {
+					return nil, errors.New("collections nested too deeply")
+				}
+
+				var c Usage
+
+				if len(l.usages) > 0 {
+					c = l.usages[0]
+				}
+
+				if len(collections) == 0 && u == APPLICATION {
+					r.Applications = append(r.Applications, c)
+				}
+
+				// fields are attributed to their top level collection
+				if len(collections) > 0 {
+					c = collections[0]
+				}
+
+				collections = append(collections, c)
+			case END_COLLECTION:
+				if len(collections) == 0 {
+					return nil, errors.New("unbalanced end collection")
+				}
+
+				collections = collections[:len(collections)-1]
+			default:
+				return nil, fmt.Errorf("invalid main item %#x", tag)
+			}
+
+			l = locals{}
+		case globalItem:
+			switch tag {
+			case usagePage:
+				g.usagePage = uint16(u)
+			case logicalMinimum:
+				g.logicalMinimum = s
+			case logicalMaximum:
+				// unsigned when the minimum is not negative
+				if g.logicalMinimum >= 0 {
+					g.logicalMaximum = int32(u)
+				} else {
+					g.logicalMaximum = s
+				}
+			case reportSize:
+				if u > maxReportSize {
+					return nil, fmt.Errorf("invalid report size %d", u)
+				}
+
+				g.reportSize = u
+			case reportID:
+				if u == 0 || u > 0xff {
+					return nil, fmt.Errorf("invalid report ID %d", u)
+				}
+
+				g.reportID = uint8(u)
+			case reportCount:
+				if u > maxReportCount {
+					return nil, fmt.Errorf("invalid report count %d", u)
+				}
+
+				g.reportCount = u
+			case push:
+				if len(stack) >= MaxDepth {
+					return nil, errors.New("item state stack overflow")
+				}
+
+				stack = append(stack, g)
+			case pop:
+				if len(stack) == 0 {
+					return nil, errors.New("item state stack underflow")
+				}
+
+				g = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case localItem:
+			switch tag {
+			case usage:
+				if len(l.usages) >= maxUsages {
+					return nil, errors.New("too many usages")
+				}
+
+				l.usages = append(l.usages, g.usage(data, u))
+			case usageMinimum:
+				l.usageMinimum = g.usage(data, u)
+			case usageMaximum:
+				l.usageMaximum = g.usage(data, u)
+			}
+		default:
+			return nil, fmt.Errorf("invalid item %#x", prefix)
+		}
+	}
+
+	if len(collections) > 0 {
+		return nil, errors.New("unbalanced collection")
+	}
+
+	return
+}
+
+// ReportIDs returns the distinct report IDs in use, 0 if report IDs are not
+// used.
+func (r *ReportDescriptor) ReportIDs() (ids []uint8) {
+	for _, f := range r.Fields {
+		if !slices.Contains(ids, f.ReportID) {
+			ids = append(ids, f.ReportID)
+		}
+	}
+
+	slices.Sort(ids)
+
+	return
+}
+
+// Report returns the fields of a report, by main item tag and report ID.
+func (r *ReportDescriptor) Report(typ uint8, id uint8) (fields []*Field) {
+	for _, f := range r.Fields {
+		if f.Type == typ && f.ReportID == id {
+			fields = append(fields, f)
+		}
+	}
+
+	return
+}
+
+// ReportSize returns the size in bytes of a report data, not including the
+// report ID.
+func (r *ReportDescriptor) ReportSize(typ uint8, id uint8) int {
+	var bits uint32
+
+	for _, f := range r.Report(typ, id) {
+		bits += f.Size()
+	}
+
+	return int((bits + 7) / 8)
+}
+
+// element returns the field, and element index, starting at a given bit
+// offset within a set of report fields.
+func element(fields []*Field, off uint32) (*Field, int) {
+	for _, f := range fields {
+		if f.ReportSize == 0 || off < f.Offset || off >= f.Offset+f.Size() {
+			continue
+		}
+
+		if (off-f.Offset)%f.ReportSize != 0 {
+			break
+		}
+
+		return f, int((off - f.Offset) / f.ReportSize)
+	}
+
+	return nil, 0
+}
+
+// elementUsage returns whether the element at a given bit offset is a
+// variable element of the argument size and usage.
+func elementUsage(fields []*Field, off uint32, size uint32, u Usage) (f *Field, ok bool) {
+	f, i := element(fields, off)
+
+	if f == nil || !f.Variable() || f.Constant() || f.ReportSize != size {
+		return nil, false
+	}
+
+	usage, ok := f.Usage(i)
+
+	return f, ok && usage == u
+}
+
+// BootKeyboard returns whether the descriptor input report layout matches
+// the boot keyboard protocol one (p59, Appendix B.1, HID1.11): 8 modifier
+// bits, a reserved byte and an array of 6 key codes, without report ID.
+func (r *ReportDescriptor) BootKeyboard() bool {
+	fields := r.Report(INPUT, 0)
+
+	if len(fields) == 0 || r.ReportSize(INPUT, 0) != 8 {
+		return false
+	}
+
+	for _, f := range fields {
+		if f.Application != NewUsage(GENERIC_DESKTOP, KEYBOARD) {
+			return false
+		}
+	}
+
+	// modifier keys (Left Control to Right GUI)
+	for i := uint32(0); i < 8; i++ {
+		if _, ok := elementUsage(fields, i, 1, NewUsage(KEYBOARD_KEYPAD, 0xe0+uint16(i))); !ok {
+			return false
+		}
+	}
+
+	// key codes
+	for off := uint32(16); off < 64; off += 8 {
+		f, _ := element(fields, off)
+
+		if f == nil || f.Variable() || f.Constant() || f.ReportSize != 8 {
+			return false
+		}
+
+		if u, ok := f.Usage(0); !ok || u.Page() != KEYBOARD_KEYPAD {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BootMouse returns whether the descriptor input report layout starts as
+// the boot mouse protocol one (p61, Appendix B.2, HID1.11): 3 button bits,
+// padding and relative X and Y bytes, without report ID.
+func (r *ReportDescriptor) BootMouse() bool {
+	fields := r.Report(INPUT, 0)
+
+	if len(fields) == 0 || r.ReportSize(INPUT, 0) < 3 {
+		return false
+	}
+
+	for _, f := range fields {
+		if f.Application != NewUsage(GENERIC_DESKTOP, MOUSE) {
+			return false
+		}
+	}
+
+	for i := uint32(0); i < 3; i++ {
+		if _, ok := elementUsage(fields, i, 1, NewUsage(BUTTON, 1+uint16(i))); !ok {
+			return false
+		}
+	}
+
+	for i, u := range []uint16{X, Y} {
+		f, ok := elementUsage(fields, 8+uint32(i)*8, 8, NewUsage(GENERIC_DESKTOP, u))
+
+		if !ok || !f.Relative() {
+			return false
+		}
+	}
+
+	return true
+}
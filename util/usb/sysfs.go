@@ -0,0 +1,97 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SysfsDevices is the Linux sysfs path of USB devices.
+const SysfsDevices = "/sys/bus/usb/devices"
+
+// ReadSysfs reads the descriptors of a device connected to a Linux host,
+// identified by its bus ID (e.g. 1-1), including its serial number and HID
+// report descriptors. Sysfs exposes the device descriptor followed by all
+// configuration descriptors, only the active one is parsed.
+func ReadSysfs(busid string) (d *Device, err error) {
+	path := filepath.Join(SysfsDevices, busid)
+
+	buf, err := os.ReadFile(filepath.Join(path, "descriptors"))
+
+	if err != nil {
+		return
+	}
+
+	value, err := os.ReadFile(filepath.Join(path, "bConfigurationValue"))
+
+	if err != nil {
+		return
+	}
+
+	active := strings.TrimSpace(string(value))
+
+	if len(buf) < DEVICE_LENGTH {
+		return nil, errors.New("invalid descriptors")
+	}
+
+	device := buf[:DEVICE_LENGTH]
+
+	for rest := buf[DEVICE_LENGTH:]; len(rest) > 0; {
+		config, err := ParseConfiguration(rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if strconv.Itoa(int(config.ConfigurationValue)) == active {
+			if d, err = ParseDevice(device, rest[:config.TotalLength]); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		rest = rest[config.TotalLength:]
+	}
+
+	if d == nil {
+		return nil, errors.New("no active configuration")
+	}
+
+	if serial, err := os.ReadFile(filepath.Join(path, "serial")); err == nil {
+		d.Serial = strings.TrimSpace(string(serial))
+	}
+
+	for _, iface := range d.Interfaces() {
+		if iface.HID == nil || iface.AlternateSetting != 0 {
+			continue
+		}
+
+		pattern := fmt.Sprintf("%s:%s.%d/*/report_descriptor", path, active, iface.InterfaceNumber)
+		matches, _ := filepath.Glob(pattern)
+
+		if len(matches) == 0 {
+			continue
+		}
+
+		report, err := os.ReadFile(matches[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = d.SetReport(iface.InterfaceNumber, report); err != nil {
+			return nil, fmt.Errorf("interface %d, %v", iface.InterfaceNumber, err)
+		}
+	}
+
+	return
+}
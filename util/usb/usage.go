@@ -0,0 +1,122 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usb
+
+import (
+	"fmt"
+)
+
+// Generic Desktop system control usages (HID Usage Tables 1.4, 4.5)
+const (
+	SYSTEM_CONTROL = 0x80
+	SYSTEM_POWER   = 0x81
+	SYSTEM_SLEEP   = 0x82
+	SYSTEM_WAKE    = 0x83
+)
+
+// Consumer page usages (HID Usage Tables 1.4, 15)
+const (
+	CONSUMER_CONTROL = 0x01
+	AC_PAN           = 0x238
+)
+
+// keyNames holds the Keyboard/Keypad page usage names, from KEY_A, following
+// the Linux input event codes naming.
+var keyNames = []string{
+	"KEY_A", "KEY_B", "KEY_C", "KEY_D", "KEY_E", "KEY_F", "KEY_G", "KEY_H",
+	"KEY_I", "KEY_J", "KEY_K", "KEY_L", "KEY_M", "KEY_N", "KEY_O", "KEY_P",
+	"KEY_Q", "KEY_R", "KEY_S", "KEY_T", "KEY_U", "KEY_V", "KEY_W", "KEY_X",
+	"KEY_Y", "KEY_Z", "KEY_1", "KEY_2", "KEY_3", "KEY_4", "KEY_5", "KEY_6",
+	"KEY_7", "KEY_8", "KEY_9", "KEY_0", "KEY_ENTER", "KEY_ESC", "KEY_BACKSPACE", "KEY_TAB",
+	"KEY_SPACE", "KEY_MINUS", "KEY_EQUAL", "KEY_LEFTBRACE", "KEY_RIGHTBRACE", "KEY_BACKSLASH", "KEY_HASHTILDE", "KEY_SEMICOLON",
+	"KEY_APOSTROPHE", "KEY_GRAVE", "KEY_COMMA", "KEY_DOT", "KEY_SLASH", "KEY_CAPSLOCK", "KEY_F1", "KEY_F2",
+	"KEY_F3", "KEY_F4", "KEY_F5", "KEY_F6", "KEY_F7", "KEY_F8", "KEY_F9", "KEY_F10",
+	"KEY_F11", "KEY_F12", "KEY_SYSRQ", "KEY_SCROLLLOCK", "KEY_PAUSE", "KEY_INSERT", "KEY_HOME", "KEY_PAGEUP",
+	"KEY_DELETE", "KEY_END", "KEY_PAGEDOWN", "KEY_RIGHT", "KEY_LEFT", "KEY_DOWN", "KEY_UP", "KEY_NUMLOCK",
+	"KEY_KPSLASH", "KEY_KPASTERISK", "KEY_KPMINUS", "KEY_KPPLUS", "KEY_KPENTER", "KEY_KP1", "KEY_KP2", "KEY_KP3",
+	"KEY_KP4", "KEY_KP5", "KEY_KP6", "KEY_KP7", "KEY_KP8", "KEY_KP9", "KEY_KP0", "KEY_KPDOT",
+	"KEY_102ND", "KEY_COMPOSE",
+}
+
+// modifierNames holds the Keyboard/Keypad page modifier usage names, from
+// Left Control (0xe0).
+var modifierNames = []string{
+	"KEY_LEFTCTRL", "KEY_LEFTSHIFT", "KEY_LEFTALT", "KEY_LEFTMETA",
+	"KEY_RIGHTCTRL", "KEY_RIGHTSHIFT", "KEY_RIGHTALT", "KEY_RIGHTMETA",
+}
+
+var buttonNames = []string{
+	"BTN_LEFT", "BTN_RIGHT", "BTN_MIDDLE", "BTN_SIDE", "BTN_EXTRA",
+}
+
+var usageNames = map[Usage]string{
+	NewUsage(GENERIC_DESKTOP, X):            "X",
+	NewUsage(GENERIC_DESKTOP, Y):            "Y",
+	NewUsage(GENERIC_DESKTOP, 0x32):         "Z",
+	NewUsage(GENERIC_DESKTOP, WHEEL):        "WHEEL",
+	NewUsage(GENERIC_DESKTOP, SYSTEM_POWER): "KEY_POWER",
+	NewUsage(GENERIC_DESKTOP, SYSTEM_SLEEP): "KEY_SLEEP",
+	NewUsage(GENERIC_DESKTOP, SYSTEM_WAKE):  "KEY_WAKEUP",
+	NewUsage(CONSUMER, 0x30):                "KEY_POWER",
+	NewUsage(CONSUMER, 0x6f):                "KEY_BRIGHTNESSUP",
+	NewUsage(CONSUMER, 0x70):                "KEY_BRIGHTNESSDOWN",
+	NewUsage(CONSUMER, 0xb5):                "KEY_NEXTSONG",
+	NewUsage(CONSUMER, 0xb6):                "KEY_PREVIOUSSONG",
+	NewUsage(CONSUMER, 0xb7):                "KEY_STOPCD",
+	NewUsage(CONSUMER, 0xb8):                "KEY_EJECTCD",
+	NewUsage(CONSUMER, 0xcd):                "KEY_PLAYPAUSE",
+	NewUsage(CONSUMER, 0xe2):                "KEY_MUTE",
+	NewUsage(CONSUMER, 0xe9):                "KEY_VOLUMEUP",
+	NewUsage(CONSUMER, 0xea):                "KEY_VOLUMEDOWN",
+	NewUsage(CONSUMER, 0x183):               "KEY_CONFIG",
+	NewUsage(CONSUMER, 0x18a):               "KEY_MAIL",
+	NewUsage(CONSUMER, 0x192):               "KEY_CALC",
+	NewUsage(CONSUMER, 0x194):               "KEY_FILE",
+	NewUsage(CONSUMER, 0x221):               "KEY_SEARCH",
+	NewUsage(CONSUMER, 0x223):               "KEY_HOMEPAGE",
+	NewUsage(CONSUMER, 0x224):               "KEY_BACK",
+	NewUsage(CONSUMER, 0x225):               "KEY_FORWARD",
+	NewUsage(CONSUMER, 0x226):               "KEY_STOP",
+	NewUsage(CONSUMER, 0x227):               "KEY_REFRESH",
+	NewUsage(CONSUMER, 0x22a):               "KEY_BOOKMARKS",
+	NewUsage(CONSUMER, AC_PAN):              "HWHEEL",
+}
+
+// Vendor returns whether the usage belongs to a vendor defined page.
+func (u Usage) Vendor() bool {
+	return u.Page() >= VENDOR_PAGE
+}
+
+// Name returns the usage name, following the Linux input event codes naming
+// for keys and buttons (e.g. KEY_A, BTN_LEFT) and without type prefix for
+// axes (e.g. X, WHEEL), usages without a name are returned in pppp:iiii
+// format.
+func (u Usage) Name() string {
+	id := int(u.ID())
+
+	switch u.Page() {
+	case KEYBOARD_KEYPAD:
+		switch {
+		case id >= KEY_A && id < KEY_A+len(keyNames):
+			return keyNames[id-KEY_A]
+		case id >= 0xe0 && id < 0xe0+len(modifierNames):
+			return modifierNames[id-0xe0]
+		}
+	case BUTTON:
+		switch {
+		case id >= 1 && id <= len(buttonNames):
+			return buttonNames[id-1]
+		case id > len(buttonNames):
+			return fmt.Sprintf("BTN_%d", id)
+		}
+	}
+
+	if name, ok := usageNames[u]; ok {
+		return name
+	}
+
+	return u.String()
+}
@@ -0,0 +1,312 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ExportedDevice represents a device listed by a server.
+type ExportedDevice struct {
+	DeviceInfo
+	Interfaces []InterfaceInfo
+}
+
+func request(rw io.ReadWriter, code uint16, reply uint16, data []byte) (err error) {
+	var hdr OpHeader
+
+	if _, err = rw.Write(marshal(data, OpHeader{Version: USBIP_VERSION, Code: code})); err != nil {
+		return
+	}
+
+	if err = binary.Read(rw, binary.BigEndian, &hdr); err != nil {
+		return
+	}
+
+	switch {
+	case hdr.Version != USBIP_VERSION:
+		return fmt.Errorf("unsupported protocol version %#04x", hdr.Version)
+	case hdr.Code != reply:
+		return fmt.Errorf("invalid reply %#04x", hdr.Code)
+	case hdr.Status != ST_OK:
+		return fmt.Errorf("request failed (status %d)", hdr.Status)
+	}
+
+	return
+}
+
+// DevList returns the devices exported by a server, the connection is then
+// no longer usable.
+func DevList(rw io.ReadWriter) (devices []*ExportedDevice, err error) {
+	var n uint32
+
+	if err = request(rw, OP_REQ_DEVLIST, OP_REP_DEVLIST, nil); err != nil {
+		return
+	}
+
+	if err = binary.Read(rw, binary.BigEndian, &n); err != nil {
+		return
+	}
+
+	if n > maxDevices {
+		return nil, errors.New("too many devices")
+	}
+
+	for i := uint32(0); i < n; i++ {
+		d := &ExportedDevice{}
+
+		if err = binary.Read(rw, binary.BigEndian, &d.DeviceInfo); err != nil {
+			return
+		}
+
+		d.Interfaces = make([]InterfaceInfo, d.NumInterfaces)
+
+		if err = binary.Read(rw, binary.BigEndian, d.Interfaces); err != nil {
+			return
+		}
+
+		devices = append(devices, d)
+	}
+
+	return
+}
+
+// Result represents the completion of a submitted URB.
+type Result struct {
+	// Status is the URB status, negative values are errno codes
+	Status int32
+	// Data holds the IN transfer data
+	Data []byte
+}
+
+type call struct {
+	in     bool
+	result chan *Result
+}
+
+// Client represents the URB traffic of a device imported from a server.
+type Client struct {
+	// Info is the imported device information
+	Info *DeviceInfo
+
+	rw io.ReadWriteCloser
+
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	seq     uint32
+	calls   map[uint32]*call
+	unlinks map[uint32]chan int32
+	err     error
+}
+
+// Import imports a device from a server, the connection is then used for
+// its URB traffic.
+func Import(rw io.ReadWriteCloser, busID string) (c *Client, err error) {
+	if len(busID) == 0 || len(busID) >= busIDSize {
+		return nil, errors.New("invalid bus ID")
+	}
+
+	id := make([]byte, busIDSize)
+	copy(id, busID)
+
+	if err = request(rw, OP_REQ_IMPORT, OP_REP_IMPORT, id); err != nil {
+		return
+	}
+
+	c = &Client{
+		Info:    &DeviceInfo{},
+		rw:      rw,
+		calls:   make(map[uint32]*call),
+		unlinks: make(map[uint32]chan int32),
+	}
+
+	if err = binary.Read(rw, binary.BigEndian, c.Info); err != nil {
+		return nil, err
+	}
+
+	go c.read()
+
+	return
+}
+
+// Close closes the connection, pending URBs complete with ESHUTDOWN status.
+func (c *Client) Close() error {
+	return c.rw.Close()
+}
+
+func (c *Client) in(seq uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call, ok := c.calls[seq]
+
+	return ok && call.in
+}
+
+func (c *Client) read() {
+	for {
+		ret, data, err := readReply(c.rw, c.in)
+
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+
+		c.mu.Lock()
+
+		switch r := ret.(type) {
+		case *RetSubmit:
+			if call, ok := c.calls[r.SeqNum]; ok {
+				delete(c.calls, r.SeqNum)
+				call.result <- &Result{Status: r.Status, Data: data}
+			}
+		case *RetUnlink:
+			if ch, ok := c.unlinks[r.SeqNum]; ok {
+				delete(c.unlinks, r.SeqNum)
+				ch <- r.Status
+			}
+		}
+
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+
+	for seq, call := range c.calls {
+		call.result <- &Result{Status: ESHUTDOWN}
+		delete(c.calls, seq)
+	}
+
+	for seq, ch := range c.unlinks {
+		close(ch)
+		delete(c.unlinks, seq)
+	}
+}
+
+// send registers and writes a URB command.
+func (c *Client) send(hdr *Header, v any, data []byte, register func(seq uint32)) (err error) {
+	c.mu.Lock()
+
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+
+	c.seq++
+	hdr.SeqNum = c.seq
+	hdr.DevID = c.Info.DevID()
+	register(hdr.SeqNum)
+
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_, err = c.rw.Write(marshal(data, v))
+
+	return
+}
+
+// Submit submits a URB to an endpoint, the endpoint address direction bit
+// selects the transfer direction, and returns its sequence number along with
+// a channel which receives its completion.
+func (c *Client) Submit(ep uint8, setup [8]byte, length int, data []byte) (seq uint32, result <-chan *Result, err error) {
+	ch := make(chan *Result, 1)
+	in := ep&0x80 != 0
+
+	cmd := &CmdSubmit{
+		Header: Header{
+			Command:  USBIP_CMD_SUBMIT,
+			Endpoint: uint32(ep & 0x0f),
+		},
+		TransferBufferLength: int32(length),
+		Setup:                setup,
+	}
+
+	if in {
+		cmd.Direction = USBIP_DIR_IN
+		data = nil
+	} else {
+		cmd.TransferBufferLength = int32(len(data))
+	}
+
+	err = c.send(&cmd.Header, cmd, data, func(s uint32) {
+		seq = s
+		c.calls[s] = &call{in: in, result: ch}
+	})
+
+	return seq, ch, err
+}
+
+// Control performs a control transfer on the default endpoint.
+func (c *Client) Control(setup [8]byte, data []byte) (*Result, error) {
+	var ep uint8
+
+	length := int(binary.LittleEndian.Uint16(setup[6:]))
+
+	if setup[0]&0x80 != 0 {
+		ep = 0x80
+	}
+
+	_, result, err := c.Submit(ep, setup, length, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return <-result, nil
+}
+
+// Unlink requests the cancellation of a submitted URB and returns the
+// unlink status, ECONNRESET if the URB was canceled or 0 if it already
+// completed.
+func (c *Client) Unlink(seq uint32) (status int32, err error) {
+	ch := make(chan int32, 1)
+
+	cmd := &CmdUnlink{
+		Header: Header{
+			Command: USBIP_CMD_UNLINK,
+		},
+		UnlinkSeqNum: seq,
+	}
+
+	err = c.send(&cmd.Header, cmd, nil, func(s uint32) {
+		c.unlinks[s] = ch
+	})
+
+	if err != nil {
+		return
+	}
+
+	status, ok := <-ch
+
+	if !ok {
+		return 0, c.err
+	}
+
+	// canceled URBs are not completed by the server
+	if status == ECONNRESET {
+		c.mu.Lock()
+
+		if call, ok := c.calls[seq]; ok {
+			delete(c.calls, seq)
+			call.result <- &Result{Status: ECONNRESET}
+		}
+
+		c.mu.Unlock()
+	}
+
+	return
+}
@@ -0,0 +1,91 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// Standard requests (p250, Table 9-4, USB2.0)
+const (
+	GET_STATUS        = 0
+	SET_ADDRESS       = 5
+	GET_DESCRIPTOR    = 6
+	GET_CONFIGURATION = 8
+	SET_CONFIGURATION = 9
+	SET_INTERFACE     = 11
+)
+
+// HID class requests (p51, 7.2, HID1.11)
+const (
+	SET_REPORT   = 0x09
+	SET_IDLE     = 0x0a
+	SET_PROTOCOL = 0x0b
+)
+
+// Transfer errors, reported to the remote host as URB status.
+var (
+	// ErrStall signals an unsupported request (-EPIPE)
+	ErrStall = errors.New("endpoint stalled")
+	// ErrCanceled signals a transfer canceled by an unlink request
+	// (-ECONNRESET)
+	ErrCanceled = errors.New("transfer canceled")
+	// ErrNoDevice signals a disconnected device (-ENODEV)
+	ErrNoDevice = errors.New("no such device")
+)
+
+// Request represents a transfer request to a device.
+type Request struct {
+	// Endpoint is the endpoint address, including the direction bit
+	Endpoint uint8
+	// Setup is the setup packet of control transfers
+	Setup [8]byte
+	// Length is the transfer buffer length
+	Length int
+	// Data holds the OUT transfer data
+	Data []byte
+}
+
+// Direction returns the transfer direction (usb.IN or usb.OUT).
+func (r *Request) Direction() int {
+	return int(r.Endpoint >> 7)
+}
+
+// Setup packet fields (p248, Table 9-2, USB2.0)
+func (r *Request) requestType() uint8 { return r.Setup[0] }
+func (r *Request) request() uint8     { return r.Setup[1] }
+func (r *Request) value() uint16      { return binary.LittleEndian.Uint16(r.Setup[2:]) }
+func (r *Request) index() uint16      { return binary.LittleEndian.Uint16(r.Setup[4:]) }
+func (r *Request) length() uint16     { return binary.LittleEndian.Uint16(r.Setup[6:]) }
+
+// Device represents a USB device exported by a server.
+type Device interface {
+	// Descriptor returns the device descriptors.
+	Descriptor() *usb.Device
+	// Speed returns the device speed (USB_SPEED_LOW, USB_SPEED_FULL or
+	// USB_SPEED_HIGH).
+	Speed() uint32
+	// Transfer performs a transfer and returns its IN data, it must
+	// return ErrCanceled once cancel is closed.
+	Transfer(req *Request, cancel <-chan struct{}) ([]byte, error)
+}
+
+// status returns the URB status for a transfer error.
+func status(err error) int32 {
+	switch err {
+	case nil:
+		return 0
+	case ErrCanceled:
+		return ECONNRESET
+	case ErrNoDevice:
+		return ENODEV
+	default:
+		return EPIPE
+	}
+}
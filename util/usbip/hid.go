@@ -0,0 +1,170 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// maxQueuedReports is the number of input reports queued for each endpoint.
+const maxQueuedReports = 64
+
+// HIDDevice represents a virtual HID device, which answers standard and HID
+// class control requests from its recorded descriptors and returns queued
+// input reports on its interrupt IN endpoints.
+type HIDDevice struct {
+	desc    *usb.Device
+	device  []byte
+	config  []byte
+	strings map[uint8][]byte
+	reports map[uint8][]byte
+	input   map[uint8]chan []byte
+}
+
+// NewHIDDevice returns a virtual HID device from its device, configuration,
+// serial number string (optional) and HID report descriptors, the latter
+// indexed by interface number.
+func NewHIDDevice(device []byte, config []byte, serial []byte, reports map[uint8][]byte) (d *HIDDevice, err error) {
+	desc, err := usb.ParseDevice(device, config)
+
+	if err != nil {
+		return
+	}
+
+	d = &HIDDevice{
+		desc:    desc,
+		device:  device,
+		config:  config,
+		strings: map[uint8][]byte{0: {4, usb.STRING, 0x09, 0x04}},
+		reports: reports,
+		input:   make(map[uint8]chan []byte),
+	}
+
+	if len(serial) > 0 && desc.Descriptor.SerialNumber != 0 {
+		if desc.Serial, err = usb.ParseString(serial); err != nil {
+			return nil, err
+		}
+
+		d.strings[desc.Descriptor.SerialNumber] = serial
+	}
+
+	for iface, buf := range reports {
+		if err = desc.SetReport(iface, buf); err != nil {
+			return nil, fmt.Errorf("interface %d, %v", iface, err)
+		}
+	}
+
+	for _, iface := range desc.Interfaces() {
+		for _, ep := range iface.Endpoints {
+			if ep.Direction() == usb.IN && ep.TransferType() == usb.INTERRUPT {
+				d.input[ep.EndpointAddress] = make(chan []byte, maxQueuedReports)
+			}
+		}
+	}
+
+	if len(d.input) == 0 {
+		return nil, errors.New("no interrupt IN endpoints")
+	}
+
+	return
+}
+
+// Descriptor returns the device descriptors.
+func (d *HIDDevice) Descriptor() *usb.Device {
+	return d.desc
+}
+
+// Speed returns the device speed.
+func (d *HIDDevice) Speed() uint32 {
+	return USB_SPEED_FULL
+}
+
+// Input queues an input report on an interrupt IN endpoint.
+func (d *HIDDevice) Input(ep uint8, report []byte) error {
+	ch, ok := d.input[ep]
+
+	if !ok {
+		return fmt.Errorf("invalid endpoint %#x", ep)
+	}
+
+	select {
+	case ch <- report:
+		return nil
+	default:
+		return errors.New("input queue full")
+	}
+}
+
+// Transfer performs a transfer.
+func (d *HIDDevice) Transfer(req *Request, cancel <-chan struct{}) (buf []byte, err error) {
+	if req.Endpoint&0x7f == 0 {
+		if buf, err = d.control(req); err == nil && len(buf) > int(req.length()) {
+			buf = buf[:req.length()]
+		}
+
+		return
+	}
+
+	if req.Direction() == usb.OUT {
+		// output reports (e.g. keyboard LEDs) are discarded
+		return
+	}
+
+	ch, ok := d.input[req.Endpoint]
+
+	if !ok {
+		return nil, ErrStall
+	}
+
+	select {
+	case buf = <-ch:
+		if len(buf) > req.Length {
+			buf = buf[:req.Length]
+		}
+
+		return
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+}
+
+func (d *HIDDevice) control(req *Request) ([]byte, error) {
+	typ := uint8(req.value() >> 8)
+	index := uint8(req.value())
+
+	switch {
+	case req.requestType() == 0x80 && req.request() == GET_DESCRIPTOR:
+		switch typ {
+		case usb.DEVICE:
+			return d.device, nil
+		case usb.CONFIGURATION:
+			return d.config, nil
+		case usb.STRING:
+			if s, ok := d.strings[index]; ok {
+				return s, nil
+			}
+		}
+	case req.requestType() == 0x81 && req.request() == GET_DESCRIPTOR && typ == usb.REPORT:
+		if r, ok := d.reports[uint8(req.index())]; ok {
+			return r, nil
+		}
+	case req.requestType() == 0x80 && req.request() == GET_STATUS:
+		return []byte{0, 0}, nil
+	case req.requestType() == 0x80 && req.request() == GET_CONFIGURATION:
+		return []byte{d.desc.Configuration.ConfigurationValue}, nil
+	case req.requestType() == 0x00 && (req.request() == SET_CONFIGURATION || req.request() == SET_ADDRESS):
+		return nil, nil
+	case req.requestType() == 0x01 && req.request() == SET_INTERFACE:
+		return nil, nil
+	case req.requestType() == 0x21 && (req.request() == SET_IDLE || req.request() == SET_PROTOCOL || req.request() == SET_REPORT):
+		return nil, nil
+	}
+
+	return nil, ErrStall
+}
@@ -0,0 +1,322 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package usbip implements the USB/IP protocol (Linux
+// Documentation/usb/usbip_protocol.rst), with a server which exports devices
+// to remote hosts and passes all device data through the USB packet filter
+// (see filter.Filter), a client and a virtual HID device.
+//
+// The package is pure Go and suitable for host side tools.
+package usbip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// USBIP_VERSION is the supported protocol version.
+const USBIP_VERSION = 0x0111
+
+// Operation codes
+const (
+	OP_REQ_DEVLIST = 0x8005
+	OP_REP_DEVLIST = 0x0005
+	OP_REQ_IMPORT  = 0x8003
+	OP_REP_IMPORT  = 0x0003
+)
+
+// URB commands
+const (
+	USBIP_CMD_SUBMIT = 0x00000001
+	USBIP_CMD_UNLINK = 0x00000002
+	USBIP_RET_SUBMIT = 0x00000003
+	USBIP_RET_UNLINK = 0x00000004
+)
+
+// URB directions
+const (
+	USBIP_DIR_OUT = 0
+	USBIP_DIR_IN  = 1
+)
+
+// Operation status values
+const (
+	ST_OK    = 0
+	ST_NA    = 1
+	ST_ERROR = 2
+)
+
+// Device speeds (enum usb_device_speed)
+const (
+	USB_SPEED_LOW  = 1
+	USB_SPEED_FULL = 2
+	USB_SPEED_HIGH = 3
+)
+
+// URB status values, as negative Linux errno codes.
+const (
+	EPIPE      = -32
+	ENODEV     = -19
+	ECONNRESET = -104
+	ESHUTDOWN  = -108
+)
+
+const (
+	pathSize  = 256
+	busIDSize = 32
+
+	// maxTransferLength is the maximum URB transfer buffer length
+	maxTransferLength = 1 << 16
+	// maxDevices is the maximum number of devices in a list reply
+	maxDevices = 256
+)
+
+// OpHeader represents the common header of operation requests and replies.
+type OpHeader struct {
+	Version uint16
+	Code    uint16
+	Status  uint32
+}
+
+// DeviceInfo represents an exported device (struct usbip_usb_device).
+type DeviceInfo struct {
+	Path               [pathSize]byte
+	BusID              [busIDSize]byte
+	BusNum             uint32
+	DevNum             uint32
+	Speed              uint32
+	VendorId           uint16
+	ProductId          uint16
+	BcdDevice          uint16
+	DeviceClass        uint8
+	DeviceSubClass     uint8
+	DeviceProtocol     uint8
+	ConfigurationValue uint8
+	NumConfigurations  uint8
+	NumInterfaces      uint8
+}
+
+// InterfaceInfo represents an interface of an exported device (struct
+// usbip_usb_interface).
+type InterfaceInfo struct {
+	InterfaceClass    uint8
+	InterfaceSubClass uint8
+	InterfaceProtocol uint8
+	Padding           uint8
+}
+
+// NewDeviceInfo returns the exported device information for a device.
+func NewDeviceInfo(busID string, busNum uint32, devNum uint32, speed uint32, d *usb.Device) (info *DeviceInfo, ifaces []InterfaceInfo) {
+	info = &DeviceInfo{
+		BusNum:            busNum,
+		DevNum:            devNum,
+		Speed:             speed,
+		VendorId:          d.Descriptor.VendorId,
+		ProductId:         d.Descriptor.ProductId,
+		BcdDevice:         d.Descriptor.Device,
+		DeviceClass:       d.Descriptor.DeviceClass,
+		DeviceSubClass:    d.Descriptor.DeviceSubClass,
+		DeviceProtocol:    d.Descriptor.DeviceProtocol,
+		NumConfigurations: d.Descriptor.NumConfigurations,
+	}
+
+	copy(info.Path[:pathSize-1], "/sys/devices/usbip/"+busID)
+	copy(info.BusID[:busIDSize-1], busID)
+
+	if d.Configuration != nil {
+		info.ConfigurationValue = d.Configuration.ConfigurationValue
+	}
+
+	for _, iface := range d.Interfaces() {
+		// only the default alternate setting is listed
+		if iface.AlternateSetting != 0 {
+			continue
+		}
+
+		ifaces = append(ifaces, InterfaceInfo{
+			InterfaceClass:    iface.InterfaceClass,
+			InterfaceSubClass: iface.InterfaceSubClass,
+			InterfaceProtocol: iface.InterfaceProtocol,
+		})
+	}
+
+	info.NumInterfaces = uint8(len(ifaces))
+
+	return
+}
+
+// ID returns the device bus ID.
+func (d *DeviceInfo) ID() string {
+	return cString(d.BusID[:])
+}
+
+// DevID returns the device identifier used in URB headers.
+func (d *DeviceInfo) DevID() uint32 {
+	return d.BusNum<<16 | d.DevNum
+}
+
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	return strings.Clone(string(buf))
+}
+
+// Header represents the common header of URB commands and replies (struct
+// usbip_header_basic).
+type Header struct {
+	Command   uint32
+	SeqNum    uint32
+	DevID     uint32
+	Direction uint32
+	Endpoint  uint32
+}
+
+// CmdSubmit represents a USBIP_CMD_SUBMIT command.
+type CmdSubmit struct {
+	Header
+
+	TransferFlags        uint32
+	TransferBufferLength int32
+	StartFrame           int32
+	NumberOfPackets      int32
+	Interval             int32
+	Setup                [8]byte
+}
+
+// RetSubmit represents a USBIP_RET_SUBMIT reply.
+type RetSubmit struct {
+	Header
+
+	Status          int32
+	ActualLength    int32
+	StartFrame      int32
+	NumberOfPackets int32
+	ErrorCount      int32
+	Padding         [8]byte
+}
+
+// CmdUnlink represents a USBIP_CMD_UNLINK command.
+type CmdUnlink struct {
+	Header
+
+	UnlinkSeqNum uint32
+	Padding      [24]byte
+}
+
+// RetUnlink represents a USBIP_RET_UNLINK reply.
+type RetUnlink struct {
+	Header
+
+	Status  int32
+	Padding [24]byte
+}
+
+// URB headers share the same size
+const urbHeaderSize = 48
+
+// readCommand reads a URB command, along with any OUT data.
+func readCommand(r io.Reader) (cmd any, data []byte, err error) {
+	buf := make([]byte, urbHeaderSize)
+
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+
+	switch binary.BigEndian.Uint32(buf) {
+	case USBIP_CMD_SUBMIT:
+		c := &CmdSubmit{}
+
+		if err = binary.Read(bytes.NewReader(buf), binary.BigEndian, c); err != nil {
+			return
+		}
+
+		if c.TransferBufferLength < 0 || c.TransferBufferLength > maxTransferLength {
+			return nil, nil, errors.New("invalid transfer buffer length")
+		}
+
+		if c.NumberOfPackets > 0 {
+			return nil, nil, errors.New("isochronous transfers are not supported")
+		}
+
+		if c.Direction == USBIP_DIR_OUT && c.TransferBufferLength > 0 {
+			data = make([]byte, c.TransferBufferLength)
+
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+		}
+
+		cmd = c
+	case USBIP_CMD_UNLINK:
+		c := &CmdUnlink{}
+		err = binary.Read(bytes.NewReader(buf), binary.BigEndian, c)
+		cmd = c
+	default:
+		err = errors.New("invalid command")
+	}
+
+	return
+}
+
+// readReply reads a URB reply, along with any IN data, as replies do not
+// carry the URB direction it is resolved by sequence number.
+func readReply(r io.Reader, in func(seq uint32) bool) (ret any, data []byte, err error) {
+	buf := make([]byte, urbHeaderSize)
+
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+
+	switch binary.BigEndian.Uint32(buf) {
+	case USBIP_RET_SUBMIT:
+		rs := &RetSubmit{}
+
+		if err = binary.Read(bytes.NewReader(buf), binary.BigEndian, rs); err != nil {
+			return
+		}
+
+		if rs.ActualLength < 0 || rs.ActualLength > maxTransferLength {
+			return nil, nil, errors.New("invalid actual length")
+		}
+
+		if rs.ActualLength > 0 && in(rs.SeqNum) {
+			data = make([]byte, rs.ActualLength)
+
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+		}
+
+		ret = rs
+	case USBIP_RET_UNLINK:
+		ru := &RetUnlink{}
+		err = binary.Read(bytes.NewReader(buf), binary.BigEndian, ru)
+		ret = ru
+	default:
+		err = errors.New("invalid reply")
+	}
+
+	return
+}
+
+// marshal encodes protocol structures, followed by any data.
+func marshal(data []byte, v ...any) []byte {
+	buf := new(bytes.Buffer)
+
+	for _, s := range v {
+		binary.Write(buf, binary.BigEndian, s)
+	}
+
+	buf.Write(data)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,452 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/watch"
+)
+
+// exportBus is the bus number reported for exported devices.
+const exportBus = 1
+
+// export represents an exported device.
+type export struct {
+	info   *DeviceInfo
+	ifaces []InterfaceInfo
+	dev    Device
+
+	imported bool
+	conn     io.Closer
+}
+
+// Server represents a USB/IP server, the data of imported devices is passed
+// through a USB packet filter before reaching the remote host.
+type Server struct {
+	// Filter is the USB packet filter, the device policy and endorsement
+	// tokens are evaluated on import while interrupt and bulk IN data is
+	// submitted for a verdict, blocked packets are dropped and the
+	// transfer is resubmitted.
+	Filter *filter.Filter
+	// Watcher, if not nil, tracks imported devices so that they are
+	// detached once their endorsement expires or is revoked (see
+	// Unbind()).
+	Watcher *watch.Watcher
+
+	start   time.Time
+	mu      sync.Mutex
+	exports []*export
+}
+
+// NewServer returns a USB/IP server with the argument packet filter, no
+// filtering is performed if nil.
+func NewServer(f *filter.Filter) *Server {
+	return &Server{
+		Filter: f,
+		start:  time.Now(),
+	}
+}
+
+// Export makes a device available for import by remote hosts.
+func (s *Server) Export(busID string, dev Device) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(busID) == 0 || len(busID) >= busIDSize {
+		return errors.New("invalid bus ID")
+	}
+
+	for _, e := range s.exports {
+		if e.info.ID() == busID {
+			return fmt.Errorf("bus ID %s already exported", busID)
+		}
+	}
+
+	// device number 1 is the root hub
+	info, ifaces := NewDeviceInfo(busID, exportBus, uint32(len(s.exports)+2), dev.Speed(), dev.Descriptor())
+
+	s.exports = append(s.exports, &export{
+		info:   info,
+		ifaces: ifaces,
+		dev:    dev,
+	})
+
+	return
+}
+
+// Serve accepts and serves connections until the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection, it returns after an operation
+// request has been handled or, after a successful import, once the
+// connection is closed.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	var hdr OpHeader
+
+	defer conn.Close()
+
+	if err := binary.Read(conn, binary.BigEndian, &hdr); err != nil {
+		return
+	}
+
+	if hdr.Version != USBIP_VERSION {
+		log.Printf("[USBIP] unsupported protocol version %#04x", hdr.Version)
+		return
+	}
+
+	switch hdr.Code {
+	case OP_REQ_DEVLIST:
+		s.devList(conn)
+	case OP_REQ_IMPORT:
+		e, err := s.importDevice(conn)
+
+		if err != nil {
+			log.Printf("[USBIP] import failed, %v", err)
+			return
+		}
+
+		if e == nil {
+			return
+		}
+
+		log.Printf("[USBIP] imported busid=%s vid=%04x pid=%04x", e.info.ID(), e.info.VendorId, e.info.ProductId)
+
+		s.mu.Lock()
+		e.conn = conn
+		s.mu.Unlock()
+
+		s.track(e)
+
+		sess := &session{
+			server:  s,
+			export:  e,
+			conn:    conn,
+			pending: make(map[uint32]chan struct{}),
+		}
+
+		sess.run()
+
+		if s.Watcher != nil {
+			s.Watcher.Release(e.info.ID())
+		}
+
+		s.mu.Lock()
+		e.imported = false
+		e.conn = nil
+		s.mu.Unlock()
+
+		log.Printf("[USBIP] released busid=%s", e.info.ID())
+	default:
+		log.Printf("[USBIP] invalid operation %#04x", hdr.Code)
+	}
+}
+
+// track registers an imported device with the watcher, if any.
+func (s *Server) track(e *export) {
+	if s.Watcher == nil {
+		return
+	}
+
+	dev, err := endorsement.Identity(e.dev.Descriptor())
+
+	if err != nil {
+		log.Printf("[USBIP] busid=%s not tracked, %v", e.info.ID(), err)
+		return
+	}
+
+	s.Watcher.Attach(e.info.ID(), dev)
+}
+
+// Unbind closes the connection of an imported device, which releases it, it
+// implements the watch.Transport interface.
+//
+// The device remains exported, a new import is subject to the packet filter
+// device policy and endorsement tokens, while its data is subject to the
+// endorsement check.
+func (s *Server) Unbind(busID string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.exports {
+		if e.info.ID() == busID && e.conn != nil {
+			err = e.conn.Close()
+		}
+	}
+
+	return
+}
+
+// Detach implements the watch.Transport interface, the remote host loses an
+// imported device once its connection is closed by Unbind(), therefore no
+// further action is required.
+func (s *Server) Detach(busID string) error {
+	return nil
+}
+
+func (s *Server) devList(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hdr := OpHeader{Version: USBIP_VERSION, Code: OP_REP_DEVLIST, Status: ST_OK}
+	v := []any{hdr, uint32(len(s.exports))}
+
+	for _, e := range s.exports {
+		v = append(v, e.info, e.ifaces)
+	}
+
+	w.Write(marshal(nil, v...))
+}
+
+// importDevice handles an import request and returns the imported device, or
+// nil if the request is rejected.
+func (s *Server) importDevice(rw io.ReadWriter) (e *export, err error) {
+	busID := make([]byte, busIDSize)
+
+	if _, err = io.ReadFull(rw, busID); err != nil {
+		return
+	}
+
+	id := cString(busID)
+	e = s.claim(id)
+
+	if e == nil {
+		log.Printf("[USBIP] import rejected busid=%s", id)
+		_, err = rw.Write(marshal(nil, OpHeader{Version: USBIP_VERSION, Code: OP_REP_IMPORT, Status: ST_NA}))
+		return
+	}
+
+	_, err = rw.Write(marshal(nil, OpHeader{Version: USBIP_VERSION, Code: OP_REP_IMPORT, Status: ST_OK}, e.info))
+
+	return
+}
+
+// claim returns an exported device, not already imported and allowed by
+// policy, and marks it as imported.
+func (s *Server) claim(busID string) *export {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.exports {
+		if e.info.ID() != busID || e.imported {
+			continue
+		}
+
+		if s.Filter != nil && s.Filter.Policy != nil && !s.Filter.Allow(e.dev.Descriptor()) {
+			return nil
+		}
+
+		if s.Filter != nil {
+			if err := s.Filter.Authorize(e.dev.Descriptor()); err != nil {
+				log.Printf("[USBIP] busid=%s, %v", busID, err)
+				return nil
+			}
+		}
+
+		e.imported = true
+
+		return e
+	}
+
+	return nil
+}
+
+// permitted submits device data to the packet filter.
+func (s *Server) permitted(d *usb.Device, ep uint8, data []byte) bool {
+	if s.Filter == nil {
+		return true
+	}
+
+	pkt := filter.Packet{
+		Time:     time.Since(s.start),
+		Endpoint: ep,
+		Data:     data,
+	}
+
+	// the filter is shared across connections
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Filter.Handle(d, []filter.Packet{pkt})[0]
+}
+
+// session represents the URB traffic of an imported device.
+type session struct {
+	server *Server
+	export *export
+	conn   io.ReadWriter
+
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	pending map[uint32]chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (ss *session) write(buf []byte) {
+	ss.wmu.Lock()
+	defer ss.wmu.Unlock()
+
+	ss.conn.Write(buf)
+}
+
+func (ss *session) run() {
+	defer func() {
+		ss.mu.Lock()
+
+		for seq, cancel := range ss.pending {
+			close(cancel)
+			delete(ss.pending, seq)
+		}
+
+		ss.mu.Unlock()
+		ss.wg.Wait()
+	}()
+
+	for {
+		cmd, data, err := readCommand(ss.conn)
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[USBIP] busid=%s, %v", ss.export.info.ID(), err)
+			}
+
+			return
+		}
+
+		switch c := cmd.(type) {
+		case *CmdSubmit:
+			cancel := make(chan struct{})
+
+			ss.mu.Lock()
+			ss.pending[c.SeqNum] = cancel
+			ss.mu.Unlock()
+
+			ss.wg.Add(1)
+			go ss.submit(c, data, cancel)
+		case *CmdUnlink:
+			ss.unlink(c)
+		}
+	}
+}
+
+// complete removes a pending URB and returns whether it was still pending,
+// unlinked URBs are not completed.
+func (ss *session) complete(seq uint32) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.pending[seq]; !ok {
+		return false
+	}
+
+	delete(ss.pending, seq)
+
+	return true
+}
+
+func (ss *session) submit(c *CmdSubmit, out []byte, cancel chan struct{}) {
+	var in []byte
+	var err error
+
+	defer ss.wg.Done()
+
+	req := &Request{
+		Endpoint: uint8(c.Endpoint & 0x0f),
+		Setup:    c.Setup,
+		Length:   int(c.TransferBufferLength),
+		Data:     out,
+	}
+
+	if c.Direction == USBIP_DIR_IN {
+		req.Endpoint |= 0x80
+	}
+
+	dev := ss.export.dev
+
+	for {
+		in, err = dev.Transfer(req, cancel)
+
+		// control transfers are not filtered, as they carry the
+		// device descriptors already evaluated by policy
+		if err != nil || req.Direction() == usb.OUT || req.Endpoint&0x7f == 0 {
+			break
+		}
+
+		if ss.server.permitted(dev.Descriptor(), req.Endpoint, in) {
+			break
+		}
+	}
+
+	if !ss.complete(c.SeqNum) {
+		return
+	}
+
+	ret := RetSubmit{
+		Header: Header{
+			Command: USBIP_RET_SUBMIT,
+			SeqNum:  c.SeqNum,
+		},
+		Status: status(err),
+	}
+
+	switch {
+	case err != nil:
+		in = nil
+	case req.Direction() == usb.IN:
+		if len(in) > req.Length {
+			in = in[:req.Length]
+		}
+
+		ret.ActualLength = int32(len(in))
+	default:
+		ret.ActualLength = int32(len(out))
+		in = nil
+	}
+
+	ss.write(marshal(in, ret))
+}
+
+func (ss *session) unlink(c *CmdUnlink) {
+	ret := RetUnlink{
+		Header: Header{
+			Command: USBIP_RET_UNLINK,
+			SeqNum:  c.SeqNum,
+		},
+	}
+
+	ss.mu.Lock()
+
+	// URBs which already completed are reported with status 0
+	if cancel, ok := ss.pending[c.UnlinkSeqNum]; ok {
+		close(cancel)
+		delete(ss.pending, c.UnlinkSeqNum)
+		ret.Status = ECONNRESET
+	}
+
+	ss.mu.Unlock()
+
+	ss.write(marshal(nil, ret))
+}
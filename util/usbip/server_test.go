@@ -0,0 +1,359 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+	"github.com/usbarmory/GoTEE-example/util/filter"
+	"github.com/usbarmory/GoTEE-example/util/usb"
+	"github.com/usbarmory/GoTEE-example/util/watch"
+)
+
+const testTimeout = 5 * time.Second
+
+// keyboardEP is the interrupt IN endpoint of the recorded boot keyboard.
+const keyboardEP = 0x81
+
+func newKeyboard(t *testing.T) (d *HIDDevice, device []byte, config []byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "USBIP", "descriptors", "boot_keyboard.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec usb.Recording
+
+	if err = json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+
+	device, config, serial, reports, err := rec.Descriptors()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err = NewHIDDevice(device, config, serial, reports); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+// dial serves a loopback connection and returns its client end.
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.ServeConn(server)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		client.Close()
+
+		select {
+		case <-done:
+		case <-time.After(testTimeout):
+			t.Error("server did not release connection")
+		}
+	})
+
+	client.SetDeadline(time.Now().Add(testTimeout))
+
+	return client
+}
+
+func getDescriptor(typ uint8, length uint16) (setup [8]byte) {
+	setup[0] = 0x80
+	setup[1] = GET_DESCRIPTOR
+	setup[3] = typ
+	binary.LittleEndian.PutUint16(setup[6:], length)
+
+	return
+}
+
+func wait(t *testing.T, result <-chan *Result) *Result {
+	t.Helper()
+
+	select {
+	case res := <-result:
+		return res
+	case <-time.After(testTimeout):
+		t.Fatal("URB not completed")
+	}
+
+	return nil
+}
+
+func TestDevList(t *testing.T) {
+	d, _, _ := newKeyboard(t)
+
+	s := NewServer(nil)
+
+	if err := s.Export("1-1", d); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Export("1-1", d); err == nil {
+		t.Error("duplicate bus ID exported")
+	}
+
+	devices, err := DevList(dial(t, s))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("%d devices, want 1", len(devices))
+	}
+
+	dev := devices[0]
+	desc := d.Descriptor().Descriptor
+
+	if dev.ID() != "1-1" || dev.VendorId != desc.VendorId || dev.ProductId != desc.ProductId {
+		t.Errorf("device %s %04x:%04x, want 1-1 %04x:%04x", dev.ID(), dev.VendorId, dev.ProductId, desc.VendorId, desc.ProductId)
+	}
+
+	if dev.Speed != USB_SPEED_FULL {
+		t.Errorf("speed %d, want %d", dev.Speed, USB_SPEED_FULL)
+	}
+
+	if len(dev.Interfaces) != 1 || dev.Interfaces[0].InterfaceClass != 0x03 {
+		t.Errorf("interfaces %+v, want one HID interface", dev.Interfaces)
+	}
+}
+
+func TestImport(t *testing.T) {
+	d, device, config := newKeyboard(t)
+
+	s := NewServer(nil)
+
+	if err := s.Export("1-1", d); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(dial(t, s), "2-1"); err == nil {
+		t.Error("unknown bus ID imported")
+	}
+
+	c, err := Import(dial(t, s), "1-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if c.Info.ID() != "1-1" {
+		t.Errorf("imported %s, want 1-1", c.Info.ID())
+	}
+
+	if _, err := Import(dial(t, s), "1-1"); err == nil {
+		t.Error("device imported twice")
+	}
+
+	for _, tc := range []struct {
+		typ  uint8
+		want []byte
+	}{
+		{usb.DEVICE, device},
+		{usb.CONFIGURATION, config},
+	} {
+		res, err := c.Control(getDescriptor(tc.typ, 0xff), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Status != 0 || !bytes.Equal(res.Data, tc.want) {
+			t.Errorf("descriptor %d: status %d data %x, want %x", tc.typ, res.Status, res.Data, tc.want)
+		}
+	}
+
+	// truncated to the requested length
+	if res, err := c.Control(getDescriptor(usb.DEVICE, 8), nil); err != nil || !bytes.Equal(res.Data, device[:8]) {
+		t.Errorf("truncated descriptor: %x (%v), want %x", res.Data, err, device[:8])
+	}
+
+	// unsupported requests stall
+	if res, err := c.Control([8]byte{0xc0, 0xff}, nil); err != nil || res.Status == 0 {
+		t.Errorf("vendor request: status %d (%v), want stall", res.Status, err)
+	}
+}
+
+func TestSubmitUnlink(t *testing.T) {
+	d, _, _ := newKeyboard(t)
+
+	s := NewServer(nil)
+
+	if err := s.Export("1-1", d); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Import(dial(t, s), "1-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// 'a' key press
+	report := []byte{0, 0, 0x04, 0, 0, 0, 0, 0}
+
+	if err = d.Input(keyboardEP, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Input(0x82, report); err == nil {
+		t.Error("report queued on invalid endpoint")
+	}
+
+	seq, result, err := c.Submit(keyboardEP, [8]byte{}, 8, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := wait(t, result); res.Status != 0 || !bytes.Equal(res.Data, report) {
+		t.Errorf("input report: status %d data %x, want %x", res.Status, res.Data, report)
+	}
+
+	// completed URBs are unlinked with status 0
+	if status, err := c.Unlink(seq); err != nil || status != 0 {
+		t.Errorf("completed URB unlink: status %d (%v), want 0", status, err)
+	}
+
+	// pending URBs are canceled
+	seq, result, err = c.Submit(keyboardEP, [8]byte{}, 8, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, err := c.Unlink(seq); err != nil || status != ECONNRESET {
+		t.Errorf("pending URB unlink: status %d (%v), want %d", status, err, ECONNRESET)
+	}
+
+	if res := wait(t, result); res.Status != ECONNRESET {
+		t.Errorf("canceled URB: status %d, want %d", res.Status, ECONNRESET)
+	}
+
+	// output reports (e.g. LEDs) are accepted
+	_, result, err = c.Submit(0x01, [8]byte{}, 0, []byte{0x01})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait(t, result)
+
+	// URBs pending on close complete with ESHUTDOWN
+	_, result, err = c.Submit(keyboardEP, [8]byte{}, 8, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+
+	if res := wait(t, result); res.Status != ESHUTDOWN {
+		t.Errorf("URB pending on close: status %d, want %d", res.Status, ESHUTDOWN)
+	}
+}
+
+func TestImportPolicy(t *testing.T) {
+	d, _, _ := newKeyboard(t)
+
+	p, err := usb.ParsePolicy("deny has interface 03:01:01\nallow any")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(filter.New(nil, p))
+
+	if err := s.Export("1-1", d); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(dial(t, s), "1-1"); err == nil {
+		t.Error("device denied by policy imported")
+	}
+}
+
+func TestWatcherDetach(t *testing.T) {
+	var audit bytes.Buffer
+
+	d, _, _ := newKeyboard(t)
+
+	dev, err := endorsement.Identity(d.Descriptor())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := endorsement.NewCache()
+	cache.Add(endorsement.Grant{Device: dev.DeviceID, Budget: endorsement.Unlimited})
+
+	s := NewServer(nil)
+	s.Watcher = watch.NewWatcher(cache, s, &audit)
+
+	if err := s.Export("1-1", d); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Import(dial(t, s), "1-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	_, result, err := c.Submit(keyboardEP, [8]byte{}, 8, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if detached := s.Watcher.Check(); len(detached) != 0 {
+		t.Fatalf("endorsed device detached %v", detached)
+	}
+
+	if err = cache.Revoke(dev.DeviceID); err != nil {
+		t.Fatal(err)
+	}
+
+	if detached := s.Watcher.Check(); len(detached) != 1 || detached[0] != "1-1" {
+		t.Fatalf("detached %v, want [1-1]", detached)
+	}
+
+	if res := wait(t, result); res.Status != ESHUTDOWN {
+		t.Errorf("URB pending on detach: status %d, want %d", res.Status, ESHUTDOWN)
+	}
+
+	if !bytes.Contains(audit.Bytes(), []byte(`"busid":"1-1"`)) {
+		t.Errorf("audit log %q, want 1-1 record", audit.String())
+	}
+}
@@ -0,0 +1,495 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// USBFSDevices is the Linux usbfs path of USB devices.
+const USBFSDevices = "/dev/bus/usb"
+
+// Standard request and feature selector (p250, Table 9-4 and 9-6, USB2.0)
+const (
+	CLEAR_FEATURE = 1
+	ENDPOINT_HALT = 0
+)
+
+// usbfs ioctl request encoding (see asm-generic/ioctl.h)
+const (
+	iocNone  = 0
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func ioc(dir uintptr, nr uintptr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'U'<<8 | nr
+}
+
+// usbfs ioctl requests (see linux/usbdevice_fs.h)
+var (
+	usbdevfsSetInterface     = ioc(iocRead, 4, unsafe.Sizeof(setInterface{}))
+	usbdevfsSubmitURB        = ioc(iocRead, 10, unsafe.Sizeof(urb{}))
+	usbdevfsDiscardURB       = ioc(iocNone, 11, 0)
+	usbdevfsReapURBNDelay    = ioc(iocWrite, 13, unsafe.Sizeof(uintptr(0)))
+	usbdevfsClaimInterface   = ioc(iocRead, 15, unsafe.Sizeof(uint32(0)))
+	usbdevfsReleaseInterface = ioc(iocRead, 16, unsafe.Sizeof(uint32(0)))
+	usbdevfsIoctl            = ioc(iocRead|iocWrite, 18, unsafe.Sizeof(ifaceIoctl{}))
+	usbdevfsClearHalt        = ioc(iocRead, 21, unsafe.Sizeof(uint32(0)))
+	usbdevfsDisconnect       = ioc(iocNone, 22, 0)
+	usbdevfsConnect          = ioc(iocNone, 23, 0)
+)
+
+// usbfs URB types
+const (
+	urbTypeInterrupt = 1
+	urbTypeControl   = 2
+	urbTypeBulk      = 3
+)
+
+// setInterface represents struct usbdevfs_setinterface.
+type setInterface struct {
+	Interface  uint32
+	AltSetting uint32
+}
+
+// ifaceIoctl represents struct usbdevfs_ioctl.
+type ifaceIoctl struct {
+	Interface int32
+	Code      int32
+	Data      unsafe.Pointer
+}
+
+// urb represents struct usbdevfs_urb.
+type urb struct {
+	Type            uint8
+	Endpoint        uint8
+	Status          int32
+	Flags           uint32
+	Buffer          unsafe.Pointer
+	BufferLength    int32
+	ActualLength    int32
+	StartFrame      int32
+	NumberOfPackets int32
+	ErrorCount      int32
+	SignalNumber    uint32
+	UserContext     uintptr
+}
+
+// transfer represents a URB submitted to usbfs, its address is returned by
+// the kernel on completion. The URB and its buffer are pinned until then.
+type transfer struct {
+	urb    urb
+	buf    []byte
+	err    error
+	pinner runtime.Pinner
+	done   chan struct{}
+}
+
+// USBFSDevice represents a physical USB device connected to a Linux host,
+// accessed through usbfs. All its interfaces are detached from their kernel
+// drivers and claimed until the device is closed.
+//
+// Control transfers are passed to the device except for SET_CONFIGURATION,
+// as the active configuration is retained, SET_INTERFACE and
+// CLEAR_FEATURE(ENDPOINT_HALT), which are performed with the matching usbfs
+// requests. Isochronous transfers are not supported.
+type USBFSDevice struct {
+	desc   *usb.Device
+	speed  uint32
+	ifaces []uint8
+
+	file *os.File
+	conn syscall.RawConn
+
+	mu      sync.Mutex
+	pending map[uintptr]*transfer
+	err     error
+}
+
+func readSysfs(path string, name string) (string, error) {
+	buf, err := os.ReadFile(filepath.Join(path, name))
+	return strings.TrimSpace(string(buf)), err
+}
+
+func usbfsPath(path string) (string, error) {
+	var num [2]int
+
+	for i, name := range []string{"busnum", "devnum"} {
+		s, err := readSysfs(path, name)
+
+		if err != nil {
+			return "", err
+		}
+
+		if num[i], err = strconv.Atoi(s); err != nil {
+			return "", fmt.Errorf("invalid %s", name)
+		}
+	}
+
+	return fmt.Sprintf("%s/%03d/%03d", USBFSDevices, num[0], num[1]), nil
+}
+
+// usbfsSpeed returns the USB/IP speed of a sysfs speed value (in Mbps).
+func usbfsSpeed(s string) (uint32, error) {
+	switch s {
+	case "1.5":
+		return USB_SPEED_LOW, nil
+	case "12":
+		return USB_SPEED_FULL, nil
+	case "480":
+		return USB_SPEED_HIGH, nil
+	default:
+		return 0, fmt.Errorf("unsupported speed (%s Mbps)", s)
+	}
+}
+
+// usbfsError returns the transfer error of a usbfs error number.
+func usbfsError(errno syscall.Errno) error {
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENODEV, syscall.ESHUTDOWN:
+		return ErrNoDevice
+	case syscall.ENOENT, syscall.ECONNRESET:
+		return ErrCanceled
+	case syscall.EPIPE:
+		return ErrStall
+	default:
+		return errno
+	}
+}
+
+// OpenUSBFS opens a device connected to a Linux host, identified by its bus
+// ID (e.g. 1-1), and claims all its interfaces. Its descriptors, including
+// HID report descriptors, are read from sysfs (see usb.ReadSysfs) before its
+// kernel drivers are detached.
+func OpenUSBFS(busID string) (d *USBFSDevice, err error) {
+	path := filepath.Join(usb.SysfsDevices, busID)
+
+	d = &USBFSDevice{
+		pending: make(map[uintptr]*transfer),
+	}
+
+	if d.desc, err = usb.ReadSysfs(busID); err != nil {
+		return nil, err
+	}
+
+	speed, err := readSysfs(path, "speed")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.speed, err = usbfsSpeed(speed); err != nil {
+		return nil, err
+	}
+
+	dev, err := usbfsPath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// usbfs supports polling, so that completions are reaped through the
+	// runtime poller
+	if d.file, err = os.OpenFile(dev, os.O_RDWR, 0); err != nil {
+		return nil, err
+	}
+
+	if d.conn, err = d.file.SyscallConn(); err != nil {
+		d.file.Close()
+		return nil, err
+	}
+
+	for _, iface := range d.desc.Interfaces() {
+		if iface.AlternateSetting != 0 {
+			continue
+		}
+
+		if err = d.claim(iface.InterfaceNumber); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("interface %d, %v", iface.InterfaceNumber, err)
+		}
+	}
+
+	go d.reap()
+
+	return
+}
+
+func (d *USBFSDevice) ioctl(req uintptr, arg unsafe.Pointer) (err error) {
+	cerr := d.conn.Control(func(fd uintptr) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+			err = errno
+		}
+	})
+
+	if cerr != nil {
+		return ErrNoDevice
+	}
+
+	return
+}
+
+// claim detaches an interface from its kernel driver, if any, and claims it.
+func (d *USBFSDevice) claim(n uint8) (err error) {
+	num := uint32(n)
+
+	disconnect := ifaceIoctl{
+		Interface: int32(n),
+		Code:      int32(usbdevfsDisconnect),
+	}
+
+	// ENODATA signals that no driver is bound
+	if err = d.ioctl(usbdevfsIoctl, unsafe.Pointer(&disconnect)); err != nil && err != syscall.ENODATA {
+		return
+	}
+
+	if err = d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&num)); err != nil {
+		return
+	}
+
+	d.ifaces = append(d.ifaces, n)
+
+	return nil
+}
+
+// Close releases all interfaces, reattaches their kernel drivers and closes
+// the device, pending transfers complete with ErrNoDevice.
+func (d *USBFSDevice) Close() error {
+	for _, n := range d.ifaces {
+		num := uint32(n)
+
+		connect := ifaceIoctl{
+			Interface: int32(n),
+			Code:      int32(usbdevfsConnect),
+		}
+
+		d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&num))
+		d.ioctl(usbdevfsIoctl, unsafe.Pointer(&connect))
+	}
+
+	d.ifaces = nil
+
+	return d.file.Close()
+}
+
+// reap waits for URB completions until the device is disconnected or closed.
+func (d *USBFSDevice) reap() {
+	var p uintptr
+
+	// usbfs signals completed URBs as writable
+	d.conn.Write(func(fd uintptr) bool {
+		for {
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, usbdevfsReapURBNDelay, uintptr(unsafe.Pointer(&p)))
+
+			switch errno {
+			case 0:
+				d.complete(p, nil)
+			case syscall.EINTR:
+			case syscall.EAGAIN:
+				return false
+			default:
+				return true
+			}
+		}
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = ErrNoDevice
+
+	for p, t := range d.pending {
+		delete(d.pending, p)
+		t.err = ErrNoDevice
+		t.pinner.Unpin()
+		close(t.done)
+	}
+}
+
+func (d *USBFSDevice) complete(p uintptr, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.pending[p]
+
+	if !ok {
+		return
+	}
+
+	delete(d.pending, p)
+
+	t.err = err
+	t.pinner.Unpin()
+	close(t.done)
+}
+
+func (d *USBFSDevice) submit(t *transfer) (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if len(t.buf) > 0 {
+		t.urb.Buffer = unsafe.Pointer(&t.buf[0])
+		t.urb.BufferLength = int32(len(t.buf))
+		t.pinner.Pin(&t.buf[0])
+	}
+
+	t.pinner.Pin(t)
+
+	if err = d.ioctl(usbdevfsSubmitURB, unsafe.Pointer(&t.urb)); err != nil {
+		t.pinner.Unpin()
+
+		if errno, ok := err.(syscall.Errno); ok {
+			err = usbfsError(errno)
+		}
+
+		return
+	}
+
+	d.pending[uintptr(unsafe.Pointer(&t.urb))] = t
+
+	return
+}
+
+// Descriptor returns the device descriptors.
+func (d *USBFSDevice) Descriptor() *usb.Device {
+	return d.desc
+}
+
+// Speed returns the device speed.
+func (d *USBFSDevice) Speed() uint32 {
+	return d.speed
+}
+
+// endpointType returns the URB type of an endpoint.
+func (d *USBFSDevice) endpointType(addr uint8) (typ uint8, err error) {
+	for _, iface := range d.desc.Interfaces() {
+		for _, ep := range iface.Endpoints {
+			if ep.EndpointAddress != addr {
+				continue
+			}
+
+			switch ep.TransferType() {
+			case usb.INTERRUPT:
+				return urbTypeInterrupt, nil
+			case usb.BULK:
+				return urbTypeBulk, nil
+			}
+		}
+	}
+
+	return 0, ErrStall
+}
+
+// standard performs the control requests which cannot be passed to the
+// device, it returns false for all other requests.
+func (d *USBFSDevice) standard(req *Request) (ok bool, err error) {
+	switch {
+	case req.requestType() == 0x00 && (req.request() == SET_CONFIGURATION || req.request() == SET_ADDRESS):
+		return true, nil
+	case req.requestType() == 0x01 && req.request() == SET_INTERFACE:
+		arg := setInterface{
+			Interface:  uint32(req.index()),
+			AltSetting: uint32(req.value()),
+		}
+
+		err = d.ioctl(usbdevfsSetInterface, unsafe.Pointer(&arg))
+	case req.requestType() == 0x02 && req.request() == CLEAR_FEATURE && req.value() == ENDPOINT_HALT:
+		ep := uint32(req.index() & 0xff)
+		err = d.ioctl(usbdevfsClearHalt, unsafe.Pointer(&ep))
+	default:
+		return false, nil
+	}
+
+	if errno, ok := err.(syscall.Errno); ok {
+		err = usbfsError(errno)
+	}
+
+	return true, err
+}
+
+// Transfer performs a transfer.
+func (d *USBFSDevice) Transfer(req *Request, cancel <-chan struct{}) (buf []byte, err error) {
+	t := &transfer{
+		urb: urb{
+			Endpoint: req.Endpoint,
+		},
+		done: make(chan struct{}),
+	}
+
+	off := 0
+
+	if req.Endpoint&0x7f == 0 {
+		if ok, err := d.standard(req); ok {
+			return nil, err
+		}
+
+		// the setup packet precedes the transfer data
+		off = len(req.Setup)
+
+		t.urb.Type = urbTypeControl
+		t.buf = make([]byte, off+int(req.length()))
+		copy(t.buf, req.Setup[:])
+	} else {
+		if t.urb.Type, err = d.endpointType(req.Endpoint); err != nil {
+			return
+		}
+
+		t.buf = make([]byte, req.Length)
+	}
+
+	if req.Direction() == usb.OUT {
+		copy(t.buf[off:], req.Data)
+	}
+
+	if err = d.submit(t); err != nil {
+		return
+	}
+
+	select {
+	case <-t.done:
+	case <-cancel:
+		d.ioctl(usbdevfsDiscardURB, unsafe.Pointer(&t.urb))
+		<-t.done
+	}
+
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	if err = usbfsError(syscall.Errno(-t.urb.Status)); err != nil {
+		return
+	}
+
+	n := int(t.urb.ActualLength)
+
+	if n < 0 || off+n > len(t.buf) {
+		return nil, errors.New("invalid transfer length")
+	}
+
+	if req.Direction() == usb.IN {
+		buf = t.buf[off : off+n]
+	}
+
+	return
+}
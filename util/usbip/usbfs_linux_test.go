@@ -0,0 +1,78 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbip
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestUSBFSRequests(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("request numbers are checked on 64-bit hosts")
+	}
+
+	// values from linux/usbdevice_fs.h on 64-bit hosts
+	for _, tc := range []struct {
+		name string
+		req  uintptr
+		want uintptr
+	}{
+		{"SETINTERFACE", usbdevfsSetInterface, 0x80085504},
+		{"SUBMITURB", usbdevfsSubmitURB, 0x8038550a},
+		{"DISCARDURB", usbdevfsDiscardURB, 0x0000550b},
+		{"REAPURBNDELAY", usbdevfsReapURBNDelay, 0x4008550d},
+		{"CLAIMINTERFACE", usbdevfsClaimInterface, 0x8004550f},
+		{"RELEASEINTERFACE", usbdevfsReleaseInterface, 0x80045510},
+		{"IOCTL", usbdevfsIoctl, 0xc0105512},
+		{"CLEAR_HALT", usbdevfsClearHalt, 0x80045515},
+		{"DISCONNECT", usbdevfsDisconnect, 0x00005516},
+		{"CONNECT", usbdevfsConnect, 0x00005517},
+	} {
+		if tc.req != tc.want {
+			t.Errorf("USBDEVFS_%s %#x, want %#x", tc.name, tc.req, tc.want)
+		}
+	}
+}
+
+func TestUSBFSError(t *testing.T) {
+	for _, tc := range []struct {
+		errno syscall.Errno
+		want  error
+	}{
+		{0, nil},
+		{syscall.ENODEV, ErrNoDevice},
+		{syscall.ESHUTDOWN, ErrNoDevice},
+		{syscall.ENOENT, ErrCanceled},
+		{syscall.ECONNRESET, ErrCanceled},
+		{syscall.EPIPE, ErrStall},
+		{syscall.EPROTO, syscall.EPROTO},
+	} {
+		if err := usbfsError(tc.errno); !errors.Is(err, tc.want) || (tc.want == nil) != (err == nil) {
+			t.Errorf("%v: %v, want %v", tc.errno, err, tc.want)
+		}
+	}
+}
+
+func TestUSBFSSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		speed string
+		want  uint32
+	}{
+		{"1.5", USB_SPEED_LOW},
+		{"12", USB_SPEED_FULL},
+		{"480", USB_SPEED_HIGH},
+		{"5000", 0},
+	} {
+		speed, err := usbfsSpeed(tc.speed)
+
+		if speed != tc.want || (tc.want == 0) != (err != nil) {
+			t.Errorf("%s Mbps: %d %v, want %d", tc.speed, speed, err, tc.want)
+		}
+	}
+}
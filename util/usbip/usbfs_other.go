@@ -0,0 +1,29 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+
+package usbip
+
+import (
+	"errors"
+)
+
+// USBFSDevice represents a physical USB device connected to a Linux host,
+// accessed through usbfs.
+type USBFSDevice struct {
+	Device
+}
+
+// OpenUSBFS opens a device connected to a Linux host, it is only supported
+// on Linux.
+func OpenUSBFS(busID string) (*USBFSDevice, error) {
+	return nil, errors.New("physical devices are only supported on Linux")
+}
+
+// Close closes the device.
+func (d *USBFSDevice) Close() error {
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbmon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// GET_DESCRIPTOR request (p251, Table 9-4, USB2.0)
+const getDescriptor = 6
+
+// Address represents the bus and device number of a device.
+type Address struct {
+	Bus    uint16
+	Device uint8
+}
+
+// String returns the address in bus/device format, as in /dev/bus/usb paths.
+func (a Address) String() string {
+	return fmt.Sprintf("%03d/%03d", a.Bus, a.Device)
+}
+
+// Payload represents the data of a transfer.
+type Payload struct {
+	// Time is the transfer completion (IN) or submission (OUT) time
+	Time time.Time
+	// TransferType is the transfer type
+	TransferType int
+	// Endpoint is the endpoint address, including the direction bit
+	Endpoint uint8
+	// Data is the transfer data
+	Data []byte
+}
+
+// Direction returns the transfer direction (usb.IN or usb.OUT).
+func (p *Payload) Direction() int {
+	return int(p.Endpoint >> 7)
+}
+
+// Device represents a device observed in a capture.
+type Device struct {
+	Address
+
+	// Descriptor is the device descriptor returned to the host, if
+	// captured
+	Descriptor []byte
+	// Configuration is the last complete configuration descriptor
+	// returned to the host, if captured
+	Configuration []byte
+	// Strings holds the string descriptors returned to the host, by
+	// index
+	Strings map[uint8][]byte
+	// Reports holds the HID report descriptors returned to the host, by
+	// interface number
+	Reports map[uint8][]byte
+
+	// Payloads holds the data of interrupt and bulk transfers, in
+	// capture order
+	Payloads []Payload
+}
+
+// Parse returns the parsed descriptors of the device, including its serial
+// number and HID report descriptors when captured.
+func (d *Device) Parse() (ud *usb.Device, err error) {
+	if d.Descriptor == nil || d.Configuration == nil {
+		return nil, errors.New("descriptors not captured")
+	}
+
+	if ud, err = usb.ParseDevice(d.Descriptor, d.Configuration); err != nil {
+		return
+	}
+
+	if serial, ok := d.Strings[ud.Descriptor.SerialNumber]; ok && ud.Descriptor.SerialNumber != 0 {
+		if ud.Serial, err = usb.ParseString(serial); err != nil {
+			return nil, err
+		}
+	}
+
+	for iface, report := range d.Reports {
+		if err = ud.SetReport(iface, report); err != nil {
+			return nil, fmt.Errorf("interface %d, %v", iface, err)
+		}
+	}
+
+	return
+}
+
+// Capture represents the devices observed in a usbmon capture.
+type Capture struct {
+	// Start is the time of the first event
+	Start time.Time
+	// Devices holds all observed devices, in order of first appearance
+	Devices []*Device
+
+	// devices maps addresses to their current device
+	devices map[Address]*Device
+	// submissions holds pending control submissions by URB tag
+	submissions map[uint64]*Packet
+}
+
+// ReadCapture reads a usbmon pcap capture.
+func ReadCapture(r io.Reader) (c *Capture, err error) {
+	pr, err := NewReader(r)
+
+	if err != nil {
+		return
+	}
+
+	c = &Capture{
+		devices:     make(map[Address]*Device),
+		submissions: make(map[uint64]*Packet),
+	}
+
+	for {
+		p, err := pr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Start.IsZero() {
+			c.Start = p.Time
+		}
+
+		c.add(p)
+	}
+
+	return
+}
+
+// Device returns the last device observed at a given address.
+func (c *Capture) Device(a Address) *Device {
+	return c.devices[a]
+}
+
+func (c *Capture) device(a Address) *Device {
+	d, ok := c.devices[a]
+
+	if !ok {
+		d = &Device{
+			Address: a,
+			Strings: make(map[uint8][]byte),
+			Reports: make(map[uint8][]byte),
+		}
+
+		c.devices[a] = d
+		c.Devices = append(c.Devices, d)
+	}
+
+	return d
+}
+
+func (c *Capture) add(p *Packet) {
+	a := Address{p.Bus, p.Device}
+
+	switch p.TransferType {
+	case usb.CONTROL:
+		switch {
+		case p.Event == SUBMISSION && p.Setup != nil:
+			c.submissions[p.ID] = p
+		case p.Event == CALLBACK:
+			if s, ok := c.submissions[p.ID]; ok {
+				delete(c.submissions, p.ID)
+
+				if p.Status == 0 && len(p.Data) > 0 {
+					c.descriptor(a, s.Setup, p.Data)
+				}
+			}
+		}
+	case usb.INTERRUPT, usb.BULK:
+		in := p.Direction() == usb.IN
+
+		// IN data is captured on callback, OUT data on submission
+		switch {
+		case len(p.Data) == 0:
+			return
+		case in && (p.Event != CALLBACK || p.Status != 0):
+			return
+		case !in && p.Event != SUBMISSION:
+			return
+		}
+
+		d := c.device(a)
+		d.Payloads = append(d.Payloads, Payload{
+			Time:         p.Time,
+			TransferType: p.TransferType,
+			Endpoint:     p.Endpoint,
+			Data:         p.Data,
+		})
+	}
+}
+
+// descriptor records the result of a GET_DESCRIPTOR request.
+func (c *Capture) descriptor(a Address, setup []byte, data []byte) {
+	// standard (device) or interface IN request
+	if setup[0]&0x80 == 0 || setup[0]&0x60 != 0 || setup[1] != getDescriptor {
+		return
+	}
+
+	index := setup[2]
+	typ := setup[3]
+	wIndex := binary.LittleEndian.Uint16(setup[4:])
+
+	switch typ {
+	case usb.DEVICE:
+		if len(data) < usb.DEVICE_LENGTH {
+			return
+		}
+
+		d := c.device(a)
+
+		// a different descriptor on the same address is a new device
+		if d.Descriptor != nil && !bytes.Equal(d.Descriptor, data) {
+			delete(c.devices, a)
+			d = c.device(a)
+		}
+
+		d.Descriptor = data
+	case usb.CONFIGURATION:
+		if len(data) < usb.CONFIGURATION_LENGTH || len(data) < int(binary.LittleEndian.Uint16(data[2:])) {
+			return
+		}
+
+		c.device(a).Configuration = data
+	case usb.STRING:
+		if index != 0 {
+			c.device(a).Strings[index] = data
+		}
+	case usb.REPORT:
+		c.device(a).Reports[uint8(wIndex)] = data
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package usbmon implements a reader for Linux usbmon captures in pcap format
+// (e.g. as recorded by Wireshark or tcpdump on a usbmonX interface), along
+// with the extraction of per-device descriptors and transfer payloads.
+//
+// The package is pure Go and suitable for use on both the Normal World and
+// host side tools.
+package usbmon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// pcap link types
+const (
+	LINKTYPE_USB_LINUX         = 189
+	LINKTYPE_USB_LINUX_MMAPPED = 220
+)
+
+// pcap magic numbers
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+)
+
+const (
+	fileHeaderSize   = 24
+	recordHeaderSize = 16
+
+	// maxRecordSize is the maximum size of a captured packet
+	maxRecordSize = 1 << 20
+)
+
+// usbmon header sizes (Documentation/usb/usbmon.rst)
+const (
+	headerSize        = 48
+	mmappedHeaderSize = 64
+	isoDescriptorSize = 16
+)
+
+// usbmon event types
+const (
+	SUBMISSION = 'S'
+	CALLBACK   = 'C'
+	ERROR      = 'E'
+)
+
+// usbmon transfer types, which differ from the endpoint descriptor ones
+var transferTypes = []int{
+	usb.ISOCHRONOUS,
+	usb.INTERRUPT,
+	usb.CONTROL,
+	usb.BULK,
+}
+
+// Packet represents a usbmon event.
+type Packet struct {
+	// ID is the URB tag, shared by its submission and callback events
+	ID uint64
+	// Time is the event time
+	Time time.Time
+	// Event is the event type (SUBMISSION, CALLBACK or ERROR)
+	Event byte
+	// TransferType is the transfer type (usb.CONTROL, usb.ISOCHRONOUS,
+	// usb.BULK or usb.INTERRUPT)
+	TransferType int
+	// Endpoint is the endpoint address, including the direction bit
+	Endpoint uint8
+	// Device is the device number
+	Device uint8
+	// Bus is the bus number
+	Bus uint16
+	// Status is the URB status, negative values are errno codes
+	Status int32
+	// Length is the URB length
+	Length uint32
+	// Setup is the setup packet of control submissions, if any
+	Setup []byte
+	// Data is the captured URB data
+	Data []byte
+}
+
+// Direction returns the transfer direction (usb.IN or usb.OUT).
+func (p *Packet) Direction() int {
+	return int(p.Endpoint >> 7)
+}
+
+// Reader represents a usbmon pcap capture reader.
+type Reader struct {
+	// LinkType is the capture link type
+	LinkType uint32
+
+	r     io.Reader
+	order binary.ByteOrder
+	nano  bool
+}
+
+// NewReader returns a reader for a usbmon pcap capture, after validating its
+// file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	hdr := make([]byte, fileHeaderSize)
+
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("invalid pcap header, %v", err)
+	}
+
+	pr := &Reader{
+		r: r,
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr) {
+		case magicMicroseconds:
+			pr.order = order
+		case magicNanoseconds:
+			pr.order = order
+			pr.nano = true
+		}
+
+		if pr.order != nil {
+			break
+		}
+	}
+
+	if pr.order == nil {
+		return nil, errors.New("invalid pcap magic")
+	}
+
+	pr.LinkType = pr.order.Uint32(hdr[20:]) & 0xffff
+
+	if pr.LinkType != LINKTYPE_USB_LINUX && pr.LinkType != LINKTYPE_USB_LINUX_MMAPPED {
+		return nil, fmt.Errorf("unsupported link type %d", pr.LinkType)
+	}
+
+	return pr, nil
+}
+
+// Next returns the next capture event, io.EOF is returned at the end of the
+// capture.
+func (r *Reader) Next() (p *Packet, err error) {
+	rec := make([]byte, recordHeaderSize)
+
+	if _, err = io.ReadFull(r.r, rec); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated record header")
+		}
+
+		return
+	}
+
+	sec := r.order.Uint32(rec[0:])
+	frac := r.order.Uint32(rec[4:])
+	n := r.order.Uint32(rec[8:])
+
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("invalid record size %d", n)
+	}
+
+	buf := make([]byte, n)
+
+	if _, err = io.ReadFull(r.r, buf); err != nil {
+		return nil, errors.New("truncated record")
+	}
+
+	if !r.nano {
+		frac *= 1000
+	}
+
+	if p, err = r.parse(buf); err != nil {
+		return
+	}
+
+	p.Time = time.Unix(int64(sec), int64(frac))
+
+	return
+}
+
+// parse parses a usbmon header (struct mon_bin_hdr), along with its data.
+func (r *Reader) parse(buf []byte) (p *Packet, err error) {
+	size := headerSize
+
+	if r.LinkType == LINKTYPE_USB_LINUX_MMAPPED {
+		size = mmappedHeaderSize
+	}
+
+	if len(buf) < size {
+		return nil, errors.New("invalid usbmon header size")
+	}
+
+	xfer := int(buf[9])
+
+	if xfer >= len(transferTypes) {
+		return nil, fmt.Errorf("invalid transfer type %d", xfer)
+	}
+
+	p = &Packet{
+		ID:           r.order.Uint64(buf[0:]),
+		Event:        buf[8],
+		TransferType: transferTypes[xfer],
+		Endpoint:     buf[10],
+		Device:       buf[11],
+		Bus:          r.order.Uint16(buf[12:]),
+		Status:       int32(r.order.Uint32(buf[28:])),
+		Length:       r.order.Uint32(buf[32:]),
+	}
+
+	// setup flag is 0 when the setup packet is present
+	if buf[14] == 0 {
+		p.Setup = buf[40:48]
+	}
+
+	data := buf[size:]
+
+	// isochronous descriptors precede data in memory mapped captures
+	if r.LinkType == LINKTYPE_USB_LINUX_MMAPPED && p.TransferType == usb.ISOCHRONOUS {
+		n := int(r.order.Uint32(buf[60:])) * isoDescriptorSize
+
+		if n > len(data) {
+			return nil, errors.New("invalid isochronous descriptors")
+		}
+
+		data = data[n:]
+	}
+
+	// data flag is 0 when data is present
+	if buf[15] == 0 && len(data) > 0 {
+		p.Data = data
+	}
+
+	return
+}
@@ -0,0 +1,245 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usbmon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/usbarmory/GoTEE-example/util/usb"
+)
+
+// capture is a memory mapped (LINKTYPE_USB_LINUX_MMAPPED) keyboard capture.
+var capture = filepath.Join("testdata", "keyboard_capture.pcap")
+
+func readTestdata(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(capture)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// toLinuxHeader converts a little-endian memory mapped capture to the
+// LINKTYPE_USB_LINUX header layout, which lacks the trailing 16 bytes of
+// isochronous fields.
+func toLinuxHeader(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	hdr := bytes.Clone(data[:fileHeaderSize])
+	binary.LittleEndian.PutUint32(hdr[20:], LINKTYPE_USB_LINUX)
+	out.Write(hdr)
+
+	for off := fileHeaderSize; off < len(data); {
+		rec := bytes.Clone(data[off : off+recordHeaderSize])
+		n := int(binary.LittleEndian.Uint32(rec[8:]))
+		buf := data[off+recordHeaderSize : off+recordHeaderSize+n]
+
+		if buf[9] == 0 {
+			t.Fatal("isochronous transfer in capture")
+		}
+
+		binary.LittleEndian.PutUint32(rec[8:], uint32(n-(mmappedHeaderSize-headerSize)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(n-(mmappedHeaderSize-headerSize)))
+
+		out.Write(rec)
+		out.Write(buf[:headerSize])
+		out.Write(buf[mmappedHeaderSize:])
+
+		off += recordHeaderSize + n
+	}
+
+	return out.Bytes()
+}
+
+func readAll(t *testing.T, data []byte) (r *Reader, packets []*Packet) {
+	t.Helper()
+
+	r, err := NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		p, err := r.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packets = append(packets, p)
+	}
+
+	return
+}
+
+func TestReader(t *testing.T) {
+	data := readTestdata(t)
+
+	mmapped, packets := readAll(t, data)
+
+	if mmapped.LinkType != LINKTYPE_USB_LINUX_MMAPPED {
+		t.Errorf("link type %d, want %d", mmapped.LinkType, LINKTYPE_USB_LINUX_MMAPPED)
+	}
+
+	if len(packets) != 84 {
+		t.Fatalf("%d packets, want 84", len(packets))
+	}
+
+	// GET_DESCRIPTOR(DEVICE) submission
+	p := packets[0]
+
+	if p.Event != SUBMISSION || p.TransferType != usb.CONTROL || p.Endpoint != 0x80 || p.Bus != 1 || p.Device != 6 {
+		t.Errorf("first packet %+v, want control submission to 001/006", p)
+	}
+
+	if want := []byte{0x80, 0x06, 0x00, 0x01, 0x00, 0x00, 0x12, 0x00}; !bytes.Equal(p.Setup, want) {
+		t.Errorf("setup %x, want %x", p.Setup, want)
+	}
+
+	if p.Direction() != usb.IN || p.Length != 18 || p.Data != nil {
+		t.Errorf("direction:%d length:%d data:%x, want IN 18 bytes without data", p.Direction(), p.Length, p.Data)
+	}
+
+	if callback := packets[1]; callback.Event != CALLBACK || callback.ID != p.ID || len(callback.Data) != 18 || callback.Data[1] != usb.DEVICE {
+		t.Errorf("second packet %+v, want device descriptor callback", callback)
+	}
+
+	linux, linuxPackets := readAll(t, toLinuxHeader(t, data))
+
+	if linux.LinkType != LINKTYPE_USB_LINUX {
+		t.Errorf("link type %d, want %d", linux.LinkType, LINKTYPE_USB_LINUX)
+	}
+
+	if !reflect.DeepEqual(packets, linuxPackets) {
+		t.Error("LINKTYPE_USB_LINUX packets differ from memory mapped ones")
+	}
+}
+
+func TestReadCapture(t *testing.T) {
+	c, err := ReadCapture(bytes.NewReader(readTestdata(t)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		addr       Address
+		vid, pid   uint16
+		interfaces int
+		payloads   int
+	}{
+		{Address{1, 6}, 0x046d, 0xc31c, 2, 6},
+		{Address{1, 5}, 0x046d, 0xc53f, 3, 0},
+		{Address{1, 3}, 0x0b05, 0x19b6, 3, 7},
+	} {
+		d := c.Device(tc.addr)
+
+		if d == nil {
+			t.Errorf("%s: not captured", tc.addr)
+			continue
+		}
+
+		ud, err := d.Parse()
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.addr, err)
+			continue
+		}
+
+		if ud.Descriptor.VendorId != tc.vid || ud.Descriptor.ProductId != tc.pid || len(ud.Interfaces()) != tc.interfaces {
+			t.Errorf("%s: %04x:%04x interfaces:%d, want %04x:%04x interfaces:%d", tc.addr,
+				ud.Descriptor.VendorId, ud.Descriptor.ProductId, len(ud.Interfaces()), tc.vid, tc.pid, tc.interfaces)
+		}
+
+		if len(d.Payloads) != tc.payloads {
+			t.Errorf("%s: %d payloads, want %d", tc.addr, len(d.Payloads), tc.payloads)
+		}
+	}
+
+	// keyboard input reports ('s' key press and release)
+	keyboard := c.Device(Address{1, 6}).Payloads
+
+	for i, want := range [][]byte{
+		{0, 0, 0x16, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if p := keyboard[i]; p.Endpoint != 0x81 || p.TransferType != usb.INTERRUPT || !bytes.Equal(p.Data, want) {
+			t.Errorf("payload %d: ep:%#x data:%x, want ep:0x81 data:%x", i, p.Endpoint, p.Data, want)
+		}
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	data := readTestdata(t)
+
+	badLinkType := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(badLinkType[20:], 1)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated file header", data[:fileHeaderSize-1]},
+		{"garbage", bytes.Repeat([]byte{0xa5}, 256)},
+		{"link type", badLinkType},
+	} {
+		if _, err := NewReader(bytes.NewReader(tc.data)); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+
+		if _, err := ReadCapture(bytes.NewReader(tc.data)); err == nil {
+			t.Errorf("%s: capture accepted", tc.name)
+		}
+	}
+
+	// first record length
+	n := int(binary.LittleEndian.Uint32(data[fileHeaderSize+8:]))
+	first := fileHeaderSize + recordHeaderSize + n
+
+	oversized := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(oversized[fileHeaderSize+8:], maxRecordSize+1)
+
+	shortHeader := bytes.Clone(data[:first])
+	binary.LittleEndian.PutUint32(shortHeader[fileHeaderSize+8:], headerSize)
+	shortHeader = shortHeader[:fileHeaderSize+recordHeaderSize+headerSize]
+
+	badTransfer := bytes.Clone(data)
+	badTransfer[fileHeaderSize+recordHeaderSize+9] = 0xff
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"truncated record header", data[:first+recordHeaderSize/2]},
+		{"truncated record", data[:len(data)-1]},
+		{"oversized record", oversized},
+		{"short usbmon header", shortHeader},
+		{"transfer type", badTransfer},
+	} {
+		if _, err := ReadCapture(bytes.NewReader(tc.data)); err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("%s: error %v, want invalid capture", tc.name, err)
+		}
+	}
+}
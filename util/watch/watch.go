@@ -0,0 +1,266 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package watch implements the revocation watcher, which tracks devices
+// attached over USB/IP and detaches them once their endorsement expires or is
+// revoked.
+//
+// The package is pure Go, USB/IP operations are performed through a
+// Transport so that the watcher logic can be exercised without USB/IP
+// drivers.
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// Transport represents the USB/IP operations required to detach a device.
+type Transport interface {
+	// Unbind releases a device from the exporting side USB/IP driver.
+	Unbind(busID string) error
+	// Detach requests the importing side to detach a device.
+	Detach(busID string) error
+}
+
+// Endorsements represents the source of device endorsements (e.g.
+// endorsement.Cache).
+type Endorsements interface {
+	// Lookup returns the endorsement applicable to a device, if present.
+	Lookup(d *endorsement.Device) (endorsement.Entry, bool)
+}
+
+// Record represents the audit record of a detached device.
+type Record struct {
+	// Time is the detach time (Unix seconds)
+	Time int64 `json:"time"`
+	// BusID is the device bus ID
+	BusID string `json:"busid"`
+	// Device is the device identity in vid:pid:serial format
+	Device string `json:"device"`
+	// Status is the endorsement status which triggered the detach
+	Status endorsement.Status `json:"status"`
+	// Reason describes why the device is no longer permitted
+	Reason string `json:"reason"`
+	// Error holds any unbind or detach error
+	Error string `json:"error,omitempty"`
+}
+
+// Watcher represents a revocation watcher.
+type Watcher struct {
+	// Endorsements is the endorsement source
+	Endorsements Endorsements
+	// Tokens holds the endorsement tokens, token verification is
+	// disabled if nil
+	Tokens *endorsement.Tokens
+	// Transport performs USB/IP operations
+	Transport Transport
+	// Audit receives audit records in JSON lines format, if not nil
+	Audit io.Writer
+
+	// Nanotime returns the time source for audit records, in nanoseconds
+	// since the Unix epoch, it defaults to time.Now().
+	Nanotime func() int64
+
+	mu       sync.Mutex
+	attached map[string]endorsement.Device
+}
+
+// NewWatcher returns a revocation watcher.
+func NewWatcher(e Endorsements, t Transport, audit io.Writer) *Watcher {
+	return &Watcher{
+		Endorsements: e,
+		Transport:    t,
+		Audit:        audit,
+		attached:     make(map[string]endorsement.Device),
+	}
+}
+
+// now returns the current time in Unix seconds.
+func (w *Watcher) now() int64 {
+	if w.Nanotime == nil {
+		return time.Now().Unix()
+	}
+
+	return w.Nanotime() / int64(time.Second)
+}
+
+// Attach tracks an attached device.
+func (w *Watcher) Attach(busID string, d endorsement.Device) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.attached[busID]; !ok {
+		log.Printf("[WATCH] tracking busid=%s dev=%s", busID, d.DeviceID)
+	}
+
+	w.attached[busID] = d
+}
+
+// Release stops tracking a device, when no longer attached.
+func (w *Watcher) Release(busID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.attached[busID]; ok {
+		log.Printf("[WATCH] released busid=%s", busID)
+		delete(w.attached, busID)
+	}
+}
+
+// Sync replaces the tracked devices with the argument ones, indexed by bus
+// ID.
+func (w *Watcher) Sync(devices map[string]endorsement.Device) {
+	for busID := range w.Attached() {
+		if _, ok := devices[busID]; !ok {
+			w.Release(busID)
+		}
+	}
+
+	for busID, d := range devices {
+		w.Attach(busID, d)
+	}
+}
+
+// Attached returns the tracked devices, indexed by bus ID.
+func (w *Watcher) Attached() map[string]endorsement.Device {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	devices := make(map[string]endorsement.Device, len(w.attached))
+
+	for busID, d := range w.attached {
+		devices[busID] = d
+	}
+
+	return devices
+}
+
+// permitted returns the endorsement status of a device along with the reason
+// why it is no longer permitted, if so.
+func (w *Watcher) permitted(d *endorsement.Device) (status endorsement.Status, reason string) {
+	e, ok := w.Endorsements.Lookup(d)
+
+	if !ok {
+		return endorsement.Unknown, "not endorsed"
+	}
+
+	if e.Status != endorsement.Active {
+		return e.Status, "endorsement " + e.Status.String()
+	}
+
+	if w.Tokens != nil {
+		if _, err := w.Tokens.Permit(d); err != nil {
+			return e.Status, err.Error()
+		}
+	}
+
+	return e.Status, ""
+}
+
+// Check evaluates the endorsement of all tracked devices, devices no longer
+// permitted are unbound and detached and returned by bus ID.
+//
+// Devices which cannot be unbound remain tracked, so that the operation is
+// retried on the next check.
+func (w *Watcher) Check() (detached []string) {
+	devices := w.Attached()
+
+	for busID, d := range devices {
+		status, reason := w.permitted(&d)
+
+		if reason == "" {
+			continue
+		}
+
+		if err := w.detach(busID, d, status, reason); err != nil {
+			continue
+		}
+
+		w.mu.Lock()
+		delete(w.attached, busID)
+		w.mu.Unlock()
+
+		detached = append(detached, busID)
+	}
+
+	sort.Strings(detached)
+
+	return
+}
+
+func (w *Watcher) detach(busID string, d endorsement.Device, status endorsement.Status, reason string) (err error) {
+	r := &Record{
+		Time:   w.now(),
+		BusID:  busID,
+		Device: d.Key(),
+		Status: status,
+		Reason: reason,
+	}
+
+	log.Printf("[WATCH] detaching busid=%s dev=%s (%s)", busID, d.DeviceID, reason)
+
+	if err = w.Transport.Unbind(busID); err != nil {
+		err = errors.New("unbind failed, " + err.Error())
+	} else if detachErr := w.Transport.Detach(busID); detachErr != nil {
+		// the device is no longer exported, the importing side
+		// loses it regardless
+		r.Error = "detach failed, " + detachErr.Error()
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if r.Error != "" {
+		log.Printf("[WATCH] busid=%s, %s", busID, r.Error)
+	}
+
+	w.audit(r)
+
+	return
+}
+
+func (w *Watcher) audit(r *Record) {
+	if w.Audit == nil {
+		return
+	}
+
+	buf, err := json.Marshal(r)
+
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err = w.Audit.Write(append(buf, '\n')); err != nil {
+		log.Printf("[WATCH] could not write audit record, %v", err)
+	}
+}
+
+// Run checks tracked devices at every interval until the stop channel is
+// closed.
+func (w *Watcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Check()
+		case <-stop:
+			return
+		}
+	}
+}
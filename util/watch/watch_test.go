@@ -0,0 +1,280 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/usbarmory/GoTEE-example/util/endorsement"
+)
+
+// fakeTransport records USB/IP operations, failing unbinds of the bus IDs
+// in the fail set.
+type fakeTransport struct {
+	unbound  []string
+	detached []string
+	fail     map[string]bool
+}
+
+func (t *fakeTransport) Unbind(busID string) error {
+	if t.fail[busID] {
+		return errors.New("device busy")
+	}
+
+	t.unbound = append(t.unbound, busID)
+
+	return nil
+}
+
+func (t *fakeTransport) Detach(busID string) error {
+	t.detached = append(t.detached, busID)
+	return nil
+}
+
+// clock represents a settable time source.
+type clock struct {
+	now int64
+}
+
+func (c *clock) Nanotime() int64 {
+	return c.now * int64(time.Second)
+}
+
+var (
+	keyboard = endorsement.Device{DeviceID: endorsement.DeviceID{VendorID: 0x1a86, ProductID: 0xe026}}
+	receiver = endorsement.Device{DeviceID: endorsement.DeviceID{VendorID: 0x046d, ProductID: 0xc53f, Serial: "1234"}}
+)
+
+func newWatcher(t *testing.T) (w *Watcher, cache *endorsement.Cache, transport *fakeTransport, audit *bytes.Buffer, c *clock) {
+	t.Helper()
+
+	c = &clock{now: 1000}
+
+	cache = endorsement.NewCache()
+	cache.Nanotime = c.Nanotime
+
+	transport = &fakeTransport{fail: make(map[string]bool)}
+	audit = &bytes.Buffer{}
+
+	w = NewWatcher(cache, transport, audit)
+	w.Nanotime = c.Nanotime
+
+	for _, d := range []endorsement.Device{keyboard, receiver} {
+		cache.Add(endorsement.Grant{
+			Device:   d.DeviceID,
+			Lifetime: time.Hour,
+			Budget:   endorsement.Unlimited,
+		})
+	}
+
+	w.Attach("1-1", keyboard)
+	w.Attach("1-2", receiver)
+
+	return
+}
+
+func records(t *testing.T, audit *bytes.Buffer) (records []Record) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(bytes.NewReader(audit.Bytes()))
+
+	for scanner.Scan() {
+		var r Record
+
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit record, %v", err)
+		}
+
+		records = append(records, r)
+	}
+
+	return
+}
+
+func checkDetached(t *testing.T, w *Watcher, transport *fakeTransport, audit *bytes.Buffer, busID string, dev string, status endorsement.Status) {
+	t.Helper()
+
+	if detached := w.Check(); !slices.Equal(detached, []string{busID}) {
+		t.Fatalf("detached %v, want [%s]", detached, busID)
+	}
+
+	if !slices.Equal(transport.unbound, []string{busID}) || !slices.Equal(transport.detached, []string{busID}) {
+		t.Errorf("unbound %v detached %v, want [%s]", transport.unbound, transport.detached, busID)
+	}
+
+	if _, ok := w.Attached()[busID]; ok {
+		t.Errorf("%s still tracked", busID)
+	}
+
+	recs := records(t, audit)
+
+	if len(recs) != 1 {
+		t.Fatalf("%d audit records, want 1", len(recs))
+	}
+
+	r := recs[0]
+
+	if r.BusID != busID || r.Device != dev || r.Status != status || r.Reason == "" || r.Error != "" {
+		t.Errorf("audit record %+v, want busid:%s device:%s status:%s", r, busID, dev, status)
+	}
+}
+
+func TestCheckPermitted(t *testing.T) {
+	w, _, transport, audit, _ := newWatcher(t)
+
+	if detached := w.Check(); len(detached) != 0 {
+		t.Errorf("detached %v, want none", detached)
+	}
+
+	if len(transport.unbound) != 0 || audit.Len() != 0 {
+		t.Errorf("unbound %v, audit %q, want none", transport.unbound, audit)
+	}
+
+	if n := len(w.Attached()); n != 2 {
+		t.Errorf("%d devices tracked, want 2", n)
+	}
+}
+
+func TestCheckRevoked(t *testing.T) {
+	w, cache, transport, audit, _ := newWatcher(t)
+
+	if err := cache.Revoke(keyboard.DeviceID); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDetached(t, w, transport, audit, "1-1", "1a86:e026:-", endorsement.Revoked)
+}
+
+func TestCheckExpired(t *testing.T) {
+	w, _, transport, audit, c := newWatcher(t)
+
+	c.now += int64(time.Hour/time.Second) + 1
+
+	if detached := w.Check(); !slices.Equal(detached, []string{"1-1", "1-2"}) {
+		t.Fatalf("detached %v, want [1-1 1-2]", detached)
+	}
+
+	for _, r := range records(t, audit) {
+		if r.Status != endorsement.Expired || r.Time != c.now {
+			t.Errorf("audit record %+v, want status:expired time:%d", r, c.now)
+		}
+	}
+
+	if len(transport.unbound) != 2 || len(transport.detached) != 2 {
+		t.Errorf("unbound %v detached %v, want both devices", transport.unbound, transport.detached)
+	}
+}
+
+func TestCheckNotEndorsed(t *testing.T) {
+	w, cache, transport, audit, _ := newWatcher(t)
+
+	cache.Reset()
+	cache.Add(endorsement.Grant{Device: receiver.DeviceID, Budget: endorsement.Unlimited})
+
+	checkDetached(t, w, transport, audit, "1-1", "1a86:e026:-", endorsement.Unknown)
+}
+
+func TestCheckToken(t *testing.T) {
+	w, _, transport, audit, c := newWatcher(t)
+
+	seed := sha256.Sum256([]byte("GoTEE test token key"))
+	key := ed25519.NewKeyFromSeed(seed[:])
+
+	w.Tokens = endorsement.NewTokens(key.Public().(ed25519.PublicKey))
+	w.Tokens.Nanotime = c.Nanotime
+
+	token := &endorsement.Token{
+		Version: endorsement.TokenVersion,
+		Device:  receiver.DeviceID,
+		Issued:  c.now,
+		Expiry:  c.now + 60,
+	}
+
+	if err := token.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Tokens.Add(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keyboard has no token
+	checkDetached(t, w, transport, audit, "1-1", "1a86:e026:-", endorsement.Active)
+
+	if r := records(t, audit)[0]; r.Reason != endorsement.ErrNoToken.Error() {
+		t.Errorf("reason %q, want %q", r.Reason, endorsement.ErrNoToken)
+	}
+
+	// the receiver token expires before its endorsement
+	c.now += 61
+	audit.Reset()
+
+	if detached := w.Check(); !slices.Equal(detached, []string{"1-2"}) {
+		t.Fatalf("detached %v, want [1-2]", detached)
+	}
+
+	if r := records(t, audit)[0]; r.Status != endorsement.Active || r.Reason != endorsement.ErrTokenExpired.Error() {
+		t.Errorf("audit record %+v, want status:trusted reason:%q", r, endorsement.ErrTokenExpired)
+	}
+}
+
+func TestCheckUnbindFailure(t *testing.T) {
+	w, cache, transport, audit, _ := newWatcher(t)
+
+	transport.fail["1-1"] = true
+
+	if err := cache.Revoke(keyboard.DeviceID); err != nil {
+		t.Fatal(err)
+	}
+
+	if detached := w.Check(); len(detached) != 0 {
+		t.Fatalf("detached %v, want none", detached)
+	}
+
+	if len(transport.detached) != 0 {
+		t.Errorf("detached %v without unbind", transport.detached)
+	}
+
+	if _, ok := w.Attached()["1-1"]; !ok {
+		t.Fatal("device no longer tracked after failed unbind")
+	}
+
+	if r := records(t, audit); len(r) != 1 || r[0].Error == "" {
+		t.Errorf("audit records %+v, want one with error", r)
+	}
+
+	// retried on the next check
+	delete(transport.fail, "1-1")
+	audit.Reset()
+
+	checkDetached(t, w, transport, audit, "1-1", "1a86:e026:-", endorsement.Revoked)
+}
+
+func TestSync(t *testing.T) {
+	w, _, _, _, _ := newWatcher(t)
+
+	w.Sync(map[string]endorsement.Device{"1-2": receiver, "1-3": keyboard})
+
+	attached := w.Attached()
+
+	if len(attached) != 2 || attached["1-2"].DeviceID != receiver.DeviceID || attached["1-3"].DeviceID != keyboard.DeviceID {
+		t.Errorf("tracked %v, want 1-2 and 1-3", attached)
+	}
+
+	w.Release("1-2")
+
+	if _, ok := w.Attached()["1-2"]; ok {
+		t.Error("released device still tracked")
+	}
+}